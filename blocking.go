@@ -0,0 +1,149 @@
+package s2kv
+
+import (
+	"sync"
+	"time"
+)
+
+// listWaiters tracks connections blocked on list and stream keys. Pushes wake
+// the connection that has been waiting longest on the key, which in turn wakes
+// the next one once it has popped, so blocked clients are served in FIFO
+// order. Stream entries are not consumed by reading, so XADD wakes them all.
+type listWaiters struct {
+	mu     sync.Mutex
+	queues map[dbKey][]*listWaiter
+}
+
+type listWaiter struct {
+	keys []dbKey
+	wake chan struct{}
+}
+
+func newListWaiters() *listWaiters {
+	return &listWaiters{queues: make(map[dbKey][]*listWaiter)}
+}
+
+func (lw *listWaiters) add(keys []dbKey) *listWaiter {
+	w := &listWaiter{keys: keys, wake: make(chan struct{}, 1)}
+
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	for _, k := range keys {
+		lw.queues[k] = append(lw.queues[k], w)
+	}
+	return w
+}
+
+func (lw *listWaiters) remove(w *listWaiter) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	for _, k := range w.keys {
+		queue := lw.queues[k]
+		for i, other := range queue {
+			if other == w {
+				queue = append(queue[:i:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(lw.queues, k)
+		} else {
+			lw.queues[k] = queue
+		}
+	}
+}
+
+// notify wakes the longest waiting connection blocked on k, if any.
+func (lw *listWaiters) notify(k dbKey) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if queue := lw.queues[k]; len(queue) > 0 {
+		queue[0].notify()
+	}
+}
+
+// notifyAll wakes every connection blocked on k, for writes that every
+// waiter can read rather than only the first to pop.
+func (lw *listWaiters) notifyAll(k dbKey) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	for _, w := range lw.queues[k] {
+		w.notify()
+	}
+}
+
+// notifyDB wakes the longest waiting connection blocked on each key of db.
+func (lw *listWaiters) notifyDB(db int) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	for k, queue := range lw.queues {
+		if k.db == db && len(queue) > 0 {
+			queue[0].notify()
+		}
+	}
+}
+
+func (w *listWaiter) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// block calls pop until it reports success, waiting for a push to one of keys
+// between attempts. It gives up once timeout elapses, returning false, or
+// with an error once the client disconnects. A timeout of 0 waits forever.
+func (s *SingleStore) block(keys [][]byte, timeout time.Duration, pop func() (bool, error)) (bool, error) {
+	ok, err := pop()
+	if ok || err != nil {
+		return ok, err
+	}
+
+	dbKeys := make([]dbKey, len(keys))
+	for i, k := range keys {
+		dbKeys[i] = s.dbKey(k)
+	}
+	w := s.waiters.add(dbKeys)
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	// a push may have happened before we were registered
+	ok, err = pop()
+	for !ok && err == nil {
+		select {
+		case <-w.wake:
+			ok, err = pop()
+		case <-expired:
+			s.unblock(w)
+			return false, nil
+		case <-s.ctx.Done():
+			s.unblock(w)
+			return false, s.ctx.Err()
+		}
+	}
+
+	s.waiters.remove(w)
+	// pass the wakeup on in case the keys still hold elements
+	for _, k := range dbKeys {
+		s.waiters.notify(k)
+	}
+	return ok, err
+}
+
+// unblock removes w from the waiters, handing on any wakeup it received but
+// did not act on.
+func (s *SingleStore) unblock(w *listWaiter) {
+	s.waiters.remove(w)
+	select {
+	case <-w.wake:
+		for _, k := range w.keys {
+			s.waiters.notify(k)
+		}
+	default:
+	}
+}
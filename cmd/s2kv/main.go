@@ -24,7 +24,7 @@ func main() {
 		panic(err)
 	}
 
-	server := s2kv.NewServer(db)
+	server := s2kv.NewServer(db, config.Server)
 
 	if err := server.ListenAndServe("6379"); err != nil {
 		log.Fatal(err)
@@ -1,7 +1,13 @@
 package s2kv
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
 	"strconv"
+	"strings"
+	"time"
 )
 
 //go:generate mockgen -destination=mocks_test.go -package=s2kv_test . Command,Writer
@@ -20,17 +26,76 @@ type Writer interface {
 	WriteSimpleString(string) error
 	WriteInt(int64) error
 	WriteError(string) error
+	WriteObjects(...interface{}) error
 }
 
 type CommandHandler func(*SingleStore, Writer, Command) error
 
+// ReplyError is an error which is sent to the client as an error reply
+// rather than terminating the connection.
+type ReplyError string
+
+func (e ReplyError) Error() string {
+	return string(e)
+}
+
+var (
+	ErrNotInteger    = ReplyError("ERR value is not an integer or out of range")
+	ErrNotFloat      = ReplyError("ERR value is not a valid float")
+	ErrOverflow      = ReplyError("ERR increment or decrement would overflow")
+	ErrSyntax        = ReplyError("ERR syntax error")
+	ErrBitOffset     = ReplyError("ERR bit offset is not an integer or out of range")
+	ErrBitValue      = ReplyError("ERR bit is not an integer or out of range")
+	ErrBitType       = ReplyError("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	ErrOverflowType  = ReplyError("ERR Invalid OVERFLOW type specified")
+	ErrScoreRange    = ReplyError("ERR min or max is not a float")
+	ErrLexRange      = ReplyError("ERR min or max not valid string range item")
+	ErrNotPositive   = ReplyError("ERR value is out of range, must be positive")
+	ErrOutOfRange    = ReplyError("ERR value is out of range")
+	ErrInvalidCursor = ReplyError("ERR invalid cursor")
+	ErrDBIndex       = ReplyError("ERR DB index is out of range")
+	ErrStreamID      = ReplyError("ERR Invalid stream ID specified as stream command argument")
+	ErrNotHLL        = ReplyError("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	ErrCorruptHLL    = ReplyError("INVALIDOBJ Corrupted HLL object detected")
+	ErrJSONRoot      = ReplyError("ERR new objects must be created at the root")
+	ErrJSONNoKey     = ReplyError("ERR could not perform this operation on a key that doesn't exist")
+	ErrJSONValue     = ReplyError("ERR expected a valid JSON value")
+)
+
+// databaseCount is the number of logical databases, numbered from 0, like
+// the default databases setting of redis.
+const databaseCount = 16
+
+// ErrorReply returns the message to send to the client if err should be
+// reported as an error reply: either a ReplyError or an exception raised by a
+// procedure.
+func ErrorReply(err error) (string, bool) {
+	var rerr ReplyError
+	if errors.As(err, &rerr) {
+		return rerr.Error(), true
+	}
+	if msg, ok := userExceptionMessage(err); ok {
+		for _, code := range exceptionCodes {
+			if strings.HasPrefix(msg, code+" ") {
+				return msg, true
+			}
+		}
+		return "ERR " + msg, true
+	}
+	return "", false
+}
+
+// exceptionCodes are the error codes which procedures raise exceptions with,
+// which are reported as is rather than as ERR.
+var exceptionCodes = []string{"BUSYGROUP", "NOGROUP"}
+
 var CommandHandlers = map[string]CommandHandler{
 	"PING": func(_ *SingleStore, w Writer, c Command) error {
 		return w.WriteSimpleString("PONG")
 	},
 
 	"SET": func(db *SingleStore, w Writer, c Command) error {
-		key := string(c.Get(1))
+		key := c.Get(1)
 		val := c.Get(2)
 
 		err := db.BlobSet(key, val)
@@ -40,9 +105,18 @@ var CommandHandlers = map[string]CommandHandler{
 		return w.WriteSimpleString("OK")
 	},
 
+	"INCR": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		result, err := db.IncrBy(key, 1)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(result)
+	},
+
 	"INCRBY": func(db *SingleStore, w Writer, c Command) error {
-		key := string(c.Get(1))
-		val, err := strconv.ParseInt(string(c.Get(2)), 10, 64)
+		key := c.Get(1)
+		val, err := parseInt(c.Get(2))
 		if err != nil {
 			return err
 		}
@@ -54,8 +128,227 @@ var CommandHandlers = map[string]CommandHandler{
 		return w.WriteInt(result)
 	},
 
+	"DECR": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		result, err := db.DecrBy(key, 1)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(result)
+	},
+
+	"DECRBY": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		val, err := parseInt(c.Get(2))
+		if err != nil {
+			return err
+		}
+		if val == math.MinInt64 {
+			// the negation would overflow before reaching the database
+			return ErrOverflow
+		}
+
+		result, err := db.DecrBy(key, val)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(result)
+	},
+
+	"INCRBYFLOAT": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		val, err := strconv.ParseFloat(string(c.Get(2)), 64)
+		if err != nil || math.IsNaN(val) || math.IsInf(val, 0) {
+			return ErrNotFloat
+		}
+
+		result, err := db.IncrByFloat(key, val)
+		if err != nil {
+			return err
+		}
+		return w.WriteBulk(result)
+	},
+
+	"SETBIT": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		bit := string(c.Get(3))
+		offset, err := parseBitOffset(c.Get(2), 1)
+		if err != nil {
+			return err
+		}
+		if bit != "0" && bit != "1" {
+			return ErrBitValue
+		}
+
+		result, err := db.SetBit(key, offset, int(bit[0]-'0'))
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(result)
+	},
+
+	"GETBIT": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		offset, err := parseBitOffset(c.Get(2), 1)
+		if err != nil {
+			return err
+		}
+
+		result, err := db.GetBit(key, offset)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(result)
+	},
+
+	"BITCOUNT": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		start, end := int64(0), int64(-1)
+		bitMode := false
+
+		switch c.ArgCount() {
+		case 2:
+		case 4, 5:
+			var err error
+			if start, err = parseInt(c.Get(2)); err != nil {
+				return err
+			}
+			if end, err = parseInt(c.Get(3)); err != nil {
+				return err
+			}
+			if c.ArgCount() == 5 {
+				if bitMode, err = parseBitMode(c.Get(4)); err != nil {
+					return err
+				}
+			}
+		default:
+			return ErrSyntax
+		}
+
+		result, err := db.BitCount(key, start, end, bitMode)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(result)
+	},
+
+	"BITPOS": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		bit := string(c.Get(2))
+		if bit != "0" && bit != "1" {
+			return ErrBitValue
+		}
+		start, end := int64(0), int64(-1)
+		hasEnd, bitMode := false, false
+
+		var err error
+		if c.ArgCount() > 6 {
+			return ErrSyntax
+		}
+		if c.ArgCount() > 3 {
+			if start, err = parseInt(c.Get(3)); err != nil {
+				return err
+			}
+		}
+		if c.ArgCount() > 4 {
+			if end, err = parseInt(c.Get(4)); err != nil {
+				return err
+			}
+			hasEnd = true
+		}
+		if c.ArgCount() > 5 {
+			if bitMode, err = parseBitMode(c.Get(5)); err != nil {
+				return err
+			}
+		}
+
+		result, err := db.BitPos(key, int(bit[0]-'0'), start, end, hasEnd, bitMode)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(result)
+	},
+
+	"BITOP": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 3 {
+			return ReplyError("ERR wrong number of arguments for 'bitop' command")
+		}
+		op, dest, keys := strings.ToLower(string(args[0])), args[1], args[2:]
+		if op != "and" && op != "or" && op != "xor" && op != "not" {
+			return ErrSyntax
+		}
+
+		result, err := db.BitOp(op, dest, keys...)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(result)
+	},
+
+	"BITFIELD": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		overflow := "wrap"
+		ops := []BitFieldOp{}
+
+		for i := 2; i < c.ArgCount(); {
+			sub := strings.ToLower(string(c.Get(i)))
+			if sub == "overflow" {
+				overflow = strings.ToLower(string(c.Get(i + 1)))
+				if overflow != "wrap" && overflow != "sat" && overflow != "fail" {
+					return ErrOverflowType
+				}
+				i += 2
+				continue
+			}
+
+			arity := 3
+			if sub == "get" {
+				arity = 2
+			} else if sub != "set" && sub != "incrby" {
+				return ErrSyntax
+			}
+			if i+arity >= c.ArgCount() {
+				return ErrSyntax
+			}
+
+			args := commandSlice(c, i+1, i+arity+1)
+			op := BitFieldOp{Op: sub, Overflow: overflow}
+			var err error
+			if op.Signed, op.Bits, err = parseBitFieldType(args[0]); err != nil {
+				return err
+			}
+			if op.Offset, err = parseBitOffset(args[1], op.Bits); err != nil {
+				return err
+			}
+			if arity == 3 {
+				if op.Value, err = parseInt(args[2]); err != nil {
+					return err
+				}
+			}
+			ops = append(ops, op)
+			i += arity + 1
+		}
+
+		out := make([]interface{}, 0, len(ops))
+		if len(ops) > 0 {
+			results, err := db.BitField(key, ops)
+			if err != nil {
+				return err
+			}
+			for _, r := range results {
+				if r == nil {
+					out = append(out, nil)
+				} else {
+					out = append(out, *r)
+				}
+			}
+		}
+		return w.WriteObjects(out...)
+	},
+
 	"GET": func(db *SingleStore, w Writer, c Command) error {
-		key := string(c.Get(1))
+		key := c.Get(1)
 		val, err := db.BlobGet(key)
 		if err != nil {
 			return err
@@ -64,15 +357,15 @@ var CommandHandlers = map[string]CommandHandler{
 	},
 
 	"DEL": func(db *SingleStore, w Writer, c Command) error {
-		key := string(c.Get(1))
-		val, err := db.KeyDelete(key)
+		keys := commandSlice(c, 1, c.ArgCount())
+		if len(keys) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'del' command")
+		}
+		n, err := db.KeyDelete(keys...)
 		if err != nil {
 			return err
 		}
-		if val {
-			return w.WriteInt(1)
-		}
-		return w.WriteInt(0)
+		return w.WriteInt(n)
 	},
 
 	"FLUSHALL": func(db *SingleStore, w Writer, c Command) error {
@@ -83,155 +376,3746 @@ var CommandHandlers = map[string]CommandHandler{
 		return w.WriteSimpleString("OK")
 	},
 
-	"KEYS": func(db *SingleStore, w Writer, c Command) error {
-		pattern := string(c.Get(1))
-		if pattern == "" {
-			pattern = "%"
+	"FLUSHDB": func(db *SingleStore, w Writer, c Command) error {
+		err := db.FlushDB()
+		if err != nil {
+			return err
 		}
-		out, err := db.Keys(pattern)
+		return w.WriteSimpleString("OK")
+	},
+
+	"SELECT": func(db *SingleStore, w Writer, c Command) error {
+		n, err := parseDBIndex(c.Get(1))
 		if err != nil {
 			return err
 		}
-		return w.WriteBulks(out...)
+		db.SelectDB(n)
+		return w.WriteSimpleString("OK")
 	},
 
-	"EXISTS": func(db *SingleStore, w Writer, c Command) error {
-		key := string(c.Get(1))
-		exists, err := db.KeyExists(key)
+	"SWAPDB": func(db *SingleStore, w Writer, c Command) error {
+		a, err := parseDBIndex(c.Get(1))
+		if err == ErrNotInteger {
+			return ReplyError("ERR invalid first DB index")
+		} else if err != nil {
+			return err
+		}
+		b, err := parseDBIndex(c.Get(2))
+		if err == ErrNotInteger {
+			return ReplyError("ERR invalid second DB index")
+		} else if err != nil {
+			return err
+		}
+
+		err = db.SwapDB(a, b)
 		if err != nil {
 			return err
 		}
-		if exists {
-			return w.WriteInt(1)
+		return w.WriteSimpleString("OK")
+	},
+
+	"KEYS": func(db *SingleStore, w Writer, c Command) error {
+		out, err := db.Keys(ParseGlob(c.Get(1)))
+		if err != nil {
+			return err
 		}
-		return w.WriteInt(0)
+		if out == nil {
+			out = [][]byte{}
+		}
+		return w.WriteBulks(out...)
 	},
 
-	"RPUSH": func(db *SingleStore, w Writer, c Command) error {
-		key := string(c.Get(1))
-		val := c.Get(2)
-		err := db.ListAppend(key, val)
+	"SCAN": func(db *SingleStore, w Writer, c Command) error {
+		raw := commandSlice(c, 1, c.ArgCount())
+		if len(raw) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'scan' command")
+		}
+		args, err := parseScan(raw, "TYPE")
 		if err != nil {
 			return err
 		}
-		return w.WriteSimpleString("OK")
+
+		next, keys, err := db.Scan(args.cursor, args.pattern, args.count, args.typ)
+		if err != nil {
+			return err
+		}
+		out := make([][]byte, len(keys))
+		for i, k := range keys {
+			out[i] = []byte(k)
+		}
+		return writeScan(w, next, out)
 	},
 
-	"LREM": func(db *SingleStore, w Writer, c Command) error {
-		key := string(c.Get(1))
-		val := c.Get(2)
-		n, err := db.ListRemove(key, val)
+	"EXISTS": func(db *SingleStore, w Writer, c Command) error {
+		keys := commandSlice(c, 1, c.ArgCount())
+		if len(keys) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'exists' command")
+		}
+		n, err := db.KeyExists(keys...)
 		if err != nil {
 			return err
 		}
 		return w.WriteInt(n)
 	},
 
-	"LRANGE": func(db *SingleStore, w Writer, c Command) error {
-		key := string(c.Get(1))
-		start, err := strconv.Atoi(string(c.Get(2)))
+	"TOUCH": func(db *SingleStore, w Writer, c Command) error {
+		keys := commandSlice(c, 1, c.ArgCount())
+		if len(keys) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'touch' command")
+		}
+		n, err := db.KeyExists(keys...)
 		if err != nil {
 			return err
 		}
-		stop, err := strconv.Atoi(string(c.Get(3)))
+		return w.WriteInt(n)
+	},
+
+	"UNLINK": func(db *SingleStore, w Writer, c Command) error {
+		keys := commandSlice(c, 1, c.ArgCount())
+		if len(keys) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'unlink' command")
+		}
+		n, err := db.KeyUnlink(keys...)
 		if err != nil {
 			return err
 		}
+		return w.WriteInt(n)
+	},
 
-		var out [][]byte
-		if start == 0 && stop == -1 {
-			out, err = db.ListGet(key)
-			if err != nil {
-				return err
-			}
-		} else {
-			out, err = db.ListRange(key, start, stop)
-			if err != nil {
-				return err
-			}
+	"TYPE": func(db *SingleStore, w Writer, c Command) error {
+		t, err := db.KeyType(c.Get(1))
+		if err != nil {
+			return err
 		}
-		return w.WriteBulks(out...)
+		return w.WriteSimpleString(redisType(t))
 	},
 
-	"SADD": func(db *SingleStore, w Writer, c Command) error {
-		key := string(c.Get(1))
-		val := c.Get(2)
-		err := db.SetAdd(key, val)
+	"RENAME": func(db *SingleStore, w Writer, c Command) error {
+		src := c.Get(1)
+		dest := c.Get(2)
+		_, err := db.KeyRename(src, dest, false)
 		if err != nil {
 			return err
 		}
 		return w.WriteSimpleString("OK")
 	},
 
-	"SREM": func(db *SingleStore, w Writer, c Command) error {
-		key := string(c.Get(1))
-		val := c.Get(2)
-		n, err := db.SetRemove(key, val)
+	"RENAMENX": func(db *SingleStore, w Writer, c Command) error {
+		src := c.Get(1)
+		dest := c.Get(2)
+		renamed, err := db.KeyRename(src, dest, true)
 		if err != nil {
 			return err
 		}
-		return w.WriteInt(n)
+		if renamed {
+			return w.WriteInt(1)
+		}
+		return w.WriteInt(0)
 	},
 
-	"SMEMBERS": func(db *SingleStore, w Writer, c Command) error {
-		key := string(c.Get(1))
-		out, err := db.SetGet(key)
+	"COPY": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 2 {
+			return ReplyError("ERR wrong number of arguments for 'copy' command")
+		}
+		src, dest := args[0], args[1]
+
+		destDB := db.dbIndex
+		replace := false
+		for i := 2; i < len(args); i++ {
+			switch strings.ToUpper(string(args[i])) {
+			case "REPLACE":
+				replace = true
+			case "DB":
+				if i+1 >= len(args) {
+					return ErrSyntax
+				}
+				i++
+				var err error
+				if destDB, err = parseDBIndex(args[i]); err != nil {
+					return err
+				}
+			default:
+				return ErrSyntax
+			}
+		}
+		if bytes.Equal(src, dest) && destDB == db.dbIndex {
+			return ReplyError("ERR source and destination objects are the same")
+		}
+
+		copied, err := db.KeyCopy(src, destDB, dest, replace)
 		if err != nil {
 			return err
 		}
-		return w.WriteBulks(out...)
+		if copied {
+			return w.WriteInt(1)
+		}
+		return w.WriteInt(0)
 	},
 
-	"SUNION": func(db *SingleStore, w Writer, c Command) error {
-		keys := commandSliceStr(c, 1, c.ArgCount())
-		out, err := db.SetUnion(keys...)
+	"MOVE": func(db *SingleStore, w Writer, c Command) error {
+		k := c.Get(1)
+		destDB, err := parseDBIndex(c.Get(2))
 		if err != nil {
 			return err
 		}
-		return w.WriteBulks(out...)
-	},
+		if destDB == db.dbIndex {
+			return ReplyError("ERR source and destination objects are the same")
+		}
 
-	"SINTER": func(db *SingleStore, w Writer, c Command) error {
-		keys := commandSliceStr(c, 1, c.ArgCount())
-		out, err := db.SetIntersect(keys...)
+		moved, err := db.KeyMove(k, destDB)
 		if err != nil {
 			return err
 		}
-		return w.WriteBulks(out...)
+		if moved {
+			return w.WriteInt(1)
+		}
+		return w.WriteInt(0)
 	},
 
-	"SINTERCARD": func(db *SingleStore, w Writer, c Command) error {
-		keys := commandSliceStr(c, 1, c.ArgCount())
-		out, err := db.SetIntersectCardinality(keys...)
+	"RANDOMKEY": func(db *SingleStore, w Writer, c Command) error {
+		k, err := db.KeyRandom()
 		if err != nil {
 			return err
 		}
-		return w.WriteInt(out)
+		return w.WriteBulk(k)
 	},
 
-	"SWITHMEMBER": func(db *SingleStore, w Writer, c Command) error {
-		val := c.Get(1)
-		out, err := db.SetsWithMember(val)
+	"DBSIZE": func(db *SingleStore, w Writer, c Command) error {
+		n, err := db.KeyCount()
 		if err != nil {
 			return err
 		}
-		return w.WriteBulkStrings(out)
+		return w.WriteInt(n)
 	},
 
-	"SCARD": func(db *SingleStore, w Writer, c Command) error {
-		key := string(c.Get(1))
-		n, err := db.SetCardinality(key)
+	"RPUSH": listPushHandler("rpush", false, false),
+
+	"LREM": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		val := c.Get(2)
+		n, err := db.ListRemove(key, val)
 		if err != nil {
 			return err
 		}
 		return w.WriteInt(n)
 	},
-}
 
-func commandSliceStr(c Command, start, end int) []string {
-	ret := make([]string, end-start)
+	"LRANGE": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		start, err := parseInt(c.Get(2))
+		if err != nil {
+			return err
+		}
+		stop, err := parseInt(c.Get(3))
+		if err != nil {
+			return err
+		}
+
+		var out [][]byte
+		if start == 0 && stop == -1 {
+			out, err = db.ListGet(key)
+		} else {
+			out, err = db.ListRange(key, start, stop)
+		}
+		if err != nil {
+			return err
+		}
+		if out == nil {
+			out = [][]byte{}
+		}
+		return w.WriteBulks(out...)
+	},
+
+	"LPUSH":  listPushHandler("lpush", true, false),
+	"LPUSHX": listPushHandler("lpushx", true, true),
+	"RPUSHX": listPushHandler("rpushx", false, true),
+
+	"LPOP": listPopHandler(true),
+	"RPOP": listPopHandler(false),
+
+	"LLEN": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		n, err := db.ListLength(key)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"LINDEX": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		index, err := parseInt(c.Get(2))
+		if err != nil {
+			return err
+		}
+
+		val, err := db.ListIndex(key, index)
+		if err != nil {
+			return err
+		}
+		return w.WriteBulk(val)
+	},
+
+	"LSET": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		val := c.Get(3)
+		index, err := parseInt(c.Get(2))
+		if err != nil {
+			return err
+		}
+
+		err = db.ListSet(key, index, val)
+		if err != nil {
+			return err
+		}
+		return w.WriteSimpleString("OK")
+	},
+
+	"LINSERT": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		where := strings.ToUpper(string(c.Get(2)))
+		pivot := c.Get(3)
+		val := c.Get(4)
+		if where != "BEFORE" && where != "AFTER" {
+			return ErrSyntax
+		}
+
+		n, err := db.ListInsert(key, where == "BEFORE", pivot, val)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"LPOS": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 2 {
+			return ReplyError("ERR wrong number of arguments for 'lpos' command")
+		}
+		key, val, opts := args[0], args[1], args[2:]
+
+		rank, count, maxLen := int64(1), int64(-1), int64(0)
+		for i := 0; i < len(opts); i += 2 {
+			if i+1 >= len(opts) {
+				return ErrSyntax
+			}
+			n, err := parseInt(opts[i+1])
+			if err != nil {
+				return err
+			}
+
+			switch strings.ToUpper(string(opts[i])) {
+			case "RANK":
+				if n == 0 || n == math.MinInt64 {
+					return ReplyError("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the last match")
+				}
+				rank = n
+			case "COUNT":
+				if n < 0 {
+					return ReplyError("ERR COUNT can't be negative")
+				}
+				count = n
+			case "MAXLEN":
+				if n < 0 {
+					return ReplyError("ERR MAXLEN can't be negative")
+				}
+				maxLen = n
+			default:
+				return ErrSyntax
+			}
+		}
+
+		if count < 0 {
+			out, err := db.ListPositions(key, val, rank, 1, maxLen)
+			if err != nil {
+				return err
+			}
+			if len(out) == 0 {
+				return w.WriteBulk(nil)
+			}
+			return w.WriteInt(out[0])
+		}
+
+		out, err := db.ListPositions(key, val, rank, count, maxLen)
+		if err != nil {
+			return err
+		}
+		objs := make([]interface{}, len(out))
+		for i, n := range out {
+			objs[i] = n
+		}
+		return w.WriteObjects(objs...)
+	},
+
+	"LTRIM": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		start, err := parseInt(c.Get(2))
+		if err != nil {
+			return err
+		}
+		stop, err := parseInt(c.Get(3))
+		if err != nil {
+			return err
+		}
+
+		err = db.ListTrim(key, start, stop)
+		if err != nil {
+			return err
+		}
+		return w.WriteSimpleString("OK")
+	},
+
+	"LMOVE": func(db *SingleStore, w Writer, c Command) error {
+		src := c.Get(1)
+		dest := c.Get(2)
+		rawTo := c.Get(4)
+		fromLeft, err := parseListDirection(c.Get(3))
+		if err != nil {
+			return err
+		}
+		toLeft, err := parseListDirection(rawTo)
+		if err != nil {
+			return err
+		}
+
+		val, err := db.ListMove(src, dest, fromLeft, toLeft)
+		if err != nil {
+			return err
+		}
+		return w.WriteBulk(val)
+	},
+
+	"RPOPLPUSH": func(db *SingleStore, w Writer, c Command) error {
+		src := c.Get(1)
+		dest := c.Get(2)
+		val, err := db.ListMove(src, dest, false, true)
+		if err != nil {
+			return err
+		}
+		return w.WriteBulk(val)
+	},
+
+	"LMPOP": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 3 {
+			return ReplyError("ERR wrong number of arguments for 'lmpop' command")
+		}
+		keys, left, count, err := parseMultiPop(args)
+		if err != nil {
+			return err
+		}
+
+		key, out, err := db.ListMultiPop(keys, count, left)
+		if err != nil {
+			return err
+		}
+		return writeMultiPop(w, key, out)
+	},
+
+	"BLPOP": blockingPopHandler("blpop", true),
+	"BRPOP": blockingPopHandler("brpop", false),
+
+	"BLMOVE": func(db *SingleStore, w Writer, c Command) error {
+		src := c.Get(1)
+		dest := c.Get(2)
+		rawTo := c.Get(4)
+		rawTimeout := c.Get(5)
+		fromLeft, err := parseListDirection(c.Get(3))
+		if err != nil {
+			return err
+		}
+		toLeft, err := parseListDirection(rawTo)
+		if err != nil {
+			return err
+		}
+		timeout, err := parseTimeout(rawTimeout)
+		if err != nil {
+			return err
+		}
+
+		var val []byte
+		_, err = db.block([][]byte{src}, timeout, func() (bool, error) {
+			var err error
+			val, err = db.ListMove(src, dest, fromLeft, toLeft)
+			return val != nil, err
+		})
+		if err != nil {
+			return err
+		}
+		return w.WriteBulk(val)
+	},
+
+	"BRPOPLPUSH": func(db *SingleStore, w Writer, c Command) error {
+		src := c.Get(1)
+		dest := c.Get(2)
+		timeout, err := parseTimeout(c.Get(3))
+		if err != nil {
+			return err
+		}
+
+		var val []byte
+		_, err = db.block([][]byte{src}, timeout, func() (bool, error) {
+			var err error
+			val, err = db.ListMove(src, dest, false, true)
+			return val != nil, err
+		})
+		if err != nil {
+			return err
+		}
+		return w.WriteBulk(val)
+	},
+
+	"BLMPOP": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 4 {
+			return ReplyError("ERR wrong number of arguments for 'blmpop' command")
+		}
+		timeout, err := parseTimeout(args[0])
+		if err != nil {
+			return err
+		}
+		keys, left, count, err := parseMultiPop(args[1:])
+		if err != nil {
+			return err
+		}
+
+		var key []byte
+		var out [][]byte
+		_, err = db.block(keys, timeout, func() (bool, error) {
+			var err error
+			key, out, err = db.ListMultiPop(keys, count, left)
+			return out != nil, err
+		})
+		if err != nil {
+			return err
+		}
+		return writeMultiPop(w, key, out)
+	},
+
+	"SADD": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		values := commandSlice(c, 2, c.ArgCount())
+		if len(values) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'sadd' command")
+		}
+		n, err := db.SetAdd(key, values)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"SREM": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		values := commandSlice(c, 2, c.ArgCount())
+		if len(values) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'srem' command")
+		}
+		n, err := db.SetRemove(key, values)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"SMEMBERS": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		out, err := db.SetGet(key)
+		if err != nil {
+			return err
+		}
+		if out == nil {
+			out = [][]byte{}
+		}
+		return w.WriteBulks(out...)
+	},
+
+	"SUNION": func(db *SingleStore, w Writer, c Command) error {
+		keys := commandSlice(c, 1, c.ArgCount())
+		if len(keys) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'sunion' command")
+		}
+		out, err := db.SetUnion(keys...)
+		if err != nil {
+			return err
+		}
+		if out == nil {
+			out = [][]byte{}
+		}
+		return w.WriteBulks(out...)
+	},
+
+	"SINTER": func(db *SingleStore, w Writer, c Command) error {
+		keys := commandSlice(c, 1, c.ArgCount())
+		if len(keys) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'sinter' command")
+		}
+		out, err := db.SetIntersect(keys...)
+		if err != nil {
+			return err
+		}
+		if out == nil {
+			out = [][]byte{}
+		}
+		return w.WriteBulks(out...)
+	},
+
+	"SINTERCARD": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 2 {
+			return ReplyError("ERR wrong number of arguments for 'sintercard' command")
+		}
+		numKeys, err := parseInt(args[0])
+		if err != nil {
+			return err
+		}
+		if numKeys < 1 {
+			return ReplyError("ERR numkeys should be greater than 0")
+		}
+		if numKeys > int64(len(args)-1) {
+			return ReplyError("ERR Number of keys can't be greater than number of args")
+		}
+
+		keys := make([][]byte, numKeys)
+		for i := range keys {
+			keys[i] = args[i+1]
+		}
+
+		limit := int64(0)
+		opts := args[numKeys+1:]
+		switch {
+		case len(opts) == 2 && strings.ToUpper(string(opts[0])) == "LIMIT":
+			if limit, err = parseInt(opts[1]); err != nil {
+				return err
+			}
+			if limit < 0 {
+				return ReplyError("ERR LIMIT can't be negative")
+			}
+		case len(opts) != 0:
+			return ErrSyntax
+		}
+
+		n, err := db.SetIntersectCardinality(limit, keys...)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"SDIFF": func(db *SingleStore, w Writer, c Command) error {
+		keys := commandSlice(c, 1, c.ArgCount())
+		if len(keys) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'sdiff' command")
+		}
+		out, err := db.SetDiff(keys...)
+		if err != nil {
+			return err
+		}
+		if out == nil {
+			out = [][]byte{}
+		}
+		return w.WriteBulks(out...)
+	},
+
+	"SUNIONSTORE": setStoreHandler("sunionstore", "union"),
+	"SINTERSTORE": setStoreHandler("sinterstore", "inter"),
+	"SDIFFSTORE":  setStoreHandler("sdiffstore", "diff"),
+
+	"SSCAN": func(db *SingleStore, w Writer, c Command) error {
+		raw := commandSlice(c, 1, c.ArgCount())
+		if len(raw) < 2 {
+			return ReplyError("ERR wrong number of arguments for 'sscan' command")
+		}
+		key := raw[0]
+		args, err := parseScan(raw[1:], "")
+		if err != nil {
+			return err
+		}
+
+		next, out, err := db.SetScan(key, args.cursor, args.pattern, args.count)
+		if err != nil {
+			return err
+		}
+		return writeScan(w, next, out)
+	},
+
+	"SISMEMBER": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		found, err := db.SetIsMember(key, c.Get(2))
+		if err != nil {
+			return err
+		}
+		if found {
+			return w.WriteInt(1)
+		}
+		return w.WriteInt(0)
+	},
+
+	"SMISMEMBER": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 2 {
+			return ReplyError("ERR wrong number of arguments for 'smismember' command")
+		}
+		found, err := db.SetMultiIsMember(args[0], args[1:])
+		if err != nil {
+			return err
+		}
+
+		out := make([]interface{}, len(found))
+		for i, f := range found {
+			out[i] = int64(0)
+			if f {
+				out[i] = int64(1)
+			}
+		}
+		return w.WriteObjects(out...)
+	},
+
+	"SPOP": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		if c.ArgCount() == 2 {
+			out, err := db.SetPop(key, 1)
+			if err != nil {
+				return err
+			}
+			if len(out) == 0 {
+				return w.WriteBulk(nil)
+			}
+			return w.WriteBulk(out[0])
+		}
+
+		count, err := parseInt(c.Get(2))
+		if err != nil {
+			return err
+		}
+		if count < 0 {
+			return ErrNotPositive
+		}
+
+		out, err := db.SetPop(key, count)
+		if err != nil {
+			return err
+		}
+		if out == nil {
+			out = [][]byte{}
+		}
+		return w.WriteBulks(out...)
+	},
+
+	"SRANDMEMBER": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		if c.ArgCount() == 2 {
+			out, err := db.SetRandomMembers(key, 1, false)
+			if err != nil {
+				return err
+			}
+			if len(out) == 0 {
+				return w.WriteBulk(nil)
+			}
+			return w.WriteBulk(out[0])
+		}
+
+		count, err := parseInt(c.Get(2))
+		if err != nil {
+			return err
+		}
+		if count == math.MinInt64 {
+			return ErrOutOfRange
+		}
+		repeat := count < 0
+		if repeat {
+			count = -count
+		}
+
+		out, err := db.SetRandomMembers(key, count, repeat)
+		if err != nil {
+			return err
+		}
+		if out == nil {
+			out = [][]byte{}
+		}
+		return w.WriteBulks(out...)
+	},
+
+	"SMOVE": func(db *SingleStore, w Writer, c Command) error {
+		src := c.Get(1)
+		dest := c.Get(2)
+		moved, err := db.SetMove(src, dest, c.Get(3))
+		if err != nil {
+			return err
+		}
+		if moved {
+			return w.WriteInt(1)
+		}
+		return w.WriteInt(0)
+	},
+
+	"SWITHMEMBER": func(db *SingleStore, w Writer, c Command) error {
+		val := c.Get(1)
+		out, err := db.SetsWithMember(val)
+		if err != nil {
+			return err
+		}
+		if out == nil {
+			out = [][]byte{}
+		}
+		return w.WriteBulks(out...)
+	},
+
+	"SCARD": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		n, err := db.SetCardinality(key)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"HSET": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		pairs := commandSlice(c, 2, c.ArgCount())
+		if len(pairs) == 0 || len(pairs)%2 != 0 {
+			return ReplyError("ERR wrong number of arguments for 'hset' command")
+		}
+
+		fields := make([][]byte, 0, len(pairs)/2)
+		values := make([][]byte, 0, len(pairs)/2)
+		for i := 0; i < len(pairs); i += 2 {
+			fields = append(fields, pairs[i])
+			values = append(values, pairs[i+1])
+		}
+
+		n, err := db.HashSet(key, fields, values)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"HSETNX": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		set, err := db.HashSetNX(key, c.Get(2), c.Get(3))
+		if err != nil {
+			return err
+		}
+		if set {
+			return w.WriteInt(1)
+		}
+		return w.WriteInt(0)
+	},
+
+	"HGET": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		val, err := db.HashGet(key, c.Get(2))
+		if err != nil {
+			return err
+		}
+		return w.WriteBulk(val)
+	},
+
+	"HMGET": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		fields := commandSlice(c, 2, c.ArgCount())
+		if len(fields) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'hmget' command")
+		}
+		out, err := db.HashMultiGet(key, fields)
+		if err != nil {
+			return err
+		}
+		return w.WriteBulks(out...)
+	},
+
+	"HGETALL": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		fields, err := db.HashGetAll(key)
+		if err != nil {
+			return err
+		}
+		return w.WriteBulks(flattenHashFields(fields, true)...)
+	},
+
+	"HKEYS": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		out, err := db.HashKeys(key)
+		if err != nil {
+			return err
+		}
+		if out == nil {
+			out = [][]byte{}
+		}
+		return w.WriteBulks(out...)
+	},
+
+	"HVALS": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		out, err := db.HashValues(key)
+		if err != nil {
+			return err
+		}
+		if out == nil {
+			out = [][]byte{}
+		}
+		return w.WriteBulks(out...)
+	},
+
+	"HDEL": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		fields := commandSlice(c, 2, c.ArgCount())
+		if len(fields) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'hdel' command")
+		}
+		n, err := db.HashDelete(key, fields)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"HEXISTS": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		exists, err := db.HashExists(key, c.Get(2))
+		if err != nil {
+			return err
+		}
+		if exists {
+			return w.WriteInt(1)
+		}
+		return w.WriteInt(0)
+	},
+
+	"HLEN": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		n, err := db.HashLength(key)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"HSTRLEN": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		n, err := db.HashStrLength(key, c.Get(2))
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"HINCRBY": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		field := c.Get(2)
+		val, err := parseInt(c.Get(3))
+		if err != nil {
+			return err
+		}
+
+		result, err := db.HashIncrBy(key, field, val)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(result)
+	},
+
+	"HINCRBYFLOAT": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		field := c.Get(2)
+		val, err := strconv.ParseFloat(string(c.Get(3)), 64)
+		if err != nil || math.IsNaN(val) || math.IsInf(val, 0) {
+			return ErrNotFloat
+		}
+
+		result, err := db.HashIncrByFloat(key, field, val)
+		if err != nil {
+			return err
+		}
+		return w.WriteBulk(result)
+	},
+
+	"HSCAN": func(db *SingleStore, w Writer, c Command) error {
+		raw := commandSlice(c, 1, c.ArgCount())
+		if len(raw) < 2 {
+			return ReplyError("ERR wrong number of arguments for 'hscan' command")
+		}
+		key := raw[0]
+		args, err := parseScan(raw[1:], "NOVALUES")
+		if err != nil {
+			return err
+		}
+
+		next, fields, err := db.HashScan(key, args.cursor, args.pattern, args.count)
+		if err != nil {
+			return err
+		}
+		return writeScan(w, next, flattenHashFields(fields, !args.noValues))
+	},
+
+	"HRANDFIELD": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		if c.ArgCount() == 2 {
+			fields, err := db.HashRandomFields(key, 1, false)
+			if err != nil {
+				return err
+			}
+			if len(fields) == 0 {
+				return w.WriteBulk(nil)
+			}
+			return w.WriteBulk(fields[0].F)
+		}
+
+		count, err := parseInt(c.Get(2))
+		if err != nil {
+			return err
+		}
+		withValues := false
+		if c.ArgCount() == 4 {
+			if !strings.EqualFold(string(c.Get(3)), "WITHVALUES") {
+				return ErrSyntax
+			}
+			withValues = true
+		} else if c.ArgCount() > 4 {
+			return ErrSyntax
+		}
+
+		if count == math.MinInt64 {
+			return ErrOutOfRange
+		}
+		repeat := count < 0
+		if repeat {
+			count = -count
+		}
+		fields, err := db.HashRandomFields(key, count, repeat)
+		if err != nil {
+			return err
+		}
+		return w.WriteBulks(flattenHashFields(fields, withValues)...)
+	},
+
+	"ZADD": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		args := commandSlice(c, 2, c.ArgCount())
+		if len(args) < 2 {
+			return ReplyError("ERR wrong number of arguments for 'zadd' command")
+		}
+
+		var flags ZAddFlags
+		incr := false
+	options:
+		for len(args) > 0 {
+			switch strings.ToUpper(string(args[0])) {
+			case "NX":
+				flags.NX = true
+			case "XX":
+				flags.XX = true
+			case "GT":
+				flags.GT = true
+			case "LT":
+				flags.LT = true
+			case "CH":
+				flags.CH = true
+			case "INCR":
+				incr = true
+			default:
+				break options
+			}
+			args = args[1:]
+		}
+
+		if len(args) == 0 || len(args)%2 != 0 {
+			return ErrSyntax
+		}
+		if flags.NX && flags.XX {
+			return ReplyError("ERR XX and NX options at the same time are not compatible")
+		}
+		if (flags.GT && flags.LT) || ((flags.GT || flags.LT) && flags.NX) {
+			return ReplyError("ERR GT, LT, and/or NX options at the same time are not compatible")
+		}
+		if incr && len(args) != 2 {
+			return ReplyError("ERR INCR option supports a single increment-element pair")
+		}
+
+		members := make([]ZMember, 0, len(args)/2)
+		for i := 0; i < len(args); i += 2 {
+			score, err := parseScore(args[i])
+			if err != nil {
+				return err
+			}
+			members = append(members, ZMember{Member: args[i+1], Score: score})
+		}
+
+		if incr {
+			score, ok, err := db.ZSetIncrBy(key, members[0].Member, members[0].Score, flags)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return w.WriteBulk(nil)
+			}
+			return w.WriteBulk(formatScore(score))
+		}
+
+		n, err := db.ZSetAdd(key, members, flags)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"ZINCRBY": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		member := c.Get(3)
+		delta, err := parseScore(c.Get(2))
+		if err != nil {
+			return err
+		}
+
+		score, _, err := db.ZSetIncrBy(key, member, delta, ZAddFlags{})
+		if err != nil {
+			return err
+		}
+		return w.WriteBulk(formatScore(score))
+	},
+
+	"ZREM": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		members := commandSlice(c, 2, c.ArgCount())
+		if len(members) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'zrem' command")
+		}
+		n, err := db.ZSetRemove(key, members)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"ZSCORE": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		score, ok, err := db.ZSetScore(key, c.Get(2))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return w.WriteBulk(nil)
+		}
+		return w.WriteBulk(formatScore(score))
+	},
+
+	"ZCARD": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		n, err := db.ZSetCardinality(key)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"ZCOUNT": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		r, err := parseScoreRange(c.Get(2), c.Get(3))
+		if err != nil {
+			return err
+		}
+
+		n, err := db.ZSetCount(key, r)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"ZRANK":    zrankHandler(false),
+	"ZREVRANK": zrankHandler(true),
+
+	"ZRANGE": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		args := commandSlice(c, 2, c.ArgCount())
+		if len(args) < 2 {
+			return ReplyError("ERR wrong number of arguments for 'zrange' command")
+		}
+		start, stop, opts := args[0], args[1], args[2:]
+
+		by, rev, withScores, hasLimit := "", false, false, false
+		offset, count := int64(0), int64(-1)
+		for i := 0; i < len(opts); i++ {
+			switch strings.ToUpper(string(opts[i])) {
+			case "BYSCORE":
+				by = "score"
+			case "BYLEX":
+				by = "lex"
+			case "REV":
+				rev = true
+			case "WITHSCORES":
+				withScores = true
+			case "LIMIT":
+				var err error
+				if offset, count, err = parseLimit(opts[i+1:]); err != nil {
+					return err
+				}
+				hasLimit = true
+				i += 2
+			default:
+				return ErrSyntax
+			}
+		}
+		if hasLimit && by == "" {
+			return ReplyError("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+		}
+		if withScores && by == "lex" {
+			return ReplyError("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+		}
+
+		members, err := zsetRange(db, key, start, stop, by, rev, offset, count)
+		if err != nil {
+			return err
+		}
+		return w.WriteBulks(flattenZMembers(members, withScores)...)
+	},
+
+	"ZRANGEBYSCORE": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		args := commandSlice(c, 2, c.ArgCount())
+		if len(args) < 2 {
+			return ReplyError("ERR wrong number of arguments for 'zrangebyscore' command")
+		}
+		min, max, opts := args[0], args[1], args[2:]
+
+		withScores := false
+		offset, count := int64(0), int64(-1)
+		for i := 0; i < len(opts); i++ {
+			switch strings.ToUpper(string(opts[i])) {
+			case "WITHSCORES":
+				withScores = true
+			case "LIMIT":
+				var err error
+				if offset, count, err = parseLimit(opts[i+1:]); err != nil {
+					return err
+				}
+				i += 2
+			default:
+				return ErrSyntax
+			}
+		}
+
+		members, err := zsetRange(db, key, min, max, "score", false, offset, count)
+		if err != nil {
+			return err
+		}
+		return w.WriteBulks(flattenZMembers(members, withScores)...)
+	},
+
+	"ZPOPMIN": zpopHandler(false),
+	"ZPOPMAX": zpopHandler(true),
+
+	"ZSCAN": func(db *SingleStore, w Writer, c Command) error {
+		raw := commandSlice(c, 1, c.ArgCount())
+		if len(raw) < 2 {
+			return ReplyError("ERR wrong number of arguments for 'zscan' command")
+		}
+		key := raw[0]
+		args, err := parseScan(raw[1:], "")
+		if err != nil {
+			return err
+		}
+
+		next, members, err := db.ZSetScan(key, args.cursor, args.pattern, args.count)
+		if err != nil {
+			return err
+		}
+		return writeScan(w, next, flattenZMembers(members, true))
+	},
+
+	"ZREMRANGEBYRANK": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		start, err := parseInt(c.Get(2))
+		if err != nil {
+			return err
+		}
+		stop, err := parseInt(c.Get(3))
+		if err != nil {
+			return err
+		}
+
+		n, err := db.ZSetRemoveRangeByRank(key, start, stop)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"ZREMRANGEBYSCORE": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		r, err := parseScoreRange(c.Get(2), c.Get(3))
+		if err != nil {
+			return err
+		}
+
+		n, err := db.ZSetRemoveRangeByScore(key, r)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"ZREMRANGEBYLEX": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		r, empty, err := parseLexRange(c.Get(2), c.Get(3))
+		if err != nil {
+			return err
+		}
+		if empty {
+			return w.WriteInt(0)
+		}
+
+		n, err := db.ZSetRemoveRangeByLex(key, r)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"ZUNION":      zcombineHandler("union"),
+	"ZINTER":      zcombineHandler("inter"),
+	"ZDIFF":       zcombineHandler("diff"),
+	"ZUNIONSTORE": zcombineStoreHandler("union"),
+	"ZINTERSTORE": zcombineStoreHandler("inter"),
+	"ZDIFFSTORE":  zcombineStoreHandler("diff"),
+
+	"XADD": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		args := commandSlice(c, 2, c.ArgCount())
+
+		var noMkStream bool
+		var trim *StreamTrim
+	opts:
+		for len(args) > 0 {
+			switch strings.ToUpper(string(args[0])) {
+			case "NOMKSTREAM":
+				noMkStream = true
+				args = args[1:]
+			case "MAXLEN", "MINID":
+				var n int
+				var err error
+				if trim, n, err = parseStreamTrim(args); err != nil {
+					return err
+				}
+				args = args[n:]
+			default:
+				break opts
+			}
+		}
+		if len(args) < 3 || len(args)%2 == 0 {
+			return ReplyError("ERR wrong number of arguments for 'xadd' command")
+		}
+		ms, seq, err := parseStreamAddID(args[0])
+		if err != nil {
+			return err
+		}
+
+		id, err := db.StreamAdd(key, ms, seq, args[1:], noMkStream, trim)
+		if err != nil {
+			return err
+		}
+		return w.WriteBulk(id)
+	},
+
+	"XRANGE":    streamRangeHandler("xrange", false),
+	"XREVRANGE": streamRangeHandler("xrevrange", true),
+
+	"XLEN": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		n, err := db.StreamLength(key)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"XDEL": func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		args := commandSlice(c, 2, c.ArgCount())
+		if len(args) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'xdel' command")
+		}
+		ids := make([]StreamID, len(args))
+		for i, arg := range args {
+			var err error
+			if ids[i], err = parseStreamID(arg, 0); err != nil {
+				return err
+			}
+		}
+
+		n, err := db.StreamDelete(key, ids)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"XTRIM": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 3 {
+			return ReplyError("ERR wrong number of arguments for 'xtrim' command")
+		}
+		key, args := args[0], args[1:]
+		switch strings.ToUpper(string(args[0])) {
+		case "MAXLEN", "MINID":
+		default:
+			return ErrSyntax
+		}
+		trim, n, err := parseStreamTrim(args)
+		if err != nil {
+			return err
+		}
+		if n != len(args) {
+			return ErrSyntax
+		}
+
+		trimmed, err := db.StreamTrim(key, trim)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(trimmed)
+	},
+
+	"XREAD": func(db *SingleStore, w Writer, c Command) error {
+		raw := commandSlice(c, 1, c.ArgCount())
+		if len(raw) < 3 {
+			return ReplyError("ERR wrong number of arguments for 'xread' command")
+		}
+		args, err := parseStreamRead("xread", raw, false)
+		if err != nil {
+			return err
+		}
+
+		// entries are read from the ID after the one given, skipping keys
+		// where there can be none
+		var readKeys [][]byte
+		var starts []StreamID
+		for i, arg := range args.ids {
+			var id StreamID
+			switch string(arg) {
+			case "$":
+				last, _, err := db.StreamLastID(args.keys[i])
+				if err != nil {
+					return err
+				}
+				id = last
+			case ">":
+				return ReplyError("ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")
+			default:
+				if id, err = parseStreamID(arg, 0); err != nil {
+					return err
+				}
+			}
+			if start, ok := id.Next(); ok {
+				readKeys = append(readKeys, args.keys[i])
+				starts = append(starts, start)
+			}
+		}
+
+		var readFrom [][]byte
+		var results [][]StreamEntry
+		read := func() (bool, error) {
+			readFrom, results = nil, nil
+			for i, key := range readKeys {
+				entries, err := db.StreamRange(key, starts[i], MaxStreamID, false, args.count)
+				if err != nil {
+					return false, err
+				}
+				if len(entries) > 0 {
+					readFrom = append(readFrom, key)
+					results = append(results, entries)
+				}
+			}
+			return len(results) > 0, nil
+		}
+
+		if args.blocking {
+			_, err = db.block(args.keys, args.timeout, read)
+		} else {
+			_, err = read()
+		}
+		if err != nil {
+			return err
+		}
+		return writeStreamReads(w, readFrom, results)
+	},
+
+	"XGROUP": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'xgroup' command")
+		}
+		sub := strings.ToLower(string(args[0]))
+		arity := map[string]int{"create": 4, "destroy": 3, "setid": 4, "createconsumer": 4, "delconsumer": 4}
+		want, ok := arity[sub]
+		if !ok {
+			return ReplyError("ERR unknown subcommand '" + string(args[0]) + "'. Try XGROUP HELP.")
+		}
+		if len(args) < want || (sub != "create" && len(args) > want) {
+			return ReplyError("ERR wrong number of arguments for 'xgroup|" + sub + "' command")
+		}
+		key, grp := args[1], args[2]
+
+		switch sub {
+		case "create":
+			var mkStream bool
+			for _, opt := range args[4:] {
+				if strings.ToUpper(string(opt)) != "MKSTREAM" {
+					return ErrSyntax
+				}
+				mkStream = true
+			}
+			id, err := parseStreamGroupID(args[3])
+			if err != nil {
+				return err
+			}
+			if err := db.StreamGroupCreate(key, grp, id, mkStream); err != nil {
+				return err
+			}
+			return w.WriteSimpleString("OK")
+
+		case "destroy":
+			n, err := db.StreamGroupDestroy(key, grp)
+			if err != nil {
+				return err
+			}
+			return w.WriteInt(n)
+
+		case "setid":
+			id, err := parseStreamGroupID(args[3])
+			if err != nil {
+				return err
+			}
+			if err := db.StreamGroupSetID(key, grp, id); err != nil {
+				return err
+			}
+			return w.WriteSimpleString("OK")
+
+		case "createconsumer":
+			n, err := db.StreamConsumerCreate(key, grp, args[3])
+			if err != nil {
+				return err
+			}
+			return w.WriteInt(n)
+		}
+
+		n, err := db.StreamConsumerDelete(key, grp, args[3])
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"XREADGROUP": func(db *SingleStore, w Writer, c Command) error {
+		raw := commandSlice(c, 1, c.ArgCount())
+		if len(raw) < 6 {
+			return ReplyError("ERR wrong number of arguments for 'xreadgroup' command")
+		}
+		args, err := parseStreamRead("xreadgroup", raw, true)
+		if err != nil {
+			return err
+		}
+
+		// ">" reads new entries, and any other ID the consumer's pending
+		// entries after it
+		newOnly := make([]bool, len(args.ids))
+		starts := make([]StreamID, len(args.ids))
+		for i, arg := range args.ids {
+			switch string(arg) {
+			case ">":
+				newOnly[i] = true
+			case "$":
+				return ReplyError("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+			default:
+				if starts[i], err = parseStreamID(arg, 0); err != nil {
+					return err
+				}
+			}
+		}
+
+		// keys whose pending entries were asked for are always in the reply
+		var readFrom [][]byte
+		var results [][]StreamEntry
+		read := func() (bool, error) {
+			readFrom, results = nil, nil
+			for i, key := range args.keys {
+				var entries []StreamEntry
+				var err error
+				if newOnly[i] {
+					entries, err = db.StreamReadGroup(key, args.group, args.consumer, args.count, args.noAck)
+				} else if start, ok := starts[i].Next(); ok {
+					entries, err = db.StreamReadPending(key, args.group, args.consumer, start, args.count)
+				}
+				if err != nil {
+					return false, err
+				}
+				if len(entries) > 0 || !newOnly[i] {
+					readFrom = append(readFrom, key)
+					results = append(results, entries)
+				}
+			}
+			return len(results) > 0, nil
+		}
+
+		if args.blocking {
+			_, err = db.block(args.keys, args.timeout, read)
+		} else {
+			_, err = read()
+		}
+		if err != nil {
+			return err
+		}
+		return writeStreamReads(w, readFrom, results)
+	},
+
+	"XACK": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 3 {
+			return ReplyError("ERR wrong number of arguments for 'xack' command")
+		}
+		key, grp, args := args[0], args[1], args[2:]
+		ids := make([]StreamID, len(args))
+		for i, arg := range args {
+			var err error
+			if ids[i], err = parseStreamID(arg, 0); err != nil {
+				return err
+			}
+		}
+
+		n, err := db.StreamAck(key, grp, ids)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"XPENDING": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 2 {
+			return ReplyError("ERR wrong number of arguments for 'xpending' command")
+		}
+		key, grp, args := args[0], args[1], args[2:]
+
+		if len(args) == 0 {
+			summary, err := db.StreamPendingSummary(key, grp)
+			if err != nil {
+				return err
+			}
+			return writeStreamPendingSummary(w, summary)
+		}
+
+		var minIdle int64
+		if strings.ToUpper(string(args[0])) == "IDLE" {
+			if len(args) < 2 {
+				return ErrSyntax
+			}
+			var err error
+			if minIdle, err = parseInt(args[1]); err != nil {
+				return err
+			}
+			args = args[2:]
+		}
+		if len(args) < 3 || len(args) > 4 {
+			return ErrSyntax
+		}
+		start, err := parseStreamBound(args[0], true)
+		if err != nil {
+			return err
+		}
+		end, err := parseStreamBound(args[1], false)
+		if err != nil {
+			return err
+		}
+		count, err := parseInt(args[2])
+		if err != nil {
+			return err
+		}
+		if count < 0 {
+			count = 0
+		}
+		var consumer []byte
+		if len(args) == 4 {
+			consumer = args[3]
+		}
+
+		entries, err := db.StreamPending(key, grp, minIdle, start, end, count, consumer)
+		if err != nil {
+			return err
+		}
+		if err := writeArrayLen(w, len(entries)); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := w.WriteObjects(e.StreamID.String(), e.Consumer, e.Idle, e.Deliveries); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+
+	"XCLAIM": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 5 {
+			return ReplyError("ERR wrong number of arguments for 'xclaim' command")
+		}
+		key, grp, consumer, rawMinIdle, args := args[0], args[1], args[2], args[3], args[4:]
+		minIdle, err := parseMinIdle(rawMinIdle, "xclaim")
+		if err != nil {
+			return err
+		}
+
+		// the IDs run up to the first option
+		var ids []StreamID
+		for len(args) > 0 {
+			id, err := parseStreamID(args[0], 0)
+			if err != nil {
+				break
+			}
+			ids = append(ids, id)
+			args = args[1:]
+		}
+
+		var claim StreamClaim
+		for i := 0; i < len(args); i++ {
+			opt := strings.ToUpper(string(args[i]))
+			switch opt {
+			case "FORCE":
+				claim.Force = true
+				continue
+			case "JUSTID":
+				claim.JustID = true
+				continue
+			case "IDLE", "TIME", "RETRYCOUNT", "LASTID":
+				if i+1 >= len(args) {
+					return ErrSyntax
+				}
+			default:
+				return ReplyError("ERR Unrecognized XCLAIM option '" + string(args[i]) + "'")
+			}
+
+			i++
+			if opt == "LASTID" {
+				id, err := parseStreamID(args[i], 0)
+				if err != nil {
+					return err
+				}
+				claim.LastID = &id
+				continue
+			}
+			n, err := parseInt(args[i])
+			if err != nil {
+				return ReplyError("ERR Invalid " + opt + " option argument for XCLAIM")
+			}
+			switch opt {
+			case "IDLE":
+				if n < 0 {
+					n = 0
+				}
+				claim.Idle = &n
+			case "TIME":
+				if n < 0 {
+					n = 0
+				}
+				t := uint64(n)
+				claim.Time = &t
+			case "RETRYCOUNT":
+				claim.Deliveries = &n
+			}
+		}
+
+		entries, err := db.StreamClaim(key, grp, consumer, minIdle, ids, claim)
+		if err != nil {
+			return err
+		}
+		if claim.JustID {
+			return w.WriteBulks(streamEntryIDs(entries)...)
+		}
+		return writeStreamEntries(w, entries)
+	},
+
+	"XAUTOCLAIM": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 5 {
+			return ReplyError("ERR wrong number of arguments for 'xautoclaim' command")
+		}
+		key, grp, consumer := args[0], args[1], args[2]
+		rawMinIdle, rawStart, opts := args[3], args[4], args[5:]
+		minIdle, err := parseMinIdle(rawMinIdle, "xautoclaim")
+		if err != nil {
+			return err
+		}
+		start, err := parseStreamBound(rawStart, true)
+		if err != nil {
+			return err
+		}
+
+		count := int64(100)
+		var justID bool
+		for i := 0; i < len(opts); i++ {
+			switch strings.ToUpper(string(opts[i])) {
+			case "COUNT":
+				if i+1 >= len(opts) {
+					return ErrSyntax
+				}
+				i++
+				if count, err = parseInt(opts[i]); err != nil {
+					return err
+				}
+				// at most ten times as many entries are looked at
+				if count < 1 || count > math.MaxInt64/10-1 {
+					return ReplyError("ERR COUNT must be > 0")
+				}
+			case "JUSTID":
+				justID = true
+			default:
+				return ErrSyntax
+			}
+		}
+
+		next, entries, deleted, err := db.StreamAutoClaim(key, grp, consumer, minIdle, start, count, justID)
+		if err != nil {
+			return err
+		}
+		if err := writeArrayLen(w, 3); err != nil {
+			return err
+		}
+		if err := w.WriteBulkString(next.String()); err != nil {
+			return err
+		}
+		if justID {
+			err = w.WriteBulks(streamEntryIDs(entries)...)
+		} else {
+			err = writeStreamEntries(w, entries)
+		}
+		if err != nil {
+			return err
+		}
+		deletedIDs := make([][]byte, len(deleted))
+		for i, id := range deleted {
+			deletedIDs[i] = []byte(id.String())
+		}
+		return w.WriteBulks(deletedIDs...)
+	},
+
+	"XINFO": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'xinfo' command")
+		}
+		sub := strings.ToLower(string(args[0]))
+		arity := map[string]int{"stream": 2, "groups": 2, "consumers": 3}
+		want, ok := arity[sub]
+		if !ok {
+			return ReplyError("ERR unknown subcommand '" + string(args[0]) + "'. Try XINFO HELP.")
+		}
+		if len(args) != want {
+			return ReplyError("ERR wrong number of arguments for 'xinfo|" + sub + "' command")
+		}
+		key := args[1]
+
+		last, ok, err := db.StreamLastID(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ReplyError("ERR no such key")
+		}
+		groups, err := db.StreamGroups(key)
+		if err != nil {
+			return err
+		}
+
+		switch sub {
+		case "stream":
+			return writeStreamInfo(db, w, key, last, len(groups))
+
+		case "groups":
+			if err := writeArrayLen(w, len(groups)); err != nil {
+				return err
+			}
+			for _, g := range groups {
+				err := w.WriteObjects(
+					"name", g.Name,
+					"consumers", g.Consumers,
+					"pending", g.Pending,
+					"last-delivered-id", g.LastDelivered.String())
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		grp := args[2]
+		found := false
+		for _, g := range groups {
+			found = found || bytes.Equal(g.Name, grp)
+		}
+		if !found {
+			return ReplyError("NOGROUP No such consumer group '" + string(grp) + "' for key name '" + string(key) + "'")
+		}
+		consumers, err := db.StreamConsumers(key, grp)
+		if err != nil {
+			return err
+		}
+		if err := writeArrayLen(w, len(consumers)); err != nil {
+			return err
+		}
+		for _, consumer := range consumers {
+			err := w.WriteObjects("name", consumer.Name, "pending", consumer.Pending, "idle", consumer.Idle)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+
+	"PFADD": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'pfadd' command")
+		}
+
+		changed, err := db.HLLAdd(args[0], args[1:])
+		if err != nil {
+			return err
+		}
+		if changed {
+			return w.WriteInt(1)
+		}
+		return w.WriteInt(0)
+	},
+
+	"PFCOUNT": func(db *SingleStore, w Writer, c Command) error {
+		keys := commandSlice(c, 1, c.ArgCount())
+		if len(keys) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'pfcount' command")
+		}
+
+		n, err := db.HLLCount(keys...)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(int64(n))
+	},
+
+	"PFMERGE": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'pfmerge' command")
+		}
+
+		err := db.HLLMerge(args[0], args[1:]...)
+		if err != nil {
+			return err
+		}
+		return w.WriteSimpleString("OK")
+	},
+
+	"GEOADD": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 4 {
+			return ReplyError("ERR wrong number of arguments for 'geoadd' command")
+		}
+		key, args := args[0], args[1:]
+
+		var flags ZAddFlags
+	options:
+		for len(args) > 0 {
+			switch strings.ToUpper(string(args[0])) {
+			case "NX":
+				flags.NX = true
+			case "XX":
+				flags.XX = true
+			case "CH":
+				flags.CH = true
+			default:
+				break options
+			}
+			args = args[1:]
+		}
+
+		if len(args) == 0 || len(args)%3 != 0 {
+			return ReplyError("ERR syntax error. Try GEOADD key [x1] [y1] [name1] [x2] [y2] [name2] ... ")
+		}
+		if flags.NX && flags.XX {
+			return ReplyError("ERR XX and NX options at the same time are not compatible")
+		}
+
+		members := make([]GeoMember, 0, len(args)/3)
+		for i := 0; i < len(args); i += 3 {
+			p, err := parseGeoPoint(args[i], args[i+1])
+			if err != nil {
+				return err
+			}
+			members = append(members, GeoMember{Member: args[i+2], GeoPoint: p})
+		}
+
+		n, err := db.GeoAdd(key, members, flags)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"GEOPOS": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'geopos' command")
+		}
+
+		points, err := db.GeoPos(args[0], args[1:])
+		if err != nil {
+			return err
+		}
+		if err := writeArrayLen(w, len(points)); err != nil {
+			return err
+		}
+		for _, p := range points {
+			if p == nil {
+				err = w.WriteBulks()
+			} else {
+				err = w.WriteBulks(formatGeoCoordinate(p.Lon), formatGeoCoordinate(p.Lat))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+
+	"GEODIST": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) != 3 && len(args) != 4 {
+			return ReplyError("ERR wrong number of arguments for 'geodist' command")
+		}
+		unit := 1.0
+		if len(args) == 4 {
+			var err error
+			if unit, err = parseGeoUnit(args[3]); err != nil {
+				return err
+			}
+		}
+
+		dist, ok, err := db.GeoDist(args[0], args[1], args[2])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return w.WriteBulk(nil)
+		}
+		return w.WriteBulkString(formatGeoDistance(dist, unit))
+	},
+
+	"GEOHASH": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'geohash' command")
+		}
+
+		points, err := db.GeoPos(args[0], args[1:])
+		if err != nil {
+			return err
+		}
+		out := make([][]byte, len(points))
+		for i, p := range points {
+			if p != nil {
+				out[i] = []byte(geoHashString(*p))
+			}
+		}
+		return w.WriteBulks(out...)
+	},
+
+	"GEOSEARCH": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'geosearch' command")
+		}
+		key := args[0]
+
+		search, err := parseGeoSearch(db, "GEOSEARCH", key, args[1:], false)
+		if err != nil {
+			return err
+		}
+		members, err := db.GeoSearch(key, &search.area)
+		if err != nil {
+			return err
+		}
+		return writeGeoSearch(w, members, search)
+	},
+
+	"GEOSEARCHSTORE": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 2 {
+			return ReplyError("ERR wrong number of arguments for 'geosearchstore' command")
+		}
+		dest, key := args[0], args[1]
+
+		search, err := parseGeoSearch(db, "GEOSEARCHSTORE", key, args[2:], true)
+		if err != nil {
+			return err
+		}
+		unit := 0.0
+		if search.storeDist {
+			unit = search.unit
+		}
+		n, err := db.GeoSearchStore(dest, key, &search.area, unit)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"JSON.SET": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 3 {
+			return ReplyError("ERR wrong number of arguments for 'json.set' command")
+		}
+		path, err := parseJSONPath(string(args[1]))
+		if err != nil {
+			return err
+		}
+		v, err := parseJSONValue(args[2])
+		if err != nil {
+			return err
+		}
+
+		var nx, xx bool
+		for _, arg := range args[3:] {
+			switch strings.ToUpper(string(arg)) {
+			case "NX":
+				nx = true
+			case "XX":
+				xx = true
+			default:
+				return ErrSyntax
+			}
+		}
+		if nx && xx {
+			return ErrSyntax
+		}
+
+		ok, err := db.JSONSet(args[0], path, v, nx, xx)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return w.WriteBulk(nil)
+		}
+		return w.WriteSimpleString("OK")
+	},
+
+	"JSON.GET": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) == 0 {
+			return ReplyError("ERR wrong number of arguments for 'json.get' command")
+		}
+
+		var indent, newline, space string
+		var paths []JSONPath
+		for i := 1; i < len(args); i++ {
+			opt := strings.ToUpper(string(args[i]))
+			if opt == "INDENT" || opt == "NEWLINE" || opt == "SPACE" {
+				if i+1 == len(args) {
+					return ErrSyntax
+				}
+				i++
+				switch opt {
+				case "INDENT":
+					indent = string(args[i])
+				case "NEWLINE":
+					newline = string(args[i])
+				case "SPACE":
+					space = string(args[i])
+				}
+				continue
+			}
+
+			path, err := parseJSONPath(string(args[i]))
+			if err != nil {
+				return err
+			}
+			paths = append(paths, path)
+		}
+		if len(paths) == 0 {
+			paths = append(paths, JSONPath{Raw: ".", Legacy: true})
+		}
+
+		values, ok, err := db.JSONGet(args[0], paths)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return w.WriteBulk(nil)
+		}
+
+		var out bytes.Buffer
+		if len(paths) == 1 {
+			v, err := jsonPathReply(paths[0], values[0])
+			if err != nil {
+				return err
+			}
+			out.Write(v)
+		} else {
+			// several paths reply with an object of the values of each
+			out.WriteByte('{')
+			for i, path := range paths {
+				v, err := jsonPathReply(path, values[i])
+				if err != nil {
+					return err
+				}
+				name, err := json.Marshal(path.Raw)
+				if err != nil {
+					return err
+				}
+				if i > 0 {
+					out.WriteByte(',')
+				}
+				out.Write(name)
+				out.WriteByte(':')
+				out.Write(v)
+			}
+			out.WriteByte('}')
+		}
+		return w.WriteBulk(formatJSON(out.Bytes(), indent, newline, space))
+	},
+
+	"JSON.MGET": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 2 {
+			return ReplyError("ERR wrong number of arguments for 'json.mget' command")
+		}
+		keys := args[:len(args)-1]
+		path, err := parseJSONPath(string(args[len(args)-1]))
+		if err != nil {
+			return err
+		}
+
+		out := make([][]byte, len(keys))
+		for i, k := range keys {
+			values, ok, err := db.JSONGet(k, []JSONPath{path})
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			// a legacy path missing from one of the documents isn't an
+			// error, it just gives a nil for that key
+			if v, err := jsonPathReply(path, values[0]); err == nil {
+				out[i] = v
+			}
+		}
+		return w.WriteBulks(out...)
+	},
+
+	"JSON.DEL": func(db *SingleStore, w Writer, c Command) error {
+		path, err := parseJSONPathArg(c, "json.del", "$")
+		if err != nil {
+			return err
+		}
+
+		n, err := db.JSONDelete(c.Get(1), path)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	},
+
+	"JSON.TYPE": func(db *SingleStore, w Writer, c Command) error {
+		path, err := parseJSONPathArg(c, "json.type", ".")
+		if err != nil {
+			return err
+		}
+
+		values, ok, err := db.JSONGet(c.Get(1), []JSONPath{path})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return w.WriteBulk(nil)
+		}
+		if path.Legacy {
+			if len(values[0]) == 0 {
+				return w.WriteBulk(nil)
+			}
+			return w.WriteSimpleString(jsonType(values[0][0]))
+		}
+		types := make([]string, len(values[0]))
+		for i, v := range values[0] {
+			types[i] = jsonType(v)
+		}
+		return w.WriteBulkStrings(types)
+	},
+
+	"JSON.NUMINCRBY": func(db *SingleStore, w Writer, c Command) error {
+		if c.ArgCount() != 4 {
+			return ReplyError("ERR wrong number of arguments for 'json.numincrby' command")
+		}
+		path, err := parseJSONPath(string(c.Get(2)))
+		if err != nil {
+			return err
+		}
+		by, err := parseJSONValue(c.Get(3))
+		if err != nil {
+			return err
+		}
+		if t := jsonType(by); t != "integer" && t != "number" {
+			return ErrJSONValue
+		}
+
+		values, err := db.JSONNumIncrBy(c.Get(1), path, by)
+		if err != nil {
+			return err
+		}
+		if path.Legacy {
+			if len(values) == 0 || values[0] == nil {
+				return jsonPathTypeError(path, "a number")
+			}
+			return w.WriteBulk(values[0])
+		}
+
+		out := make([][]byte, len(values))
+		for i, v := range values {
+			out[i] = v
+			if v == nil {
+				out[i] = []byte("null")
+			}
+		}
+		return w.WriteBulk(append(append([]byte("["), bytes.Join(out, []byte(","))...), ']'))
+	},
+
+	"JSON.STRAPPEND": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) != 2 && len(args) != 3 {
+			return ReplyError("ERR wrong number of arguments for 'json.strappend' command")
+		}
+		path := JSONPath{Raw: ".", Legacy: true}
+		if len(args) == 3 {
+			var err error
+			if path, err = parseJSONPath(string(args[1])); err != nil {
+				return err
+			}
+		}
+		var str string
+		if err := json.Unmarshal(args[len(args)-1], &str); err != nil {
+			return ErrJSONValue
+		}
+
+		lengths, err := db.JSONStrAppend(args[0], path, str)
+		if err != nil {
+			return err
+		}
+		return writeJSONLengths(w, path, lengths, "a string")
+	},
+
+	"JSON.ARRAPPEND": func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 3 {
+			return ReplyError("ERR wrong number of arguments for 'json.arrappend' command")
+		}
+		path, err := parseJSONPath(string(args[1]))
+		if err != nil {
+			return err
+		}
+		values := make([]json.RawMessage, len(args)-2)
+		for i, arg := range args[2:] {
+			if values[i], err = parseJSONValue(arg); err != nil {
+				return err
+			}
+		}
+
+		lengths, err := db.JSONArrAppend(args[0], path, values)
+		if err != nil {
+			return err
+		}
+		return writeJSONLengths(w, path, lengths, "an array")
+	},
+
+	"JSON.ARRLEN": func(db *SingleStore, w Writer, c Command) error {
+		path, err := parseJSONPathArg(c, "json.arrlen", ".")
+		if err != nil {
+			return err
+		}
+
+		values, ok, err := db.JSONGet(c.Get(1), []JSONPath{path})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return w.WriteBulk(nil)
+		}
+		lengths := make([]*int64, len(values[0]))
+		for i, v := range values[0] {
+			if items, ok := jsonElements(v); ok {
+				n := int64(len(items))
+				lengths[i] = &n
+			}
+		}
+		return writeJSONLengths(w, path, lengths, "an array")
+	},
+
+	"JSON.OBJKEYS": func(db *SingleStore, w Writer, c Command) error {
+		path, err := parseJSONPathArg(c, "json.objkeys", ".")
+		if err != nil {
+			return err
+		}
+
+		values, ok, err := db.JSONGet(c.Get(1), []JSONPath{path})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return w.WriteBulk(nil)
+		}
+		if path.Legacy {
+			if len(values[0]) == 0 {
+				return jsonPathTypeError(path, "an object")
+			}
+			keys, _, ok := jsonMembers(values[0][0])
+			if !ok {
+				return jsonPathTypeError(path, "an object")
+			}
+			return w.WriteBulkStrings(keys)
+		}
+
+		if err := writeArrayLen(w, len(values[0])); err != nil {
+			return err
+		}
+		for _, v := range values[0] {
+			// values which aren't objects have a null array of keys
+			keys, _, _ := jsonMembers(v)
+			if err := w.WriteBulkStrings(keys); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+func listPushHandler(name string, left, existing bool) CommandHandler {
+	return func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		values := commandSlice(c, 2, c.ArgCount())
+		if len(values) == 0 {
+			return ReplyError("ERR wrong number of arguments for '" + name + "' command")
+		}
+
+		n, err := db.ListPush(key, values, left, existing)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	}
+}
+
+func listPopHandler(left bool) CommandHandler {
+	return func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		if c.ArgCount() < 3 {
+			out, err := db.ListPop(key, 1, left)
+			if err != nil {
+				return err
+			}
+			if len(out) == 0 {
+				return w.WriteBulk(nil)
+			}
+			return w.WriteBulk(out[0])
+		}
+
+		count, err := parseInt(c.Get(2))
+		if err != nil {
+			return err
+		}
+		if count < 0 {
+			return ErrNotPositive
+		}
+
+		out, err := db.ListPop(key, count, left)
+		if err != nil {
+			return err
+		}
+		if len(out) == 0 {
+			return w.WriteBulks()
+		}
+		return w.WriteBulks(out...)
+	}
+}
+
+// blockingPopHandler handles BLPOP and BRPOP, which pop a single element from
+// the first non-empty list, waiting for one if they are all empty.
+func blockingPopHandler(name string, left bool) CommandHandler {
+	return func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 2 {
+			return ReplyError("ERR wrong number of arguments for '" + name + "' command")
+		}
+		keys := make([][]byte, len(args)-1)
+		for i := range keys {
+			keys[i] = args[i]
+		}
+		timeout, err := parseTimeout(args[len(args)-1])
+		if err != nil {
+			return err
+		}
+
+		var key []byte
+		var out [][]byte
+		ok, err := db.block(keys, timeout, func() (bool, error) {
+			var err error
+			key, out, err = db.ListMultiPop(keys, 1, left)
+			return out != nil, err
+		})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return w.WriteBulks()
+		}
+		return w.WriteBulks([]byte(key), out[0])
+	}
+}
+
+// parseMultiPop parses the arguments of LMPOP, starting at numkeys.
+func parseMultiPop(args [][]byte) ([][]byte, bool, int64, error) {
+	if len(args) < 1 {
+		return nil, false, 0, ErrSyntax
+	}
+	numKeys, err := parseInt(args[0])
+	if err != nil {
+		return nil, false, 0, err
+	}
+	if numKeys < 1 {
+		return nil, false, 0, ReplyError("ERR numkeys should be greater than 0")
+	}
+	if numKeys > int64(len(args)-2) {
+		return nil, false, 0, ErrSyntax
+	}
+
+	keys := make([][]byte, numKeys)
+	for i := range keys {
+		keys[i] = args[i+1]
+	}
+	opts := args[numKeys+1:]
+
+	left, err := parseListDirection(opts[0])
+	if err != nil {
+		return nil, false, 0, err
+	}
+
+	count := int64(1)
+	switch {
+	case len(opts) == 3 && strings.ToUpper(string(opts[1])) == "COUNT":
+		if count, err = parseInt(opts[2]); err != nil {
+			return nil, false, 0, err
+		}
+		if count < 1 {
+			return nil, false, 0, ReplyError("ERR count should be greater than 0")
+		}
+	case len(opts) != 1:
+		return nil, false, 0, ErrSyntax
+	}
+	return keys, left, count, nil
+}
+
+// writeMultiPop writes the reply of LMPOP, a nil array if nothing was popped
+// or else the key followed by an array of the popped elements.
+func writeMultiPop(w Writer, key []byte, out [][]byte) error {
+	if out == nil {
+		return w.WriteBulks()
+	}
+	if err := writeArrayLen(w, 2); err != nil {
+		return err
+	}
+	if err := w.WriteBulk(key); err != nil {
+		return err
+	}
+	return w.WriteBulks(out...)
+}
+
+// streamRangeHandler handles XRANGE and XREVRANGE, which take their bounds in
+// opposite orders.
+func streamRangeHandler(name string, rev bool) CommandHandler {
+	return func(db *SingleStore, w Writer, c Command) error {
+		args := commandSlice(c, 1, c.ArgCount())
+		if len(args) < 3 {
+			return ReplyError("ERR wrong number of arguments for '" + name + "' command")
+		}
+		key, rawStart, rawEnd, opts := args[0], args[1], args[2], args[3:]
+		if rev {
+			rawStart, rawEnd = rawEnd, rawStart
+		}
+
+		count := int64(-1)
+		switch {
+		case len(opts) == 2 && strings.ToUpper(string(opts[0])) == "COUNT":
+			var err error
+			if count, err = parseInt(opts[1]); err != nil {
+				return err
+			}
+			if count < 0 {
+				count = 0
+			}
+		case len(opts) != 0:
+			return ErrSyntax
+		}
+
+		start, err := parseStreamBound(rawStart, true)
+		if err != nil {
+			return err
+		}
+		end, err := parseStreamBound(rawEnd, false)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return w.WriteBulks()
+		}
+
+		entries, err := db.StreamRange(key, start, end, rev, count)
+		if err != nil {
+			return err
+		}
+		return writeStreamEntries(w, entries)
+	}
+}
+
+// parseStreamID parses a stream ID of the form ms-seq, where seq defaults to
+// missingSeq.
+func parseStreamID(arg []byte, missingSeq uint64) (StreamID, error) {
+	rawMs, rawSeq, hasSeq := strings.Cut(string(arg), "-")
+	ms, err := strconv.ParseUint(rawMs, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrStreamID
+	}
+	if !hasSeq {
+		return StreamID{ms, missingSeq}, nil
+	}
+	seq, err := strconv.ParseUint(rawSeq, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrStreamID
+	}
+	return StreamID{ms, seq}, nil
+}
+
+// parseStreamAddID parses the ID given to XADD, returning a nil ms for "*"
+// and a nil seq for "*" or "ms-*", which are then generated.
+func parseStreamAddID(arg []byte) (*uint64, *uint64, error) {
+	if string(arg) == "*" {
+		return nil, nil, nil
+	}
+	if strings.HasSuffix(string(arg), "-*") {
+		ms, err := strconv.ParseUint(strings.TrimSuffix(string(arg), "-*"), 10, 64)
+		if err != nil {
+			return nil, nil, ErrStreamID
+		}
+		return &ms, nil, nil
+	}
+
+	id, err := parseStreamID(arg, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if id == (StreamID{}) {
+		return nil, nil, ReplyError("ERR The ID specified in XADD must be greater than 0-0")
+	}
+	return &id.Ms, &id.Seq, nil
+}
+
+// parseStreamBound parses an XRANGE bound: "-", "+", or an ID where a missing
+// sequence number covers the whole millisecond, optionally prefixed with "("
+// to exclude it.
+func parseStreamBound(arg []byte, start bool) (StreamID, error) {
+	exclusive := len(arg) > 1 && arg[0] == '('
+	if exclusive {
+		arg = arg[1:]
+	}
+
+	var id StreamID
+	switch string(arg) {
+	case "-":
+	case "+":
+		id = MaxStreamID
+	default:
+		missingSeq := uint64(0)
+		if !start {
+			missingSeq = math.MaxUint64
+		}
+		var err error
+		if id, err = parseStreamID(arg, missingSeq); err != nil {
+			return id, err
+		}
+	}
+	if !exclusive {
+		return id, nil
+	}
+
+	if start {
+		next, ok := id.Next()
+		if !ok {
+			return id, ReplyError("ERR invalid start ID for the interval")
+		}
+		return next, nil
+	}
+	prev, ok := id.Prev()
+	if !ok {
+		return id, ReplyError("ERR invalid end ID for the interval")
+	}
+	return prev, nil
+}
+
+// parseStreamTrim parses the MAXLEN|MINID [=|~] threshold [LIMIT count]
+// trimming options of XADD and XTRIM, returning how many arguments they took.
+// Trimming is always exact, so ~ only permits LIMIT.
+func parseStreamTrim(args [][]byte) (*StreamTrim, int, error) {
+	trim := &StreamTrim{ByMinID: strings.ToUpper(string(args[0])) == "MINID"}
+	i := 1
+	approx := false
+	if i < len(args) && (string(args[i]) == "=" || string(args[i]) == "~") {
+		approx = string(args[i]) == "~"
+		i++
+	}
+	if i >= len(args) {
+		return nil, 0, ErrSyntax
+	}
+
+	if trim.ByMinID {
+		var err error
+		if trim.MinID, err = parseStreamID(args[i], 0); err != nil {
+			return nil, 0, err
+		}
+	} else {
+		var err error
+		if trim.MaxLen, err = parseInt(args[i]); err != nil {
+			return nil, 0, err
+		}
+		if trim.MaxLen < 0 {
+			return nil, 0, ReplyError("ERR The MAXLEN argument must be >= 0.")
+		}
+	}
+	i++
+
+	if i < len(args) && strings.ToUpper(string(args[i])) == "LIMIT" {
+		if i+1 >= len(args) {
+			return nil, 0, ErrSyntax
+		}
+		if !approx {
+			return nil, 0, ReplyError("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		var err error
+		if trim.Limit, err = parseInt(args[i+1]); err != nil {
+			return nil, 0, err
+		}
+		if trim.Limit < 0 {
+			return nil, 0, ReplyError("ERR The LIMIT argument must be >= 0.")
+		}
+		i += 2
+	}
+	return trim, i, nil
+}
+
+// writeStreamEntries writes an array of stream entries.
+func writeStreamEntries(w Writer, entries []StreamEntry) error {
+	if err := writeArrayLen(w, len(entries)); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := writeStreamEntry(w, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeStreamEntry writes a stream entry as an array of its ID and its fields
+// and values, which are a nil array for a pending entry since deleted.
+func writeStreamEntry(w Writer, e StreamEntry) error {
+	if err := writeArrayLen(w, 2); err != nil {
+		return err
+	}
+	if err := w.WriteBulkString(e.ID.String()); err != nil {
+		return err
+	}
+	return w.WriteBulks(e.Fields...)
+}
+
+// streamReadArgs are the arguments of XREAD and XREADGROUP.
+type streamReadArgs struct {
+	group, consumer []byte
+	count           int64
+	timeout         time.Duration
+	blocking        bool
+	noAck           bool
+	keys, ids       [][]byte
+}
+
+// parseStreamRead parses the arguments of XREAD, or of XREADGROUP if group is
+// set, following the command name.
+func parseStreamRead(name string, args [][]byte, group bool) (streamReadArgs, error) {
+	out := streamReadArgs{count: -1}
+	streams := -1
+opts:
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		switch opt {
+		case "COUNT", "BLOCK":
+			if i+1 >= len(args) {
+				return out, ErrSyntax
+			}
+			i++
+			if opt == "BLOCK" {
+				var err error
+				if out.timeout, err = parseMillisTimeout(args[i]); err != nil {
+					return out, err
+				}
+				out.blocking = true
+				continue
+			}
+			n, err := parseInt(args[i])
+			if err != nil {
+				return out, err
+			}
+			if n > 0 {
+				out.count = n
+			}
+		case "GROUP":
+			if !group {
+				return out, ReplyError("ERR The GROUP option is only supported by XREADGROUP. You called XREAD instead.")
+			}
+			if i+2 >= len(args) {
+				return out, ErrSyntax
+			}
+			out.group, out.consumer = args[i+1], args[i+2]
+			i += 2
+		case "NOACK":
+			if !group {
+				return out, ReplyError("ERR The NOACK option is only supported by XREADGROUP. You called XREAD instead.")
+			}
+			out.noAck = true
+		case "STREAMS":
+			streams = i + 1
+			break opts
+		default:
+			return out, ErrSyntax
+		}
+	}
+	if streams < 0 {
+		return out, ErrSyntax
+	}
+	if group && out.group == nil {
+		return out, ReplyError("ERR Missing GROUP option for XREADGROUP")
+	}
+
+	args = args[streams:]
+	if len(args) == 0 || len(args)%2 != 0 {
+		return out, ReplyError("ERR Unbalanced '" + name + "' list of streams: for each stream key an ID or '$' must be specified.")
+	}
+	out.keys, out.ids = args[:len(args)/2], args[len(args)/2:]
+	return out, nil
+}
+
+// writeStreamReads writes the reply of XREAD and XREADGROUP: an array of the
+// keys read from along with their entries, or a nil array if there are none.
+func writeStreamReads(w Writer, keys [][]byte, results [][]StreamEntry) error {
+	if len(results) == 0 {
+		return w.WriteBulks()
+	}
+	if err := writeArrayLen(w, len(results)); err != nil {
+		return err
+	}
+	for i, entries := range results {
+		if err := writeArrayLen(w, 2); err != nil {
+			return err
+		}
+		if err := w.WriteBulk(keys[i]); err != nil {
+			return err
+		}
+		if err := writeStreamEntries(w, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseStreamGroupID parses the ID given to XGROUP CREATE and SETID, returning
+// nil for "$", the last entry added.
+func parseStreamGroupID(arg []byte) (*StreamID, error) {
+	if string(arg) == "$" {
+		return nil, nil
+	}
+	id, err := parseStreamID(arg, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// parseMinIdle parses the min-idle-time of XCLAIM and XAUTOCLAIM, where
+// negative times count as 0.
+func parseMinIdle(arg []byte, name string) (int64, error) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, ReplyError("ERR Invalid min-idle-time argument for " + strings.ToUpper(name))
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n, nil
+}
+
+// writeStreamPendingSummary writes the reply of XPENDING without a range: the
+// number of pending entries, the first and last of their IDs, and how many
+// each consumer has.
+func writeStreamPendingSummary(w Writer, summary StreamPendingSummary) error {
+	if err := writeArrayLen(w, 4); err != nil {
+		return err
+	}
+	if err := w.WriteInt(summary.Count); err != nil {
+		return err
+	}
+	if summary.Count == 0 {
+		if err := w.WriteBulk(nil); err != nil {
+			return err
+		}
+		if err := w.WriteBulk(nil); err != nil {
+			return err
+		}
+		return w.WriteBulks()
+	}
+
+	if err := w.WriteBulkString(summary.First.String()); err != nil {
+		return err
+	}
+	if err := w.WriteBulkString(summary.Last.String()); err != nil {
+		return err
+	}
+	if err := writeArrayLen(w, len(summary.Consumers)); err != nil {
+		return err
+	}
+	for _, consumer := range summary.Consumers {
+		if err := w.WriteBulks(consumer.Name, []byte(strconv.FormatInt(consumer.Pending, 10))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeStreamInfo writes the reply of XINFO STREAM.
+func writeStreamInfo(db *SingleStore, w Writer, key []byte, last StreamID, groups int) error {
+	length, err := db.StreamLength(key)
+	if err != nil {
+		return err
+	}
+	first, err := db.StreamRange(key, StreamID{}, MaxStreamID, false, 1)
+	if err != nil {
+		return err
+	}
+	newest, err := db.StreamRange(key, StreamID{}, MaxStreamID, true, 1)
+	if err != nil {
+		return err
+	}
+
+	if err := writeArrayLen(w, 10); err != nil {
+		return err
+	}
+	if err := w.WriteBulkString("length"); err != nil {
+		return err
+	}
+	if err := w.WriteInt(length); err != nil {
+		return err
+	}
+	if err := w.WriteBulkString("last-generated-id"); err != nil {
+		return err
+	}
+	if err := w.WriteBulkString(last.String()); err != nil {
+		return err
+	}
+	if err := w.WriteBulkString("groups"); err != nil {
+		return err
+	}
+	if err := w.WriteInt(int64(groups)); err != nil {
+		return err
+	}
+	for _, entry := range []struct {
+		name    string
+		entries []StreamEntry
+	}{{"first-entry", first}, {"last-entry", newest}} {
+		if err := w.WriteBulkString(entry.name); err != nil {
+			return err
+		}
+		if len(entry.entries) == 0 {
+			err = w.WriteBulks()
+		} else {
+			err = writeStreamEntry(w, entry.entries[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamEntryIDs returns the IDs of entries, for replies with JUSTID.
+func streamEntryIDs(entries []StreamEntry) [][]byte {
+	out := make([][]byte, len(entries))
+	for i, e := range entries {
+		out[i] = []byte(e.ID.String())
+	}
+	return out
+}
+
+type geoSearchArgs struct {
+	area                          GeoSearch
+	unit                          float64
+	withCoord, withDist, withHash bool
+	storeDist                     bool
+}
+
+// parseGeoSearch parses the arguments of GEOSEARCH, or of GEOSEARCHSTORE if
+// store is set, following the source key. FROMMEMBER is resolved to the
+// location of the member in key.
+func parseGeoSearch(db *SingleStore, name string, key []byte, args [][]byte, store bool) (geoSearchArgs, error) {
+	out := geoSearchArgs{unit: 1}
+	var from []byte
+	fromLonLat, byRadius, unsorted := false, false, false
+
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		// the number of arguments the option takes
+		want := map[string]int{"FROMMEMBER": 1, "FROMLONLAT": 2, "BYRADIUS": 2, "BYBOX": 3, "COUNT": 1}[opt]
+		if i+want >= len(args) {
+			return out, ErrSyntax
+		}
+		opts := args[i+1 : i+1+want]
+		i += want
+
+		var err error
+		switch opt {
+		case "FROMMEMBER":
+			from = opts[0]
+		case "FROMLONLAT":
+			out.area.Center, err = parseGeoPoint(opts[0], opts[1])
+			fromLonLat = true
+		case "BYRADIUS":
+			if out.area.Radius, err = parseScore(opts[0]); err != nil {
+				return out, err
+			}
+			if out.area.Radius < 0 {
+				return out, ReplyError("ERR radius cannot be negative")
+			}
+			out.unit, err = parseGeoUnit(opts[1])
+			byRadius = true
+		case "BYBOX":
+			if out.area.Width, err = parseScore(opts[0]); err != nil {
+				return out, err
+			}
+			if out.area.Height, err = parseScore(opts[1]); err != nil {
+				return out, err
+			}
+			if out.area.Width < 0 || out.area.Height < 0 {
+				return out, ReplyError("ERR height or width cannot be negative")
+			}
+			out.unit, err = parseGeoUnit(opts[2])
+			out.area.Box = true
+		case "ASC", "DESC":
+			out.area.Order = strings.ToLower(opt)
+		case "COUNT":
+			if out.area.Count, err = parseInt(opts[0]); err != nil {
+				return out, err
+			}
+			if out.area.Count <= 0 {
+				return out, ReplyError("ERR COUNT must be > 0")
+			}
+			if i+1 < len(args) && strings.ToUpper(string(args[i+1])) == "ANY" {
+				unsorted = true
+				i++
+			}
+		case "ANY":
+			return out, ReplyError("ERR the ANY argument requires COUNT argument")
+		case "WITHCOORD", "WITHDIST", "WITHHASH":
+			if store {
+				return out, ErrSyntax
+			}
+			out.withCoord = out.withCoord || opt == "WITHCOORD"
+			out.withDist = out.withDist || opt == "WITHDIST"
+			out.withHash = out.withHash || opt == "WITHHASH"
+		case "STOREDIST":
+			if !store {
+				return out, ErrSyntax
+			}
+			out.storeDist = true
+		default:
+			return out, ErrSyntax
+		}
+		if err != nil {
+			return out, err
+		}
+	}
+
+	if (from == nil) == !fromLonLat {
+		return out, ReplyError("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + name)
+	}
+	if byRadius == out.area.Box {
+		return out, ReplyError("ERR exactly one of BYRADIUS and BYBOX can be specified for " + name)
+	}
+
+	// the search itself is in meters
+	out.area.Radius *= out.unit
+	out.area.Width *= out.unit
+	out.area.Height *= out.unit
+	// COUNT alone returns the nearest members
+	if out.area.Count > 0 && out.area.Order == "" && !unsorted {
+		out.area.Order = "asc"
+	}
+
+	if from != nil {
+		points, err := db.GeoPos(key, [][]byte{from})
+		if err != nil {
+			return out, err
+		}
+		if points[0] == nil {
+			return out, ReplyError("ERR could not decode requested zset member")
+		}
+		out.area.Center = *points[0]
+	}
+	return out, nil
+}
+
+// writeGeoSearch writes the members found by GEOSEARCH, each as an array
+// with its distance, hash and coordinates if they were asked for.
+func writeGeoSearch(w Writer, members []GeoMember, search geoSearchArgs) error {
+	extra := 0
+	for _, with := range []bool{search.withDist, search.withHash, search.withCoord} {
+		if with {
+			extra++
+		}
+	}
+	if extra == 0 {
+		names := make([][]byte, len(members))
+		for i, m := range members {
+			names[i] = m.Member
+		}
+		return w.WriteBulks(names...)
+	}
+
+	if err := writeArrayLen(w, len(members)); err != nil {
+		return err
+	}
+	for _, m := range members {
+		if err := writeArrayLen(w, 1+extra); err != nil {
+			return err
+		}
+		if err := w.WriteBulk(m.Member); err != nil {
+			return err
+		}
+		if search.withDist {
+			if err := w.WriteBulkString(formatGeoDistance(m.Dist, search.unit)); err != nil {
+				return err
+			}
+		}
+		if search.withHash {
+			if err := w.WriteInt(geoHashScore(m.GeoPoint)); err != nil {
+				return err
+			}
+		}
+		if search.withCoord {
+			if err := w.WriteBulks(formatGeoCoordinate(m.Lon), formatGeoCoordinate(m.Lat)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseJSONPathArg parses the optional path following the key of a JSON
+// command which takes nothing else, defaulting to def.
+func parseJSONPathArg(c Command, name, def string) (JSONPath, error) {
+	switch c.ArgCount() {
+	case 2:
+		return parseJSONPath(def)
+	case 3:
+		return parseJSONPath(string(c.Get(2)))
+	}
+	return JSONPath{}, ReplyError("ERR wrong number of arguments for '" + name + "' command")
+}
+
+func parseJSONValue(arg []byte) (json.RawMessage, error) {
+	if !json.Valid(arg) {
+		return nil, ErrJSONValue
+	}
+	return json.RawMessage(arg), nil
+}
+
+// jsonPathReply returns the reply of JSON.GET for the values matched by path,
+// which is an array of them for JSONPath and the first of them, which must
+// exist, for a legacy path.
+func jsonPathReply(path JSONPath, values []json.RawMessage) (json.RawMessage, error) {
+	if path.Legacy {
+		if len(values) == 0 {
+			return nil, ReplyError("ERR Path '" + path.Raw + "' does not exist")
+		}
+		return values[0], nil
+	}
+
+	out := []byte{'['}
+	for i, v := range values {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		out = append(out, v...)
+	}
+	return append(out, ']'), nil
+}
+
+func jsonPathTypeError(path JSONPath, what string) error {
+	return ReplyError("ERR Path '" + path.Raw + "' does not exist or does not hold " + what)
+}
+
+// writeJSONLengths writes the lengths returned by the JSON commands changing
+// strings and arrays, which are an array with a nil for each value which
+// isn't one for JSONPath, or the first length for a legacy path.
+func writeJSONLengths(w Writer, path JSONPath, lengths []*int64, what string) error {
+	if path.Legacy {
+		if len(lengths) == 0 || lengths[0] == nil {
+			return jsonPathTypeError(path, what)
+		}
+		return w.WriteInt(*lengths[0])
+	}
+
+	out := make([]interface{}, len(lengths))
+	for i, n := range lengths {
+		if n != nil {
+			out[i] = *n
+		}
+	}
+	return w.WriteObjects(out...)
+}
+
+func setStoreHandler(name, op string) CommandHandler {
+	return func(db *SingleStore, w Writer, c Command) error {
+		keys := commandSlice(c, 1, c.ArgCount())
+		if len(keys) < 2 {
+			return ReplyError("ERR wrong number of arguments for '" + name + "' command")
+		}
+
+		n, err := db.SetCombineStore(keys[0], op, keys[1:]...)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	}
+}
+
+func zcombineHandler(op string) CommandHandler {
+	return func(db *SingleStore, w Writer, c Command) error {
+		raw := commandSlice(c, 1, c.ArgCount())
+		if len(raw) < 2 {
+			return ReplyError("ERR wrong number of arguments for 'z" + op + "' command")
+		}
+		args, err := parseZCombine(op, raw, true)
+		if err != nil {
+			return err
+		}
+
+		members, err := db.ZSetCombine(op, args.keys, args.weights, args.aggregate)
+		if err != nil {
+			return err
+		}
+		return w.WriteBulks(flattenZMembers(members, args.withScores)...)
+	}
+}
+
+func zcombineStoreHandler(op string) CommandHandler {
+	return func(db *SingleStore, w Writer, c Command) error {
+		raw := commandSlice(c, 1, c.ArgCount())
+		if len(raw) < 3 {
+			return ReplyError("ERR wrong number of arguments for 'z" + op + "store' command")
+		}
+		dest := raw[0]
+		args, err := parseZCombine(op, raw[1:], false)
+		if err != nil {
+			return err
+		}
+
+		n, err := db.ZSetCombineStore(dest, op, args.keys, args.weights, args.aggregate)
+		if err != nil {
+			return err
+		}
+		return w.WriteInt(n)
+	}
+}
+
+type zcombineArgs struct {
+	keys       [][]byte
+	weights    []float64
+	aggregate  string
+	withScores bool
+}
+
+// parseZCombine parses the arguments shared by ZUNION, ZINTER, ZDIFF and
+// their STORE variants, starting at numkeys.
+func parseZCombine(op string, args [][]byte, allowWithScores bool) (zcombineArgs, error) {
+	out := zcombineArgs{aggregate: "sum"}
+
+	numKeys, err := parseInt(args[0])
+	if err != nil {
+		return out, err
+	}
+	if numKeys < 1 {
+		return out, ReplyError("ERR at least 1 input key is needed for this command")
+	}
+	if numKeys > int64(len(args)-1) {
+		return out, ErrSyntax
+	}
+
+	out.keys = make([][]byte, numKeys)
+	out.weights = make([]float64, numKeys)
+	for i := range out.keys {
+		out.keys[i] = args[i+1]
+		out.weights[i] = 1
+	}
+
+	opts := args[numKeys+1:]
+	for i := 0; i < len(opts); i++ {
+		switch strings.ToUpper(string(opts[i])) {
+		case "WEIGHTS":
+			if op == "diff" || int64(len(opts)-i-1) < numKeys {
+				return out, ErrSyntax
+			}
+			for j := range out.weights {
+				weight, err := strconv.ParseFloat(string(opts[i+j+1]), 64)
+				if err != nil || math.IsNaN(weight) {
+					return out, ReplyError("ERR weight value is not a float")
+				}
+				out.weights[j] = weight
+			}
+			i += int(numKeys)
+		case "AGGREGATE":
+			if op == "diff" || i+1 >= len(opts) {
+				return out, ErrSyntax
+			}
+			out.aggregate = strings.ToLower(string(opts[i+1]))
+			if out.aggregate != "sum" && out.aggregate != "min" && out.aggregate != "max" {
+				return out, ErrSyntax
+			}
+			i++
+		case "WITHSCORES":
+			if !allowWithScores {
+				return out, ErrSyntax
+			}
+			out.withScores = true
+		default:
+			return out, ErrSyntax
+		}
+	}
+	return out, nil
+}
+
+func zrankHandler(rev bool) CommandHandler {
+	return func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		rank, ok, err := db.ZSetRank(key, c.Get(2), rev)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return w.WriteBulk(nil)
+		}
+		return w.WriteInt(rank)
+	}
+}
+
+func zpopHandler(max bool) CommandHandler {
+	return func(db *SingleStore, w Writer, c Command) error {
+		key := c.Get(1)
+		count := int64(1)
+		if c.ArgCount() > 2 {
+			var err error
+			if count, err = parseInt(c.Get(2)); err != nil {
+				return err
+			}
+			if count < 0 {
+				return ErrNotPositive
+			}
+		}
+
+		members, err := db.ZSetPop(key, count, max)
+		if err != nil {
+			return err
+		}
+		return w.WriteBulks(flattenZMembers(members, true)...)
+	}
+}
+
+// zsetRange reads a ZRANGE style range where by is "", "score" or "lex".
+// Like redis, reversed score and lex ranges are given from max to min.
+func zsetRange(db *SingleStore, key []byte, start, stop []byte, by string, rev bool, offset, count int64) ([]ZMember, error) {
+	if offset < 0 {
+		return nil, nil
+	}
+	if rev && by != "" {
+		start, stop = stop, start
+	}
+
+	switch by {
+	case "score":
+		r, err := parseScoreRange(start, stop)
+		if err != nil {
+			return nil, err
+		}
+		return db.ZSetRangeByScore(key, r, rev, offset, count)
+	case "lex":
+		r, empty, err := parseLexRange(start, stop)
+		if err != nil || empty {
+			return nil, err
+		}
+		return db.ZSetRangeByLex(key, r, rev, offset, count)
+	}
+
+	startIdx, err := parseInt(start)
+	if err != nil {
+		return nil, err
+	}
+	stopIdx, err := parseInt(stop)
+	if err != nil {
+		return nil, err
+	}
+	return db.ZSetRangeByRank(key, startIdx, stopIdx, rev)
+}
+
+// flattenZMembers returns the members, optionally interleaved with their
+// scores, as a flat list of bulk strings.
+func flattenZMembers(members []ZMember, withScores bool) [][]byte {
+	out := make([][]byte, 0, len(members)*2)
+	for _, m := range members {
+		out = append(out, m.Member)
+		if withScores {
+			out = append(out, formatScore(m.Score))
+		}
+	}
+	return out
+}
+
+// flattenHashFields returns the fields, optionally interleaved with their
+// values, as a flat list of bulk strings.
+func flattenHashFields(fields []HashField, withValues bool) [][]byte {
+	out := make([][]byte, 0, len(fields)*2)
+	for _, hf := range fields {
+		out = append(out, hf.F)
+		if withValues {
+			out = append(out, hf.V)
+		}
+	}
+	return out
+}
+
+// parseInt parses a command argument the way redis parses integers.
+func parseInt(arg []byte) (int64, error) {
+	val, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	return val, nil
+}
+
+// parseDBIndex parses the number of a logical database.
+func parseDBIndex(arg []byte) (int, error) {
+	n, err := parseInt(arg)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n >= databaseCount {
+		return 0, ErrDBIndex
+	}
+	return int(n), nil
+}
+
+// parseScore parses a sorted set score, which may be infinite but not NaN.
+func parseScore(arg []byte) (float64, error) {
+	f, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, ErrNotFloat
+	}
+	return f, nil
+}
+
+// formatScore formats a score the way redis does, using exponents only for
+// very large or small magnitudes.
+func formatScore(f float64) []byte {
+	switch {
+	case math.IsInf(f, 1):
+		return []byte("inf")
+	case math.IsInf(f, -1):
+		return []byte("-inf")
+	}
+	if exp := math.Log10(math.Abs(f)); f == 0 || (exp >= -4 && exp < 17) {
+		return strconv.AppendFloat(nil, f, 'f', -1, 64)
+	}
+	return strconv.AppendFloat(nil, f, 'g', -1, 64)
+}
+
+// parseScoreRange parses score bounds such as "1", "(1" or "-inf".
+func parseScoreRange(min, max []byte) (ScoreRange, error) {
+	var r ScoreRange
+	var err error
+	if r.Min, r.MinExclusive, err = parseScoreBound(min); err != nil {
+		return r, err
+	}
+	if r.Max, r.MaxExclusive, err = parseScoreBound(max); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+func parseScoreBound(arg []byte) (float64, bool, error) {
+	exclusive := len(arg) > 0 && arg[0] == '('
+	if exclusive {
+		arg = arg[1:]
+	}
+	f, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false, ErrScoreRange
+	}
+	return f, exclusive, nil
+}
+
+// parseLexRange parses lex bounds such as "[a", "(a", "-" or "+", reporting
+// whether the range is trivially empty.
+func parseLexRange(min, max []byte) (LexRange, bool, error) {
+	var r LexRange
+	var minInf, maxInf int
+	var err error
+	if r.Min, r.MinExclusive, minInf, err = parseLexBound(min); err != nil {
+		return r, false, err
+	}
+	if r.Max, r.MaxExclusive, maxInf, err = parseLexBound(max); err != nil {
+		return r, false, err
+	}
+	return r, minInf > 0 || maxInf < 0, nil
+}
+
+func parseLexBound(arg []byte) ([]byte, bool, int, error) {
+	switch {
+	case len(arg) == 1 && arg[0] == '-':
+		return nil, false, -1, nil
+	case len(arg) == 1 && arg[0] == '+':
+		return nil, false, 1, nil
+	case len(arg) > 0 && arg[0] == '[':
+		return arg[1:], false, 0, nil
+	case len(arg) > 0 && arg[0] == '(':
+		return arg[1:], true, 0, nil
+	}
+	return nil, false, 0, ErrLexRange
+}
+
+// parseLimit parses the offset and count following a LIMIT option.
+func parseLimit(args [][]byte) (int64, int64, error) {
+	if len(args) < 2 {
+		return 0, 0, ErrSyntax
+	}
+	offset, err := parseInt(args[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	count, err := parseInt(args[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return offset, count, nil
+}
+
+// parseBitOffset parses a bit offset for a field of the given width,
+// accepting the #N form which means N times the width.
+func parseBitOffset(arg []byte, bits int) (int64, error) {
+	mult := int64(1)
+	if len(arg) > 0 && arg[0] == '#' {
+		mult = int64(bits)
+		arg = arg[1:]
+	}
+	offset, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil || offset < 0 || offset > (1<<32-int64(bits))/mult {
+		return 0, ErrBitOffset
+	}
+	return offset * mult, nil
+}
+
+// parseBitFieldType parses a BITFIELD type such as i8 or u16.
+func parseBitFieldType(arg []byte) (bool, int, error) {
+	if len(arg) < 2 || (arg[0] != 'i' && arg[0] != 'I' && arg[0] != 'u' && arg[0] != 'U') {
+		return false, 0, ErrBitType
+	}
+	signed := arg[0] == 'i' || arg[0] == 'I'
+	bits, err := strconv.Atoi(string(arg[1:]))
+	if err != nil || bits < 1 || bits > 64 || (!signed && bits == 64) {
+		return false, 0, ErrBitType
+	}
+	return signed, bits, nil
+}
+
+type scanArgs struct {
+	cursor   uint64
+	pattern  Glob
+	count    int64
+	typ      string
+	noValues bool
+}
+
+// parseScan parses the arguments of SCAN and friends starting at the cursor.
+// extra names the option accepted besides MATCH and COUNT, if any: TYPE for
+// SCAN and NOVALUES for HSCAN.
+func parseScan(args [][]byte, extra string) (scanArgs, error) {
+	out := scanArgs{pattern: ParseGlob([]byte("*")), count: 10}
+	if len(args) == 0 {
+		return out, ErrSyntax
+	}
+
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return out, ErrInvalidCursor
+	}
+	out.cursor = cursor
+
+	opts := args[1:]
+	for i := 0; i < len(opts); i++ {
+		opt := strings.ToUpper(string(opts[i]))
+		if opt == "NOVALUES" && extra == opt {
+			out.noValues = true
+			continue
+		}
+		if i+1 >= len(opts) {
+			return out, ErrSyntax
+		}
+
+		switch {
+		case opt == "MATCH":
+			out.pattern = ParseGlob(opts[i+1])
+		case opt == "COUNT":
+			if out.count, err = parseInt(opts[i+1]); err != nil {
+				return out, err
+			}
+			if out.count < 1 {
+				return out, ErrSyntax
+			}
+		case opt == "TYPE" && extra == opt:
+			out.typ = keyType(opts[i+1])
+		default:
+			return out, ErrSyntax
+		}
+		i++
+	}
+	return out, nil
+}
+
+// keyType converts a redis type name into the type stored in the keyspace.
+func keyType(name []byte) string {
+	switch t := strings.ToLower(string(name)); t {
+	case "string":
+		return "blob"
+	case "rejson-rl":
+		return "json"
+	default:
+		return t
+	}
+}
+
+// redisType converts a type stored in the keyspace into its redis name, where
+// an empty type is a missing key.
+func redisType(t string) string {
+	switch t {
+	case "":
+		return "none"
+	case "blob", "hll":
+		return "string"
+	case "json":
+		return "ReJSON-RL"
+	}
+	return t
+}
+
+// writeScan writes the reply of SCAN and friends: the next cursor followed by
+// an array of the items found.
+func writeScan(w Writer, next uint64, items [][]byte) error {
+	if err := writeArrayLen(w, 2); err != nil {
+		return err
+	}
+	if err := w.WriteBulkString(strconv.FormatUint(next, 10)); err != nil {
+		return err
+	}
+	if items == nil {
+		items = [][]byte{}
+	}
+	return w.WriteBulks(items...)
+}
+
+// parseListDirection parses the LEFT|RIGHT argument of list moves, returning
+// true for LEFT.
+func parseListDirection(arg []byte) (bool, error) {
+	switch strings.ToUpper(string(arg)) {
+	case "LEFT":
+		return true, nil
+	case "RIGHT":
+		return false, nil
+	}
+	return false, ErrSyntax
+}
+
+// parseTimeout parses the timeout of a blocking command, given in seconds
+// with 0 meaning forever.
+func parseTimeout(arg []byte) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(secs) || secs*float64(time.Second) > math.MaxInt64 {
+		return 0, ReplyError("ERR timeout is not a float or out of range")
+	}
+	if secs < 0 {
+		return 0, ReplyError("ERR timeout is negative")
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// parseMillisTimeout parses the BLOCK timeout of stream commands, given in
+// milliseconds with 0 meaning forever.
+func parseMillisTimeout(arg []byte) (time.Duration, error) {
+	ms, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil || ms > math.MaxInt64/int64(time.Millisecond) {
+		return 0, ReplyError("ERR timeout is not an integer or out of range")
+	}
+	if ms < 0 {
+		return 0, ReplyError("ERR timeout is negative")
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// writeArrayLen starts an array reply of n elements, for replies that nest
+// arrays; each element must then be written separately.
+func writeArrayLen(w Writer, n int) error {
+	_, err := w.Write([]byte("*" + strconv.Itoa(n) + "\r\n"))
+	return err
+}
+
+// parseBitMode parses the BYTE|BIT unit accepted by BITCOUNT and BITPOS,
+// returning true for BIT.
+func parseBitMode(arg []byte) (bool, error) {
+	switch strings.ToUpper(string(arg)) {
+	case "BYTE":
+		return false, nil
+	case "BIT":
+		return true, nil
+	}
+	return false, ErrSyntax
+}
+
+// commandSlice returns the arguments of c from start up to end, which are
+// none if c has no more than start arguments.
+func commandSlice(c Command, start, end int) [][]byte {
+	if end <= start {
+		return [][]byte{}
+	}
+	ret := make([][]byte, end-start)
 	for i := start; i < end; i++ {
-		ret[i-start] = string(c.Get(i))
+		ret[i-start] = c.Get(i)
 	}
 	return ret
 }
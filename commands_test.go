@@ -1,10 +1,15 @@
 package s2kv_test
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"s2kv"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/onsi/gomega"
@@ -81,6 +86,14 @@ func mockInt(v int) TestOp {
 	}
 }
 
+func mockError(v string) TestOp {
+	return TestOp{
+		write: func(writer *MockWriter) *gomock.Call {
+			return writer.EXPECT().WriteError(v)
+		},
+	}
+}
+
 func mockBulk(v interface{}) TestOp {
 	return TestOp{
 		write: func(writer *MockWriter) *gomock.Call {
@@ -106,10 +119,111 @@ func mockBulks(v ...string) TestOp {
 	}
 }
 
+// mockOrderedBulks expects the bulks in order, nil meaning a nil bulk
+func mockOrderedBulks(v ...interface{}) TestOp {
+	x := make([]interface{}, len(v))
+	for i, b := range v {
+		if b == nil {
+			x[i] = gomock.Nil()
+		} else {
+			x[i] = []byte(b.(string))
+		}
+	}
+	return TestOp{
+		write: func(writer *MockWriter) *gomock.Call {
+			return writer.EXPECT().WriteBulks(x...)
+		},
+	}
+}
+
+func mockObjects(v ...interface{}) TestOp {
+	x := make([]interface{}, len(v))
+	for i, o := range v {
+		if n, ok := o.(int); ok {
+			x[i] = int64(n)
+		} else {
+			x[i] = o
+		}
+	}
+	return TestOp{
+		write: func(writer *MockWriter) *gomock.Call {
+			return writer.EXPECT().WriteObjects(x...)
+		},
+	}
+}
+
+// mockDenseHLL expects a HyperLogLog in the dense encoding, which the server
+// writes whenever it changes one
+func mockDenseHLL() TestOp {
+	return TestOp{
+		write: func(writer *MockWriter) *gomock.Call {
+			return writer.EXPECT().WriteBulk(Match(gomega.And(gomega.HaveLen(12304), gomega.HavePrefix("HYLL\x00"))))
+		},
+	}
+}
+
+// approxFloat matches a float formatted as a bulk or bulk string within tol of
+// v, for values like distances which depend on how SingleStore computes them
+func approxFloat(v, tol float64) gomock.Matcher {
+	return Match(gomega.WithTransform(func(s interface{}) float64 {
+		f, _ := strconv.ParseFloat(fmt.Sprintf("%s", s), 64)
+		return f
+	}, gomega.BeNumerically("~", v, tol)))
+}
+
+func mockApproxBulkString(v, tol float64) TestOp {
+	return TestOp{
+		write: func(writer *MockWriter) *gomock.Call {
+			return writer.EXPECT().WriteBulkString(approxFloat(v, tol))
+		},
+	}
+}
+
+// mockCoords expects a longitude and latitude as stored in a geography point
+func mockCoords(lon, lat float64) TestOp {
+	return TestOp{
+		write: func(writer *MockWriter) *gomock.Call {
+			return writer.EXPECT().WriteBulks(approxFloat(lon, 1e-5), approxFloat(lat, 1e-5))
+		},
+	}
+}
+
+func mockBulkString(v string) TestOp {
+	return TestOp{
+		write: func(writer *MockWriter) *gomock.Call {
+			return writer.EXPECT().WriteBulkString(v)
+		},
+	}
+}
+
+// mockBulkStrings expects the strings in order, no strings meaning a null
+// array
 func mockBulkStrings(v ...string) TestOp {
 	return TestOp{
 		write: func(writer *MockWriter) *gomock.Call {
-			return writer.EXPECT().WriteBulkStrings(Match(gomega.ConsistOf(v)))
+			if len(v) == 0 {
+				return writer.EXPECT().WriteBulkStrings(gomock.Nil())
+			}
+			return writer.EXPECT().WriteBulkStrings(v)
+		},
+	}
+}
+
+// mockJSON expects a bulk holding json equivalent to v, whatever the order of
+// its object keys and its spacing
+func mockJSON(v string) TestOp {
+	return TestOp{
+		write: func(writer *MockWriter) *gomock.Call {
+			return writer.EXPECT().WriteBulk(Match(gomega.MatchJSON(v)))
+		},
+	}
+}
+
+// mockArrayLen expects the header of a nested array reply
+func mockArrayLen(n int) TestOp {
+	return TestOp{
+		write: func(writer *MockWriter) *gomock.Call {
+			return writer.EXPECT().Write([]byte(fmt.Sprintf("*%d\r\n", n))).Return(0, nil)
 		},
 	}
 }
@@ -136,7 +250,7 @@ func TestAll(t *testing.T) {
 				mockCmd("GET", "foo"),
 				mockBulk("bar"),
 				mockCmd("SADD", "set", "foo"),
-				mockSimpleString("OK"),
+				mockInt(1),
 				mockCmd("GET", "set"),
 				mockBulk(nil),
 			},
@@ -152,11 +266,29 @@ func TestAll(t *testing.T) {
 				mockSimpleString("OK"),
 				mockCmd("GET", "foo"),
 				mockBulk("baz"),
+				// keys are case sensitive and binary safe
+				mockCmd("SET", "FOO", "upper"),
+				mockSimpleString("OK"),
+				mockCmd("GET", "foo"),
+				mockBulk("baz"),
+				mockCmd("SET", "\xff\x00\xfe", "binary"),
+				mockSimpleString("OK"),
+				mockCmd("GET", "\xff\x00\xfe"),
+				mockBulk("binary"),
+				mockCmd("GET", "\xff\x00"),
+				mockBulk(nil),
+				// values aren't limited to the 64KB of a blob column
+				mockCmd("SET", "large", strings.Repeat("x", 100000)),
+				mockSimpleString("OK"),
+				mockCmd("GET", "large"),
+				mockBulk(strings.Repeat("x", 100000)),
 			},
 		},
 		{
 			name: "DEL",
 			ops: []TestOp{
+				mockCmd("DEL"),
+				mockError("ERR wrong number of arguments for 'del' command"),
 				mockCmd("SET", "key", "value"),
 				mockSimpleString("OK"),
 				mockCmd("GET", "key"),
@@ -165,6 +297,20 @@ func TestAll(t *testing.T) {
 				mockInt(1),
 				mockCmd("GET", "key"),
 				mockBulk(nil),
+				mockCmd("HSET", "hash", "a", "1"),
+				mockInt(1),
+				mockCmd("DEL", "hash"),
+				mockInt(1),
+				mockCmd("HLEN", "hash"),
+				mockInt(0),
+				mockCmd("SET", "a", "1"),
+				mockSimpleString("OK"),
+				mockCmd("SADD", "b", "1", "2"),
+				mockInt(2),
+				mockCmd("DEL", "a", "b", "missing", "a"),
+				mockInt(2),
+				mockCmd("EXISTS", "a", "b"),
+				mockInt(0),
 			},
 		},
 		{
@@ -179,328 +325,3104 @@ func TestAll(t *testing.T) {
 			},
 		},
 		{
-			name: "KEYS",
+			name: "FLUSHDB",
 			ops: []TestOp{
 				mockCmd("SET", "key", "value"),
 				mockSimpleString("OK"),
-				mockCmd("SET", "foo", "bar"),
+				mockCmd("SELECT", "1"),
 				mockSimpleString("OK"),
-				mockCmd("KEYS", ""),
-				mockBulks("key", "foo"),
+				mockCmd("SET", "key", "other"),
+				mockSimpleString("OK"),
+				mockCmd("FLUSHDB"),
+				mockSimpleString("OK"),
+				mockCmd("GET", "key"),
+				mockBulk(nil),
+				mockCmd("SELECT", "0"),
+				mockSimpleString("OK"),
+				mockCmd("GET", "key"),
+				mockBulk("value"),
 			},
 		},
 		{
-			name: "EXISTS",
+			name: "SELECT",
 			ops: []TestOp{
-				mockCmd("EXISTS", "key"),
-				mockInt(0),
-				mockCmd("SET", "key", "value"),
+				mockCmd("SET", "key", "zero"),
 				mockSimpleString("OK"),
-				mockCmd("EXISTS", "key"),
+				mockCmd("SELECT", "1"),
+				mockSimpleString("OK"),
+				mockCmd("GET", "key"),
+				mockBulk(nil),
+				mockCmd("RPUSH", "key", "one"),
 				mockInt(1),
+				mockCmd("DBSIZE"),
+				mockInt(1),
+				mockCmd("SELECT", "0"),
+				mockSimpleString("OK"),
+				mockCmd("GET", "key"),
+				mockBulk("zero"),
+				mockCmd("SELECT", "16"),
+				mockError("ERR DB index is out of range"),
+				mockCmd("SELECT", "-1"),
+				mockError("ERR DB index is out of range"),
+				mockCmd("SELECT", "one"),
+				mockError("ERR value is not an integer or out of range"),
+				mockCmd("FLUSHALL"),
+				mockSimpleString("OK"),
+				mockCmd("SELECT", "1"),
+				mockSimpleString("OK"),
+				mockCmd("DBSIZE"),
+				mockInt(0),
 			},
 		},
 		{
-			name: "INCRBY",
+			name: "SWAPDB",
 			ops: []TestOp{
-				mockCmd("INCRBY", "foo", "0"),
-				mockInt(0),
-				mockCmd("GET", "foo"),
-				mockBulk("0"),
-				mockCmd("INCRBY", "foo", "1"),
+				mockCmd("SET", "key", "zero"),
+				mockSimpleString("OK"),
+				mockCmd("SADD", "set", "x"),
 				mockInt(1),
-				mockCmd("GET", "foo"),
-				mockBulk("1"),
-				mockCmd("INCRBY", "foo", "10"),
-				mockInt(11),
-				mockCmd("GET", "foo"),
-				mockBulk("11"),
-				mockCmd("INCRBY", "foo", "-5"),
-				mockInt(6),
-				mockCmd("GET", "foo"),
-				mockBulk("6"),
-				mockCmd("INCRBY", "bar", "-5"),
-				mockInt(-5),
-				mockCmd("GET", "bar"),
-				mockBulk("-5"),
-				mockCmd("INCRBY", "baz", "100"),
-				mockInt(100),
-				mockCmd("GET", "baz"),
-				mockBulk("100"),
+				mockCmd("SWAPDB", "0", "1"),
+				mockSimpleString("OK"),
+				mockCmd("GET", "key"),
+				mockBulk(nil),
+				mockCmd("SELECT", "1"),
+				mockSimpleString("OK"),
+				mockCmd("GET", "key"),
+				mockBulk("zero"),
+				mockCmd("SMEMBERS", "set"),
+				mockBulks("x"),
+				mockCmd("SWAPDB", "1", "1"),
+				mockSimpleString("OK"),
+				mockCmd("SWAPDB", "x", "1"),
+				mockError("ERR invalid first DB index"),
+				mockCmd("SWAPDB", "0", "x"),
+				mockError("ERR invalid second DB index"),
+				mockCmd("SWAPDB", "0", "16"),
+				mockError("ERR DB index is out of range"),
 			},
 		},
 		{
-			name: "RPUSH",
+			name: "KEYS",
 			ops: []TestOp{
-				mockCmd("RPUSH", "foo", "bar"),
-				mockSimpleString("OK"),
-				mockCmd("RPUSH", "foo", "baz"),
+				mockCmd("SET", "key", "value"),
 				mockSimpleString("OK"),
-				mockCmd("LRANGE", "foo", "0", "-1"),
-				mockBulks("bar", "baz"),
-				mockCmd("RPUSH", "foo", "baz"),
+				mockCmd("SET", "foo", "bar"),
 				mockSimpleString("OK"),
-				mockCmd("LRANGE", "foo", "0", "-1"),
-				mockBulks("bar", "baz", "baz"),
+				mockCmd("SET", "k%y", "value"),
+				mockSimpleString("OK"),
+				mockCmd("KEYS", "*"),
+				mockBulks("key", "foo", "k%y"),
+				mockCmd("KEYS", "k*"),
+				mockBulks("key", "k%y"),
+				mockCmd("KEYS", "k%*"),
+				mockBulks("k%y"),
+				mockCmd("KEYS", "?e[a-z]"),
+				mockBulks("key"),
+				mockCmd("KEYS", "f[^a]?"),
+				mockBulks("foo"),
+				mockCmd("KEYS", "k\\%y"),
+				mockBulks("k%y"),
+				mockCmd("KEYS", "user:*"),
+				mockBulks(),
+				mockCmd("KEYS", "K*"),
+				mockBulks(),
 			},
 		},
 		{
-			name: "LREM",
+			name: "SCAN",
 			ops: []TestOp{
-				mockCmd("RPUSH", "foo", "bar"),
-				mockSimpleString("OK"),
-				mockCmd("RPUSH", "foo", "baz"),
+				mockCmd("SET", "key", "value"),
 				mockSimpleString("OK"),
-				mockCmd("LREM", "foo", "bar"),
+				mockCmd("SADD", "kset", "a"),
 				mockInt(1),
-				mockCmd("LRANGE", "foo", "0", "-1"),
-				mockBulks("baz"),
-				mockCmd("RPUSH", "foo", "baz"),
+				mockCmd("SET", "foo", "bar"),
 				mockSimpleString("OK"),
-				mockCmd("LRANGE", "foo", "0", "-1"),
-				mockBulks("baz", "baz"),
-				mockCmd("LREM", "foo", "baz"),
-				mockInt(2),
-				mockCmd("LRANGE", "foo", "0", "-1"),
-				mockBulks(),
+				mockCmd("SCAN", "0", "COUNT", "100"),
+				mockArrayLen(2),
+				mockBulkString("0"),
+				mockBulks("key", "kset", "foo"),
+				mockCmd("SCAN", "0", "MATCH", "k*", "COUNT", "100"),
+				mockArrayLen(2),
+				mockBulkString("0"),
+				mockBulks("key", "kset"),
+				mockCmd("SCAN", "0", "COUNT", "100", "TYPE", "string"),
+				mockArrayLen(2),
+				mockBulkString("0"),
+				mockBulks("key", "foo"),
+				// the cursor resumes after the last key of the page
+				mockCmd("SCAN", "0", "COUNT", "2"),
+				mockArrayLen(2),
+				mockBulkString("748426717482650619"),
+				mockOrderedBulks("foo", "key"),
+				mockCmd("SCAN", "748426717482650619", "COUNT", "2"),
+				mockArrayLen(2),
+				mockBulkString("0"),
+				mockOrderedBulks("kset"),
+				mockCmd("SCAN", "1"),
+				mockError("ERR invalid cursor"),
+				mockCmd("SCAN", "x"),
+				mockError("ERR invalid cursor"),
+				mockCmd("SCAN", "9223372036854775808"),
+				mockError("ERR invalid cursor"),
+				mockCmd("SCAN"),
+				mockError("ERR wrong number of arguments for 'scan' command"),
+				mockCmd("SCAN", "0", "COUNT", "0"),
+				mockError("ERR syntax error"),
 			},
 		},
 		{
-			name: "LRANGE",
+			name: "TYPE",
 			ops: []TestOp{
-				mockCmd("RPUSH", "foo", "1"),
-				mockSimpleString("OK"),
-				mockCmd("RPUSH", "foo", "2"),
+				mockCmd("TYPE", "missing"),
+				mockSimpleString("none"),
+				mockCmd("SET", "blob", "value"),
 				mockSimpleString("OK"),
-				mockCmd("RPUSH", "foo", "3"),
-				mockSimpleString("OK"),
-				mockCmd("LRANGE", "foo", "0", "-1"),
-				mockBulks("1", "2", "3"),
-				mockCmd("LRANGE", "foo", "0", "0"),
-				mockBulks("1"),
-				mockCmd("LRANGE", "foo", "1", "1"),
-				mockBulks("2"),
-				mockCmd("LRANGE", "foo", "0", "1"),
-				mockBulks("1", "2"),
-				mockCmd("LRANGE", "foo", "1", "2"),
-				mockBulks("2", "3"),
-				mockCmd("LRANGE", "foo", "0", "2"),
-				mockBulks("1", "2", "3"),
-				mockCmd("LRANGE", "foo", "0", "100"),
-				mockBulks("1", "2", "3"),
+				mockCmd("TYPE", "blob"),
+				mockSimpleString("string"),
+				mockCmd("RPUSH", "list", "a"),
+				mockInt(1),
+				mockCmd("TYPE", "list"),
+				mockSimpleString("list"),
+				mockCmd("SADD", "set", "a"),
+				mockInt(1),
+				mockCmd("TYPE", "set"),
+				mockSimpleString("set"),
+				mockCmd("HSET", "hash", "a", "1"),
+				mockInt(1),
+				mockCmd("TYPE", "hash"),
+				mockSimpleString("hash"),
+				mockCmd("ZADD", "zset", "1", "a"),
+				mockInt(1),
+				mockCmd("TYPE", "zset"),
+				mockSimpleString("zset"),
 			},
 		},
 		{
-			name: "SADD",
+			name: "RENAME",
 			ops: []TestOp{
-				mockCmd("SADD", "foo", "1"),
+				mockCmd("RENAME", "missing", "b"),
+				mockError("ERR no such key"),
+				mockCmd("SET", "a", "1"),
 				mockSimpleString("OK"),
-				mockCmd("SMEMBERS", "foo"),
-				mockBulks("1"),
-				mockCmd("SADD", "foo", "1"),
+				mockCmd("RENAME", "a", "b"),
 				mockSimpleString("OK"),
-				mockCmd("SMEMBERS", "foo"),
-				mockBulks("1"),
-				mockCmd("SADD", "foo", "2"),
+				mockCmd("EXISTS", "a"),
+				mockInt(0),
+				mockCmd("GET", "b"),
+				mockBulk("1"),
+				mockCmd("RENAME", "b", "b"),
 				mockSimpleString("OK"),
-				mockCmd("SMEMBERS", "foo"),
-				mockBulks("1", "2"),
+				mockCmd("RPUSH", "list", "x", "y"),
+				mockInt(2),
+				mockCmd("RENAME", "list", "b"),
+				mockSimpleString("OK"),
+				mockCmd("TYPE", "b"),
+				mockSimpleString("list"),
+				mockCmd("LRANGE", "b", "0", "-1"),
+				mockOrderedBulks("x", "y"),
 			},
 		},
 		{
-			name: "SREM",
+			name: "RENAMENX",
 			ops: []TestOp{
-				mockCmd("SADD", "foo", "1"),
+				mockCmd("SET", "a", "1"),
 				mockSimpleString("OK"),
-				mockCmd("SADD", "foo", "2"),
+				mockCmd("SET", "b", "2"),
 				mockSimpleString("OK"),
-				mockCmd("SADD", "foo", "3"),
+				mockCmd("RENAMENX", "a", "b"),
+				mockInt(0),
+				mockCmd("RENAMENX", "a", "a"),
+				mockInt(0),
+				mockCmd("RENAMENX", "a", "c"),
+				mockInt(1),
+				mockCmd("GET", "c"),
+				mockBulk("1"),
+				mockCmd("RENAMENX", "missing", "d"),
+				mockError("ERR no such key"),
+			},
+		},
+		{
+			name: "COPY",
+			ops: []TestOp{
+				mockCmd("COPY", "missing", "b"),
+				mockInt(0),
+				mockCmd("SET", "a", "1"),
 				mockSimpleString("OK"),
-				mockCmd("SREM", "foo", "1"),
+				mockCmd("COPY", "a", "b"),
 				mockInt(1),
-				mockCmd("SMEMBERS", "foo"),
-				mockBulks("2", "3"),
-				mockCmd("SREM", "foo", "1"),
+				mockCmd("GET", "b"),
+				mockBulk("1"),
+				mockCmd("SET", "a", "2"),
+				mockSimpleString("OK"),
+				mockCmd("COPY", "a", "b"),
 				mockInt(0),
-				mockCmd("SMEMBERS", "foo"),
-				mockBulks("2", "3"),
-				mockCmd("SREM", "foo", "2"),
+				mockCmd("COPY", "a", "b", "REPLACE"),
 				mockInt(1),
-				mockCmd("SREM", "foo", "3"),
+				mockCmd("GET", "b"),
+				mockBulk("2"),
+				mockCmd("GET", "a"),
+				mockBulk("2"),
+				mockCmd("SADD", "set", "x", "y"),
+				mockInt(2),
+				mockCmd("COPY", "set", "b", "REPLACE"),
 				mockInt(1),
-				mockCmd("SMEMBERS", "foo"),
-				mockBulks(),
+				mockCmd("SMEMBERS", "b"),
+				mockBulks("x", "y"),
+				mockCmd("COPY", "a", "a"),
+				mockError("ERR source and destination objects are the same"),
+				mockCmd("COPY", "a", "b", "NX"),
+				mockError("ERR syntax error"),
+				mockCmd("COPY", "a", "a", "DB", "1"),
+				mockInt(1),
+				mockCmd("COPY", "a", "a", "DB", "1"),
+				mockInt(0),
+				mockCmd("COPY", "a", "a", "DB", "16"),
+				mockError("ERR DB index is out of range"),
+				mockCmd("COPY", "a", "a", "DB"),
+				mockError("ERR syntax error"),
+				mockCmd("SELECT", "1"),
+				mockSimpleString("OK"),
+				mockCmd("GET", "a"),
+				mockBulk("2"),
 			},
 		},
 		{
-			name: "SMEMBERS",
+			name: "MOVE",
 			ops: []TestOp{
-				mockCmd("SADD", "foo", "1"),
+				mockCmd("MOVE", "missing", "1"),
+				mockInt(0),
+				mockCmd("SET", "a", "1"),
 				mockSimpleString("OK"),
-				mockCmd("SADD", "foo", "2"),
+				mockCmd("MOVE", "a", "0"),
+				mockError("ERR source and destination objects are the same"),
+				mockCmd("MOVE", "a", "x"),
+				mockError("ERR value is not an integer or out of range"),
+				mockCmd("MOVE", "a", "1"),
+				mockInt(1),
+				mockCmd("EXISTS", "a"),
+				mockInt(0),
+				mockCmd("SET", "a", "2"),
 				mockSimpleString("OK"),
-				mockCmd("SADD", "foo", "3"),
+				mockCmd("MOVE", "a", "1"),
+				mockInt(0),
+				mockCmd("SELECT", "1"),
 				mockSimpleString("OK"),
-				mockCmd("SMEMBERS", "foo"),
-				mockBulks("1", "2", "3"),
+				mockCmd("GET", "a"),
+				mockBulk("1"),
 			},
 		},
 		{
-			name: "SINTER",
+			name: "RANDOMKEY",
 			ops: []TestOp{
-				mockCmd("SADD", "foo", "1"),
-				mockSimpleString("OK"),
-				mockCmd("SADD", "foo", "2"),
-				mockSimpleString("OK"),
-				mockCmd("SADD", "foo", "3"),
+				mockCmd("RANDOMKEY"),
+				mockBulk(nil),
+				mockCmd("SET", "key", "value"),
 				mockSimpleString("OK"),
-				mockCmd("SADD", "bar", "3"),
+				mockCmd("RANDOMKEY"),
+				mockBulk("key"),
+			},
+		},
+		{
+			name: "DBSIZE",
+			ops: []TestOp{
+				mockCmd("DBSIZE"),
+				mockInt(0),
+				mockCmd("SET", "key", "value"),
 				mockSimpleString("OK"),
+				mockCmd("SADD", "set", "a", "b"),
+				mockInt(2),
+				mockCmd("DBSIZE"),
+				mockInt(2),
+			},
+		},
+		{
+			name: "TOUCH",
+			ops: []TestOp{
+				mockCmd("SET", "key", "value"),
+				mockSimpleString("OK"),
+				mockCmd("TOUCH", "key", "missing", "key"),
+				mockInt(2),
+			},
+		},
+		{
+			name: "UNLINK",
+			ops: []TestOp{
+				mockCmd("SADD", "foo", "a", "b"),
+				mockInt(2),
+				mockCmd("UNLINK", "foo", "missing"),
+				mockInt(1),
+				mockCmd("EXISTS", "foo"),
+				mockInt(0),
+				mockCmd("SADD", "foo", "c"),
+				mockInt(1),
+				mockCmd("SMEMBERS", "foo"),
+				mockBulks("c"),
+				mockCmd("SET", "str", "value"),
+				mockSimpleString("OK"),
+				mockCmd("UNLINK", "str"),
+				mockInt(1),
+				mockCmd("GET", "str"),
+				mockBulk(nil),
+			},
+		},
+		{
+			name: "EXISTS",
+			ops: []TestOp{
+				mockCmd("EXISTS"),
+				mockError("ERR wrong number of arguments for 'exists' command"),
+				mockCmd("EXISTS", "key"),
+				mockInt(0),
+				mockCmd("SET", "key", "value"),
+				mockSimpleString("OK"),
+				mockCmd("EXISTS", "key"),
+				mockInt(1),
+				mockCmd("EXISTS", "key", "missing", "key"),
+				mockInt(2),
+			},
+		},
+		{
+			name: "INCRBY",
+			ops: []TestOp{
+				mockCmd("INCRBY", "foo", "0"),
+				mockInt(0),
+				mockCmd("GET", "foo"),
+				mockBulk("0"),
+				mockCmd("INCRBY", "foo", "1"),
+				mockInt(1),
+				mockCmd("GET", "foo"),
+				mockBulk("1"),
+				mockCmd("INCRBY", "foo", "10"),
+				mockInt(11),
+				mockCmd("GET", "foo"),
+				mockBulk("11"),
+				mockCmd("INCRBY", "foo", "-5"),
+				mockInt(6),
+				mockCmd("GET", "foo"),
+				mockBulk("6"),
+				mockCmd("INCRBY", "bar", "-5"),
+				mockInt(-5),
+				mockCmd("GET", "bar"),
+				mockBulk("-5"),
+				mockCmd("INCRBY", "baz", "100"),
+				mockInt(100),
+				mockCmd("GET", "baz"),
+				mockBulk("100"),
+				mockCmd("INCRBY", "baz", "nope"),
+				mockError("ERR value is not an integer or out of range"),
+				mockCmd("SET", "str", "bar"),
+				mockSimpleString("OK"),
+				mockCmd("INCRBY", "str", "1"),
+				mockError("ERR value is not an integer or out of range"),
+				mockCmd("SET", "max", "9223372036854775806"),
+				mockSimpleString("OK"),
+				mockCmd("INCRBY", "max", "1"),
+				mockInt(9223372036854775807),
+				mockCmd("INCRBY", "max", "1"),
+				mockError("ERR increment or decrement would overflow"),
+				mockCmd("GET", "max"),
+				mockBulk("9223372036854775807"),
+			},
+		},
+		{
+			name: "INCR",
+			ops: []TestOp{
+				mockCmd("INCR", "foo"),
+				mockInt(1),
+				mockCmd("INCR", "foo"),
+				mockInt(2),
+				mockCmd("GET", "foo"),
+				mockBulk("2"),
+				mockCmd("SET", "bar", " 1"),
+				mockSimpleString("OK"),
+				mockCmd("INCR", "bar"),
+				mockError("ERR value is not an integer or out of range"),
+			},
+		},
+		{
+			name: "DECR",
+			ops: []TestOp{
+				mockCmd("DECR", "foo"),
+				mockInt(-1),
+				mockCmd("DECR", "foo"),
+				mockInt(-2),
+				mockCmd("GET", "foo"),
+				mockBulk("-2"),
+				mockCmd("SET", "min", "-9223372036854775808"),
+				mockSimpleString("OK"),
+				mockCmd("DECR", "min"),
+				mockError("ERR increment or decrement would overflow"),
+			},
+		},
+		{
+			name: "DECRBY",
+			ops: []TestOp{
+				mockCmd("DECRBY", "foo", "0"),
+				mockInt(0),
+				mockCmd("GET", "foo"),
+				mockBulk("0"),
+				mockCmd("DECRBY", "foo", "1"),
+				mockInt(-1),
+				mockCmd("GET", "foo"),
+				mockBulk("-1"),
+				mockCmd("DECRBY", "foo", "10"),
+				mockInt(-11),
+				mockCmd("GET", "foo"),
+				mockBulk("-11"),
+				mockCmd("DECRBY", "foo", "-5"),
+				mockInt(-6),
+				mockCmd("GET", "foo"),
+				mockBulk("-6"),
+				mockCmd("DECRBY", "bar", "-5"),
+				mockInt(5),
+				mockCmd("GET", "bar"),
+				mockBulk("5"),
+				mockCmd("DECRBY", "baz", "100"),
+				mockInt(-100),
+				mockCmd("GET", "baz"),
+				mockBulk("-100"),
+				mockCmd("DECRBY", "baz", "-9223372036854775808"),
+				mockError("ERR increment or decrement would overflow"),
+			},
+		},
+		{
+			name: "INCRBYFLOAT",
+			ops: []TestOp{
+				mockCmd("INCRBYFLOAT", "foo", "10.5"),
+				mockBulk("10.5"),
+				mockCmd("INCRBYFLOAT", "foo", "0.1"),
+				mockBulk("10.6"),
+				mockCmd("INCRBYFLOAT", "foo", "-5"),
+				mockBulk("5.6"),
+				mockCmd("SET", "bar", "5.0e3"),
+				mockSimpleString("OK"),
+				mockCmd("INCRBYFLOAT", "bar", "2.0e2"),
+				mockBulk("5200"),
+				mockCmd("GET", "bar"),
+				mockBulk("5200"),
+				mockCmd("INCRBYFLOAT", "bar", "nope"),
+				mockError("ERR value is not a valid float"),
+				mockCmd("SET", "str", "bar"),
+				mockSimpleString("OK"),
+				mockCmd("INCRBYFLOAT", "str", "1"),
+				mockError("ERR value is not a valid float"),
+			},
+		},
+		{
+			name: "SETBIT",
+			ops: []TestOp{
+				mockCmd("SETBIT", "foo", "7", "1"),
+				mockInt(0),
+				mockCmd("GET", "foo"),
+				mockBulk("\x01"),
+				mockCmd("SETBIT", "foo", "7", "0"),
+				mockInt(1),
+				mockCmd("SETBIT", "foo", "100", "1"),
+				mockInt(0),
+				mockCmd("GETBIT", "foo", "100"),
+				mockInt(1),
+				mockCmd("SETBIT", "foo", "-1", "1"),
+				mockError("ERR bit offset is not an integer or out of range"),
+				mockCmd("SETBIT", "foo", "1", "2"),
+				mockError("ERR bit is not an integer or out of range"),
+			},
+		},
+		{
+			name: "GETBIT",
+			ops: []TestOp{
+				mockCmd("GETBIT", "foo", "0"),
+				mockInt(0),
+				mockCmd("SET", "foo", "\x80"),
+				mockSimpleString("OK"),
+				mockCmd("GETBIT", "foo", "0"),
+				mockInt(1),
+				mockCmd("GETBIT", "foo", "1"),
+				mockInt(0),
+				mockCmd("GETBIT", "foo", "1000"),
+				mockInt(0),
+			},
+		},
+		{
+			name: "BITCOUNT",
+			ops: []TestOp{
+				mockCmd("BITCOUNT", "foo"),
+				mockInt(0),
+				mockCmd("SET", "foo", "foobar"),
+				mockSimpleString("OK"),
+				mockCmd("BITCOUNT", "foo"),
+				mockInt(26),
+				mockCmd("BITCOUNT", "foo", "0", "0"),
+				mockInt(4),
+				mockCmd("BITCOUNT", "foo", "1", "1"),
+				mockInt(6),
+				mockCmd("BITCOUNT", "foo", "1", "1", "BYTE"),
+				mockInt(6),
+				mockCmd("BITCOUNT", "foo", "5", "30", "BIT"),
+				mockInt(17),
+				mockCmd("BITCOUNT", "foo", "-2", "-1"),
+				mockInt(7),
+				mockCmd("BITCOUNT", "foo", "3", "1"),
+				mockInt(0),
+				mockCmd("BITCOUNT", "foo", "0", "1", "WORD"),
+				mockError("ERR syntax error"),
+			},
+		},
+		{
+			name: "BITPOS",
+			ops: []TestOp{
+				mockCmd("BITPOS", "foo", "1"),
+				mockInt(-1),
+				mockCmd("BITPOS", "foo", "0"),
+				mockInt(0),
+				mockCmd("SET", "foo", "\xff\xf0\x00"),
+				mockSimpleString("OK"),
+				mockCmd("BITPOS", "foo", "0"),
+				mockInt(12),
+				mockCmd("SET", "foo", "\x00\xff\xf0"),
+				mockSimpleString("OK"),
+				mockCmd("BITPOS", "foo", "1", "0"),
+				mockInt(8),
+				mockCmd("BITPOS", "foo", "1", "2"),
+				mockInt(16),
+				mockCmd("BITPOS", "foo", "1", "2", "-1", "BYTE"),
+				mockInt(16),
+				mockCmd("BITPOS", "foo", "1", "7", "15", "BIT"),
+				mockInt(8),
+				mockCmd("SET", "foo", "\xff\xff\xff"),
+				mockSimpleString("OK"),
+				mockCmd("BITPOS", "foo", "0"),
+				mockInt(24),
+				mockCmd("BITPOS", "foo", "0", "0", "-1"),
+				mockInt(-1),
+			},
+		},
+		{
+			name: "BITOP",
+			ops: []TestOp{
+				mockCmd("SET", "foo", "foobar"),
+				mockSimpleString("OK"),
+				mockCmd("SET", "bar", "abcdef"),
+				mockSimpleString("OK"),
+				mockCmd("BITOP", "AND", "dest", "foo", "bar"),
+				mockInt(6),
+				mockCmd("GET", "dest"),
+				mockBulk("`bc`ab"),
+				mockCmd("BITOP", "OR", "dest", "foo", "bar"),
+				mockInt(6),
+				mockCmd("GET", "dest"),
+				mockBulk("goofev"),
+				mockCmd("SET", "a", "a"),
+				mockSimpleString("OK"),
+				mockCmd("SET", "ab", "ab"),
+				mockSimpleString("OK"),
+				mockCmd("BITOP", "XOR", "dest", "a", "ab"),
+				mockInt(2),
+				mockCmd("GET", "dest"),
+				mockBulk("\x00b"),
+				mockCmd("SET", "nib", "\x0f"),
+				mockSimpleString("OK"),
+				mockCmd("BITOP", "NOT", "dest", "nib"),
+				mockInt(1),
+				mockCmd("GET", "dest"),
+				mockBulk("\xf0"),
+				mockCmd("BITOP", "AND", "dest", "missing"),
+				mockInt(0),
+				mockCmd("EXISTS", "dest"),
+				mockInt(0),
+				mockCmd("BITOP", "NOT", "dest", "foo", "bar"),
+				mockError("ERR BITOP NOT must be called with a single source key."),
+				mockCmd("BITOP", "AND", "dest"),
+				mockError("ERR wrong number of arguments for 'bitop' command"),
+			},
+		},
+		{
+			name: "BITFIELD",
+			ops: []TestOp{
+				mockCmd("BITFIELD", "foo", "INCRBY", "i5", "100", "1", "GET", "u4", "0"),
+				mockObjects(1, 0),
+				mockCmd("BITFIELD", "bar", "INCRBY", "u2", "100", "1", "OVERFLOW", "SAT", "INCRBY", "u2", "102", "1"),
+				mockObjects(1, 1),
+				mockCmd("BITFIELD", "bar", "INCRBY", "u2", "100", "1", "OVERFLOW", "SAT", "INCRBY", "u2", "102", "1"),
+				mockObjects(2, 2),
+				mockCmd("BITFIELD", "bar", "INCRBY", "u2", "100", "1", "OVERFLOW", "SAT", "INCRBY", "u2", "102", "1"),
+				mockObjects(3, 3),
+				mockCmd("BITFIELD", "bar", "INCRBY", "u2", "100", "1", "OVERFLOW", "SAT", "INCRBY", "u2", "102", "1"),
+				mockObjects(0, 3),
+				mockCmd("BITFIELD", "bar", "OVERFLOW", "FAIL", "INCRBY", "u2", "102", "1"),
+				mockObjects(nil),
+				mockCmd("BITFIELD", "baz", "SET", "i8", "#1", "-1", "GET", "u8", "8", "GET", "i8", "#1"),
+				mockObjects(0, 255, -1),
+				mockCmd("BITFIELD", "baz"),
+				mockObjects(),
+				mockCmd("BITFIELD", "baz", "GET", "u64", "0"),
+				mockError("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is."),
+				mockCmd("BITFIELD", "baz", "GET", "i64", "#288230376151711744"),
+				mockError("ERR bit offset is not an integer or out of range"),
+			},
+		},
+		{
+			name: "HSET",
+			ops: []TestOp{
+				mockCmd("HSET", "foo", "a", "1", "b", "2"),
+				mockInt(2),
+				mockCmd("HSET", "foo", "b", "3", "c", "4"),
+				mockInt(1),
+				mockCmd("HGETALL", "foo"),
+				mockBulks("a", "1", "b", "3", "c", "4"),
+				mockCmd("HSET", "foo", "a"),
+				mockError("ERR wrong number of arguments for 'hset' command"),
+				mockCmd("SET", "str", "bar"),
+				mockSimpleString("OK"),
+				mockCmd("HSET", "str", "a", "1"),
+				mockError("ERR type mismatch; got blob, expected hash"),
+			},
+		},
+		{
+			name: "HSETNX",
+			ops: []TestOp{
+				mockCmd("HSETNX", "foo", "a", "1"),
+				mockInt(1),
+				mockCmd("HSETNX", "foo", "a", "2"),
+				mockInt(0),
+				mockCmd("HGET", "foo", "a"),
+				mockBulk("1"),
+			},
+		},
+		{
+			name: "HGET",
+			ops: []TestOp{
+				mockCmd("HGET", "foo", "a"),
+				mockBulk(nil),
+				mockCmd("HSET", "foo", "a", "1"),
+				mockInt(1),
+				mockCmd("HGET", "foo", "a"),
+				mockBulk("1"),
+				mockCmd("HGET", "foo", "b"),
+				mockBulk(nil),
+			},
+		},
+		{
+			name: "HMGET",
+			ops: []TestOp{
+				mockCmd("HSET", "foo", "a", "1", "b", "2"),
+				mockInt(2),
+				mockCmd("HMGET", "foo", "b", "nope", "a"),
+				mockOrderedBulks("2", nil, "1"),
+				// fields and values are binary safe
+				mockCmd("HSET", "foo", "\xff\x00'", "\x00\xfe"),
+				mockInt(1),
+				mockCmd("HMGET", "foo", "\xff\x00'", "\xff"),
+				mockOrderedBulks("\x00\xfe", nil),
+				mockCmd("HMGET", "foo"),
+				mockError("ERR wrong number of arguments for 'hmget' command"),
+			},
+		},
+		{
+			name: "HGETALL",
+			ops: []TestOp{
+				mockCmd("HGETALL", "foo"),
+				mockBulks(),
+				mockCmd("HSET", "foo", "a", "1", "b", "2"),
+				mockInt(2),
+				mockCmd("HGETALL", "foo"),
+				mockBulks("a", "1", "b", "2"),
+			},
+		},
+		{
+			name: "HKEYS",
+			ops: []TestOp{
+				mockCmd("HSET", "foo", "a", "1", "b", "2"),
+				mockInt(2),
+				mockCmd("HKEYS", "foo"),
+				mockBulks("a", "b"),
+			},
+		},
+		{
+			name: "HVALS",
+			ops: []TestOp{
+				mockCmd("HSET", "foo", "a", "1", "b", "2"),
+				mockInt(2),
+				mockCmd("HVALS", "foo"),
+				mockBulks("1", "2"),
+			},
+		},
+		{
+			name: "HDEL",
+			ops: []TestOp{
+				mockCmd("HSET", "foo", "a", "1", "b", "2", "c", "3"),
+				mockInt(3),
+				mockCmd("HDEL", "foo", "a", "nope"),
+				mockInt(1),
+				mockCmd("HKEYS", "foo"),
+				mockBulks("b", "c"),
+				mockCmd("HDEL", "foo", "b", "c"),
+				mockInt(2),
+				mockCmd("EXISTS", "foo"),
+				mockInt(0),
+				mockCmd("HDEL", "foo"),
+				mockError("ERR wrong number of arguments for 'hdel' command"),
+			},
+		},
+		{
+			name: "HEXISTS",
+			ops: []TestOp{
+				mockCmd("HEXISTS", "foo", "a"),
+				mockInt(0),
+				mockCmd("HSET", "foo", "a", "1"),
+				mockInt(1),
+				mockCmd("HEXISTS", "foo", "a"),
+				mockInt(1),
+			},
+		},
+		{
+			name: "HLEN",
+			ops: []TestOp{
+				mockCmd("HLEN", "foo"),
+				mockInt(0),
+				mockCmd("HSET", "foo", "a", "1", "b", "2"),
+				mockInt(2),
+				mockCmd("HLEN", "foo"),
+				mockInt(2),
+			},
+		},
+		{
+			name: "HSTRLEN",
+			ops: []TestOp{
+				mockCmd("HSTRLEN", "foo", "a"),
+				mockInt(0),
+				mockCmd("HSET", "foo", "a", "hello"),
+				mockInt(1),
+				mockCmd("HSTRLEN", "foo", "a"),
+				mockInt(5),
+			},
+		},
+		{
+			name: "HINCRBY",
+			ops: []TestOp{
+				mockCmd("HINCRBY", "foo", "a", "5"),
+				mockInt(5),
+				mockCmd("HINCRBY", "foo", "a", "-7"),
+				mockInt(-2),
+				mockCmd("HGET", "foo", "a"),
+				mockBulk("-2"),
+				mockCmd("HSET", "foo", "b", "x"),
+				mockInt(1),
+				mockCmd("HINCRBY", "foo", "b", "1"),
+				mockError("ERR value is not an integer or out of range"),
+			},
+		},
+		{
+			name: "HINCRBYFLOAT",
+			ops: []TestOp{
+				mockCmd("HINCRBYFLOAT", "foo", "a", "10.5"),
+				mockBulk("10.5"),
+				mockCmd("HINCRBYFLOAT", "foo", "a", "0.1"),
+				mockBulk("10.6"),
+				mockCmd("HSET", "foo", "b", "5.0e3"),
+				mockInt(1),
+				mockCmd("HINCRBYFLOAT", "foo", "b", "2.0e2"),
+				mockBulk("5200"),
+			},
+		},
+		{
+			name: "HSCAN",
+			ops: []TestOp{
+				mockCmd("HSCAN", "foo"),
+				mockError("ERR wrong number of arguments for 'hscan' command"),
+				mockCmd("HSCAN", "foo", "0"),
+				mockArrayLen(2),
+				mockBulkString("0"),
+				mockBulks(),
+				mockCmd("HSET", "foo", "a", "1", "b", "2"),
+				mockInt(2),
+				mockCmd("HSCAN", "foo", "0"),
+				mockArrayLen(2),
+				mockBulkString("0"),
+				mockOrderedBulks("a", "1", "b", "2"),
+				mockCmd("HSCAN", "foo", "0", "MATCH", "b", "NOVALUES"),
+				mockArrayLen(2),
+				mockBulkString("0"),
+				mockBulks("b"),
+			},
+		},
+		{
+			name: "HRANDFIELD",
+			ops: []TestOp{
+				mockCmd("HRANDFIELD", "foo"),
+				mockBulk(nil),
+				mockCmd("HSET", "foo", "a", "1"),
+				mockInt(1),
+				mockCmd("HRANDFIELD", "foo"),
+				mockBulk("a"),
+				mockCmd("HRANDFIELD", "foo", "5"),
+				mockBulks("a"),
+				mockCmd("HRANDFIELD", "foo", "-3"),
+				mockBulks("a", "a", "a"),
+				mockCmd("HRANDFIELD", "foo", "1", "WITHVALUES"),
+				mockBulks("a", "1"),
+				mockCmd("HRANDFIELD", "foo", "-9223372036854775808"),
+				mockError("ERR value is out of range"),
+			},
+		},
+		{
+			name: "ZADD",
+			ops: []TestOp{
+				mockCmd("ZADD", "z", "1", "a", "2", "b", "3", "c"),
+				mockInt(3),
+				mockCmd("ZADD", "z", "NX", "10", "a", "4", "d"),
+				mockInt(1),
+				mockCmd("ZADD", "z", "XX", "CH", "5", "a", "6", "e"),
+				mockInt(1),
+				mockCmd("ZADD", "z", "GT", "CH", "1", "a"),
+				mockInt(0),
+				mockCmd("ZADD", "z", "LT", "CH", "1", "a"),
+				mockInt(1),
+				mockCmd("ZRANGE", "z", "0", "-1", "WITHSCORES"),
+				mockOrderedBulks("a", "1", "b", "2", "c", "3", "d", "4"),
+				mockCmd("ZADD", "z", "INCR", "5", "a"),
+				mockBulk("6"),
+				mockCmd("ZADD", "z", "NX", "INCR", "1", "a"),
+				mockBulk(nil),
+				mockCmd("ZADD", "z", "NX", "XX", "1", "a"),
+				mockError("ERR XX and NX options at the same time are not compatible"),
+				mockCmd("ZADD", "z", "1"),
+				mockError("ERR wrong number of arguments for 'zadd' command"),
+				mockCmd("ZADD", "z", "NX", "1"),
+				mockError("ERR syntax error"),
+				mockCmd("ZADD", "z", "nope", "a"),
+				mockError("ERR value is not a valid float"),
+				mockCmd("ZADD", "missing", "XX", "1", "a"),
+				mockInt(0),
+				mockCmd("EXISTS", "missing"),
+				mockInt(0),
+			},
+		},
+		{
+			name: "ZINCRBY",
+			ops: []TestOp{
+				mockCmd("ZINCRBY", "z", "2.5", "a"),
+				mockBulk("2.5"),
+				mockCmd("ZINCRBY", "z", "2.5", "a"),
+				mockBulk("5"),
+				mockCmd("ZSCORE", "z", "a"),
+				mockBulk("5"),
+			},
+		},
+		{
+			name: "ZREM",
+			ops: []TestOp{
+				mockCmd("ZADD", "z", "1", "a", "2", "b"),
+				mockInt(2),
+				mockCmd("ZREM", "z", "a", "nope"),
+				mockInt(1),
+				mockCmd("ZREM", "z", "b"),
+				mockInt(1),
+				mockCmd("EXISTS", "z"),
+				mockInt(0),
+				mockCmd("ZREM", "z"),
+				mockError("ERR wrong number of arguments for 'zrem' command"),
+			},
+		},
+		{
+			name: "ZSCORE",
+			ops: []TestOp{
+				mockCmd("ZSCORE", "z", "a"),
+				mockBulk(nil),
+				mockCmd("ZADD", "z", "1.5", "a", "+inf", "b"),
+				mockInt(2),
+				mockCmd("ZSCORE", "z", "a"),
+				mockBulk("1.5"),
+				mockCmd("ZSCORE", "z", "b"),
+				mockBulk("inf"),
+			},
+		},
+		{
+			name: "ZCARD",
+			ops: []TestOp{
+				mockCmd("ZCARD", "z"),
+				mockInt(0),
+				mockCmd("ZADD", "z", "1", "a", "2", "b"),
+				mockInt(2),
+				mockCmd("ZCARD", "z"),
+				mockInt(2),
+			},
+		},
+		{
+			name: "ZCOUNT",
+			ops: []TestOp{
+				mockCmd("ZADD", "z", "1", "a", "2", "b", "3", "c"),
+				mockInt(3),
+				mockCmd("ZCOUNT", "z", "-inf", "+inf"),
+				mockInt(3),
+				mockCmd("ZCOUNT", "z", "(1", "3"),
+				mockInt(2),
+				mockCmd("ZCOUNT", "z", "(1", "(3"),
+				mockInt(1),
+				mockCmd("ZCOUNT", "z", "x", "3"),
+				mockError("ERR min or max is not a float"),
+			},
+		},
+		{
+			name: "ZRANK",
+			ops: []TestOp{
+				mockCmd("ZADD", "z", "1", "a", "2", "b", "3", "c"),
+				mockInt(3),
+				mockCmd("ZRANK", "z", "a"),
+				mockInt(0),
+				mockCmd("ZRANK", "z", "c"),
+				mockInt(2),
+				mockCmd("ZRANK", "z", "nope"),
+				mockBulk(nil),
+			},
+		},
+		{
+			name: "ZREVRANK",
+			ops: []TestOp{
+				mockCmd("ZADD", "z", "1", "a", "2", "b", "3", "c"),
+				mockInt(3),
+				mockCmd("ZREVRANK", "z", "a"),
+				mockInt(2),
+				mockCmd("ZREVRANK", "z", "c"),
+				mockInt(0),
+			},
+		},
+		{
+			name: "ZRANGE",
+			ops: []TestOp{
+				mockCmd("ZADD", "z", "1", "a", "2", "b", "3", "c", "4", "d"),
+				mockInt(4),
+				mockCmd("ZRANGE", "z", "1", "2"),
+				mockOrderedBulks("b", "c"),
+				mockCmd("ZRANGE", "z", "-2", "-1"),
+				mockOrderedBulks("c", "d"),
+				mockCmd("ZRANGE", "z", "0", "1", "REV"),
+				mockOrderedBulks("d", "c"),
+				mockCmd("ZRANGE", "z", "(1", "3", "BYSCORE", "WITHSCORES"),
+				mockOrderedBulks("b", "2", "c", "3"),
+				mockCmd("ZRANGE", "z", "+inf", "-inf", "BYSCORE", "REV", "LIMIT", "1", "2"),
+				mockOrderedBulks("c", "b"),
+				mockCmd("ZRANGE", "z", "[b", "(d", "BYLEX"),
+				mockOrderedBulks("b", "c"),
+				mockCmd("ZRANGE", "z", "+", "-", "BYLEX", "REV", "LIMIT", "0", "1"),
+				mockOrderedBulks("d"),
+				mockCmd("ZRANGE", "z", "0", "1", "LIMIT", "0", "1"),
+				mockError("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX"),
+				mockCmd("ZRANGE", "z", "0"),
+				mockError("ERR wrong number of arguments for 'zrange' command"),
+			},
+		},
+		{
+			name: "ZRANGEBYSCORE",
+			ops: []TestOp{
+				mockCmd("ZADD", "z", "1", "a", "2", "b", "3", "c"),
+				mockInt(3),
+				mockCmd("ZRANGEBYSCORE", "z", "-inf", "+inf"),
+				mockOrderedBulks("a", "b", "c"),
+				mockCmd("ZRANGEBYSCORE", "z", "2", "+inf", "WITHSCORES", "LIMIT", "1", "-1"),
+				mockOrderedBulks("c", "3"),
+				mockCmd("ZRANGEBYSCORE", "z", "2"),
+				mockError("ERR wrong number of arguments for 'zrangebyscore' command"),
+			},
+		},
+		{
+			name: "ZPOPMIN",
+			ops: []TestOp{
+				mockCmd("ZPOPMIN", "z"),
+				mockOrderedBulks(),
+				mockCmd("ZADD", "z", "1", "a", "2", "b", "3", "c"),
+				mockInt(3),
+				mockCmd("ZPOPMIN", "z"),
+				mockOrderedBulks("a", "1"),
+				mockCmd("ZPOPMIN", "z", "5"),
+				mockOrderedBulks("b", "2", "c", "3"),
+				mockCmd("EXISTS", "z"),
+				mockInt(0),
+				// members are binary safe
+				mockCmd("ZADD", "z", "1", "\xff\x00'", "2", "\\"),
+				mockInt(2),
+				mockCmd("ZPOPMIN", "z", "2"),
+				mockOrderedBulks("\xff\x00'", "1", "\\", "2"),
+			},
+		},
+		{
+			name: "ZPOPMAX",
+			ops: []TestOp{
+				mockCmd("ZADD", "z", "1", "a", "2", "b", "3", "c"),
+				mockInt(3),
+				mockCmd("ZPOPMAX", "z", "2"),
+				mockOrderedBulks("c", "3", "b", "2"),
+				mockCmd("ZPOPMAX", "z", "-1"),
+				mockError("ERR value is out of range, must be positive"),
+			},
+		},
+		{
+			name: "ZREMRANGEBYRANK",
+			ops: []TestOp{
+				mockCmd("ZADD", "z", "1", "a", "2", "b", "3", "c"),
+				mockInt(3),
+				mockCmd("ZREMRANGEBYRANK", "z", "0", "1"),
+				mockInt(2),
+				mockCmd("ZRANGE", "z", "0", "-1"),
+				mockOrderedBulks("c"),
+			},
+		},
+		{
+			name: "ZREMRANGEBYSCORE",
+			ops: []TestOp{
+				mockCmd("ZADD", "z", "1", "a", "2", "b", "3", "c"),
+				mockInt(3),
+				mockCmd("ZREMRANGEBYSCORE", "z", "(1", "+inf"),
+				mockInt(2),
+				mockCmd("ZRANGE", "z", "0", "-1"),
+				mockOrderedBulks("a"),
+			},
+		},
+		{
+			name: "ZREMRANGEBYLEX",
+			ops: []TestOp{
+				mockCmd("ZADD", "z", "0", "a", "0", "b", "0", "c", "0", "d"),
+				mockInt(4),
+				mockCmd("ZREMRANGEBYLEX", "z", "[b", "(d"),
+				mockInt(2),
+				mockCmd("ZRANGE", "z", "0", "-1"),
+				mockOrderedBulks("a", "d"),
+				mockCmd("ZREMRANGEBYLEX", "z", "b", "d"),
+				mockError("ERR min or max not valid string range item"),
+			},
+		},
+		{
+			name: "ZSCAN",
+			ops: []TestOp{
+				mockCmd("ZADD", "foo", "1", "a", "2", "b"),
+				mockInt(2),
+				mockCmd("ZSCAN", "foo", "0"),
+				mockArrayLen(2),
+				mockBulkString("0"),
+				mockOrderedBulks("a", "1", "b", "2"),
+				mockCmd("ZSCAN", "foo"),
+				mockError("ERR wrong number of arguments for 'zscan' command"),
+			},
+		},
+		{
+			name: "ZUNION",
+			ops: []TestOp{
+				mockCmd("ZADD", "zset1", "1", "one", "2", "two"),
+				mockInt(2),
+				mockCmd("ZADD", "zset2", "1", "one", "2", "two", "3", "three"),
+				mockInt(3),
+				mockCmd("ZUNION", "2", "zset1", "zset2", "AGGREGATE", "MAX", "WITHSCORES"),
+				mockOrderedBulks("one", "1", "two", "2", "three", "3"),
+				mockCmd("SADD", "set", "four"),
+				mockInt(1),
+				mockCmd("ZUNION", "2", "zset1", "set", "WEIGHTS", "1", "5", "WITHSCORES"),
+				mockOrderedBulks("one", "1", "two", "2", "four", "5"),
+				mockCmd("SET", "str", "foo"),
+				mockSimpleString("OK"),
+				mockCmd("ZUNION", "2", "zset1", "str"),
+				mockError("ERR type mismatch; got blob, expected zset"),
+				mockCmd("ZUNION"),
+				mockError("ERR wrong number of arguments for 'zunion' command"),
+			},
+		},
+		{
+			name: "ZINTER",
+			ops: []TestOp{
+				mockCmd("ZADD", "zset1", "1", "one", "2", "two"),
+				mockInt(2),
+				mockCmd("ZADD", "zset2", "1", "one", "2", "two", "3", "three"),
+				mockInt(3),
+				mockCmd("ZINTER", "2", "zset1", "zset2"),
+				mockOrderedBulks("one", "two"),
+				mockCmd("ZINTER", "2", "zset1", "zset2", "AGGREGATE", "MIN", "WITHSCORES"),
+				mockOrderedBulks("one", "1", "two", "2"),
+			},
+		},
+		{
+			name: "ZDIFF",
+			ops: []TestOp{
+				mockCmd("ZADD", "zset1", "1", "one", "2", "two"),
+				mockInt(2),
+				mockCmd("ZADD", "zset2", "1", "one", "2", "two", "3", "three"),
+				mockInt(3),
+				mockCmd("ZDIFF", "2", "zset2", "zset1", "WITHSCORES"),
+				mockOrderedBulks("three", "3"),
+				mockCmd("ZDIFF", "2", "zset2", "zset1", "WEIGHTS", "1", "1"),
+				mockError("ERR syntax error"),
+			},
+		},
+		{
+			name: "ZUNIONSTORE",
+			ops: []TestOp{
+				mockCmd("ZADD", "zset1", "1", "one", "2", "two"),
+				mockInt(2),
+				mockCmd("ZADD", "zset2", "1", "one", "2", "two", "3", "three"),
+				mockInt(3),
+				mockCmd("ZUNIONSTORE", "out", "2", "zset1", "zset2", "WEIGHTS", "2", "3"),
+				mockInt(3),
+				mockCmd("ZRANGE", "out", "0", "-1", "WITHSCORES"),
+				mockOrderedBulks("one", "5", "three", "9", "two", "10"),
+				mockCmd("ZUNIONSTORE", "zset1", "2", "zset1", "zset2"),
+				mockInt(3),
+				mockCmd("ZRANGE", "zset1", "0", "-1", "WITHSCORES"),
+				mockOrderedBulks("one", "2", "three", "3", "two", "4"),
+				mockCmd("ZUNIONSTORE", "out", "0", "zset1"),
+				mockError("ERR at least 1 input key is needed for this command"),
+				mockCmd("ZUNIONSTORE", "out", "1"),
+				mockError("ERR wrong number of arguments for 'zunionstore' command"),
+			},
+		},
+		{
+			name: "ZINTERSTORE",
+			ops: []TestOp{
+				mockCmd("ZADD", "zset1", "1", "one", "2", "two"),
+				mockInt(2),
+				mockCmd("ZADD", "zset2", "1", "one", "2", "two", "3", "three"),
+				mockInt(3),
+				mockCmd("ZINTERSTORE", "out", "2", "zset1", "zset2", "WEIGHTS", "2", "3"),
+				mockInt(2),
+				mockCmd("ZRANGE", "out", "0", "-1", "WITHSCORES"),
+				mockOrderedBulks("one", "5", "two", "10"),
+				mockCmd("ZINTERSTORE", "out", "2", "zset1", "missing"),
+				mockInt(0),
+				mockCmd("EXISTS", "out"),
+				mockInt(0),
+			},
+		},
+		{
+			name: "ZDIFFSTORE",
+			ops: []TestOp{
+				mockCmd("ZADD", "zset1", "1", "one", "2", "two"),
+				mockInt(2),
+				mockCmd("ZADD", "zset2", "1", "one", "2", "two", "3", "three"),
+				mockInt(3),
+				mockCmd("ZDIFFSTORE", "out", "2", "zset2", "zset1"),
+				mockInt(1),
+				mockCmd("ZRANGE", "out", "0", "-1", "WITHSCORES"),
+				mockOrderedBulks("three", "3"),
+			},
+		},
+		{
+			name: "RPUSH",
+			ops: []TestOp{
+				mockCmd("RPUSH", "foo"),
+				mockError("ERR wrong number of arguments for 'rpush' command"),
+				mockCmd("RPUSH", "foo", "bar"),
+				mockInt(1),
+				mockCmd("RPUSH", "foo", "baz"),
+				mockInt(2),
+				mockCmd("LRANGE", "foo", "0", "-1"),
+				mockBulks("bar", "baz"),
+				mockCmd("RPUSH", "foo", "baz"),
+				mockInt(3),
+				mockCmd("LRANGE", "foo", "0", "-1"),
+				mockBulks("bar", "baz", "baz"),
+				mockCmd("RPUSH", "foo", "a", "b", "c"),
+				mockInt(6),
+				mockCmd("LRANGE", "foo", "0", "-1"),
+				mockOrderedBulks("bar", "baz", "baz", "a", "b", "c"),
+				mockCmd("SET", "str", "x"),
+				mockSimpleString("OK"),
+				mockCmd("RPUSH", "str", "a"),
+				mockError("ERR type mismatch; got blob, expected list"),
+			},
+		},
+		{
+			name: "LREM",
+			ops: []TestOp{
+				mockCmd("RPUSH", "foo", "bar"),
+				mockInt(1),
+				mockCmd("RPUSH", "foo", "baz"),
+				mockInt(2),
+				mockCmd("LREM", "foo", "bar"),
+				mockInt(1),
+				mockCmd("LRANGE", "foo", "0", "-1"),
+				mockBulks("baz"),
+				mockCmd("RPUSH", "foo", "baz"),
+				mockInt(2),
+				mockCmd("LRANGE", "foo", "0", "-1"),
+				mockBulks("baz", "baz"),
+				mockCmd("LREM", "foo", "baz"),
+				mockInt(2),
+				mockCmd("LRANGE", "foo", "0", "-1"),
+				mockBulks(),
+			},
+		},
+		{
+			name: "LRANGE",
+			ops: []TestOp{
+				mockCmd("RPUSH", "foo", "1"),
+				mockInt(1),
+				mockCmd("RPUSH", "foo", "2"),
+				mockInt(2),
+				mockCmd("RPUSH", "foo", "3"),
+				mockInt(3),
+				mockCmd("LRANGE", "foo", "0", "-1"),
+				mockBulks("1", "2", "3"),
+				mockCmd("LRANGE", "foo", "0", "0"),
+				mockBulks("1"),
+				mockCmd("LRANGE", "foo", "1", "1"),
+				mockBulks("2"),
+				mockCmd("LRANGE", "foo", "0", "1"),
+				mockBulks("1", "2"),
+				mockCmd("LRANGE", "foo", "1", "2"),
+				mockBulks("2", "3"),
+				mockCmd("LRANGE", "foo", "0", "2"),
+				mockBulks("1", "2", "3"),
+				mockCmd("LRANGE", "foo", "0", "100"),
+				mockBulks("1", "2", "3"),
+				mockCmd("LRANGE", "foo", "-2", "-1"),
+				mockOrderedBulks("2", "3"),
+				mockCmd("LRANGE", "foo", "-100", "0"),
+				mockOrderedBulks("1"),
+				mockCmd("LRANGE", "foo", "-1", "100"),
+				mockOrderedBulks("3"),
+				mockCmd("LRANGE", "foo", "1", "-1"),
+				mockOrderedBulks("2", "3"),
+				mockCmd("LRANGE", "foo", "2", "1"),
+				mockOrderedBulks(),
+				mockCmd("LRANGE", "foo", "5", "10"),
+				mockOrderedBulks(),
+				mockCmd("LRANGE", "foo", "-100", "-4"),
+				mockOrderedBulks(),
+				mockCmd("LRANGE", "missing", "0", "-1"),
+				mockOrderedBulks(),
+				mockCmd("LRANGE", "foo", "0", "a"),
+				mockError("ERR value is not an integer or out of range"),
+			},
+		},
+		{
+			name: "LPUSH",
+			ops: []TestOp{
+				mockCmd("LPUSH", "foo", "a"),
+				mockInt(1),
+				mockCmd("LPUSH", "foo", "b", "c"),
+				mockInt(3),
+				mockCmd("RPUSH", "foo", "d"),
+				mockInt(4),
+				mockCmd("LRANGE", "foo", "0", "-1"),
+				mockOrderedBulks("c", "b", "a", "d"),
+				mockCmd("LRANGE", "foo", "0", "1"),
+				mockOrderedBulks("c", "b"),
+				mockCmd("SET", "str", "bar"),
+				mockSimpleString("OK"),
+				mockCmd("LPUSH", "str", "a"),
+				mockError("ERR type mismatch; got blob, expected list"),
+			},
+		},
+		{
+			name: "LPUSHX",
+			ops: []TestOp{
+				mockCmd("LPUSHX", "foo", "a"),
+				mockInt(0),
+				mockCmd("EXISTS", "foo"),
+				mockInt(0),
+				mockCmd("RPUSH", "foo", "b"),
+				mockInt(1),
+				mockCmd("LPUSHX", "foo", "a", "z"),
+				mockInt(3),
+				mockCmd("LRANGE", "foo", "0", "-1"),
+				mockOrderedBulks("z", "a", "b"),
+			},
+		},
+		{
+			name: "RPUSHX",
+			ops: []TestOp{
+				mockCmd("RPUSHX", "foo", "a"),
+				mockInt(0),
+				mockCmd("LPUSH", "foo", "b"),
+				mockInt(1),
+				mockCmd("RPUSHX", "foo", "c", "d"),
+				mockInt(3),
+				mockCmd("LRANGE", "foo", "0", "-1"),
+				mockOrderedBulks("b", "c", "d"),
+			},
+		},
+		{
+			name: "LPOP",
+			ops: []TestOp{
+				mockCmd("LPOP", "foo"),
+				mockBulk(nil),
+				mockCmd("RPUSH", "foo", "a"),
+				mockInt(1),
+				mockCmd("RPUSH", "foo", "b"),
+				mockInt(2),
+				mockCmd("RPUSH", "foo", "c"),
+				mockInt(3),
+				mockCmd("LPOP", "foo"),
+				mockBulk("a"),
+				mockCmd("LPOP", "foo", "5"),
+				mockOrderedBulks("b", "c"),
+				mockCmd("EXISTS", "foo"),
+				mockInt(0),
+				mockCmd("LPOP", "foo", "1"),
+				mockOrderedBulks(),
+				mockCmd("LPOP", "foo", "-1"),
+				mockError("ERR value is out of range, must be positive"),
+				// elements are binary safe
+				mockCmd("RPUSH", "foo", "\xff\x00'", "\\"),
+				mockInt(2),
+				mockCmd("LPOP", "foo", "2"),
+				mockOrderedBulks("\xff\x00'", "\\"),
+			},
+		},
+		{
+			name: "RPOP",
+			ops: []TestOp{
+				mockCmd("LPUSH", "foo", "a", "b", "c"),
+				mockInt(3),
+				mockCmd("RPOP", "foo"),
+				mockBulk("a"),
+				mockCmd("RPOP", "foo", "2"),
+				mockOrderedBulks("b", "c"),
+				mockCmd("RPOP", "foo"),
+				mockBulk(nil),
+			},
+		},
+		{
+			name: "LLEN",
+			ops: []TestOp{
+				mockCmd("LLEN", "foo"),
+				mockInt(0),
+				mockCmd("LPUSH", "foo", "a", "b"),
+				mockInt(2),
+				mockCmd("LLEN", "foo"),
+				mockInt(2),
+			},
+		},
+		{
+			name: "LINDEX",
+			ops: []TestOp{
+				mockCmd("LPUSH", "foo", "c", "b", "a"),
+				mockInt(3),
+				mockCmd("LINDEX", "foo", "0"),
+				mockBulk("a"),
+				mockCmd("LINDEX", "foo", "-1"),
+				mockBulk("c"),
+				mockCmd("LINDEX", "foo", "-3"),
+				mockBulk("a"),
+				mockCmd("LINDEX", "foo", "3"),
+				mockBulk(nil),
+				mockCmd("LINDEX", "foo", "-4"),
+				mockBulk(nil),
+				mockCmd("LINDEX", "missing", "0"),
+				mockBulk(nil),
+			},
+		},
+		{
+			name: "LSET",
+			ops: []TestOp{
+				mockCmd("LPUSH", "foo", "c", "b", "a"),
+				mockInt(3),
+				mockCmd("LSET", "foo", "0", "x"),
+				mockSimpleString("OK"),
+				mockCmd("LSET", "foo", "-2", "y"),
+				mockSimpleString("OK"),
+				mockCmd("LRANGE", "foo", "0", "-1"),
+				mockOrderedBulks("x", "y", "c"),
+				mockCmd("LSET", "foo", "3", "z"),
+				mockError("ERR index out of range"),
+				mockCmd("LSET", "missing", "0", "z"),
+				mockError("ERR no such key"),
+			},
+		},
+		{
+			name: "LINSERT",
+			ops: []TestOp{
+				mockCmd("LINSERT", "foo", "BEFORE", "a", "x"),
+				mockInt(0),
+				mockCmd("LPUSH", "foo", "d", "c", "b", "a"),
+				mockInt(4),
+				mockCmd("LINSERT", "foo", "BEFORE", "b", "x"),
+				mockInt(5),
+				mockCmd("LINSERT", "foo", "AFTER", "c", "y"),
+				mockInt(6),
+				mockCmd("LINSERT", "foo", "after", "d", "z"),
+				mockInt(7),
+				mockCmd("LRANGE", "foo", "0", "-1"),
+				mockOrderedBulks("a", "x", "b", "c", "y", "d", "z"),
+				mockCmd("LINSERT", "foo", "BEFORE", "missing", "x"),
+				mockInt(-1),
+				mockCmd("LINSERT", "foo", "AROUND", "a", "x"),
+				mockError("ERR syntax error"),
+			},
+		},
+		{
+			name: "LPOS",
+			ops: []TestOp{
+				mockCmd("LPUSH", "foo", "c", "b", "a", "c", "b", "a"),
+				mockInt(6),
+				mockCmd("LPOS", "foo", "b"),
+				mockInt(1),
+				mockCmd("LPOS", "foo", "b", "RANK", "2"),
+				mockInt(4),
+				mockCmd("LPOS", "foo", "b", "RANK", "-1"),
+				mockInt(4),
+				mockCmd("LPOS", "foo", "a", "COUNT", "0"),
+				mockObjects(0, 3),
+				mockCmd("LPOS", "foo", "c", "RANK", "-1", "COUNT", "2"),
+				mockObjects(5, 2),
+				mockCmd("LPOS", "foo", "c", "COUNT", "0", "MAXLEN", "3"),
+				mockObjects(2),
+				mockCmd("LPOS", "foo", "x"),
+				mockBulk(nil),
+				mockCmd("LPOS", "foo", "x", "COUNT", "1"),
+				mockObjects(),
+				mockCmd("LPOS", "foo", "a", "RANK", "0"),
+				mockError("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the last match"),
+				mockCmd("LPOS", "foo"),
+				mockError("ERR wrong number of arguments for 'lpos' command"),
+			},
+		},
+		{
+			name: "LTRIM",
+			ops: []TestOp{
+				mockCmd("LPUSH", "foo", "e", "d", "c", "b", "a"),
+				mockInt(5),
+				mockCmd("LTRIM", "foo", "1", "-2"),
+				mockSimpleString("OK"),
+				mockCmd("LRANGE", "foo", "0", "-1"),
+				mockOrderedBulks("b", "c", "d"),
+				mockCmd("LTRIM", "foo", "-100", "100"),
+				mockSimpleString("OK"),
+				mockCmd("LLEN", "foo"),
+				mockInt(3),
+				mockCmd("LTRIM", "foo", "2", "1"),
+				mockSimpleString("OK"),
+				mockCmd("EXISTS", "foo"),
+				mockInt(0),
+			},
+		},
+		{
+			name: "LMOVE",
+			ops: []TestOp{
+				mockCmd("LMOVE", "src", "dest", "LEFT", "RIGHT"),
+				mockBulk(nil),
+				mockCmd("EXISTS", "dest"),
+				mockInt(0),
+				mockCmd("LPUSH", "src", "a", "b", "c"),
+				mockInt(3),
+				mockCmd("LMOVE", "src", "dest", "LEFT", "RIGHT"),
+				mockBulk("c"),
+				mockCmd("LMOVE", "src", "dest", "right", "left"),
+				mockBulk("a"),
+				mockCmd("LRANGE", "dest", "0", "-1"),
+				mockOrderedBulks("a", "c"),
+				mockCmd("LMOVE", "dest", "dest", "LEFT", "RIGHT"),
+				mockBulk("a"),
+				mockCmd("LRANGE", "dest", "0", "-1"),
+				mockOrderedBulks("c", "a"),
+				mockCmd("LMOVE", "src", "dest", "LEFT", "LEFT"),
+				mockBulk("b"),
+				mockCmd("EXISTS", "src"),
+				mockInt(0),
+				mockCmd("SET", "str", "foo"),
+				mockSimpleString("OK"),
+				mockCmd("LMOVE", "dest", "str", "LEFT", "LEFT"),
+				mockError("ERR type mismatch; got blob, expected list"),
+				mockCmd("LRANGE", "dest", "0", "-1"),
+				mockOrderedBulks("b", "c", "a"),
+				mockCmd("LMOVE", "dest", "str", "UP", "LEFT"),
+				mockError("ERR syntax error"),
+			},
+		},
+		{
+			name: "RPOPLPUSH",
+			ops: []TestOp{
+				mockCmd("LPUSH", "queue", "a", "b", "c"),
+				mockInt(3),
+				mockCmd("RPOPLPUSH", "queue", "processing"),
+				mockBulk("a"),
+				mockCmd("RPOPLPUSH", "queue", "queue"),
+				mockBulk("b"),
+				mockCmd("LRANGE", "queue", "0", "-1"),
+				mockOrderedBulks("b", "c"),
+				mockCmd("LRANGE", "processing", "0", "-1"),
+				mockOrderedBulks("a"),
+				mockCmd("RPOPLPUSH", "missing", "processing"),
+				mockBulk(nil),
+			},
+		},
+		{
+			name: "LMPOP",
+			ops: []TestOp{
+				mockCmd("LMPOP", "2", "foo", "bar", "LEFT"),
+				mockOrderedBulks(),
+				mockCmd("LPUSH", "bar", "c", "b", "a"),
+				mockInt(3),
+				mockCmd("LMPOP", "2", "foo", "bar", "LEFT"),
+				mockArrayLen(2),
+				mockBulk("bar"),
+				mockOrderedBulks("a"),
+				mockCmd("LMPOP", "2", "foo", "bar", "RIGHT", "COUNT", "5"),
+				mockArrayLen(2),
+				mockBulk("bar"),
+				mockOrderedBulks("c", "b"),
+				mockCmd("EXISTS", "bar"),
+				mockInt(0),
+				mockCmd("LMPOP", "2", "foo"),
+				mockError("ERR wrong number of arguments for 'lmpop' command"),
+				mockCmd("LMPOP", "0", "foo", "LEFT"),
+				mockError("ERR numkeys should be greater than 0"),
+				mockCmd("LMPOP", "1", "foo", "LEFT", "COUNT", "0"),
+				mockError("ERR count should be greater than 0"),
+			},
+		},
+		{
+			name: "BLPOP",
+			ops: []TestOp{
+				mockCmd("RPUSH", "bar", "a"),
+				mockInt(1),
+				mockCmd("RPUSH", "bar", "b"),
+				mockInt(2),
+				mockCmd("BLPOP", "foo", "bar", "0"),
+				mockOrderedBulks("bar", "a"),
+				mockCmd("BLPOP", "foo", "0.01"),
+				mockOrderedBulks(),
+				mockCmd("BLPOP", "foo", "-1"),
+				mockError("ERR timeout is negative"),
+				mockCmd("BLPOP", "foo", "soon"),
+				mockError("ERR timeout is not a float or out of range"),
+			},
+		},
+		{
+			name: "BRPOP",
+			ops: []TestOp{
+				mockCmd("LPUSH", "foo", "a", "b"),
+				mockInt(2),
+				mockCmd("BRPOP", "foo", "1"),
+				mockOrderedBulks("foo", "a"),
+				mockCmd("BRPOP", "foo", "1"),
+				mockOrderedBulks("foo", "b"),
+				mockCmd("BRPOP", "foo", "0.01"),
+				mockOrderedBulks(),
+			},
+		},
+		{
+			name: "BLMOVE",
+			ops: []TestOp{
+				mockCmd("LPUSH", "src", "a", "b"),
+				mockInt(2),
+				mockCmd("BLMOVE", "src", "dest", "RIGHT", "LEFT", "0"),
+				mockBulk("a"),
+				mockCmd("BLMOVE", "missing", "dest", "RIGHT", "LEFT", "0.01"),
+				mockBulk(nil),
+				mockCmd("LRANGE", "dest", "0", "-1"),
+				mockOrderedBulks("a"),
+			},
+		},
+		{
+			name: "BRPOPLPUSH",
+			ops: []TestOp{
+				mockCmd("LPUSH", "queue", "a"),
+				mockInt(1),
+				mockCmd("BRPOPLPUSH", "queue", "processing", "0"),
+				mockBulk("a"),
+				mockCmd("BRPOPLPUSH", "queue", "processing", "0.01"),
+				mockBulk(nil),
+			},
+		},
+		{
+			name: "BLMPOP",
+			ops: []TestOp{
+				mockCmd("LPUSH", "bar", "b", "a"),
+				mockInt(2),
+				mockCmd("BLMPOP", "0", "2", "foo", "bar", "RIGHT", "COUNT", "2"),
+				mockArrayLen(2),
+				mockBulk("bar"),
+				mockOrderedBulks("b", "a"),
+				mockCmd("BLMPOP", "0.01", "1", "foo", "LEFT"),
+				mockOrderedBulks(),
+				mockCmd("BLMPOP", "0"),
+				mockError("ERR wrong number of arguments for 'blmpop' command"),
+			},
+		},
+		{
+			name: "SADD",
+			ops: []TestOp{
+				mockCmd("SADD", "foo", "1"),
+				mockInt(1),
+				mockCmd("SMEMBERS", "foo"),
+				mockBulks("1"),
+				mockCmd("SADD", "foo", "1"),
+				mockInt(0),
+				mockCmd("SMEMBERS", "foo"),
+				mockBulks("1"),
+				mockCmd("SADD", "foo", "2"),
+				mockInt(1),
+				mockCmd("SMEMBERS", "foo"),
+				mockBulks("1", "2"),
+				mockCmd("SADD", "foo", "2", "3", "4", "4"),
+				mockInt(2),
+				mockCmd("SMEMBERS", "foo"),
+				mockBulks("1", "2", "3", "4"),
+				mockCmd("SADD", "foo"),
+				mockError("ERR wrong number of arguments for 'sadd' command"),
+			},
+		},
+		{
+			name: "SREM",
+			ops: []TestOp{
+				mockCmd("SREM", "foo"),
+				mockError("ERR wrong number of arguments for 'srem' command"),
+				mockCmd("SADD", "foo", "1"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "2"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "3"),
+				mockInt(1),
+				mockCmd("SREM", "foo", "1"),
+				mockInt(1),
+				mockCmd("SMEMBERS", "foo"),
+				mockBulks("2", "3"),
+				mockCmd("SREM", "foo", "1"),
+				mockInt(0),
+				mockCmd("SMEMBERS", "foo"),
+				mockBulks("2", "3"),
+				mockCmd("SREM", "foo", "2"),
+				mockInt(1),
+				mockCmd("SREM", "foo", "3"),
+				mockInt(1),
+				mockCmd("SMEMBERS", "foo"),
+				mockBulks(),
+				mockCmd("EXISTS", "foo"),
+				mockInt(0),
+				mockCmd("SADD", "foo", "1", "2", "3"),
+				mockInt(3),
+				mockCmd("SREM", "foo", "1", "3", "5"),
+				mockInt(2),
+				mockCmd("SMEMBERS", "foo"),
+				mockBulks("2"),
+			},
+		},
+		{
+			name: "SMEMBERS",
+			ops: []TestOp{
+				mockCmd("SADD", "foo", "1"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "2"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "3"),
+				mockInt(1),
+				mockCmd("SMEMBERS", "foo"),
+				mockBulks("1", "2", "3"),
+			},
+		},
+		{
+			name: "SINTER",
+			ops: []TestOp{
+				mockCmd("SADD", "foo", "1"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "2"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "3"),
+				mockInt(1),
+				mockCmd("SADD", "bar", "3"),
+				mockInt(1),
+				mockCmd("SADD", "bar", "4"),
+				mockInt(1),
+				mockCmd("SADD", "bar", "5"),
+				mockInt(1),
+				mockCmd("SADD", "baz", "5"),
+				mockInt(1),
+				mockCmd("SADD", "baz", "6"),
+				mockInt(1),
+				mockCmd("SADD", "baz", "7"),
+				mockInt(1),
+				mockCmd("SINTER", "foo", "bar"),
+				mockBulks("3"),
+				mockCmd("SINTER", "foo", "bar", "baz"),
+				mockBulks(),
+				mockCmd("SADD", "baz", "3"),
+				mockInt(1),
+				mockCmd("SINTER", "foo", "bar", "baz"),
+				mockBulks("3"),
+				mockCmd("SADD", "t", "1"),
+				mockInt(1),
+				mockCmd("SADD", "t2", "2"),
+				mockInt(1),
+				mockCmd("SINTER", "t", "t2"),
+				mockBulks(),
+				mockCmd("SINTER", "foo"),
+				mockBulks("1", "2", "3"),
+			},
+		},
+		{
+			name: "SUNION",
+			ops: []TestOp{
+				mockCmd("SADD", "foo", "1"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "2"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "3"),
+				mockInt(1),
+				mockCmd("SADD", "bar", "3"),
+				mockInt(1),
+				mockCmd("SADD", "bar", "4"),
+				mockInt(1),
+				mockCmd("SADD", "bar", "5"),
+				mockInt(1),
+				mockCmd("SADD", "baz", "5"),
+				mockInt(1),
+				mockCmd("SADD", "baz", "6"),
+				mockInt(1),
+				mockCmd("SUNION", "foo", "bar"),
+				mockBulks("1", "2", "3", "4", "5"),
+				mockCmd("SUNION", "foo", "bar", "baz"),
+				mockBulks("1", "2", "3", "4", "5", "6"),
+				mockCmd("SADD", "t", "1"),
+				mockInt(1),
+				mockCmd("SADD", "t2", "2"),
+				mockInt(1),
+				mockCmd("SUNION", "t", "t2"),
+				mockBulks("1", "2"),
+				mockCmd("SUNION", "foo"),
+				mockBulks("1", "2", "3"),
+			},
+		},
+		{
+			name: "SWITHMEMBER",
+			ops: []TestOp{
+				mockCmd("SADD", "foo", "1"),
+				mockInt(1),
+				mockCmd("SADD", "baz", "1"),
+				mockInt(1),
+				mockCmd("SADD", "baz", "2"),
+				mockInt(1),
+				mockCmd("SADD", "bar", "1"),
+				mockInt(1),
+				mockCmd("SADD", "bar", "2"),
+				mockInt(1),
+				mockCmd("SWITHMEMBER", "1"),
+				mockBulks("foo", "bar", "baz"),
+				mockCmd("SWITHMEMBER", "2"),
+				mockBulks("bar", "baz"),
+				mockCmd("UNLINK", "bar"),
+				mockInt(1),
+				mockCmd("SWITHMEMBER", "2"),
+				mockBulks("baz"),
+			},
+		},
+		{
+			name: "SCARD",
+			ops: []TestOp{
+				mockCmd("SCARD", "foo"),
+				mockInt(0),
+				mockCmd("SADD", "foo", "1"),
+				mockInt(1),
+				mockCmd("SCARD", "foo"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "2"),
+				mockInt(1),
+				mockCmd("SCARD", "foo"),
+				mockInt(2),
+				mockCmd("SREM", "foo", "2"),
+				mockInt(1),
+				mockCmd("SCARD", "foo"),
+				mockInt(1),
+				mockCmd("DEL", "foo"),
+				mockInt(1),
+				mockCmd("SCARD", "foo"),
+				mockInt(0),
+			},
+		},
+		{
+			name: "SDIFF",
+			ops: []TestOp{
+				mockCmd("SADD", "foo", "1"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "2"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "3"),
+				mockInt(1),
+				mockCmd("SADD", "bar", "2"),
+				mockInt(1),
+				mockCmd("SADD", "baz", "3"),
+				mockInt(1),
+				mockCmd("SDIFF", "foo", "bar"),
+				mockBulks("1", "3"),
+				mockCmd("SDIFF", "foo", "bar", "baz"),
+				mockBulks("1"),
+				mockCmd("SDIFF", "foo"),
+				mockBulks("1", "2", "3"),
+				mockCmd("SDIFF", "missing", "foo"),
+				mockBulks(),
+			},
+		},
+		{
+			name: "SUNIONSTORE",
+			ops: []TestOp{
+				mockCmd("SADD", "foo", "1"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "2"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "3"),
+				mockInt(1),
+				mockCmd("SADD", "bar", "3"),
+				mockInt(1),
+				mockCmd("SADD", "bar", "4"),
+				mockInt(1),
+				mockCmd("SUNIONSTORE", "out", "foo", "bar"),
+				mockInt(4),
+				mockCmd("SMEMBERS", "out"),
+				mockBulks("1", "2", "3", "4"),
+				mockCmd("SUNIONSTORE", "foo", "foo", "bar"),
+				mockInt(4),
+				mockCmd("SMEMBERS", "foo"),
+				mockBulks("1", "2", "3", "4"),
+			},
+		},
+		{
+			name: "SINTERSTORE",
+			ops: []TestOp{
+				mockCmd("SADD", "foo", "1"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "2"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "3"),
+				mockInt(1),
+				mockCmd("SADD", "bar", "3"),
+				mockInt(1),
+				mockCmd("SADD", "bar", "4"),
+				mockInt(1),
+				mockCmd("SINTERSTORE", "out", "foo", "bar"),
+				mockInt(1),
+				mockCmd("SMEMBERS", "out"),
+				mockBulks("3"),
+				mockCmd("SINTERSTORE", "out", "foo", "missing"),
+				mockInt(0),
+				mockCmd("EXISTS", "out"),
+				mockInt(0),
+			},
+		},
+		{
+			name: "SDIFFSTORE",
+			ops: []TestOp{
+				mockCmd("SADD", "foo", "1"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "2"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "3"),
+				mockInt(1),
+				mockCmd("SADD", "bar", "3"),
+				mockInt(1),
 				mockCmd("SADD", "bar", "4"),
+				mockInt(1),
+				mockCmd("SDIFFSTORE", "out", "foo", "bar"),
+				mockInt(2),
+				mockCmd("SMEMBERS", "out"),
+				mockBulks("1", "2"),
+				mockCmd("SET", "str", "x"),
 				mockSimpleString("OK"),
-				mockCmd("SADD", "bar", "5"),
+				mockCmd("SDIFFSTORE", "str", "bar", "foo"),
+				mockInt(1),
+				mockCmd("SMEMBERS", "str"),
+				mockBulks("4"),
+				mockCmd("SDIFFSTORE", "out"),
+				mockError("ERR wrong number of arguments for 'sdiffstore' command"),
+			},
+		},
+		{
+			name: "SSCAN",
+			ops: []TestOp{
+				mockCmd("SADD", "foo", "a", "b", "c"),
+				mockInt(3),
+				mockCmd("SSCAN", "foo", "0"),
+				mockArrayLen(2),
+				mockBulkString("0"),
+				mockBulks("a", "b", "c"),
+				mockCmd("SSCAN", "foo", "0", "MATCH", "b*"),
+				mockArrayLen(2),
+				mockBulkString("0"),
+				mockBulks("b"),
+				mockCmd("SSCAN", "foo", "0", "TYPE", "set"),
+				mockError("ERR syntax error"),
+				mockCmd("SSCAN", "foo", "0", "COUNT", "2"),
+				mockArrayLen(2),
+				mockBulkString("1053123188956631767"),
+				mockOrderedBulks("a", "b"),
+				mockCmd("SSCAN", "foo", "1053123188956631767", "COUNT", "2"),
+				mockArrayLen(2),
+				mockBulkString("0"),
+				mockOrderedBulks("c"),
+				mockCmd("SSCAN", "foo"),
+				mockError("ERR wrong number of arguments for 'sscan' command"),
+				// members and patterns are binary safe
+				mockCmd("SADD", "foo", "\xff\x00'"),
+				mockInt(1),
+				mockCmd("SSCAN", "foo", "0", "MATCH", "\xff*"),
+				mockArrayLen(2),
+				mockBulkString("0"),
+				mockBulks("\xff\x00'"),
+			},
+		},
+		{
+			name: "SISMEMBER",
+			ops: []TestOp{
+				mockCmd("SADD", "foo", "1"),
+				mockInt(1),
+				mockCmd("SISMEMBER", "foo", "1"),
+				mockInt(1),
+				mockCmd("SISMEMBER", "foo", "2"),
+				mockInt(0),
+				mockCmd("SISMEMBER", "missing", "1"),
+				mockInt(0),
+			},
+		},
+		{
+			name: "SMISMEMBER",
+			ops: []TestOp{
+				mockCmd("SADD", "foo", "1"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "3"),
+				mockInt(1),
+				mockCmd("SMISMEMBER", "foo", "1", "2", "3", "1"),
+				mockObjects(1, 0, 1, 1),
+				mockCmd("SMISMEMBER", "missing", "1"),
+				mockObjects(0),
+				// members are binary safe
+				mockCmd("SADD", "foo", "\xff\x00'"),
+				mockInt(1),
+				mockCmd("SMISMEMBER", "foo", "\xff\x00'", "\xff"),
+				mockObjects(1, 0),
+				mockCmd("SMISMEMBER", "foo"),
+				mockError("ERR wrong number of arguments for 'smismember' command"),
+			},
+		},
+		{
+			name: "SPOP",
+			ops: []TestOp{
+				mockCmd("SPOP", "foo"),
+				mockBulk(nil),
+				mockCmd("SPOP", "foo", "2"),
+				mockBulks(),
+				mockCmd("SADD", "foo", "1"),
+				mockInt(1),
+				mockCmd("SPOP", "foo"),
+				mockBulk("1"),
+				mockCmd("EXISTS", "foo"),
+				mockInt(0),
+				mockCmd("SADD", "foo", "1"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "2"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "3"),
+				mockInt(1),
+				mockCmd("SPOP", "foo", "5"),
+				mockBulks("1", "2", "3"),
+				mockCmd("SCARD", "foo"),
+				mockInt(0),
+				mockCmd("SPOP", "foo", "-1"),
+				mockError("ERR value is out of range, must be positive"),
+				// members are binary safe
+				mockCmd("SADD", "foo", "\xff\x00'", "\\"),
+				mockInt(2),
+				mockCmd("SPOP", "foo", "2"),
+				mockBulks("\xff\x00'", "\\"),
+			},
+		},
+		{
+			name: "SRANDMEMBER",
+			ops: []TestOp{
+				mockCmd("SRANDMEMBER", "foo"),
+				mockBulk(nil),
+				mockCmd("SADD", "foo", "1"),
+				mockInt(1),
+				mockCmd("SRANDMEMBER", "foo"),
+				mockBulk("1"),
+				mockCmd("SRANDMEMBER", "foo", "-3"),
+				mockBulks("1", "1", "1"),
+				mockCmd("SADD", "foo", "2"),
+				mockInt(1),
+				mockCmd("SRANDMEMBER", "foo", "5"),
+				mockBulks("1", "2"),
+				mockCmd("SRANDMEMBER", "foo", "0"),
+				mockBulks(),
+				mockCmd("SRANDMEMBER", "foo", "-9223372036854775808"),
+				mockError("ERR value is out of range"),
+				mockCmd("SCARD", "foo"),
+				mockInt(2),
+			},
+		},
+		{
+			name: "SMOVE",
+			ops: []TestOp{
+				mockCmd("SADD", "src", "1"),
+				mockInt(1),
+				mockCmd("SADD", "src", "2"),
+				mockInt(1),
+				mockCmd("SMOVE", "src", "dest", "1"),
+				mockInt(1),
+				mockCmd("SMOVE", "src", "dest", "1"),
+				mockInt(0),
+				mockCmd("SMOVE", "src", "src", "2"),
+				mockInt(1),
+				mockCmd("SMOVE", "src", "dest", "2"),
+				mockInt(1),
+				mockCmd("EXISTS", "src"),
+				mockInt(0),
+				mockCmd("SMEMBERS", "dest"),
+				mockBulks("1", "2"),
+				mockCmd("SET", "str", "foo"),
 				mockSimpleString("OK"),
+				mockCmd("SMOVE", "dest", "str", "1"),
+				mockError("ERR type mismatch; got blob, expected set"),
+				mockCmd("SISMEMBER", "dest", "1"),
+				mockInt(1),
+			},
+		},
+		{
+			name: "SINTERCARD",
+			ops: []TestOp{
+				mockCmd("SADD", "foo", "1"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "2"),
+				mockInt(1),
+				mockCmd("SADD", "foo", "3"),
+				mockInt(1),
+				mockCmd("SADD", "bar", "3"),
+				mockInt(1),
+				mockCmd("SADD", "bar", "4"),
+				mockInt(1),
+				mockCmd("SADD", "bar", "5"),
+				mockInt(1),
 				mockCmd("SADD", "baz", "5"),
-				mockSimpleString("OK"),
+				mockInt(1),
 				mockCmd("SADD", "baz", "6"),
-				mockSimpleString("OK"),
+				mockInt(1),
 				mockCmd("SADD", "baz", "7"),
-				mockSimpleString("OK"),
-				mockCmd("SINTER", "foo", "bar"),
-				mockBulks("3"),
-				mockCmd("SINTER", "foo", "bar", "baz"),
-				mockBulks(),
+				mockInt(1),
+				mockCmd("SINTERCARD", "2", "foo", "bar"),
+				mockInt(1),
+				mockCmd("SINTERCARD", "3", "foo", "bar", "baz"),
+				mockInt(0),
 				mockCmd("SADD", "baz", "3"),
-				mockSimpleString("OK"),
-				mockCmd("SINTER", "foo", "bar", "baz"),
-				mockBulks("3"),
+				mockInt(1),
+				mockCmd("SINTERCARD", "3", "foo", "bar", "baz"),
+				mockInt(1),
 				mockCmd("SADD", "t", "1"),
-				mockSimpleString("OK"),
+				mockInt(1),
 				mockCmd("SADD", "t2", "2"),
-				mockSimpleString("OK"),
-				mockCmd("SINTER", "t", "t2"),
-				mockBulks(),
+				mockInt(1),
+				mockCmd("SINTERCARD", "2", "t", "t2"),
+				mockInt(0),
+				mockCmd("SINTERCARD", "1", "foo"),
+				mockInt(3),
+				mockCmd("SINTERCARD", "1", "foo", "LIMIT", "2"),
+				mockInt(2),
+				mockCmd("SINTERCARD", "1", "foo", "LIMIT", "0"),
+				mockInt(3),
+				mockCmd("SINTERCARD", "3", "foo", "bar", "baz", "limit", "1"),
+				mockInt(1),
+				mockCmd("SINTERCARD", "0", "foo"),
+				mockError("ERR numkeys should be greater than 0"),
+				mockCmd("SINTERCARD", "3", "foo", "bar"),
+				mockError("ERR Number of keys can't be greater than number of args"),
+				mockCmd("SINTERCARD", "1", "foo", "LIMIT", "-1"),
+				mockError("ERR LIMIT can't be negative"),
+				mockCmd("SINTERCARD", "2", "foo", "bar", "baz"),
+				mockError("ERR syntax error"),
+			},
+		},
+		{
+			name: "XADD",
+			ops: []TestOp{
+				mockCmd("XADD", "s", "1-1", "a", "1"),
+				mockBulk("1-1"),
+				mockCmd("XADD", "s", "1-*", "b", "2"),
+				mockBulk("1-2"),
+				mockCmd("XADD", "s", "1-2", "c", "3"),
+				mockError("ERR The ID specified in XADD is equal or smaller than the target stream top item"),
+				mockCmd("XADD", "s", "5", "c", "3"),
+				mockBulk("5-0"),
+				mockCmd("XADD", "s", "MAXLEN", "2", "6-0", "d", "4"),
+				mockBulk("6-0"),
+				mockCmd("XRANGE", "s", "-", "+"),
+				mockArrayLen(2),
+				mockArrayLen(2),
+				mockBulkString("5-0"),
+				mockOrderedBulks("c", "3"),
+				mockArrayLen(2),
+				mockBulkString("6-0"),
+				mockOrderedBulks("d", "4"),
+				mockCmd("XADD", "missing", "NOMKSTREAM", "*", "a", "1"),
+				mockBulk(nil),
+				mockCmd("EXISTS", "missing"),
+				mockInt(0),
+				mockCmd("XADD", "new", "0-0", "a", "1"),
+				mockError("ERR The ID specified in XADD must be greater than 0-0"),
+				mockCmd("XADD", "new", "1-x", "a", "1"),
+				mockError("ERR Invalid stream ID specified as stream command argument"),
+				mockCmd("XADD", "new", "*", "a"),
+				mockError("ERR wrong number of arguments for 'xadd' command"),
+				mockCmd("XADD", "new", "MAXLEN", "1", "LIMIT", "10", "*", "a", "1"),
+				mockError("ERR syntax error, LIMIT cannot be used without the special ~ option"),
+				mockCmd("SET", "str", "x"),
+				mockSimpleString("OK"),
+				mockCmd("XADD", "str", "*", "a", "1"),
+				mockError("ERR type mismatch; got blob, expected stream"),
+				mockCmd("TYPE", "s"),
+				mockSimpleString("stream"),
+			},
+		},
+		{
+			name: "XRANGE",
+			ops: []TestOp{
+				mockCmd("XRANGE", "s", "-", "+"),
+				mockArrayLen(0),
+				mockCmd("XADD", "s", "1-0", "a", "1"),
+				mockBulk("1-0"),
+				mockCmd("XADD", "s", "1-1", "b", "2"),
+				mockBulk("1-1"),
+				mockCmd("XADD", "s", "2-0", "c", "3", "d", "4"),
+				mockBulk("2-0"),
+				mockCmd("XRANGE", "s", "1", "1"),
+				mockArrayLen(2),
+				mockArrayLen(2),
+				mockBulkString("1-0"),
+				mockOrderedBulks("a", "1"),
+				mockArrayLen(2),
+				mockBulkString("1-1"),
+				mockOrderedBulks("b", "2"),
+				mockCmd("XRANGE", "s", "(1-0", "+", "COUNT", "1"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulkString("1-1"),
+				mockOrderedBulks("b", "2"),
+				mockCmd("XRANGE", "s", "2", "+"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulkString("2-0"),
+				mockOrderedBulks("c", "3", "d", "4"),
+				mockCmd("XRANGE", "s", "-", "+", "COUNT", "0"),
+				mockOrderedBulks(),
+				mockCmd("XRANGE", "s", "(+", "+"),
+				mockError("ERR invalid start ID for the interval"),
+				mockCmd("XRANGE", "s", "x", "+"),
+				mockError("ERR Invalid stream ID specified as stream command argument"),
+			},
+		},
+		{
+			name: "XREVRANGE",
+			ops: []TestOp{
+				mockCmd("XADD", "s", "1-0", "a", "1"),
+				mockBulk("1-0"),
+				mockCmd("XADD", "s", "2-0", "b", "2"),
+				mockBulk("2-0"),
+				mockCmd("XREVRANGE", "s", "+", "-"),
+				mockArrayLen(2),
+				mockArrayLen(2),
+				mockBulkString("2-0"),
+				mockOrderedBulks("b", "2"),
+				mockArrayLen(2),
+				mockBulkString("1-0"),
+				mockOrderedBulks("a", "1"),
+				mockCmd("XREVRANGE", "s", "+", "(1-0"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulkString("2-0"),
+				mockOrderedBulks("b", "2"),
+				mockCmd("XREVRANGE", "s", "(0-0", "-"),
+				mockError("ERR invalid end ID for the interval"),
+				mockCmd("XRANGE", "s"),
+				mockError("ERR wrong number of arguments for 'xrange' command"),
+			},
+		},
+		{
+			name: "XLEN",
+			ops: []TestOp{
+				mockCmd("XLEN", "s"),
+				mockInt(0),
+				mockCmd("XADD", "s", "1-0", "a", "1"),
+				mockBulk("1-0"),
+				mockCmd("XADD", "s", "2-0", "b", "2"),
+				mockBulk("2-0"),
+				mockCmd("XLEN", "s"),
+				mockInt(2),
+			},
+		},
+		{
+			name: "XDEL",
+			ops: []TestOp{
+				mockCmd("XADD", "s", "1-0", "a", "1"),
+				mockBulk("1-0"),
+				mockCmd("XADD", "s", "2-0", "b", "2"),
+				mockBulk("2-0"),
+				mockCmd("XDEL", "s", "1-0", "3-0", "1"),
+				mockInt(1),
+				mockCmd("XDEL", "s", "2-0"),
+				mockInt(1),
+				mockCmd("XLEN", "s"),
+				mockInt(0),
+				mockCmd("EXISTS", "s"),
+				mockInt(1),
+				mockCmd("XADD", "s", "2-0", "c", "3"),
+				mockError("ERR The ID specified in XADD is equal or smaller than the target stream top item"),
+			},
+		},
+		{
+			name: "XTRIM",
+			ops: []TestOp{
+				mockCmd("XADD", "s", "1-0", "a", "1"),
+				mockBulk("1-0"),
+				mockCmd("XADD", "s", "2-0", "b", "2"),
+				mockBulk("2-0"),
+				mockCmd("XADD", "s", "3-0", "c", "3"),
+				mockBulk("3-0"),
+				mockCmd("XADD", "s", "4-0", "d", "4"),
+				mockBulk("4-0"),
+				mockCmd("XTRIM", "s", "MINID", "2"),
+				mockInt(1),
+				mockCmd("XTRIM", "s", "MAXLEN", "~", "0", "LIMIT", "1"),
+				mockInt(1),
+				mockCmd("XTRIM", "s", "MAXLEN", "=", "1"),
+				mockInt(1),
+				mockCmd("XRANGE", "s", "-", "+"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulkString("4-0"),
+				mockOrderedBulks("d", "4"),
+				mockCmd("XTRIM", "s", "MAXLEN", "-1"),
+				mockError("ERR The MAXLEN argument must be >= 0."),
+				mockCmd("XTRIM", "s", "COUNT", "1"),
+				mockError("ERR syntax error"),
+				mockCmd("XTRIM", "s", "MAXLEN"),
+				mockError("ERR wrong number of arguments for 'xtrim' command"),
 			},
 		},
 		{
-			name: "SUNION",
+			name: "XREAD",
 			ops: []TestOp{
-				mockCmd("SADD", "foo", "1"),
-				mockSimpleString("OK"),
-				mockCmd("SADD", "foo", "2"),
-				mockSimpleString("OK"),
-				mockCmd("SADD", "foo", "3"),
-				mockSimpleString("OK"),
-				mockCmd("SADD", "bar", "3"),
-				mockSimpleString("OK"),
-				mockCmd("SADD", "bar", "4"),
-				mockSimpleString("OK"),
-				mockCmd("SADD", "bar", "5"),
-				mockSimpleString("OK"),
-				mockCmd("SADD", "baz", "5"),
-				mockSimpleString("OK"),
-				mockCmd("SADD", "baz", "6"),
-				mockSimpleString("OK"),
-				mockCmd("SUNION", "foo", "bar"),
-				mockBulks("1", "2", "3", "4", "5"),
-				mockCmd("SUNION", "foo", "bar", "baz"),
-				mockBulks("1", "2", "3", "4", "5", "6"),
-				mockCmd("SADD", "t", "1"),
+				mockCmd("XREAD", "STREAMS", "s"),
+				mockError("ERR wrong number of arguments for 'xread' command"),
+				mockCmd("XREAD", "STREAMS", "s", "t", "0"),
+				mockError("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified."),
+				mockCmd("XREAD", "STREAMS", "s", "t", "0", "0"),
+				mockOrderedBulks(),
+				mockCmd("XADD", "s", "1-0", "a", "1"),
+				mockBulk("1-0"),
+				mockCmd("XADD", "s", "2-0", "b", "2"),
+				mockBulk("2-0"),
+				mockCmd("XADD", "t", "1-0", "c", "3"),
+				mockBulk("1-0"),
+				mockCmd("XREAD", "COUNT", "1", "STREAMS", "s", "t", "0", "1-0"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulk("s"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulkString("1-0"),
+				mockOrderedBulks("a", "1"),
+				mockCmd("XREAD", "STREAMS", "s", "t", "1-0", "0"),
+				mockArrayLen(2),
+				mockArrayLen(2),
+				mockBulk("s"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulkString("2-0"),
+				mockOrderedBulks("b", "2"),
+				mockArrayLen(2),
+				mockBulk("t"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulkString("1-0"),
+				mockOrderedBulks("c", "3"),
+				mockCmd("XREAD", "BLOCK", "10", "STREAMS", "s", "$"),
+				mockOrderedBulks(),
+				mockCmd("XREAD", "BLOCK", "-1", "STREAMS", "s", "$"),
+				mockError("ERR timeout is negative"),
+			},
+		},
+		{
+			name: "XGROUP",
+			ops: []TestOp{
+				mockCmd("XGROUP", "CREATE", "s", "g", "$"),
+				mockError("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."),
+				mockCmd("XGROUP", "CREATE", "s", "g", "$", "MKSTREAM"),
 				mockSimpleString("OK"),
-				mockCmd("SADD", "t2", "2"),
+				mockCmd("XGROUP", "CREATE", "s", "g", "0"),
+				mockError("BUSYGROUP Consumer Group name already exists"),
+				mockCmd("XGROUP", "CREATECONSUMER", "s", "g", "alice"),
+				mockInt(1),
+				mockCmd("XGROUP", "CREATECONSUMER", "s", "g", "alice"),
+				mockInt(0),
+				mockCmd("XGROUP", "CREATECONSUMER", "s", "missing", "alice"),
+				mockError("NOGROUP No such consumer group 'missing' for key name 's'"),
+				mockCmd("XGROUP", "SETID", "s", "g", "0"),
 				mockSimpleString("OK"),
-				mockCmd("SUNION", "t", "t2"),
-				mockBulks("1", "2"),
+				mockCmd("XGROUP", "DELCONSUMER", "s", "g", "alice"),
+				mockInt(0),
+				mockCmd("XGROUP", "DESTROY", "s", "g"),
+				mockInt(1),
+				mockCmd("XGROUP", "DESTROY", "s", "g"),
+				mockInt(0),
+				mockCmd("XGROUP", "CREATE", "s"),
+				mockError("ERR wrong number of arguments for 'xgroup|create' command"),
+				mockCmd("XGROUP", "FOO"),
+				mockError("ERR unknown subcommand 'FOO'. Try XGROUP HELP."),
 			},
 		},
 		{
-			name: "SWITHMEMBER",
+			name: "XREADGROUP",
 			ops: []TestOp{
-				mockCmd("SADD", "foo", "1"),
-				mockSimpleString("OK"),
-				mockCmd("SADD", "baz", "1"),
+				mockCmd("XREADGROUP", "GROUP", "g", "c", "STREAMS", "s"),
+				mockError("ERR wrong number of arguments for 'xreadgroup' command"),
+				mockCmd("XADD", "s", "1-0", "a", "1"),
+				mockBulk("1-0"),
+				mockCmd("XADD", "s", "2-0", "b", "2"),
+				mockBulk("2-0"),
+				mockCmd("XGROUP", "CREATE", "s", "g", "0"),
+				mockSimpleString("OK"),
+				mockCmd("XREADGROUP", "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "s", ">"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulk("s"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulkString("1-0"),
+				mockOrderedBulks("a", "1"),
+				mockCmd("XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulk("s"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulkString("2-0"),
+				mockOrderedBulks("b", "2"),
+				mockCmd("XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">"),
+				mockOrderedBulks(),
+				mockCmd("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulk("s"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulkString("1-0"),
+				mockOrderedBulks("a", "1"),
+				mockCmd("XDEL", "s", "1-0"),
+				mockInt(1),
+				mockCmd("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulk("s"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulkString("1-0"),
+				mockOrderedBulks(),
+				mockCmd("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "1-0"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulk("s"),
+				mockArrayLen(0),
+				mockCmd("XREADGROUP", "GROUP", "missing", "alice", "STREAMS", "s", ">"),
+				mockError("NOGROUP No such key 's' or consumer group 'missing' in XREADGROUP with GROUP option"),
+				mockCmd("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "$"),
+				mockError("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set."),
+				mockCmd("XREADGROUP", "STREAMS", "s", ">"),
+				mockError("ERR Missing GROUP option for XREADGROUP"),
+				mockCmd("XREADGROUP", "GROUP", "g", "alice", "BLOCK", "10", "STREAMS", "s", ">"),
+				mockOrderedBulks(),
+			},
+		},
+		{
+			name: "XACK",
+			ops: []TestOp{
+				mockCmd("XADD", "s", "1-0", "a", "1"),
+				mockBulk("1-0"),
+				mockCmd("XGROUP", "CREATE", "s", "g", "0"),
+				mockSimpleString("OK"),
+				mockCmd("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulk("s"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulkString("1-0"),
+				mockOrderedBulks("a", "1"),
+				mockCmd("XACK", "s", "g", "1-0", "9-0"),
+				mockInt(1),
+				mockCmd("XACK", "s", "g", "1-0"),
+				mockInt(0),
+				mockCmd("XACK", "s", "missing", "1-0"),
+				mockInt(0),
+				mockCmd("XACK", "s", "g"),
+				mockError("ERR wrong number of arguments for 'xack' command"),
+			},
+		},
+		{
+			name: "XPENDING",
+			ops: []TestOp{
+				mockCmd("XADD", "s", "1-0", "a", "1"),
+				mockBulk("1-0"),
+				mockCmd("XADD", "s", "2-0", "b", "2"),
+				mockBulk("2-0"),
+				mockCmd("XGROUP", "CREATE", "s", "g", "0"),
+				mockSimpleString("OK"),
+				mockCmd("XPENDING", "s", "g"),
+				mockArrayLen(4),
+				mockInt(0),
+				mockBulk(nil),
+				mockBulk(nil),
+				mockOrderedBulks(),
+				mockCmd("XREADGROUP", "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "s", ">"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulk("s"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulkString("1-0"),
+				mockOrderedBulks("a", "1"),
+				mockCmd("XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulk("s"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulkString("2-0"),
+				mockOrderedBulks("b", "2"),
+				mockCmd("XPENDING", "s", "g"),
+				mockArrayLen(4),
+				mockInt(2),
+				mockBulkString("1-0"),
+				mockBulkString("2-0"),
+				mockArrayLen(2),
+				mockOrderedBulks("alice", "1"),
+				mockOrderedBulks("bob", "1"),
+				mockCmd("XPENDING", "s", "g", "-", "+", "10"),
+				mockArrayLen(2),
+				mockObjects("1-0", []byte("alice"), gomock.Any(), 1),
+				mockObjects("2-0", []byte("bob"), gomock.Any(), 1),
+				mockCmd("XPENDING", "s", "g", "-", "+", "10", "bob"),
+				mockArrayLen(1),
+				mockObjects("2-0", []byte("bob"), gomock.Any(), 1),
+				mockCmd("XPENDING", "s", "g", "IDLE", "3600000", "-", "+", "10"),
+				mockArrayLen(0),
+				mockCmd("XPENDING", "s", "missing"),
+				mockError("NOGROUP No such key 's' or consumer group 'missing'"),
+				mockCmd("XPENDING", "s"),
+				mockError("ERR wrong number of arguments for 'xpending' command"),
+			},
+		},
+		{
+			name: "XCLAIM",
+			ops: []TestOp{
+				mockCmd("XADD", "s", "1-0", "a", "1"),
+				mockBulk("1-0"),
+				mockCmd("XADD", "s", "2-0", "b", "2"),
+				mockBulk("2-0"),
+				mockCmd("XGROUP", "CREATE", "s", "g", "0"),
+				mockSimpleString("OK"),
+				mockCmd("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulk("s"),
+				mockArrayLen(2),
+				mockArrayLen(2),
+				mockBulkString("1-0"),
+				mockOrderedBulks("a", "1"),
+				mockArrayLen(2),
+				mockBulkString("2-0"),
+				mockOrderedBulks("b", "2"),
+				mockCmd("XCLAIM", "s", "g", "bob", "3600000", "1-0"),
+				mockArrayLen(0),
+				mockCmd("XCLAIM", "s", "g", "bob", "0", "1-0"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulkString("1-0"),
+				mockOrderedBulks("a", "1"),
+				mockCmd("XCLAIM", "s", "g", "bob", "0", "2-0", "JUSTID"),
+				mockOrderedBulks("2-0"),
+				mockCmd("XPENDING", "s", "g", "-", "+", "10"),
+				mockArrayLen(2),
+				mockObjects("1-0", []byte("bob"), gomock.Any(), 2),
+				mockObjects("2-0", []byte("bob"), gomock.Any(), 1),
+				mockCmd("XDEL", "s", "1-0"),
+				mockInt(1),
+				mockCmd("XCLAIM", "s", "g", "carol", "0", "1-0"),
+				mockArrayLen(0),
+				mockCmd("XPENDING", "s", "g", "-", "+", "10"),
+				mockArrayLen(1),
+				mockObjects("2-0", []byte("bob"), gomock.Any(), 1),
+				mockCmd("XCLAIM", "s", "g", "bob", "0", "2-0", "BOGUS"),
+				mockError("ERR Unrecognized XCLAIM option 'BOGUS'"),
+				mockCmd("XCLAIM", "s", "g", "bob", "soon", "2-0"),
+				mockError("ERR Invalid min-idle-time argument for XCLAIM"),
+				mockCmd("XCLAIM", "s", "g", "bob", "0"),
+				mockError("ERR wrong number of arguments for 'xclaim' command"),
+			},
+		},
+		{
+			name: "XAUTOCLAIM",
+			ops: []TestOp{
+				mockCmd("XADD", "s", "1-0", "a", "1"),
+				mockBulk("1-0"),
+				mockCmd("XADD", "s", "2-0", "b", "2"),
+				mockBulk("2-0"),
+				mockCmd("XADD", "s", "3-0", "c", "3"),
+				mockBulk("3-0"),
+				mockCmd("XGROUP", "CREATE", "s", "g", "0"),
+				mockSimpleString("OK"),
+				mockCmd("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulk("s"),
+				mockArrayLen(3),
+				mockArrayLen(2),
+				mockBulkString("1-0"),
+				mockOrderedBulks("a", "1"),
+				mockArrayLen(2),
+				mockBulkString("2-0"),
+				mockOrderedBulks("b", "2"),
+				mockArrayLen(2),
+				mockBulkString("3-0"),
+				mockOrderedBulks("c", "3"),
+				mockCmd("XDEL", "s", "2-0"),
+				mockInt(1),
+				mockCmd("XAUTOCLAIM", "s", "g", "bob", "0", "0", "COUNT", "1"),
+				mockArrayLen(3),
+				mockBulkString("2-0"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulkString("1-0"),
+				mockOrderedBulks("a", "1"),
+				mockOrderedBulks(),
+				mockCmd("XAUTOCLAIM", "s", "g", "bob", "0", "(1-0", "JUSTID"),
+				mockArrayLen(3),
+				mockBulkString("0-0"),
+				mockOrderedBulks("3-0"),
+				mockOrderedBulks("2-0"),
+				mockCmd("XPENDING", "s", "g"),
+				mockArrayLen(4),
+				mockInt(2),
+				mockBulkString("1-0"),
+				mockBulkString("3-0"),
+				mockArrayLen(1),
+				mockOrderedBulks("bob", "2"),
+				mockCmd("XAUTOCLAIM", "s", "g", "bob", "0", "0", "COUNT", "0"),
+				mockError("ERR COUNT must be > 0"),
+				mockCmd("XAUTOCLAIM", "s", "g", "bob", "0"),
+				mockError("ERR wrong number of arguments for 'xautoclaim' command"),
+			},
+		},
+		{
+			name: "XINFO",
+			ops: []TestOp{
+				mockCmd("XINFO", "STREAM", "s"),
+				mockError("ERR no such key"),
+				mockCmd("XADD", "s", "1-0", "a", "1"),
+				mockBulk("1-0"),
+				mockCmd("XADD", "s", "2-0", "b", "2"),
+				mockBulk("2-0"),
+				mockCmd("XGROUP", "CREATE", "s", "g", "0"),
+				mockSimpleString("OK"),
+				mockCmd("XREADGROUP", "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "s", ">"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulk("s"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulkString("1-0"),
+				mockOrderedBulks("a", "1"),
+				mockCmd("XINFO", "STREAM", "s"),
+				mockArrayLen(10),
+				mockBulkString("length"),
+				mockInt(2),
+				mockBulkString("last-generated-id"),
+				mockBulkString("2-0"),
+				mockBulkString("groups"),
+				mockInt(1),
+				mockBulkString("first-entry"),
+				mockArrayLen(2),
+				mockBulkString("1-0"),
+				mockOrderedBulks("a", "1"),
+				mockBulkString("last-entry"),
+				mockArrayLen(2),
+				mockBulkString("2-0"),
+				mockOrderedBulks("b", "2"),
+				mockCmd("XINFO", "GROUPS", "s"),
+				mockArrayLen(1),
+				mockObjects("name", []byte("g"), "consumers", 1, "pending", 1, "last-delivered-id", "1-0"),
+				mockCmd("XINFO", "CONSUMERS", "s", "g"),
+				mockArrayLen(1),
+				mockObjects("name", []byte("alice"), "pending", 1, "idle", gomock.Any()),
+				mockCmd("XINFO", "CONSUMERS", "s", "missing"),
+				mockError("NOGROUP No such consumer group 'missing' for key name 's'"),
+			},
+		},
+		{
+			name: "PFADD",
+			ops: []TestOp{
+				mockCmd("PFADD", "h", "a", "b", "c"),
+				mockInt(1),
+				mockCmd("PFADD", "h", "a"),
+				mockInt(0),
+				mockCmd("PFADD", "h"),
+				mockInt(0),
+				mockCmd("TYPE", "h"),
+				mockSimpleString("string"),
+				mockCmd("GET", "h"),
+				mockDenseHLL(),
+				mockCmd("PFADD", "empty"),
+				mockInt(1),
+				mockCmd("GET", "empty"),
+				mockDenseHLL(),
+				mockCmd("SET", "foo", "bar"),
 				mockSimpleString("OK"),
-				mockCmd("SADD", "baz", "2"),
+				mockCmd("PFADD", "foo", "a"),
+				mockError("WRONGTYPE Key is not a valid HyperLogLog string value."),
+				mockCmd("LPUSH", "list", "a"),
+				mockInt(1),
+				mockCmd("PFADD", "list", "a"),
+				mockError("ERR type mismatch; got list, expected hll"),
+			},
+		},
+		{
+			name: "PFCOUNT",
+			ops: []TestOp{
+				mockCmd("PFCOUNT", "a"),
+				mockInt(0),
+				mockCmd("PFADD", "a", "x", "y", "z"),
+				mockInt(1),
+				mockCmd("PFADD", "b", "z", "w"),
+				mockInt(1),
+				mockCmd("PFCOUNT", "a"),
+				mockInt(3),
+				mockCmd("PFCOUNT", "a", "b", "missing"),
+				mockInt(4),
+				// a sparse HyperLogLog written by redis, caching a cardinality
+				// of 5
+				mockCmd("SET", "c", "HYLL\x01\x00\x00\x00\x05\x00\x00\x00\x00\x00\x00\x00\x7f\xff"),
+				mockSimpleString("OK"),
+				mockCmd("PFCOUNT", "c"),
+				mockInt(5),
+				mockCmd("PFCOUNT", "c", "a"),
+				mockInt(3),
+				mockCmd("PFADD", "c", "x"),
+				mockInt(1),
+				mockCmd("PFCOUNT", "c"),
+				mockInt(1),
+				mockCmd("TYPE", "c"),
+				mockSimpleString("string"),
+			},
+		},
+		{
+			name: "PFMERGE",
+			ops: []TestOp{
+				mockCmd("PFADD", "a", "x", "y"),
+				mockInt(1),
+				mockCmd("PFADD", "b", "y", "z"),
+				mockInt(1),
+				mockCmd("PFMERGE", "dest", "a", "b"),
 				mockSimpleString("OK"),
-				mockCmd("SADD", "bar", "1"),
+				mockCmd("PFCOUNT", "dest"),
+				mockInt(3),
+				mockCmd("PFADD", "c", "q"),
+				mockInt(1),
+				mockCmd("PFMERGE", "dest", "c", "missing"),
 				mockSimpleString("OK"),
-				mockCmd("SADD", "bar", "2"),
+				mockCmd("PFCOUNT", "dest"),
+				mockInt(4),
+				mockCmd("GET", "dest"),
+				mockDenseHLL(),
+				mockCmd("PFMERGE", "empty"),
 				mockSimpleString("OK"),
-				mockCmd("SWITHMEMBER", "1"),
-				mockBulkStrings("foo", "bar", "baz"),
-				mockCmd("SWITHMEMBER", "2"),
-				mockBulkStrings("bar", "baz"),
+				mockCmd("PFCOUNT", "empty"),
+				mockInt(0),
+				mockCmd("EXISTS", "empty"),
+				mockInt(1),
 			},
 		},
 		{
-			name: "SCARD",
+			name: "GEOADD",
 			ops: []TestOp{
-				mockCmd("SCARD", "foo"),
+				mockCmd("GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"),
+				mockInt(2),
+				mockCmd("GEOADD", "Sicily", "NX", "13", "38", "Palermo"),
 				mockInt(0),
-				mockCmd("SADD", "foo", "1"),
-				mockSimpleString("OK"),
-				mockCmd("SCARD", "foo"),
+				mockCmd("GEOADD", "Sicily", "XX", "CH", "13.361389", "38.115556", "Palermo", "15", "37", "Catania", "1", "1", "missing"),
 				mockInt(1),
-				mockCmd("SADD", "foo", "2"),
+				mockCmd("GEOPOS", "Sicily", "Palermo", "Catania", "missing"),
+				mockArrayLen(3),
+				mockCoords(13.361389, 38.115556),
+				mockCoords(15, 37),
+				mockOrderedBulks(),
+				mockCmd("TYPE", "Sicily"),
+				mockSimpleString("geo"),
+				mockCmd("GEOADD", "new", "XX", "1", "1", "x"),
+				mockInt(0),
+				mockCmd("EXISTS", "new"),
+				mockInt(0),
+				mockCmd("GEOADD", "Sicily", "1", "100", "x"),
+				mockError("ERR invalid longitude,latitude pair 1.000000,100.000000"),
+				mockCmd("GEOADD", "Sicily", "1", "2"),
+				mockError("ERR wrong number of arguments for 'geoadd' command"),
+				mockCmd("GEOADD", "Sicily", "1", "2", "a", "3"),
+				mockError("ERR syntax error. Try GEOADD key [x1] [y1] [name1] [x2] [y2] [name2] ... "),
+				mockCmd("GEOADD", "Sicily", "NX", "XX", "1", "2", "x"),
+				mockError("ERR XX and NX options at the same time are not compatible"),
+				mockCmd("SET", "foo", "bar"),
 				mockSimpleString("OK"),
-				mockCmd("SCARD", "foo"),
+				mockCmd("GEOADD", "foo", "1", "2", "x"),
+				mockError("ERR type mismatch; got blob, expected geo"),
+			},
+		},
+		{
+			name: "GEOPOS",
+			ops: []TestOp{
+				mockCmd("GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"),
 				mockInt(2),
-				mockCmd("SREM", "foo", "2"),
+				mockCmd("GEOPOS", "Sicily", "Palermo", "NonExisting", "Catania"),
+				mockArrayLen(3),
+				mockCoords(13.361389, 38.115556),
+				mockOrderedBulks(),
+				mockCoords(15.087269, 37.502669),
+				mockCmd("GEOPOS", "missing", "Palermo"),
+				mockArrayLen(1),
+				mockOrderedBulks(),
+			},
+		},
+		{
+			name: "GEODIST",
+			ops: []TestOp{
+				mockCmd("GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"),
+				mockInt(2),
+				mockCmd("GEODIST", "Sicily", "Palermo", "Catania"),
+				mockApproxBulkString(166274.1516, 200),
+				mockCmd("GEODIST", "Sicily", "Palermo", "Catania", "km"),
+				mockApproxBulkString(166.2742, 0.2),
+				mockCmd("GEODIST", "Sicily", "Palermo", "Catania", "MI"),
+				mockApproxBulkString(103.3182, 0.2),
+				mockCmd("GEODIST", "Sicily", "Palermo", "Palermo"),
+				mockBulkString("0.0000"),
+				mockCmd("GEODIST", "Sicily", "Foo", "Bar"),
+				mockBulk(nil),
+				mockCmd("GEODIST", "Sicily", "Palermo", "Catania", "parsecs"),
+				mockError("ERR unsupported unit provided. please use M, KM, FT, MI"),
+			},
+		},
+		{
+			name: "GEOHASH",
+			ops: []TestOp{
+				mockCmd("GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"),
+				mockInt(2),
+				mockCmd("GEOHASH", "Sicily", "Palermo", "Catania", "missing"),
+				mockOrderedBulks("sqc8b49rny0", "sqdtr74hyu0", nil),
+				mockCmd("GEOHASH", "missing", "Palermo"),
+				mockOrderedBulks(nil),
+			},
+		},
+		{
+			name: "GEOSEARCH",
+			ops: []TestOp{
+				mockCmd("GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"),
+				mockInt(2),
+				mockCmd("GEOADD", "Sicily", "17.241510", "38.788135", "edge"),
 				mockInt(1),
-				mockCmd("SCARD", "foo"),
+				mockCmd("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC"),
+				mockOrderedBulks("Catania", "Palermo"),
+				mockCmd("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "DESC"),
+				mockOrderedBulks("Palermo", "Catania"),
+				mockCmd("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC", "WITHDIST"),
+				mockArrayLen(3),
+				mockArrayLen(2),
+				mockBulk("Catania"),
+				mockApproxBulkString(56.4413, 0.2),
+				mockArrayLen(2),
+				mockBulk("Palermo"),
+				mockApproxBulkString(190.4424, 0.2),
+				mockArrayLen(2),
+				mockBulk("edge"),
+				mockApproxBulkString(279.7403, 0.2),
+				mockCmd("GEOSEARCH", "Sicily", "FROMMEMBER", "Palermo", "BYRADIUS", "500", "km", "COUNT", "1", "WITHCOORD"),
+				mockArrayLen(1),
+				mockArrayLen(2),
+				mockBulk("Palermo"),
+				mockCoords(13.361389, 38.115556),
+				mockCmd("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km"),
+				mockOrderedBulks(),
+				mockCmd("GEOSEARCH", "missing", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km"),
+				mockOrderedBulks(),
+				mockCmd("GEOSEARCH", "Sicily", "FROMMEMBER", "missing", "BYRADIUS", "1", "km"),
+				mockError("ERR could not decode requested zset member"),
+				mockCmd("GEOSEARCH", "Sicily", "FROMMEMBER", "Palermo", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km"),
+				mockError("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH"),
+				mockCmd("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37"),
+				mockError("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH"),
+				mockCmd("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "ANY"),
+				mockError("ERR the ANY argument requires COUNT argument"),
+				mockCmd("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "STOREDIST"),
+				mockError("ERR syntax error"),
+			},
+		},
+		{
+			name: "GEOSEARCHSTORE",
+			ops: []TestOp{
+				mockCmd("GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"),
+				mockInt(2),
+				mockCmd("GEOSEARCHSTORE", "dest", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "100", "km"),
 				mockInt(1),
-				mockCmd("DEL", "foo"),
+				mockCmd("GEOPOS", "dest", "Catania"),
+				mockArrayLen(1),
+				mockCoords(15.087269, 37.502669),
+				mockCmd("TYPE", "dest"),
+				mockSimpleString("geo"),
+				mockCmd("GEOSEARCHSTORE", "dists", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "STOREDIST"),
+				mockInt(2),
+				mockCmd("ZRANGE", "dists", "0", "-1"),
+				mockOrderedBulks("Catania", "Palermo"),
+				mockCmd("TYPE", "dists"),
+				mockSimpleString("zset"),
+				mockCmd("GEOSEARCHSTORE", "Sicily", "Sicily", "FROMMEMBER", "Catania", "BYRADIUS", "1", "km"),
 				mockInt(1),
-				mockCmd("SCARD", "foo"),
+				mockCmd("GEOPOS", "Sicily", "Palermo", "Catania"),
+				mockArrayLen(2),
+				mockOrderedBulks(),
+				mockCoords(15.087269, 37.502669),
+				mockCmd("GEOSEARCHSTORE", "dest", "Sicily", "FROMLONLAT", "0", "0", "BYRADIUS", "1", "km"),
 				mockInt(0),
+				mockCmd("EXISTS", "dest"),
+				mockInt(0),
+				mockCmd("GEOSEARCHSTORE", "dest", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "WITHDIST"),
+				mockError("ERR syntax error"),
 			},
 		},
 		{
-			name: "SINTERCARD",
+			name: "JSON.SET",
 			ops: []TestOp{
-				mockCmd("SADD", "foo", "1"),
-				mockSimpleString("OK"),
-				mockCmd("SADD", "foo", "2"),
+				mockCmd("JSON.SET", "doc", "$", `{"a":1,"b":{"c":"x"}}`),
 				mockSimpleString("OK"),
-				mockCmd("SADD", "foo", "3"),
+				mockCmd("TYPE", "doc"),
+				mockSimpleString("ReJSON-RL"),
+				mockCmd("JSON.SET", "doc", "$.b.d", "[1,2]"),
 				mockSimpleString("OK"),
-				mockCmd("SADD", "bar", "3"),
+				mockCmd("JSON.SET", "doc", "$.b.c", `"y"`, "NX"),
+				mockBulk(nil),
+				mockCmd("JSON.SET", "doc", "$.z", "2", "XX"),
+				mockBulk(nil),
+				mockCmd("JSON.SET", "doc", "$.missing.e", "1"),
+				mockBulk(nil),
+				mockCmd("JSON.SET", "doc", "$..c", `"z"`),
 				mockSimpleString("OK"),
-				mockCmd("SADD", "bar", "4"),
+				mockCmd("JSON.SET", "doc", ".a", "[true]", "XX"),
 				mockSimpleString("OK"),
-				mockCmd("SADD", "bar", "5"),
+				mockCmd("JSON.GET", "doc"),
+				mockJSON(`{"a":[true],"b":{"c":"z","d":[1,2]}}`),
+				mockCmd("JSON.SET", "doc", "$", "[]", "NX"),
+				mockBulk(nil),
+				mockCmd("JSON.SET", "new", "$", "[]", "XX"),
+				mockBulk(nil),
+				mockCmd("JSON.SET", "new", "$.a", "1"),
+				mockError("ERR new objects must be created at the root"),
+				mockCmd("JSON.SET", "doc", "$", "{nope"),
+				mockError("ERR expected a valid JSON value"),
+				mockCmd("JSON.SET", "doc", "$", "1", "NX", "XX"),
+				mockError("ERR syntax error"),
+				mockCmd("JSON.SET", "doc", "$[", "1"),
+				mockError("ERR JSON Path error: unsupported or invalid path '$['"),
+				mockCmd("SET", "foo", "bar"),
 				mockSimpleString("OK"),
-				mockCmd("SADD", "baz", "5"),
+				mockCmd("JSON.SET", "foo", "$", "1"),
+				mockError("ERR type mismatch; got blob, expected json"),
+			},
+		},
+		{
+			name: "JSON.GET",
+			ops: []TestOp{
+				mockCmd("JSON.SET", "doc", "$", `{"a":[1,2,3],"b":"x"}`),
+				mockSimpleString("OK"),
+				mockCmd("JSON.GET", "doc", "."),
+				mockJSON(`{"a":[1,2,3],"b":"x"}`),
+				mockCmd("JSON.GET", "doc", ".b"),
+				mockBulk(`"x"`),
+				mockCmd("JSON.GET", "doc", "$.a[-1]"),
+				mockBulk("[3]"),
+				mockCmd("JSON.GET", "doc", "$.a[*]"),
+				mockBulk("[1,2,3]"),
+				mockCmd("JSON.GET", "doc", "$.missing"),
+				mockBulk("[]"),
+				mockCmd("JSON.GET", "doc", "$.b", ".b"),
+				mockJSON(`{"$.b":["x"],".b":"x"}`),
+				mockCmd("JSON.GET", "doc", "INDENT", "  ", "NEWLINE", "\n", "SPACE", " ", "$.b"),
+				mockBulk("[\n  \"x\"\n]"),
+				mockCmd("JSON.GET", "doc", ".missing"),
+				mockError("ERR Path '.missing' does not exist"),
+				mockCmd("JSON.GET", "missing"),
+				mockBulk(nil),
+			},
+		},
+		{
+			name: "JSON.MGET",
+			ops: []TestOp{
+				mockCmd("JSON.SET", "a", "$", `{"x":1}`),
 				mockSimpleString("OK"),
-				mockCmd("SADD", "baz", "6"),
+				mockCmd("JSON.SET", "b", "$", `{"y":1}`),
 				mockSimpleString("OK"),
-				mockCmd("SADD", "baz", "7"),
+				mockCmd("JSON.MGET", "a", "b", "missing", "$.x"),
+				mockOrderedBulks("[1]", "[]", nil),
+				mockCmd("JSON.MGET", "a", "b", "missing", ".x"),
+				mockOrderedBulks("1", nil, nil),
+				mockCmd("JSON.MGET", "a"),
+				mockError("ERR wrong number of arguments for 'json.mget' command"),
+			},
+		},
+		{
+			name: "JSON.DEL",
+			ops: []TestOp{
+				mockCmd("JSON.SET", "doc", "$", `{"a":[1,2,3],"b":{"c":1}}`),
 				mockSimpleString("OK"),
-				mockCmd("SINTERCARD", "foo", "bar"),
+				mockCmd("JSON.DEL", "doc", "$.a[*]"),
+				mockInt(3),
+				mockCmd("JSON.GET", "doc", "$.a"),
+				mockBulk("[[]]"),
+				mockCmd("JSON.DEL", "doc", ".b.c"),
 				mockInt(1),
-				mockCmd("SINTERCARD", "foo", "bar", "baz"),
+				mockCmd("JSON.DEL", "doc", "$.missing"),
 				mockInt(0),
-				mockCmd("SADD", "baz", "3"),
-				mockSimpleString("OK"),
-				mockCmd("SINTERCARD", "foo", "bar", "baz"),
+				mockCmd("JSON.GET", "doc"),
+				mockJSON(`{"a":[],"b":{}}`),
+				mockCmd("JSON.DEL", "doc"),
 				mockInt(1),
-				mockCmd("SADD", "t", "1"),
+				mockCmd("EXISTS", "doc"),
+				mockInt(0),
+				mockCmd("JSON.DEL", "missing"),
+				mockInt(0),
+			},
+		},
+		{
+			name: "JSON.TYPE",
+			ops: []TestOp{
+				mockCmd("JSON.SET", "doc", "$", `{"a":1,"b":1.5,"c":"s","d":[],"e":{},"f":null,"g":true}`),
+				mockSimpleString("OK"),
+				mockCmd("JSON.TYPE", "doc"),
+				mockSimpleString("object"),
+				mockCmd("JSON.TYPE", "doc", ".a"),
+				mockSimpleString("integer"),
+				mockCmd("JSON.TYPE", "doc", "$.b"),
+				mockBulkStrings("number"),
+				mockCmd("JSON.TYPE", "doc", "$.*"),
+				mockBulkStrings("integer", "number", "string", "array", "object", "null", "boolean"),
+				mockCmd("JSON.TYPE", "doc", ".missing"),
+				mockBulk(nil),
+				mockCmd("JSON.TYPE", "missing"),
+				mockBulk(nil),
+			},
+		},
+		{
+			name: "JSON.NUMINCRBY",
+			ops: []TestOp{
+				mockCmd("JSON.SET", "doc", "$", `{"a":1,"b":{"a":2.5},"c":"x"}`),
+				mockSimpleString("OK"),
+				mockCmd("JSON.NUMINCRBY", "doc", ".a", "2"),
+				mockBulk("3"),
+				mockCmd("JSON.NUMINCRBY", "doc", "$..a", "1"),
+				mockJSON("[4,3.5]"),
+				mockCmd("JSON.NUMINCRBY", "doc", "$.c", "1"),
+				mockBulk("[null]"),
+				mockCmd("JSON.GET", "doc", "$.a"),
+				mockBulk("[4]"),
+				mockCmd("JSON.NUMINCRBY", "doc", ".c", "1"),
+				mockError("ERR Path '.c' does not exist or does not hold a number"),
+				mockCmd("JSON.NUMINCRBY", "doc", ".a", "x"),
+				mockError("ERR expected a valid JSON value"),
+				mockCmd("JSON.NUMINCRBY", "doc", ".a", `"1"`),
+				mockError("ERR expected a valid JSON value"),
+				mockCmd("JSON.NUMINCRBY", "missing", ".a", "1"),
+				mockError("ERR could not perform this operation on a key that doesn't exist"),
+			},
+		},
+		{
+			name: "JSON.STRAPPEND",
+			ops: []TestOp{
+				mockCmd("JSON.SET", "doc", "$", `{"a":"foo","b":{"a":1}}`),
 				mockSimpleString("OK"),
-				mockCmd("SADD", "t2", "2"),
+				mockCmd("JSON.STRAPPEND", "doc", ".a", `"bar"`),
+				mockInt(6),
+				mockCmd("JSON.STRAPPEND", "doc", "$..a", `"!"`),
+				mockObjects(7, nil),
+				mockCmd("JSON.GET", "doc", ".a"),
+				mockBulk(`"foobar!"`),
+				mockCmd("JSON.STRAPPEND", "doc", ".b", `"x"`),
+				mockError("ERR Path '.b' does not exist or does not hold a string"),
+				mockCmd("JSON.STRAPPEND", "doc", ".a", "bar"),
+				mockError("ERR expected a valid JSON value"),
+				mockCmd("JSON.SET", "str", "$", `"x"`),
+				mockSimpleString("OK"),
+				mockCmd("JSON.STRAPPEND", "str", `"y"`),
+				mockInt(2),
+				mockCmd("JSON.STRAPPEND", "missing", `"y"`),
+				mockError("ERR could not perform this operation on a key that doesn't exist"),
+			},
+		},
+		{
+			name: "JSON.ARRAPPEND",
+			ops: []TestOp{
+				mockCmd("JSON.SET", "doc", "$", `{"a":[1],"b":{"a":"x"}}`),
+				mockSimpleString("OK"),
+				mockCmd("JSON.ARRAPPEND", "doc", ".a", "2", `"three"`),
+				mockInt(3),
+				mockCmd("JSON.ARRAPPEND", "doc", "$..a", `{"k":1}`),
+				mockObjects(4, nil),
+				mockCmd("JSON.GET", "doc", "$.a"),
+				mockJSON(`[[1,2,"three",{"k":1}]]`),
+				mockCmd("JSON.ARRAPPEND", "doc", ".b", "1"),
+				mockError("ERR Path '.b' does not exist or does not hold an array"),
+				mockCmd("JSON.ARRAPPEND", "doc", ".a", "nope"),
+				mockError("ERR expected a valid JSON value"),
+				mockCmd("JSON.ARRAPPEND", "missing", ".a", "1"),
+				mockError("ERR could not perform this operation on a key that doesn't exist"),
+			},
+		},
+		{
+			name: "JSON.ARRLEN",
+			ops: []TestOp{
+				mockCmd("JSON.SET", "doc", "$", `{"a":[1,2],"b":{"a":[]},"c":1}`),
 				mockSimpleString("OK"),
-				mockCmd("SINTERCARD", "t", "t2"),
-				mockInt(0),
+				mockCmd("JSON.ARRLEN", "doc", ".a"),
+				mockInt(2),
+				mockCmd("JSON.ARRLEN", "doc", "$..a"),
+				mockObjects(2, 0),
+				mockCmd("JSON.ARRLEN", "doc", "$.c"),
+				mockObjects(nil),
+				mockCmd("JSON.ARRLEN", "doc", ".c"),
+				mockError("ERR Path '.c' does not exist or does not hold an array"),
+				mockCmd("JSON.SET", "arr", "$", "[1,2,3]"),
+				mockSimpleString("OK"),
+				mockCmd("JSON.ARRLEN", "arr"),
+				mockInt(3),
+				mockCmd("JSON.ARRLEN", "missing"),
+				mockBulk(nil),
+			},
+		},
+		{
+			name: "JSON.OBJKEYS",
+			ops: []TestOp{
+				mockCmd("JSON.SET", "doc", "$", `{"a":1,"b":{"c":1}}`),
+				mockSimpleString("OK"),
+				mockCmd("JSON.OBJKEYS", "doc"),
+				mockBulkStrings("a", "b"),
+				mockCmd("JSON.OBJKEYS", "doc", "$.b"),
+				mockArrayLen(1),
+				mockBulkStrings("c"),
+				mockCmd("JSON.OBJKEYS", "doc", "$.*"),
+				mockArrayLen(2),
+				mockBulkStrings(),
+				mockBulkStrings("c"),
+				mockCmd("JSON.OBJKEYS", "doc", ".a"),
+				mockError("ERR Path '.a' does not exist or does not hold an object"),
+				mockCmd("JSON.OBJKEYS", "missing"),
+				mockBulk(nil),
 			},
 		},
 	}
 
-	db := GetSingleStore(t)
+	store := GetSingleStore(t)
 
 	for _, testConfig := range tests {
 		t.Run(testConfig.name, func(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			writer := NewMockWriter(ctrl)
 
+			// each test runs as its own connection, starting on database 0
+			db := store.WithContext(context.Background())
+
 			// clear the db before each test
 			err := db.FlushAll()
 			if err != nil {
@@ -526,6 +3448,9 @@ func TestAll(t *testing.T) {
 			for _, cmd := range cmds {
 				t.Logf("running: %s", s2kv.CommandString(cmd))
 				err := s2kv.CommandHandlers[string(cmd.Get(0))](db, writer, cmd)
+				if msg, ok := s2kv.ErrorReply(err); ok {
+					err = writer.WriteError(msg)
+				}
 				if err != nil {
 					t.Error(err)
 				}
@@ -549,6 +3474,101 @@ func TestAll(t *testing.T) {
 	})
 }
 
+func TestBlockingPop(t *testing.T) {
+	db := GetSingleStore(t)
+
+	run := func(db *s2kv.SingleStore, writer s2kv.Writer, cmd s2kv.Command) chan error {
+		t.Logf("running: %s", s2kv.CommandString(cmd))
+		done := make(chan error, 1)
+		go func() {
+			done <- s2kv.CommandHandlers[string(cmd.Get(0))](db, writer, cmd)
+		}()
+		return done
+	}
+
+	t.Run("pushes wake blocked clients in order", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		if err := db.FlushAll(); err != nil {
+			t.Fatal(err)
+		}
+
+		first := NewMockWriter(ctrl)
+		first.EXPECT().WriteBulks([]byte("foo"), []byte("a"))
+		second := NewMockWriter(ctrl)
+		second.EXPECT().WriteBulks([]byte("foo"), []byte("b"))
+
+		firstDone := run(db, first, NewCmd(ctrl, "BLPOP", "foo", "5"))
+		time.Sleep(100 * time.Millisecond)
+		secondDone := run(db, second, NewCmd(ctrl, "BLPOP", "bar", "foo", "5"))
+		time.Sleep(100 * time.Millisecond)
+
+		pusher := NewMockWriter(ctrl)
+		pusher.EXPECT().WriteInt(int64(2))
+		if err := <-run(db, pusher, NewCmd(ctrl, "LPUSH", "foo", "b", "a")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := <-firstDone; err != nil {
+			t.Error(err)
+		}
+		if err := <-secondDone; err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("stream entries wake every blocked reader", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		if err := db.FlushAll(); err != nil {
+			t.Fatal(err)
+		}
+
+		readers := make([]chan error, 2)
+		for i := range readers {
+			reader := NewMockWriter(ctrl)
+			gomock.InOrder(
+				reader.EXPECT().Write([]byte("*1\r\n")).Return(0, nil),
+				reader.EXPECT().Write([]byte("*2\r\n")).Return(0, nil),
+				reader.EXPECT().WriteBulk([]byte("s")),
+				reader.EXPECT().Write([]byte("*1\r\n")).Return(0, nil),
+				reader.EXPECT().Write([]byte("*2\r\n")).Return(0, nil),
+				reader.EXPECT().WriteBulkString("1-0"),
+				reader.EXPECT().WriteBulks([]byte("a"), []byte("1")),
+			)
+			readers[i] = run(db, reader, NewCmd(ctrl, "XREAD", "BLOCK", "5000", "STREAMS", "s", "$"))
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		adder := NewMockWriter(ctrl)
+		adder.EXPECT().WriteBulk([]byte("1-0"))
+		if err := <-run(db, adder, NewCmd(ctrl, "XADD", "s", "1-0", "a", "1")); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, done := range readers {
+			if err := <-done; err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	t.Run("disconnecting unblocks the client", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		if err := db.FlushAll(); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		writer := NewMockWriter(ctrl)
+		done := run(db.WithContext(ctx), writer, NewCmd(ctrl, "BLPOP", "foo", "0"))
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+}
+
 func NewCmd(ctrl *gomock.Controller, args ...string) *MockCommand {
 	cmd := NewMockCommand(ctrl)
 	cmd.EXPECT().ArgCount().Return(len(args)).AnyTimes()
@@ -9,6 +9,18 @@ import (
 
 type Config struct {
 	Database DatabaseConfig
+	Server   ServerConfig
+}
+
+// DefaultProtoMaxBulkLen is the largest bulk string accepted from clients when
+// proto-max-bulk-len isn't configured, the same as the redis default.
+const DefaultProtoMaxBulkLen = 512 * 1024 * 1024
+
+type ServerConfig struct {
+	// ProtoMaxBulkLen is the largest bulk string, such as a key or value, a
+	// client may send. The max_allowed_packet of the database must be larger
+	// for values of this size to be stored.
+	ProtoMaxBulkLen int `toml:"proto-max-bulk-len"`
 }
 
 type DatabaseConfig struct {
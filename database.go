@@ -1,9 +1,16 @@
 package s2kv
 
 import (
+	"context"
 	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -12,6 +19,17 @@ import (
 
 type SingleStore struct {
 	db *sqlx.DB
+
+	// waiters is shared by every connection so that pushes can wake
+	// connections blocked on a list
+	waiters *listWaiters
+	// unlinked is shared by every connection so that commands wait for the
+	// keys they name to be purged
+	unlinked *unlinker
+	// ctx is cancelled once the connection using this SingleStore is closed
+	ctx context.Context
+	// dbIndex is the logical database selected by the connection
+	dbIndex int
 }
 
 func NewSingleStore(config DatabaseConfig) (*SingleStore, error) {
@@ -26,9 +44,12 @@ func NewSingleStore(config DatabaseConfig) (*SingleStore, error) {
 	mysqlConf.InterpolateParams = true
 	mysqlConf.AllowNativePasswords = true
 	mysqlConf.MultiStatements = false
+	// use the max_allowed_packet of the server rather than the driver's 4MB
+	// default, so that large values can be sent
+	mysqlConf.MaxAllowedPacket = 0
 
 	mysqlConf.Params = map[string]string{
-		"collation_server":    "utf8_general_ci",
+		"collation_server":    "utf8_bin",
 		"sql_select_limit":    "18446744073709551615",
 		"compile_only":        "false",
 		"enable_auto_profile": "false",
@@ -46,7 +67,47 @@ func NewSingleStore(config DatabaseConfig) (*SingleStore, error) {
 	db.SetConnMaxLifetime(time.Hour)
 	db.SetMaxIdleConns(20)
 
-	return &SingleStore{db: sqlx.NewDb(db, "mysql")}, nil
+	out := &SingleStore{
+		db:       sqlx.NewDb(db, "mysql"),
+		waiters:  newListWaiters(),
+		unlinked: newUnlinker(),
+		ctx:      context.Background(),
+	}
+	go out.purgeUnlinked()
+	return out, nil
+}
+
+// WithContext returns a SingleStore sharing the database connection pool and
+// blocked list waiters of s, for use by a client connection which is closed
+// when ctx is done. The connection selects its database independently of s.
+func (s *SingleStore) WithContext(ctx context.Context) *SingleStore {
+	out := *s
+	out.ctx = ctx
+	return &out
+}
+
+// SelectDB switches to the logical database n for subsequent commands.
+func (s *SingleStore) SelectDB(n int) {
+	s.dbIndex = n
+}
+
+// dbKey returns k within the selected database.
+func (s *SingleStore) dbKey(k []byte) dbKey {
+	return dbKey{s.dbIndex, string(k)}
+}
+
+// userExceptionMessage returns the message of an exception raised by a
+// procedure via raise user_exception, if err was caused by one.
+func userExceptionMessage(err error) (string, bool) {
+	var merr *mysql.MySQLError
+	if !errors.As(err, &merr) || !strings.Contains(merr.Message, "ER_USER_RAISE") {
+		return "", false
+	}
+	msg := merr.Message
+	if i := strings.LastIndex(msg, "Message: "); i >= 0 {
+		msg = msg[i+len("Message: "):]
+	}
+	return strings.TrimSpace(msg), true
 }
 
 func (s *SingleStore) Close() error {
@@ -58,142 +119,535 @@ func (s *SingleStore) FlushAll() error {
 	return err
 }
 
-func (s *SingleStore) KeyExists(k string) (bool, error) {
-	var out bool
-	err := s.db.Get(&out, "select * from keyExists(?)", k)
+// FlushDB removes every key of the selected database.
+func (s *SingleStore) FlushDB() error {
+	_, err := s.db.Exec("call flushDb(?)", s.dbIndex)
+	return err
+}
+
+// SwapDB swaps the contents of databases a and b, waking any connections
+// blocked on either.
+func (s *SingleStore) SwapDB(a, b int) error {
+	err := s.unlinked.swap(a, b, func() error {
+		_, err := s.db.Exec("call swapDb(?, ?)", a, b)
+		return err
+	})
 	if err != nil {
-		return false, err
+		return err
+	}
+	s.waiters.notifyDB(a)
+	s.waiters.notifyDB(b)
+	return nil
+}
+
+// KeyExists counts how many of keys exist, counting repeated keys each time.
+func (s *SingleStore) KeyExists(keys ...[]byte) (int64, error) {
+	var out int64
+
+	query, args, err := sqlx.In("echo keysExist(?, [?])", s.dbIndex, keys)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Get(&out, query, args...)
+	if err != nil {
+		return 0, err
 	}
 	return out, nil
 }
 
-func (s *SingleStore) Keys(pattern string) ([][]byte, error) {
+func (s *SingleStore) Keys(pattern Glob) ([][]byte, error) {
 	var out [][]byte
-	err := s.db.Select(&out, "select k from getKeys(?)", pattern)
+	err := s.db.Select(&out, "select k from getKeys(?, ?, ?)", s.dbIndex, []byte(pattern.Prefix), []byte(pattern.Regexp))
+	return out, err
+}
+
+// KeyDelete removes keys, returning how many existed.
+func (s *SingleStore) KeyDelete(keys ...[]byte) (int64, error) {
+	var out int64
+
+	query, args, err := sqlx.In("echo keyDelete(?, [?])", s.dbIndex, keys)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Get(&out, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+// KeyUnlink removes keys like KeyDelete, but leaves their values to be
+// deleted in the background.
+func (s *SingleStore) KeyUnlink(keys ...[]byte) (int64, error) {
+	var out int64
+
+	query, args, err := sqlx.In("echo keyUnlink(?, [?])", s.dbIndex, keys)
+	if err != nil {
+		return out, err
+	}
+
+	purge := s.unlinked.add(s.dbIndex, keys)
+	err = s.db.Get(&out, query, args...)
+	for _, uk := range purge {
+		// rather than wait for the queue to drain, a key which doesn't fit is
+		// purged here, as DEL would
+		select {
+		case s.unlinked.queue <- uk:
+		default:
+			s.purge(uk)
+			s.unlinked.done(uk)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+// WaitUnlinked waits for any keys named by c which are being purged after
+// UNLINK, so that c doesn't see their old values.
+func (s *SingleStore) WaitUnlinked(c Command) error {
+	return s.unlinked.wait(s.ctx, s.dbIndex, c)
+}
+
+// KeyType returns the type of k, or an empty string if it doesn't exist.
+func (s *SingleStore) KeyType(k []byte) (string, error) {
+	var out sql.NullString
+	err := s.db.Get(&out, "select t from keyType(?, ?)", s.dbIndex, k)
+	return out.String, err
+}
+
+// KeyCount returns the number of keys.
+func (s *SingleStore) KeyCount() (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "select n from keyCount(?)", s.dbIndex)
 	return out, err
 }
 
-func (s *SingleStore) KeyDelete(k string) (bool, error) {
+// KeyRandom returns a random key, or nil if there are none.
+func (s *SingleStore) KeyRandom() ([]byte, error) {
+	var out [][]byte
+	err := s.db.Select(&out, "select k from keyRandom(?)", s.dbIndex)
+	if err != nil || len(out) == 0 {
+		return nil, err
+	}
+	return out[0], nil
+}
+
+// KeyRename renames src to dest, replacing dest unless nx is set. It returns
+// false if dest was kept.
+func (s *SingleStore) KeyRename(src, dest []byte, nx bool) (bool, error) {
+	var out bool
+	err := s.db.Get(&out, "echo keyRename(?, ?, ?, ?)", s.dbIndex, src, dest, nx)
+	if err != nil {
+		return false, err
+	}
+	if out {
+		s.waiters.notify(s.dbKey(dest))
+	}
+	return out, nil
+}
+
+// KeyCopy copies src to dest in database destDB, replacing dest if replace is
+// set. It returns false if src doesn't exist or dest was kept.
+func (s *SingleStore) KeyCopy(src []byte, destDB int, dest []byte, replace bool) (bool, error) {
+	var out bool
+	err := s.db.Get(&out, "echo keyCopy(?, ?, ?, ?, ?)", s.dbIndex, src, destDB, dest, replace)
+	if err != nil {
+		return false, err
+	}
+	if out {
+		s.waiters.notify(dbKey{destDB, string(dest)})
+	}
+	return out, nil
+}
+
+// KeyMove moves k to database destDB. It returns false if k doesn't exist or
+// destDB already holds it.
+func (s *SingleStore) KeyMove(k []byte, destDB int) (bool, error) {
 	var out bool
-	err := s.db.Get(&out, "echo keyDelete(?)", k)
+	err := s.db.Get(&out, "echo keyMove(?, ?, ?)", s.dbIndex, k, destDB)
 	if err != nil {
 		return false, err
 	}
+	if out {
+		s.waiters.notify(dbKey{destDB, string(k)})
+	}
+	return out, nil
+}
+
+// scanPosition returns the position saved for a SCAN style cursor, where
+// cursor 0 starts a new iteration at a nil position.
+func (s *SingleStore) scanPosition(cursor uint64) ([]byte, error) {
+	if cursor == 0 {
+		return nil, nil
+	}
+	if cursor > math.MaxInt64 {
+		return nil, ErrInvalidCursor
+	}
+
+	var out []byte
+	err := s.db.Get(&out, "select pos from cursorLoad(?)", int64(cursor))
+	if err != nil {
+		return nil, err
+	}
+	if out == nil {
+		return nil, ErrInvalidCursor
+	}
 	return out, nil
 }
 
-func (s *SingleStore) BlobSet(k string, v []byte) error {
-	_, err := s.db.Exec("call blobSet(?, ?)", k, v)
+// scanCursor returns the cursor continuing a SCAN style iteration after a
+// batch of n rows ending at last, which is 0 once the iteration is complete.
+func (s *SingleStore) scanCursor(n int, count int64, last []byte) (uint64, error) {
+	if int64(n) < count {
+		return 0, nil
+	}
+
+	var out int64
+	err := s.db.Get(&out, "echo cursorSave(?)", last)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(out), nil
+}
+
+// Scan iterates over the keys, returning those of the next count keys which
+// match pattern and, unless it is empty, have type t.
+func (s *SingleStore) Scan(cursor uint64, pattern Glob, count int64, t string) (uint64, [][]byte, error) {
+	after, err := s.scanPosition(cursor)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var rows []struct {
+		K       []byte `db:"k"`
+		Matched bool   `db:"matched"`
+	}
+	var typ interface{}
+	if t != "" {
+		typ = t
+	}
+	err = s.db.Select(&rows, "echo keyScan(?, ?, ?, ?, ?, ?)",
+		s.dbIndex, after, count, []byte(pattern.Prefix), []byte(pattern.Regexp), typ)
+	if err != nil || len(rows) == 0 {
+		return 0, [][]byte{}, err
+	}
+
+	out := make([][]byte, 0, len(rows))
+	for _, row := range rows {
+		if row.Matched {
+			out = append(out, row.K)
+		}
+	}
+	next, err := s.scanCursor(len(rows), count, rows[len(rows)-1].K)
+	return next, out, err
+}
+
+func (s *SingleStore) BlobSet(k, v []byte) error {
+	_, err := s.db.Exec("call blobSet(?, ?, ?)", s.dbIndex, k, v)
 	return err
 }
 
-func (s *SingleStore) BlobGet(k string) ([]byte, error) {
+func (s *SingleStore) BlobGet(k []byte) ([]byte, error) {
 	var out []byte
-	err := s.db.Get(&out, "select v from blobGet(?)", k)
+	err := s.db.Get(&out, "select v from blobGet(?, ?)", s.dbIndex, k)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (s *SingleStore) IncrBy(k string, v int64) (int64, error) {
+func (s *SingleStore) IncrBy(k []byte, v int64) (int64, error) {
 	var out int64
-	err := s.db.Get(&out, "echo incrBy(?, ?)", k, v)
+	err := s.db.Get(&out, "echo incrBy(?, ?, ?)", s.dbIndex, k, v)
 	if err != nil {
 		return 0, err
 	}
 	return out, nil
 }
 
-func (s *SingleStore) ListAppend(k string, v []byte) error {
-	_, err := s.db.Exec("call listAppend(?, ?)", k, v)
-	return err
+func (s *SingleStore) DecrBy(k []byte, v int64) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "echo decrBy(?, ?, ?)", s.dbIndex, k, v)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+func (s *SingleStore) IncrByFloat(k []byte, v float64) ([]byte, error) {
+	var out []byte
+	err := s.db.Get(&out, "echo incrByFloat(?, ?, ?)", s.dbIndex, k, v)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-func (s *SingleStore) ListRemove(k string, v []byte) (int64, error) {
+func (s *SingleStore) SetBit(k []byte, offset int64, bit int) (int64, error) {
 	var out int64
-	err := s.db.Get(&out, "echo listRemove(?, ?)", k, v)
+	err := s.db.Get(&out, "echo setBit(?, ?, ?, ?)", s.dbIndex, k, offset, bit)
 	if err != nil {
 		return 0, err
 	}
 	return out, nil
 }
 
-func (s *SingleStore) ListGet(k string) ([][]byte, error) {
-	var out [][]byte
-	err := s.db.Select(&out, "select v from listGet(?)", k)
+func (s *SingleStore) GetBit(k []byte, offset int64) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "select v from getBit(?, ?, ?)", s.dbIndex, k, offset)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+func (s *SingleStore) BitCount(k []byte, start, end int64, bitMode bool) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "echo bitCount(?, ?, ?, ?, ?)", s.dbIndex, k, start, end, bitMode)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+func (s *SingleStore) BitPos(k []byte, bit int, start, end int64, hasEnd, bitMode bool) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "echo bitPos(?, ?, ?, ?, ?, ?, ?)", s.dbIndex, k, bit, start, end, hasEnd, bitMode)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+// BitOp stores the result of op ("and", "or", "xor" or "not") across keys in
+// dest and returns its length.
+func (s *SingleStore) BitOp(op string, dest []byte, keys ...[]byte) (int64, error) {
+	var out int64
+
+	query, args, err := sqlx.In("echo bitOp(?, ?, ?, [?])", s.dbIndex, op, dest, keys)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Get(&out, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+type BitFieldOp struct {
+	Op       string // "get", "set" or "incrby"
+	Signed   bool
+	Bits     int
+	Offset   int64
+	Value    int64
+	Overflow string // "wrap", "sat" or "fail"
+}
+
+// BitField runs ops against k in a single transaction. A nil result means
+// the operation was skipped because of OVERFLOW FAIL.
+func (s *SingleStore) BitField(k []byte, ops []BitFieldOp) ([]*int64, error) {
+	names := make([]string, len(ops))
+	signed := make([]bool, len(ops))
+	bits := make([]int, len(ops))
+	offsets := make([]int64, len(ops))
+	values := make([]int64, len(ops))
+	overflows := make([]string, len(ops))
+	for i, op := range ops {
+		names[i] = op.Op
+		signed[i] = op.Signed
+		bits[i] = op.Bits
+		offsets[i] = op.Offset
+		values[i] = op.Value
+		overflows[i] = op.Overflow
+	}
+
+	query, args, err := sqlx.In(
+		"echo bitField(?, ?, [?], [?], [?], [?], [?], [?])",
+		s.dbIndex, k, names, signed, bits, offsets, values, overflows,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw string
+	err = s.db.Get(&raw, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*int64
+	err = json.Unmarshal([]byte(raw), &out)
 	return out, err
 }
 
-func (s *SingleStore) ListRange(k string, start, end int) ([][]byte, error) {
+// ListPush pushes values onto the head (left) or tail of k, returning the new
+// length. If existing is set, k is left untouched unless it already exists.
+func (s *SingleStore) ListPush(k []byte, values [][]byte, left, existing bool) (int64, error) {
+	var out int64
+
+	query, args, err := sqlx.In("echo listPush(?, ?, [?], ?, ?)", s.dbIndex, k, values, left, existing)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Get(&out, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	if out > 0 {
+		s.waiters.notify(s.dbKey(k))
+	}
+	return out, nil
+}
+
+// ListPop removes and returns up to count elements from the head (left) or
+// tail of k.
+func (s *SingleStore) ListPop(k []byte, count int64, left bool) ([][]byte, error) {
 	var out [][]byte
-	err := s.db.Select(&out, "select v from listRange(?, ?, ?)", k, start, end)
+	err := s.db.Select(&out, "echo listPop(?, ?, ?, ?)", s.dbIndex, k, count, left)
 	return out, err
 }
 
-func (s *SingleStore) SetAdd(k string, v []byte) error {
-	_, err := s.db.Exec("call setAdd(?, ?)", k, v)
-	return err
+// ListMultiPop pops up to count elements from the head (left) or tail of the
+// first non-empty list in keys, returning the key they were popped from.
+func (s *SingleStore) ListMultiPop(keys [][]byte, count int64, left bool) ([]byte, [][]byte, error) {
+	var rows []struct {
+		K []byte `db:"k"`
+		V []byte `db:"v"`
+	}
+
+	query, args, err := sqlx.In("echo listMultiPop(?, [?], ?, ?)", s.dbIndex, keys, count, left)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = s.db.Select(&rows, query, args...)
+	if err != nil || len(rows) == 0 {
+		return nil, nil, err
+	}
+
+	out := make([][]byte, len(rows))
+	for i, row := range rows {
+		out[i] = row.V
+	}
+	return rows[0].K, out, nil
+}
+
+// ListMove pops an element from the head (fromLeft) or tail of src and pushes
+// it onto the head (toLeft) or tail of dest, returning nil if src does not
+// exist.
+func (s *SingleStore) ListMove(src, dest []byte, fromLeft, toLeft bool) ([]byte, error) {
+	var out []byte
+	err := s.db.Get(&out, "echo listMove(?, ?, ?, ?, ?)", s.dbIndex, src, dest, fromLeft, toLeft)
+	if err != nil {
+		return nil, err
+	}
+	if out != nil {
+		s.waiters.notify(s.dbKey(dest))
+	}
+	return out, nil
 }
 
-func (s *SingleStore) SetRemove(k string, v []byte) (int64, error) {
+func (s *SingleStore) ListRemove(k, v []byte) (int64, error) {
 	var out int64
-	err := s.db.Get(&out, "echo setRemove(?, ?)", k, v)
+	err := s.db.Get(&out, "echo listRemove(?, ?, ?)", s.dbIndex, k, v)
 	if err != nil {
 		return 0, err
 	}
 	return out, nil
 }
 
-func (s *SingleStore) SetGet(k string) ([][]byte, error) {
+func (s *SingleStore) ListGet(k []byte) ([][]byte, error) {
 	var out [][]byte
-	err := s.db.Select(&out, "select v from setGet(?)", k)
+	err := s.db.Select(&out, "select v from listGet(?, ?)", s.dbIndex, k)
 	return out, err
 }
 
-func (s *SingleStore) SetUnion(keys ...string) ([][]byte, error) {
+// ListRange returns the elements between start and stop (inclusive), where
+// negative indices count back from the tail.
+func (s *SingleStore) ListRange(k []byte, start, stop int64) ([][]byte, error) {
 	var out [][]byte
+	err := s.db.Select(&out, "echo listRange(?, ?, ?, ?)", s.dbIndex, k, start, stop)
+	return out, err
+}
 
-	query, args, err := sqlx.In("echo setUnion([?])", keys)
+func (s *SingleStore) ListLength(k []byte) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "select n from listLength(?, ?)", s.dbIndex, k)
 	if err != nil {
-		return out, err
+		return 0, err
 	}
+	return out, nil
+}
 
-	err = s.db.Select(&out, query, args...)
-	return out, err
+// ListIndex returns the element at index, or nil if it is out of range.
+// Negative indices count back from the tail.
+func (s *SingleStore) ListIndex(k []byte, index int64) ([]byte, error) {
+	var out []byte
+	err := s.db.Get(&out, "select v from listIndex(?, ?, ?)", s.dbIndex, k, index)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-func (s *SingleStore) SetIntersect(keys ...string) ([][]byte, error) {
-	var out [][]byte
+func (s *SingleStore) ListSet(k []byte, index int64, v []byte) error {
+	_, err := s.db.Exec("call listSet(?, ?, ?, ?)", s.dbIndex, k, index, v)
+	return err
+}
 
-	query, args, err := sqlx.In("echo setIntersect([?])", keys)
+// ListInsert inserts v before or after the first occurrence of pivot,
+// returning the new length, 0 if k does not exist or -1 if pivot was not
+// found.
+func (s *SingleStore) ListInsert(k []byte, before bool, pivot, v []byte) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "echo listInsert(?, ?, ?, ?, ?)", s.dbIndex, k, before, pivot, v)
 	if err != nil {
-		return out, err
+		return 0, err
 	}
+	return out, nil
+}
 
-	err = s.db.Select(&out, query, args...)
+// ListPositions returns the indices of elements equal to v, following the
+// RANK, COUNT and MAXLEN semantics of LPOS.
+func (s *SingleStore) ListPositions(k, v []byte, rank, count, maxLen int64) ([]int64, error) {
+	var out []int64
+	err := s.db.Select(&out, "select idx from listPositions(?, ?, ?, ?, ?, ?)", s.dbIndex, k, v, rank, count, maxLen)
 	return out, err
 }
 
-func (s *SingleStore) SetsWithMember(v []byte) ([]string, error) {
-	var out []string
-	err := s.db.Select(&out, "select k from setsWithMember(?)", v)
-	return out, err
+func (s *SingleStore) ListTrim(k []byte, start, stop int64) error {
+	_, err := s.db.Exec("call listTrim(?, ?, ?, ?)", s.dbIndex, k, start, stop)
+	return err
 }
 
-func (s *SingleStore) SetCardinality(k string) (int64, error) {
+// SetAdd adds values to k, returning how many were not already members.
+func (s *SingleStore) SetAdd(k []byte, values [][]byte) (int64, error) {
 	var out int64
-	err := s.db.Get(&out, "select * from setCardinality(?)", k)
+
+	query, args, err := sqlx.In("echo setAdd(?, ?, [?])", s.dbIndex, k, values)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Get(&out, query, args...)
 	if err != nil {
 		return 0, err
 	}
 	return out, nil
 }
 
-func (s *SingleStore) SetIntersectCardinality(keys ...string) (int64, error) {
+// SetRemove removes values from k, returning how many were members.
+func (s *SingleStore) SetRemove(k []byte, values [][]byte) (int64, error) {
 	var out int64
 
-	query, args, err := sqlx.In("echo setIntersectCardinality([?])", keys)
+	query, args, err := sqlx.In("echo setRemove(?, ?, [?])", s.dbIndex, k, values)
 	if err != nil {
 		return out, err
 	}
@@ -204,3 +658,1556 @@ func (s *SingleStore) SetIntersectCardinality(keys ...string) (int64, error) {
 	}
 	return out, nil
 }
+
+func (s *SingleStore) SetGet(k []byte) ([][]byte, error) {
+	var out [][]byte
+	err := s.db.Select(&out, "select v from setGet(?, ?)", s.dbIndex, k)
+	return out, err
+}
+
+func (s *SingleStore) SetUnion(keys ...[]byte) ([][]byte, error) {
+	var out [][]byte
+
+	query, args, err := sqlx.In("echo setUnion(?, [?])", s.dbIndex, keys)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Select(&out, query, args...)
+	return out, err
+}
+
+func (s *SingleStore) SetIntersect(keys ...[]byte) ([][]byte, error) {
+	var out [][]byte
+
+	query, args, err := sqlx.In("echo setIntersect(?, [?])", s.dbIndex, keys)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Select(&out, query, args...)
+	return out, err
+}
+
+// SetDiff returns the members of the first key which are not in any of the
+// others.
+func (s *SingleStore) SetDiff(keys ...[]byte) ([][]byte, error) {
+	var out [][]byte
+
+	query, args, err := sqlx.In("echo setDiff(?, [?])", s.dbIndex, keys)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Select(&out, query, args...)
+	return out, err
+}
+
+// SetCombineStore replaces dest with the union ("union"), intersection
+// ("inter") or difference ("diff") of the sets at keys, returning its
+// cardinality.
+func (s *SingleStore) SetCombineStore(dest []byte, op string, keys ...[]byte) (int64, error) {
+	var out int64
+
+	query, args, err := sqlx.In("echo setCombineStore(?, ?, ?, [?])", s.dbIndex, dest, op, keys)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Get(&out, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+// SetScan iterates over the members of k like Scan.
+func (s *SingleStore) SetScan(k []byte, cursor uint64, pattern Glob, count int64) (uint64, [][]byte, error) {
+	after, err := s.scanPosition(cursor)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var rows []struct {
+		V       []byte `db:"v"`
+		Matched bool   `db:"matched"`
+	}
+	err = s.db.Select(&rows, "echo setScan(?, ?, ?, ?, ?, ?)", s.dbIndex, k, after, count, []byte(pattern.Prefix), []byte(pattern.Regexp))
+	if err != nil || len(rows) == 0 {
+		return 0, [][]byte{}, err
+	}
+
+	out := make([][]byte, 0, len(rows))
+	for _, row := range rows {
+		if row.Matched {
+			out = append(out, row.V)
+		}
+	}
+	next, err := s.scanCursor(len(rows), count, rows[len(rows)-1].V)
+	return next, out, err
+}
+
+func (s *SingleStore) SetIsMember(k, v []byte) (bool, error) {
+	var out bool
+	err := s.db.Get(&out, "select found from setIsMember(?, ?, ?)", s.dbIndex, k, v)
+	if err != nil {
+		return false, err
+	}
+	return out, nil
+}
+
+// SetMultiIsMember reports whether each of members is in k.
+func (s *SingleStore) SetMultiIsMember(k []byte, members [][]byte) ([]bool, error) {
+	var found [][]byte
+
+	query, args, err := sqlx.In("echo setMultiIsMember(?, ?, [?])", s.dbIndex, k, members)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Select(&found, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	in := make(map[string]bool, len(found))
+	for _, v := range found {
+		in[string(v)] = true
+	}
+	out := make([]bool, len(members))
+	for i, m := range members {
+		out[i] = in[string(m)]
+	}
+	return out, nil
+}
+
+// SetPop removes and returns up to count random members of k.
+func (s *SingleStore) SetPop(k []byte, count int64) ([][]byte, error) {
+	var out [][]byte
+	err := s.db.Select(&out, "echo setPop(?, ?, ?)", s.dbIndex, k, count)
+	return out, err
+}
+
+// SetRandomMembers returns count random members of k, which are distinct
+// unless repeat is set.
+func (s *SingleStore) SetRandomMembers(k []byte, count int64, repeat bool) ([][]byte, error) {
+	var out [][]byte
+	err := s.db.Select(&out, "echo setRandomMembers(?, ?, ?, ?)", s.dbIndex, k, count, repeat)
+	return out, err
+}
+
+// SetMove moves v from src to dest, returning whether it was a member of src.
+func (s *SingleStore) SetMove(src, dest []byte, v []byte) (bool, error) {
+	var out bool
+	err := s.db.Get(&out, "echo setMove(?, ?, ?, ?)", s.dbIndex, src, dest, v)
+	if err != nil {
+		return false, err
+	}
+	return out, nil
+}
+
+func (s *SingleStore) SetsWithMember(v []byte) ([][]byte, error) {
+	var out [][]byte
+	err := s.db.Select(&out, "select k from setsWithMember(?, ?)", s.dbIndex, v)
+	return out, err
+}
+
+func (s *SingleStore) SetCardinality(k []byte) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "select * from setCardinality(?, ?)", s.dbIndex, k)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+// SetIntersectCardinality counts the members of the intersection of keys,
+// stopping once limit members have been found unless limit is 0.
+func (s *SingleStore) SetIntersectCardinality(limit int64, keys ...[]byte) (int64, error) {
+	var out int64
+
+	query, args, err := sqlx.In("echo setIntersectCardinality(?, [?], ?)", s.dbIndex, keys, limit)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Get(&out, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+type HashField struct {
+	F []byte `db:"f"`
+	V []byte `db:"v"`
+}
+
+func (s *SingleStore) HashSet(k []byte, fields, values [][]byte) (int64, error) {
+	var out int64
+
+	query, args, err := sqlx.In("echo hashSet(?, ?, [?], [?])", s.dbIndex, k, fields, values)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Get(&out, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+func (s *SingleStore) HashSetNX(k []byte, f, v []byte) (bool, error) {
+	var out bool
+	err := s.db.Get(&out, "echo hashSetNX(?, ?, ?, ?)", s.dbIndex, k, f, v)
+	if err != nil {
+		return false, err
+	}
+	return out, nil
+}
+
+func (s *SingleStore) HashGet(k []byte, f []byte) ([]byte, error) {
+	var out []byte
+	err := s.db.Get(&out, "select v from hashGet(?, ?, ?)", s.dbIndex, k, f)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// HashMultiGet returns the values of fields in order, with nil for fields
+// which don't exist.
+func (s *SingleStore) HashMultiGet(k []byte, fields [][]byte) ([][]byte, error) {
+	var found []HashField
+
+	query, args, err := sqlx.In("echo hashMultiGet(?, ?, [?])", s.dbIndex, k, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Select(&found, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string][]byte, len(found))
+	for _, hf := range found {
+		values[string(hf.F)] = hf.V
+	}
+	out := make([][]byte, len(fields))
+	for i, f := range fields {
+		out[i] = values[string(f)]
+	}
+	return out, nil
+}
+
+func (s *SingleStore) HashGetAll(k []byte) ([]HashField, error) {
+	var out []HashField
+	err := s.db.Select(&out, "select f, v from hashGetAll(?, ?)", s.dbIndex, k)
+	return out, err
+}
+
+func (s *SingleStore) HashKeys(k []byte) ([][]byte, error) {
+	var out [][]byte
+	err := s.db.Select(&out, "select f from hashGetAll(?, ?)", s.dbIndex, k)
+	return out, err
+}
+
+func (s *SingleStore) HashValues(k []byte) ([][]byte, error) {
+	var out [][]byte
+	err := s.db.Select(&out, "select v from hashGetAll(?, ?)", s.dbIndex, k)
+	return out, err
+}
+
+func (s *SingleStore) HashDelete(k []byte, fields [][]byte) (int64, error) {
+	var out int64
+
+	query, args, err := sqlx.In("echo hashDelete(?, ?, [?])", s.dbIndex, k, fields)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Get(&out, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+func (s *SingleStore) HashExists(k []byte, f []byte) (bool, error) {
+	var out bool
+	err := s.db.Get(&out, "select * from hashExists(?, ?, ?)", s.dbIndex, k, f)
+	if err != nil {
+		return false, err
+	}
+	return out, nil
+}
+
+func (s *SingleStore) HashLength(k []byte) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "select * from hashLength(?, ?)", s.dbIndex, k)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+func (s *SingleStore) HashStrLength(k []byte, f []byte) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "select * from hashStrLength(?, ?, ?)", s.dbIndex, k, f)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+func (s *SingleStore) HashIncrBy(k []byte, f []byte, v int64) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "echo hashIncrBy(?, ?, ?, ?)", s.dbIndex, k, f, v)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+func (s *SingleStore) HashIncrByFloat(k []byte, f []byte, v float64) ([]byte, error) {
+	var out []byte
+	err := s.db.Get(&out, "echo hashIncrByFloat(?, ?, ?, ?)", s.dbIndex, k, f, v)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// HashScan iterates over the fields of k like Scan.
+func (s *SingleStore) HashScan(k []byte, cursor uint64, pattern Glob, count int64) (uint64, []HashField, error) {
+	after, err := s.scanPosition(cursor)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var rows []struct {
+		HashField
+		Matched bool `db:"matched"`
+	}
+	err = s.db.Select(&rows, "echo hashScan(?, ?, ?, ?, ?, ?)", s.dbIndex, k, after, count, []byte(pattern.Prefix), []byte(pattern.Regexp))
+	if err != nil || len(rows) == 0 {
+		return 0, []HashField{}, err
+	}
+
+	out := make([]HashField, 0, len(rows))
+	for _, row := range rows {
+		if row.Matched {
+			out = append(out, row.HashField)
+		}
+	}
+	next, err := s.scanCursor(len(rows), count, rows[len(rows)-1].F)
+	return next, out, err
+}
+
+// HashRandomFields returns count random fields of k. If repeat is set the
+// fields are picked independently and may repeat.
+func (s *SingleStore) HashRandomFields(k []byte, count int64, repeat bool) ([]HashField, error) {
+	var out []HashField
+	err := s.db.Select(&out, "echo hashRandomFields(?, ?, ?, ?)", s.dbIndex, k, count, repeat)
+	return out, err
+}
+
+type ZMember struct {
+	Member []byte  `db:"member"`
+	Score  float64 `db:"score"`
+}
+
+type ZAddFlags struct {
+	NX, XX, GT, LT, CH bool
+}
+
+type ScoreRange struct {
+	Min, Max                   float64
+	MinExclusive, MaxExclusive bool
+}
+
+// LexRange bounds are unbounded when nil.
+type LexRange struct {
+	Min, Max                   []byte
+	MinExclusive, MaxExclusive bool
+}
+
+// SingleStore doubles can't hold infinities, so infinite scores are stored as
+// the largest finite values instead.
+func scoreToDB(f float64) float64 {
+	if math.IsInf(f, 1) {
+		return math.MaxFloat64
+	} else if math.IsInf(f, -1) {
+		return -math.MaxFloat64
+	}
+	return f
+}
+
+func scoreFromDB(f float64) float64 {
+	if f == math.MaxFloat64 {
+		return math.Inf(1)
+	} else if f == -math.MaxFloat64 {
+		return math.Inf(-1)
+	}
+	return f
+}
+
+func zmembersFromDB(members []ZMember) []ZMember {
+	for i := range members {
+		members[i].Score = scoreFromDB(members[i].Score)
+	}
+	return members
+}
+
+func (s *SingleStore) ZSetAdd(k []byte, members []ZMember, flags ZAddFlags) (int64, error) {
+	var out int64
+
+	names := make([][]byte, len(members))
+	scores := make([]float64, len(members))
+	for i, m := range members {
+		names[i] = m.Member
+		scores[i] = scoreToDB(m.Score)
+	}
+
+	query, args, err := sqlx.In(
+		"echo zsetAdd(?, ?, [?], [?], ?, ?, ?, ?, ?)",
+		s.dbIndex, k, names, scores, flags.NX, flags.XX, flags.GT, flags.LT, flags.CH,
+	)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Get(&out, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+// ZSetIncrBy returns false if flags prevented the update.
+func (s *SingleStore) ZSetIncrBy(k []byte, m []byte, delta float64, flags ZAddFlags) (float64, bool, error) {
+	var out sql.NullFloat64
+	err := s.db.Get(&out, "echo zsetIncrBy(?, ?, ?, ?, ?, ?, ?, ?)",
+		s.dbIndex, k, m, scoreToDB(delta), flags.NX, flags.XX, flags.GT, flags.LT)
+	if err != nil {
+		return 0, false, err
+	}
+	return scoreFromDB(out.Float64), out.Valid, nil
+}
+
+func (s *SingleStore) ZSetRemove(k []byte, members [][]byte) (int64, error) {
+	var out int64
+
+	query, args, err := sqlx.In("echo zsetRemove(?, ?, [?])", s.dbIndex, k, members)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Get(&out, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+// ZSetScore returns false if m is not a member of k.
+func (s *SingleStore) ZSetScore(k []byte, m []byte) (float64, bool, error) {
+	var out sql.NullFloat64
+	err := s.db.Get(&out, "select score from zsetScore(?, ?, ?)", s.dbIndex, k, m)
+	if err != nil {
+		return 0, false, err
+	}
+	return scoreFromDB(out.Float64), out.Valid, nil
+}
+
+func (s *SingleStore) ZSetCardinality(k []byte) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "select * from zsetCardinality(?, ?)", s.dbIndex, k)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+func (s *SingleStore) ZSetCount(k []byte, r ScoreRange) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "select * from zsetCount(?, ?, ?, ?, ?, ?)",
+		s.dbIndex, k, scoreToDB(r.Min), r.MinExclusive, scoreToDB(r.Max), r.MaxExclusive)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+// ZSetRank returns false if m is not a member of k.
+func (s *SingleStore) ZSetRank(k []byte, m []byte, rev bool) (int64, bool, error) {
+	var out sql.NullInt64
+	err := s.db.Get(&out, "select * from zsetRank(?, ?, ?, ?)", s.dbIndex, k, m, rev)
+	if err != nil {
+		return 0, false, err
+	}
+	return out.Int64, out.Valid, nil
+}
+
+func (s *SingleStore) ZSetRangeByRank(k []byte, start, stop int64, rev bool) ([]ZMember, error) {
+	var out []ZMember
+	err := s.db.Select(&out, "select member, score from zsetRangeByRank(?, ?, ?, ?, ?)", s.dbIndex, k, start, stop, rev)
+	return zmembersFromDB(out), err
+}
+
+// ZSetRangeByScore skips offset members and returns at most count of them,
+// or all of them if count is negative.
+func (s *SingleStore) ZSetRangeByScore(k []byte, r ScoreRange, rev bool, offset, count int64) ([]ZMember, error) {
+	var out []ZMember
+	err := s.db.Select(&out, "select member, score from zsetRangeByScore(?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		s.dbIndex, k, scoreToDB(r.Min), r.MinExclusive, scoreToDB(r.Max), r.MaxExclusive, rev, offset, count)
+	return zmembersFromDB(out), err
+}
+
+// ZSetRangeByLex skips offset members and returns at most count of them,
+// or all of them if count is negative.
+func (s *SingleStore) ZSetRangeByLex(k []byte, r LexRange, rev bool, offset, count int64) ([]ZMember, error) {
+	var out []ZMember
+	err := s.db.Select(&out, "select member, score from zsetRangeByLex(?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		s.dbIndex, k, r.Min, r.MinExclusive, r.Max, r.MaxExclusive, rev, offset, count)
+	return zmembersFromDB(out), err
+}
+
+// ZSetPop removes and returns up to count members with the lowest scores,
+// or the highest if max is set.
+func (s *SingleStore) ZSetPop(k []byte, count int64, max bool) ([]ZMember, error) {
+	var out []ZMember
+	err := s.db.Select(&out, "echo zsetPop(?, ?, ?, ?)", s.dbIndex, k, count, max)
+	return zmembersFromDB(out), err
+}
+
+// ZSetScan iterates over the members of k like Scan.
+func (s *SingleStore) ZSetScan(k []byte, cursor uint64, pattern Glob, count int64) (uint64, []ZMember, error) {
+	after, err := s.scanPosition(cursor)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var rows []struct {
+		ZMember
+		Matched bool `db:"matched"`
+	}
+	err = s.db.Select(&rows, "echo zsetScan(?, ?, ?, ?, ?, ?)", s.dbIndex, k, after, count, []byte(pattern.Prefix), []byte(pattern.Regexp))
+	if err != nil || len(rows) == 0 {
+		return 0, []ZMember{}, err
+	}
+
+	out := make([]ZMember, 0, len(rows))
+	for _, row := range rows {
+		if row.Matched {
+			out = append(out, row.ZMember)
+		}
+	}
+	next, err := s.scanCursor(len(rows), count, rows[len(rows)-1].Member)
+	return next, zmembersFromDB(out), err
+}
+
+func (s *SingleStore) ZSetRemoveRangeByRank(k []byte, start, stop int64) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "echo zsetRemoveRangeByRank(?, ?, ?, ?)", s.dbIndex, k, start, stop)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+func (s *SingleStore) ZSetRemoveRangeByScore(k []byte, r ScoreRange) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "echo zsetRemoveRangeByScore(?, ?, ?, ?, ?, ?)",
+		s.dbIndex, k, scoreToDB(r.Min), r.MinExclusive, scoreToDB(r.Max), r.MaxExclusive)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+func (s *SingleStore) ZSetRemoveRangeByLex(k []byte, r LexRange) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "echo zsetRemoveRangeByLex(?, ?, ?, ?, ?, ?)",
+		s.dbIndex, k, r.Min, r.MinExclusive, r.Max, r.MaxExclusive)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+// ZSetCombine combines the sorted sets at keys with op ("union", "inter" or
+// "diff"), multiplying their scores by weights and merging them with
+// aggregate ("sum", "min" or "max").
+func (s *SingleStore) ZSetCombine(op string, keys [][]byte, weights []float64, aggregate string) ([]ZMember, error) {
+	var out []ZMember
+
+	query, args, err := sqlx.In("echo zsetCombine(?, ?, [?], [?], ?)", s.dbIndex, op, keys, weightsToDB(weights), aggregate)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Select(&out, query, args...)
+	return zmembersFromDB(out), err
+}
+
+// ZSetCombineStore is like ZSetCombine but replaces dest with the result and
+// returns its cardinality.
+func (s *SingleStore) ZSetCombineStore(dest []byte, op string, keys [][]byte, weights []float64, aggregate string) (int64, error) {
+	var out int64
+
+	query, args, err := sqlx.In("echo zsetCombineStore(?, ?, ?, [?], [?], ?)", s.dbIndex, dest, op, keys, weightsToDB(weights), aggregate)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Get(&out, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+func weightsToDB(weights []float64) []float64 {
+	out := make([]float64, len(weights))
+	for i, w := range weights {
+		out[i] = scoreToDB(w)
+	}
+	return out
+}
+
+// StreamID identifies a stream entry by the millisecond time it was added and
+// a sequence number within that millisecond.
+type StreamID struct {
+	Ms  uint64 `db:"id_ms"`
+	Seq uint64 `db:"id_seq"`
+}
+
+// MaxStreamID is the greatest possible stream ID.
+var MaxStreamID = StreamID{math.MaxUint64, math.MaxUint64}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Next returns the smallest ID greater than id, or false if id is the
+// greatest possible ID.
+func (id StreamID) Next() (StreamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{id.Ms, id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return StreamID{id.Ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// Prev returns the greatest ID less than id, or false if id is 0-0.
+func (id StreamID) Prev() (StreamID, bool) {
+	switch {
+	case id.Seq > 0:
+		return StreamID{id.Ms, id.Seq - 1}, true
+	case id.Ms > 0:
+		return StreamID{id.Ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+type StreamEntry struct {
+	ID     StreamID
+	Fields [][]byte
+}
+
+// StreamTrim removes the oldest entries of a stream beyond the newest MaxLen,
+// or if ByMinID is set those with IDs less than MinID. At most Limit entries
+// are removed unless it is 0.
+type StreamTrim struct {
+	ByMinID bool
+	MaxLen  int64
+	MinID   StreamID
+	Limit   int64
+}
+
+// args returns the maxlen, min_ms, min_seq and limit arguments of the stream
+// procedures, which are null for the strategy not in use.
+func (t *StreamTrim) args() []interface{} {
+	switch {
+	case t == nil:
+		return []interface{}{nil, nil, nil, 0}
+	case t.ByMinID:
+		return []interface{}{nil, t.MinID.Ms, t.MinID.Seq, t.Limit}
+	}
+	return []interface{}{t.MaxLen, nil, nil, t.Limit}
+}
+
+// StreamAdd appends an entry holding fields to k, returning its ID or nil if
+// noMkStream is set and k does not exist. A nil ms takes the current time and
+// a nil seq the next sequence number. The stream is then trimmed unless trim
+// is nil.
+func (s *SingleStore) StreamAdd(k []byte, ms, seq *uint64, fields [][]byte, noMkStream bool, trim *StreamTrim) ([]byte, error) {
+	var out []byte
+	args := append([]interface{}{s.dbIndex, k, ms, seq, encodeStreamFields(fields), noMkStream}, trim.args()...)
+	err := s.db.Get(&out, "echo streamAdd(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", args...)
+	if err != nil {
+		return nil, err
+	}
+	if out != nil {
+		s.waiters.notifyAll(s.dbKey(k))
+	}
+	return out, nil
+}
+
+// StreamRange returns up to count entries of k, or all of them if count is
+// negative, from start to end inclusive, or from end to start if rev is set.
+func (s *SingleStore) StreamRange(k []byte, start, end StreamID, rev bool, count int64) ([]StreamEntry, error) {
+	return s.selectStreamEntries("echo streamRange(?, ?, ?, ?, ?, ?, ?, ?)",
+		s.dbIndex, k, start.Ms, start.Seq, end.Ms, end.Seq, rev, count)
+}
+
+func (s *SingleStore) StreamLength(k []byte) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "select n from streamLength(?, ?)", s.dbIndex, k)
+	return out, err
+}
+
+// StreamLastID returns the ID of the last entry added to k, which may since
+// have been deleted, or false if k does not exist.
+func (s *SingleStore) StreamLastID(k []byte) (StreamID, bool, error) {
+	var out []StreamID
+	err := s.db.Select(&out, "select last_ms as id_ms, last_seq as id_seq from streamLastID(?, ?)", s.dbIndex, k)
+	if err != nil || len(out) == 0 {
+		return StreamID{}, false, err
+	}
+	return out[0], true, nil
+}
+
+// StreamDelete deletes the entries of k with the given IDs, returning how many
+// existed.
+func (s *SingleStore) StreamDelete(k []byte, ids []StreamID) (int64, error) {
+	var out int64
+	ms, seq := splitStreamIDs(ids)
+
+	query, args, err := sqlx.In("echo streamDelete(?, ?, [?], [?])", s.dbIndex, k, ms, seq)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Get(&out, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+// StreamTrim trims k, returning how many entries were removed.
+func (s *SingleStore) StreamTrim(k []byte, trim *StreamTrim) (int64, error) {
+	var out int64
+	args := append([]interface{}{s.dbIndex, k}, trim.args()...)
+	err := s.db.Get(&out, "echo streamTrim(?, ?, ?, ?, ?, ?)", args...)
+	return out, err
+}
+
+// StreamGroupCreate creates group grp of k, which delivers the entries after
+// id, or after the last entry added if id is nil. k is created if it does not
+// exist and mkStream is set.
+func (s *SingleStore) StreamGroupCreate(k, grp []byte, id *StreamID, mkStream bool) error {
+	ms, seq := streamIDArgs(id)
+	_, err := s.db.Exec("call streamGroupCreate(?, ?, ?, ?, ?, ?)", s.dbIndex, k, grp, ms, seq, mkStream)
+	return err
+}
+
+// StreamGroupDestroy destroys group grp of k, returning 1 if it existed.
+func (s *SingleStore) StreamGroupDestroy(k, grp []byte) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "echo streamGroupDestroy(?, ?, ?)", s.dbIndex, k, grp)
+	return out, err
+}
+
+// StreamGroupSetID sets the last ID delivered to group grp of k, or to the
+// last entry added if id is nil.
+func (s *SingleStore) StreamGroupSetID(k, grp []byte, id *StreamID) error {
+	ms, seq := streamIDArgs(id)
+	_, err := s.db.Exec("call streamGroupSetID(?, ?, ?, ?, ?)", s.dbIndex, k, grp, ms, seq)
+	return err
+}
+
+// StreamConsumerCreate creates consumer in group grp of k, returning 1 if it
+// was new.
+func (s *SingleStore) StreamConsumerCreate(k, grp, consumer []byte) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "echo streamConsumerCreate(?, ?, ?, ?)", s.dbIndex, k, grp, consumer)
+	return out, err
+}
+
+// StreamConsumerDelete deletes consumer from group grp of k, returning how
+// many entries were pending for it.
+func (s *SingleStore) StreamConsumerDelete(k, grp, consumer []byte) (int64, error) {
+	var out int64
+	err := s.db.Get(&out, "echo streamConsumerDelete(?, ?, ?, ?)", s.dbIndex, k, grp, consumer)
+	return out, err
+}
+
+// StreamReadGroup delivers up to count new entries of k to consumer in group
+// grp, or all of them if count is negative, adding them to the pending
+// entries of the group unless noAck is set.
+func (s *SingleStore) StreamReadGroup(k, grp, consumer []byte, count int64, noAck bool) ([]StreamEntry, error) {
+	return s.selectStreamEntries("echo streamReadGroup(?, ?, ?, ?, ?, ?)", s.dbIndex, k, grp, consumer, count, noAck)
+}
+
+// StreamReadPending returns up to count of the entries pending for consumer
+// in group grp from start, or all of them if count is negative. Entries
+// since deleted from k have nil fields.
+func (s *SingleStore) StreamReadPending(k, grp, consumer []byte, start StreamID, count int64) ([]StreamEntry, error) {
+	return s.selectStreamEntries("echo streamReadPending(?, ?, ?, ?, ?, ?, ?)",
+		s.dbIndex, k, grp, consumer, start.Ms, start.Seq, count)
+}
+
+// StreamAck acknowledges the entries of group grp of k with the given IDs,
+// returning how many were pending.
+func (s *SingleStore) StreamAck(k, grp []byte, ids []StreamID) (int64, error) {
+	var out int64
+	ms, seq := splitStreamIDs(ids)
+
+	query, args, err := sqlx.In("echo streamAck(?, ?, ?, [?], [?])", s.dbIndex, k, grp, ms, seq)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Get(&out, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+type StreamPendingSummary struct {
+	Count       int64
+	First, Last StreamID
+	Consumers   []StreamConsumer
+}
+
+type StreamPendingEntry struct {
+	StreamID
+	Consumer   []byte `db:"consumer"`
+	Idle       int64  `db:"idle"`
+	Deliveries int64  `db:"deliveries"`
+}
+
+// StreamPendingSummary counts the pending entries of group grp of k, in total
+// and by consumer.
+func (s *SingleStore) StreamPendingSummary(k, grp []byte) (StreamPendingSummary, error) {
+	var out StreamPendingSummary
+	var rows []struct {
+		Consumer []byte `db:"consumer"`
+		N        int64  `db:"n"`
+		FirstMs  uint64 `db:"first_ms"`
+		FirstSeq uint64 `db:"first_seq"`
+		LastMs   uint64 `db:"last_ms"`
+		LastSeq  uint64 `db:"last_seq"`
+	}
+	err := s.db.Select(&rows, "echo streamPendingSummary(?, ?, ?)", s.dbIndex, k, grp)
+	if err != nil || len(rows) == 0 {
+		return out, err
+	}
+
+	out.First = StreamID{rows[0].FirstMs, rows[0].FirstSeq}
+	out.Last = StreamID{rows[0].LastMs, rows[0].LastSeq}
+	for _, row := range rows {
+		out.Count += row.N
+		out.Consumers = append(out.Consumers, StreamConsumer{Name: row.Consumer, Pending: row.N})
+	}
+	return out, nil
+}
+
+// StreamPending returns up to count of the pending entries of group grp of k
+// from start to end inclusive which have been idle for at least minIdle
+// milliseconds, only those of consumer unless it is nil.
+func (s *SingleStore) StreamPending(k, grp []byte, minIdle int64, start, end StreamID, count int64, consumer []byte) ([]StreamPendingEntry, error) {
+	var out []StreamPendingEntry
+	err := s.db.Select(&out, "echo streamPending(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		s.dbIndex, k, grp, minIdle, start.Ms, start.Seq, end.Ms, end.Seq, count, consumer)
+	return out, err
+}
+
+// StreamClaim sets how XCLAIM updates the entries it claims. They are marked
+// as delivered at Time, in milliseconds since the epoch, or Idle milliseconds
+// ago, with Deliveries deliveries or else one more unless JustID is set.
+// Force claims entries which are not pending, and LastID raises the ID last
+// delivered to the group.
+type StreamClaim struct {
+	Idle       *int64
+	Time       *uint64
+	Deliveries *int64
+	Force      bool
+	JustID     bool
+	LastID     *StreamID
+}
+
+// StreamClaim claims the pending entries of group grp of k with the given IDs
+// for consumer if they have been idle for at least minIdle milliseconds,
+// returning the claimed entries.
+func (s *SingleStore) StreamClaim(k, grp, consumer []byte, minIdle int64, ids []StreamID, claim StreamClaim) ([]StreamEntry, error) {
+	ms, seq := splitStreamIDs(ids)
+	lastMs, lastSeq := streamIDArgs(claim.LastID)
+
+	query, args, err := sqlx.In("echo streamClaim(?, ?, ?, ?, ?, [?], [?], ?, ?, ?, ?, ?, ?, ?)",
+		s.dbIndex, k, grp, consumer, minIdle, ms, seq,
+		claim.Idle, claim.Time, claim.Deliveries, claim.Force, claim.JustID, lastMs, lastSeq)
+	if err != nil {
+		return nil, err
+	}
+	return s.selectStreamEntries(query, args...)
+}
+
+// StreamAutoClaim claims up to count of the pending entries of group grp of k
+// from start for consumer if they have been idle for at least minIdle
+// milliseconds. It returns the ID to continue from, which is 0-0 once every
+// entry has been seen, the claimed entries and the IDs of the pending
+// entries it dropped as they were deleted from k.
+func (s *SingleStore) StreamAutoClaim(k, grp, consumer []byte, minIdle int64, start StreamID, count int64, justID bool) (StreamID, []StreamEntry, []StreamID, error) {
+	var rows []struct {
+		Kind string `db:"kind"`
+		StreamID
+		Fields []byte `db:"fields"`
+	}
+	err := s.db.Select(&rows, "echo streamAutoClaim(?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		s.dbIndex, k, grp, consumer, minIdle, start.Ms, start.Seq, count, justID)
+	if err != nil {
+		return StreamID{}, nil, nil, err
+	}
+
+	var next StreamID
+	claimed := []StreamEntry{}
+	deleted := []StreamID{}
+	for _, row := range rows {
+		switch row.Kind {
+		case "next":
+			next = row.StreamID
+		case "deleted":
+			deleted = append(deleted, row.StreamID)
+		case "claimed":
+			fields, err := decodeStreamFields(row.Fields)
+			if err != nil {
+				return StreamID{}, nil, nil, err
+			}
+			claimed = append(claimed, StreamEntry{ID: row.StreamID, Fields: fields})
+		}
+	}
+	return next, claimed, deleted, nil
+}
+
+type StreamGroup struct {
+	Name          []byte
+	Consumers     int64
+	Pending       int64
+	LastDelivered StreamID
+}
+
+type StreamConsumer struct {
+	Name    []byte `db:"consumer"`
+	Pending int64  `db:"pending"`
+	Idle    int64  `db:"idle"`
+}
+
+// StreamGroups returns the consumer groups of k in order of name.
+func (s *SingleStore) StreamGroups(k []byte) ([]StreamGroup, error) {
+	var rows []struct {
+		Grp       []byte `db:"grp"`
+		Consumers int64  `db:"consumers"`
+		Pending   int64  `db:"pending"`
+		LastMs    uint64 `db:"last_ms"`
+		LastSeq   uint64 `db:"last_seq"`
+	}
+	err := s.db.Select(&rows, "select * from streamGroupInfo(?, ?)", s.dbIndex, k)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]StreamGroup, len(rows))
+	for i, row := range rows {
+		out[i] = StreamGroup{row.Grp, row.Consumers, row.Pending, StreamID{row.LastMs, row.LastSeq}}
+	}
+	return out, nil
+}
+
+// StreamConsumers returns the consumers of group grp of k in order of name.
+func (s *SingleStore) StreamConsumers(k, grp []byte) ([]StreamConsumer, error) {
+	var out []StreamConsumer
+	err := s.db.Select(&out, "select * from streamConsumerInfo(?, ?, ?)", s.dbIndex, k, grp)
+	return out, err
+}
+
+// selectStreamEntries runs a query selecting stream entries, whose fields
+// are nil where they are null.
+func (s *SingleStore) selectStreamEntries(query string, args ...interface{}) ([]StreamEntry, error) {
+	var rows []struct {
+		StreamID
+		Fields []byte `db:"fields"`
+	}
+	err := s.db.Select(&rows, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]StreamEntry, len(rows))
+	for i, row := range rows {
+		out[i].ID = row.StreamID
+		if out[i].Fields, err = decodeStreamFields(row.Fields); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// streamIDArgs returns the ms and seq arguments of a stream procedure, which
+// are null if id is nil.
+func streamIDArgs(id *StreamID) (*uint64, *uint64) {
+	if id == nil {
+		return nil, nil
+	}
+	return &id.Ms, &id.Seq
+}
+
+func splitStreamIDs(ids []StreamID) ([]uint64, []uint64) {
+	ms := make([]uint64, len(ids))
+	seq := make([]uint64, len(ids))
+	for i, id := range ids {
+		ms[i], seq[i] = id.Ms, id.Seq
+	}
+	return ms, seq
+}
+
+// encodeStreamFields packs the fields and values of a stream entry into a
+// single blob, each prefixed with its length as a uvarint.
+func encodeStreamFields(fields [][]byte) []byte {
+	var out []byte
+	var prefix [binary.MaxVarintLen64]byte
+	for _, f := range fields {
+		n := binary.PutUvarint(prefix[:], uint64(len(f)))
+		out = append(out, prefix[:n]...)
+		out = append(out, f...)
+	}
+	return out
+}
+
+func decodeStreamFields(raw []byte) ([][]byte, error) {
+	var out [][]byte
+	for len(raw) > 0 {
+		n, size := binary.Uvarint(raw)
+		if size <= 0 || n > uint64(len(raw)-size) {
+			return nil, errors.New("corrupt stream entry")
+		}
+		raw = raw[size:]
+		out = append(out, raw[:n:n])
+		raw = raw[n:]
+	}
+	return out, nil
+}
+
+// HLLAdd adds elements to the HyperLogLog at k, creating it if it doesn't
+// exist, and returns whether that changed its registers.
+func (s *SingleStore) HLLAdd(k []byte, elements [][]byte) (bool, error) {
+	for {
+		h, old, err := s.hllLoad(k)
+		if err != nil {
+			return false, err
+		}
+		changed := h == nil
+		if h == nil {
+			h = new(hll)
+		}
+		for _, e := range elements {
+			if h.add(e) {
+				changed = true
+			}
+		}
+		if !changed {
+			return false, nil
+		}
+
+		ok, err := s.hllStore(k, old, h)
+		if ok || err != nil {
+			return ok, err
+		}
+	}
+}
+
+// HLLCount estimates the number of distinct elements added to the
+// HyperLogLogs at keys, skipping those that don't exist.
+func (s *SingleStore) HLLCount(keys ...[]byte) (uint64, error) {
+	if len(keys) == 1 {
+		h, raw, err := s.hllLoad(keys[0])
+		if err != nil || h == nil {
+			return 0, err
+		}
+		if card, ok := hllCachedCount(raw); ok {
+			return card, nil
+		}
+		return h.count(), nil
+	}
+
+	merged, err := s.hllUnion(keys)
+	if err != nil {
+		return 0, err
+	}
+	return merged.count(), nil
+}
+
+// HLLMerge merges the HyperLogLogs at keys into the one at dest, creating it
+// if it doesn't exist.
+func (s *SingleStore) HLLMerge(dest []byte, keys ...[]byte) error {
+	merged, err := s.hllUnion(keys)
+	if err != nil {
+		return err
+	}
+
+	for {
+		h, old, err := s.hllLoad(dest)
+		if err != nil {
+			return err
+		}
+		next := *merged
+		if h != nil {
+			next.merge(h)
+		}
+
+		ok, err := s.hllStore(dest, old, &next)
+		if ok || err != nil {
+			return err
+		}
+	}
+}
+
+// hllLoad reads the HyperLogLog at k along with the value it was decoded
+// from, returning nil if k doesn't exist.
+func (s *SingleStore) hllLoad(k []byte) (*hll, []byte, error) {
+	var rows []struct {
+		T string `db:"t"`
+		V []byte `db:"v"`
+	}
+	err := s.db.Select(&rows, "select t, v from hllGet(?, ?)", s.dbIndex, k)
+	if err != nil || len(rows) == 0 {
+		return nil, nil, err
+	}
+	if t := rows[0].T; t != "hll" && t != "blob" {
+		return nil, nil, ReplyError("ERR type mismatch; got " + t + ", expected hll")
+	}
+
+	h, err := parseHLL(rows[0].V)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, rows[0].V, nil
+}
+
+// hllStore writes h to k provided that it still holds old, which is nil for a
+// key that didn't exist, and returns false if it has changed since.
+func (s *SingleStore) hllStore(k, old []byte, h *hll) (bool, error) {
+	var out bool
+	err := s.db.Get(&out, "echo hllSet(?, ?, ?, ?)", s.dbIndex, k, old, h.dense())
+	return out, err
+}
+
+func (s *SingleStore) hllUnion(keys [][]byte) (*hll, error) {
+	merged := new(hll)
+	for _, k := range keys {
+		h, _, err := s.hllLoad(k)
+		if err != nil {
+			return nil, err
+		}
+		if h != nil {
+			merged.merge(h)
+		}
+	}
+	return merged, nil
+}
+
+// GeoMember is a member of a geo key with its location and, as returned by
+// GeoSearch, its distance in meters from the center of the search.
+type GeoMember struct {
+	Member []byte `db:"member"`
+	GeoPoint
+	Dist float64 `db:"dist"`
+}
+
+// GeoSearch is the area searched by GEOSEARCH around Center, either a circle
+// of Radius meters or, if Box is set, a box of Width by Height meters. The
+// members found are sorted by distance if Order is "asc" or "desc" and at
+// most Count are returned unless it is 0.
+type GeoSearch struct {
+	Center GeoPoint
+	Radius float64
+	Box    bool
+	Width  float64
+	Height float64
+	Order  string
+	Count  int64
+}
+
+// args returns the lon, lat, radius, width, height, order and count
+// arguments of the geo search procedures.
+func (g *GeoSearch) args() []interface{} {
+	out := []interface{}{g.Center.Lon, g.Center.Lat, nil, nil, nil, nil, nil}
+	if g.Box {
+		out[3], out[4] = g.Width, g.Height
+	} else {
+		out[2] = g.Radius
+	}
+	if g.Order != "" {
+		out[5] = g.Order
+	}
+	if g.Count > 0 {
+		out[6] = g.Count
+	}
+	return out
+}
+
+// GeoAdd sets the locations of members of k following the NX, XX and CH
+// flags, returning how many were added (and changed, with CH).
+func (s *SingleStore) GeoAdd(k []byte, members []GeoMember, flags ZAddFlags) (int64, error) {
+	var out int64
+
+	names := make([][]byte, len(members))
+	lons := make([]float64, len(members))
+	lats := make([]float64, len(members))
+	for i, m := range members {
+		names[i], lons[i], lats[i] = m.Member, m.Lon, m.Lat
+	}
+
+	query, args, err := sqlx.In(
+		"echo geoAdd(?, ?, [?], [?], [?], ?, ?, ?)",
+		s.dbIndex, k, names, lons, lats, flags.NX, flags.XX, flags.CH,
+	)
+	if err != nil {
+		return out, err
+	}
+
+	err = s.db.Get(&out, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return out, nil
+}
+
+// GeoPos returns the locations of members in order, with nil for members
+// which don't exist.
+func (s *SingleStore) GeoPos(k []byte, members [][]byte) ([]*GeoPoint, error) {
+	var found []GeoMember
+
+	query, args, err := sqlx.In("echo geoPos(?, ?, [?])", s.dbIndex, k, members)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Select(&found, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	points := make(map[string]GeoPoint, len(found))
+	for _, m := range found {
+		points[string(m.Member)] = m.GeoPoint
+	}
+	out := make([]*GeoPoint, len(members))
+	for i, m := range members {
+		if p, ok := points[string(m)]; ok {
+			out[i] = &p
+		}
+	}
+	return out, nil
+}
+
+// GeoDist returns the distance in meters between members a and b of k, or
+// false if either doesn't exist.
+func (s *SingleStore) GeoDist(k, a, b []byte) (float64, bool, error) {
+	var out []float64
+	err := s.db.Select(&out, "select dist from geoDist(?, ?, ?, ?)", s.dbIndex, k, a, b)
+	if err != nil || len(out) == 0 {
+		return 0, false, err
+	}
+	return out[0], true, nil
+}
+
+// GeoSearch returns the members of k within the area of search.
+func (s *SingleStore) GeoSearch(k []byte, search *GeoSearch) ([]GeoMember, error) {
+	var out []GeoMember
+	args := append([]interface{}{s.dbIndex, k}, search.args()...)
+	err := s.db.Select(&out, "echo geoSearch(?, ?, ?, ?, ?, ?, ?, ?, ?)", args...)
+	return out, err
+}
+
+// GeoSearchStore replaces dest with the members of k within the area of
+// search, returning how many there were. If unit is not 0 dest becomes a
+// sorted set of their distances in units of that many meters instead.
+func (s *SingleStore) GeoSearchStore(dest, k []byte, search *GeoSearch, unit float64) (int64, error) {
+	var out int64
+	var unitArg interface{}
+	if unit != 0 {
+		unitArg = unit
+	}
+	args := append([]interface{}{s.dbIndex, dest, k}, search.args()...)
+	err := s.db.Get(&out, "echo geoSearchStore(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", append(args, unitArg)...)
+	return out, err
+}
+
+// JSONGet returns the values matched by each of paths in the document at k,
+// or false if k doesn't exist.
+func (s *SingleStore) JSONGet(k []byte, paths []JSONPath) ([][]json.RawMessage, bool, error) {
+	definite := make([][]interface{}, len(paths))
+	for i, p := range paths {
+		var ok bool
+		if definite[i], ok = p.definite(); !ok {
+			return s.jsonResolveAll(k, paths)
+		}
+	}
+
+	values, exists, err := s.jsonGet(k, definite)
+	if err != nil || !exists {
+		return nil, exists, err
+	}
+	out := make([][]json.RawMessage, len(paths))
+	for i, v := range values {
+		out[i] = []json.RawMessage{}
+		if v != nil {
+			out[i] = append(out[i], v)
+		}
+	}
+	return out, true, nil
+}
+
+func (s *SingleStore) jsonResolveAll(k []byte, paths []JSONPath) ([][]json.RawMessage, bool, error) {
+	doc, err := s.jsonDocument(k)
+	if err != nil || doc == nil {
+		return nil, false, err
+	}
+	out := make([][]json.RawMessage, len(paths))
+	for i, p := range paths {
+		out[i] = []json.RawMessage{}
+		for _, m := range p.resolve(doc) {
+			out[i] = append(out[i], m.value)
+		}
+	}
+	return out, true, nil
+}
+
+// JSONSet sets the values matched by path in the document at k to v, or
+// creates the member it names in an existing object, returning false if
+// nothing was set. The root path replaces the whole document.
+func (s *SingleStore) JSONSet(k []byte, path JSONPath, v json.RawMessage, nx, xx bool) (bool, error) {
+	for {
+		targets, old, exists, err := s.jsonTargets(k, path)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			return false, ErrJSONRoot
+		}
+		if len(targets) == 0 {
+			return false, nil
+		}
+		encoded, err := jsonPathArgs(targets)
+		if err != nil {
+			return false, err
+		}
+
+		query, args, err := sqlx.In("echo jsonSet(?, ?, [?], ?, ?, ?, ?)", s.dbIndex, k, encoded, old, string(v), nx, xx)
+		if err != nil {
+			return false, err
+		}
+		var out sql.NullBool
+		err = s.db.Get(&out, query, args...)
+		if err != nil || out.Valid {
+			return out.Bool, err
+		}
+	}
+}
+
+// JSONDelete removes the values matched by path from the document at k,
+// deleting k if path is the root, and returns how many were removed.
+func (s *SingleStore) JSONDelete(k []byte, path JSONPath) (int64, error) {
+	for {
+		targets, old, exists, err := s.jsonTargets(k, path)
+		if err != nil || !exists || len(targets) == 0 {
+			return 0, err
+		}
+		// remove later elements of an array first so that the indexes of the
+		// earlier ones still hold
+		for i, j := 0, len(targets)-1; i < j; i, j = i+1, j-1 {
+			targets[i], targets[j] = targets[j], targets[i]
+		}
+		encoded, err := jsonPathArgs(targets)
+		if err != nil {
+			return 0, err
+		}
+
+		query, args, err := sqlx.In("echo jsonDelete(?, ?, [?], ?)", s.dbIndex, k, encoded, old)
+		if err != nil {
+			return 0, err
+		}
+		var out sql.NullInt64
+		err = s.db.Get(&out, query, args...)
+		if err != nil || out.Valid {
+			return out.Int64, err
+		}
+	}
+}
+
+// JSONNumIncrBy adds by to the numbers matched by path in the document at k,
+// returning their new values with nil for values which aren't numbers.
+func (s *SingleStore) JSONNumIncrBy(k []byte, path JSONPath, by json.RawMessage) ([]json.RawMessage, error) {
+	raw, err := s.jsonModify(k, path, "echo jsonNumIncrBy(?, ?, [?], ?, ?)", string(by))
+	if err != nil {
+		return nil, err
+	}
+
+	var out []json.RawMessage
+	err = json.Unmarshal(raw, &out)
+	for i, v := range out {
+		if jsonType(v) == "null" {
+			out[i] = nil
+		}
+	}
+	return out, err
+}
+
+// JSONStrAppend appends str to the strings matched by path in the document at
+// k, returning their new lengths with nil for values which aren't strings.
+func (s *SingleStore) JSONStrAppend(k []byte, path JSONPath, str string) ([]*int64, error) {
+	raw, err := s.jsonModify(k, path, "echo jsonStrAppend(?, ?, [?], ?, ?)", str)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*int64
+	err = json.Unmarshal(raw, &out)
+	return out, err
+}
+
+// JSONArrAppend appends values to the arrays matched by path in the document
+// at k, returning their new lengths with nil for values which aren't arrays.
+func (s *SingleStore) JSONArrAppend(k []byte, path JSONPath, values []json.RawMessage) ([]*int64, error) {
+	encoded := make([]string, len(values))
+	for i, v := range values {
+		encoded[i] = string(v)
+	}
+	raw, err := s.jsonModify(k, path, "echo jsonArrAppend(?, ?, [?], ?, [?])", encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*int64
+	err = json.Unmarshal(raw, &out)
+	return out, err
+}
+
+// jsonModify runs query, a json procedure taking the db, k, the paths matched
+// by path and the document they were resolved against followed by args, and
+// returns the json array it returns, which has an element per path.
+func (s *SingleStore) jsonModify(k []byte, path JSONPath, query string, args ...interface{}) ([]byte, error) {
+	for {
+		targets, old, exists, err := s.jsonTargets(k, path)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, ErrJSONNoKey
+		}
+		if len(targets) == 0 {
+			return []byte("[]"), nil
+		}
+		encoded, err := jsonPathArgs(targets)
+		if err != nil {
+			return nil, err
+		}
+
+		query, inArgs, err := sqlx.In(query, append([]interface{}{s.dbIndex, k, encoded, old}, args...)...)
+		if err != nil {
+			return nil, err
+		}
+		var out sql.NullString
+		err = s.db.Get(&out, query, inArgs...)
+		if err != nil || out.Valid {
+			return []byte(out.String), err
+		}
+	}
+}
+
+// jsonTargets returns the keys and indexes leading to each value matched by
+// path in the document at k, and false if k doesn't exist. Definite paths are
+// returned as they are without reading the document, leaving the procedures
+// to check that they exist. Otherwise the document the path was resolved
+// against is returned too, for the procedures to check that it hasn't changed
+// since, which they report with a null result for the command to try again.
+func (s *SingleStore) jsonTargets(k []byte, path JSONPath) ([][]interface{}, interface{}, bool, error) {
+	if p, ok := path.definite(); ok {
+		return [][]interface{}{p}, nil, true, nil
+	}
+
+	doc, err := s.jsonDocument(k)
+	if err != nil || doc == nil {
+		return nil, nil, false, err
+	}
+	var out [][]interface{}
+	for _, m := range path.resolve(doc) {
+		out = append(out, m.path)
+	}
+	return out, string(doc), true, nil
+}
+
+// jsonDocument returns the whole document at k, or nil if k doesn't exist.
+func (s *SingleStore) jsonDocument(k []byte) (json.RawMessage, error) {
+	values, _, err := s.jsonGet(k, [][]interface{}{{}})
+	if err != nil || values == nil {
+		return nil, err
+	}
+	return values[0], nil
+}
+
+// jsonGet returns the value at each of paths in the document at k, with nil
+// for those which don't exist, or false if k doesn't exist.
+func (s *SingleStore) jsonGet(k []byte, paths [][]interface{}) ([]json.RawMessage, bool, error) {
+	encoded, err := jsonPathArgs(paths)
+	if err != nil {
+		return nil, false, err
+	}
+	query, args, err := sqlx.In("echo jsonGet(?, ?, [?])", s.dbIndex, k, encoded)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var raw sql.NullString
+	err = s.db.Get(&raw, query, args...)
+	if err != nil || !raw.Valid {
+		return nil, false, err
+	}
+
+	// each value is wrapped in an array to tell a null value from a missing
+	// one
+	var wrapped []*[]json.RawMessage
+	if err := json.Unmarshal([]byte(raw.String), &wrapped); err != nil {
+		return nil, false, err
+	}
+	out := make([]json.RawMessage, len(wrapped))
+	for i, w := range wrapped {
+		if w != nil && len(*w) == 1 {
+			out[i] = (*w)[0]
+		}
+	}
+	return out, true, nil
+}
+
+// jsonPathArgs encodes paths as the json arrays of keys and indexes taken by
+// the json procedures.
+func jsonPathArgs(paths [][]interface{}) ([]string, error) {
+	out := make([]string, len(paths))
+	for i, p := range paths {
+		raw, err := json.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = string(raw)
+	}
+	return out, nil
+}
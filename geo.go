@@ -0,0 +1,110 @@
+package s2kv
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Geo keys keep their members as SingleStore geography points, so searches
+// use the spatial index rather than the geohash ranges of redis. Geohashes are
+// only computed here for the replies of GEOHASH and WITHHASH, from the stored
+// locations.
+const (
+	// redis limits latitudes to those of the web mercator projection
+	geoLatMin = -85.05112878
+	geoLatMax = 85.05112878
+	geoLonMin = -180
+	geoLonMax = 180
+
+	// bits per coordinate of a geohash, for 52 bits in all
+	geoHashStep = 26
+
+	geoHashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+// GeoPoint is a location as a longitude and latitude in degrees.
+type GeoPoint struct {
+	Lon float64 `db:"lon"`
+	Lat float64 `db:"lat"`
+}
+
+// geoUnits are the distance units accepted by geo commands, in meters.
+var geoUnits = map[string]float64{"m": 1, "km": 1000, "ft": 0.3048, "mi": 1609.34}
+
+// parseGeoPoint parses a longitude and latitude, which must be within the
+// bounds redis accepts.
+func parseGeoPoint(lon, lat []byte) (GeoPoint, error) {
+	var p GeoPoint
+	var err error
+	if p.Lon, err = parseScore(lon); err != nil {
+		return p, err
+	}
+	if p.Lat, err = parseScore(lat); err != nil {
+		return p, err
+	}
+	if p.Lon < geoLonMin || p.Lon > geoLonMax || p.Lat < geoLatMin || p.Lat > geoLatMax {
+		return p, ReplyError(fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", p.Lon, p.Lat))
+	}
+	return p, nil
+}
+
+// parseGeoUnit returns the length of a distance unit in meters.
+func parseGeoUnit(arg []byte) (float64, error) {
+	unit, ok := geoUnits[strings.ToLower(string(arg))]
+	if !ok {
+		return 0, ReplyError("ERR unsupported unit provided. please use M, KM, FT, MI")
+	}
+	return unit, nil
+}
+
+// formatGeoDistance formats a distance in meters in the given unit with the
+// four decimals of redis.
+func formatGeoDistance(meters, unit float64) string {
+	return strconv.FormatFloat(meters/unit, 'f', 4, 64)
+}
+
+func formatGeoCoordinate(f float64) []byte {
+	return strconv.AppendFloat(nil, f, 'f', -1, 64)
+}
+
+// geoHashScore returns the 52 bit geohash redis uses as the score of p,
+// which WITHHASH replies with.
+func geoHashScore(p GeoPoint) int64 {
+	return int64(geoHashEncode(p, geoLatMin, geoLatMax))
+}
+
+// geoHashString returns the standard 11 character geohash of p, whose last
+// character is always 0 as only 52 bits are encoded.
+func geoHashString(p GeoPoint) string {
+	bits := geoHashEncode(p, -90, 90)
+	out := make([]byte, 11)
+	for i := range out {
+		idx := uint64(0)
+		if i < 10 {
+			idx = bits >> (2*geoHashStep - (i+1)*5) & 0x1f
+		}
+		out[i] = geoHashAlphabet[idx]
+	}
+	return string(out)
+}
+
+// geoHashEncode interleaves the bits of the longitude and latitude of p,
+// scaled to their ranges, starting with the most significant longitude bit.
+func geoHashEncode(p GeoPoint, latMin, latMax float64) uint64 {
+	lat := geoHashOffset(p.Lat, latMin, latMax)
+	lon := geoHashOffset(p.Lon, geoLonMin, geoLonMax)
+
+	var out uint64
+	for i := 0; i < geoHashStep; i++ {
+		out |= uint64(lat>>i&1) << (2 * i)
+		out |= uint64(lon>>i&1) << (2*i + 1)
+	}
+	return out
+}
+
+func geoHashOffset(v, min, max float64) uint32 {
+	offset := (v - min) / (max - min) * (1 << geoHashStep)
+	return uint32(math.Max(0, math.Min(offset, 1<<geoHashStep-1)))
+}
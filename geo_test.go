@@ -0,0 +1,37 @@
+package s2kv
+
+import "testing"
+
+func TestGeoHash(t *testing.T) {
+	tests := []struct {
+		p     GeoPoint
+		hash  string
+		score int64
+	}{
+		// the examples of the redis documentation
+		{GeoPoint{13.361389, 38.115556}, "sqc8b49rny0", 3479099956230698},
+		{GeoPoint{15.087269, 37.502669}, "sqdtr74hyu0", 3479447370796909},
+	}
+
+	for _, test := range tests {
+		if hash := geoHashString(test.p); hash != test.hash {
+			t.Errorf("geoHashString(%v) = %s, want %s", test.p, hash, test.hash)
+		}
+		if score := geoHashScore(test.p); score != test.score {
+			t.Errorf("geoHashScore(%v) = %d, want %d", test.p, score, test.score)
+		}
+	}
+}
+
+func TestParseGeoPoint(t *testing.T) {
+	if _, err := parseGeoPoint([]byte("13.361389"), []byte("38.115556")); err != nil {
+		t.Error(err)
+	}
+	if _, err := parseGeoPoint([]byte("foo"), []byte("0")); err != ErrNotFloat {
+		t.Errorf("invalid longitude: %v", err)
+	}
+	_, err := parseGeoPoint([]byte("1"), []byte("100"))
+	if err != ReplyError("ERR invalid longitude,latitude pair 1.000000,100.000000") {
+		t.Errorf("out of range latitude: %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package s2kv
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Glob is a redis glob pattern, as taken by KEYS and the MATCH option of the
+// SCAN commands. In the database Prefix, a LIKE pattern for the literal
+// prefix of the glob, narrows the search to a range of the primary key and
+// Regexp, an anchored POSIX extended regular expression, matches the glob
+// exactly; Match does the same outside the database.
+type Glob struct {
+	Prefix string
+	Regexp string
+
+	tokens []globToken
+}
+
+// globToken is either a * or a single byte out of set, which covers literals,
+// ? and character classes alike.
+type globToken struct {
+	star bool
+	set  [256]bool
+}
+
+// ParseGlob parses a glob pattern the same way redis does: * matches any
+// sequence, ? any byte, [...] any byte in the class (or not in it when it
+// starts with ^) and \ escapes the next character.
+func ParseGlob(pattern []byte) Glob {
+	var tokens []globToken
+	for i := 0; i < len(pattern); i++ {
+		var t globToken
+		switch c := pattern[i]; {
+		case c == '*':
+			if len(tokens) > 0 && tokens[len(tokens)-1].star {
+				continue
+			}
+			t.star = true
+		case c == '?':
+			for b := range t.set {
+				t.set[b] = true
+			}
+		case c == '[':
+			i = parseGlobClass(pattern, i+1, &t.set)
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			t.set[pattern[i]] = true
+		default:
+			t.set[c] = true
+		}
+		tokens = append(tokens, t)
+	}
+
+	var prefix strings.Builder
+	literal := 0
+	for ; literal < len(tokens); literal++ {
+		c, ok := tokens[literal].literal()
+		if !ok {
+			break
+		}
+		if c == '\\' || c == '%' || c == '_' {
+			prefix.WriteByte('\\')
+		}
+		prefix.WriteByte(c)
+	}
+	if literal < len(tokens) {
+		prefix.WriteByte('%')
+	}
+
+	var re strings.Builder
+	re.WriteByte('^')
+	for _, t := range tokens {
+		re.WriteString(t.regexp())
+	}
+	re.WriteByte('$')
+
+	return Glob{Prefix: prefix.String(), Regexp: re.String(), tokens: tokens}
+}
+
+// Match reports whether s matches the glob, byte for byte like Regexp.
+func (g Glob) Match(s []byte) bool {
+	// on a mismatch, backtrack to the last * and let it take one more byte
+	t, i := 0, 0
+	star, mark := -1, 0
+	for i < len(s) {
+		switch {
+		case t < len(g.tokens) && g.tokens[t].star:
+			star, mark = t, i
+			t++
+		case t < len(g.tokens) && g.tokens[t].set[s[i]]:
+			t++
+			i++
+		case star >= 0:
+			mark++
+			t, i = star+1, mark
+		default:
+			return false
+		}
+	}
+	for t < len(g.tokens) && g.tokens[t].star {
+		t++
+	}
+	return t == len(g.tokens)
+}
+
+// parseGlobClass parses the character class starting at pattern[i] into set,
+// returning the index of the closing bracket. Like redis, an unterminated
+// class runs to the end of the pattern and reversed ranges are swapped.
+func parseGlobClass(pattern []byte, i int, set *[256]bool) int {
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+
+	var class [256]bool
+	for ; i < len(pattern) && pattern[i] != ']'; i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			class[pattern[i]] = true
+		case i+2 < len(pattern) && pattern[i+1] == '-':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			for b := int(lo); b <= int(hi); b++ {
+				class[b] = true
+			}
+			i += 2
+		default:
+			class[pattern[i]] = true
+		}
+	}
+
+	for b := range class {
+		set[b] = class[b] != negate
+	}
+	return i
+}
+
+// literal returns the byte matched by t if it matches exactly one.
+func (t globToken) literal() (byte, bool) {
+	n, last := t.members()
+	return last, !t.star && n == 1
+}
+
+func (t globToken) members() (int, byte) {
+	n, last := 0, byte(0)
+	for b, ok := range t.set {
+		if ok {
+			n, last = n+1, byte(b)
+		}
+	}
+	return n, last
+}
+
+func (t globToken) regexp() string {
+	if t.star {
+		return ".*"
+	}
+
+	n, last := t.members()
+	switch {
+	case n == 0:
+		// an empty class, as in "[]", matches nothing
+		return "x^"
+	case n == 1:
+		return regexp.QuoteMeta(string([]byte{last}))
+	case n == len(t.set):
+		return "."
+	case n > len(t.set)/2:
+		return globBracket(&t.set, false)
+	default:
+		return globBracket(&t.set, true)
+	}
+}
+
+// globBracket writes a bracket expression for the bytes of set which are
+// member, negating it when they are not.
+func globBracket(set *[256]bool, member bool) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	if !member {
+		b.WriteByte('^')
+	}
+
+	// "]" is only literal first, "-" first or last and "^" anywhere but
+	// first; "[" is kept at the end so it can't open a class like [:alpha:]
+	special := "]-^["
+	if set[']'] == member {
+		b.WriteByte(']')
+	}
+	for c := 0; c < len(set); c++ {
+		if set[c] != member || strings.IndexByte(special, byte(c)) >= 0 {
+			continue
+		}
+		end := c
+		for end+1 < len(set) && set[end+1] == member && strings.IndexByte(special, byte(end+1)) < 0 {
+			end++
+		}
+		b.WriteByte(byte(c))
+		if end-c > 1 {
+			b.WriteByte('-')
+		}
+		if end > c {
+			b.WriteByte(byte(end))
+		}
+		c = end
+	}
+	if set['['] == member {
+		b.WriteByte('[')
+	}
+
+	hyphen, caret := set['-'] == member, set['^'] == member
+	if hyphen && caret && b.Len() == 1 {
+		b.WriteString("-^")
+	} else {
+		if caret {
+			b.WriteByte('^')
+		}
+		if hyphen {
+			b.WriteByte('-')
+		}
+	}
+
+	b.WriteByte(']')
+	return b.String()
+}
@@ -0,0 +1,77 @@
+package s2kv
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		prefix  string
+		regexp  string
+		match   []string
+		noMatch []string
+	}{
+		{"*", "%", "^.*$", []string{"", "foo"}, nil},
+		{"", "", "^$", []string{""}, []string{"foo"}},
+		{"foo", "foo", "^foo$", []string{"foo"}, []string{"fo", "fooo", "FOO"}},
+		{"user:*", "user:%", "^user:.*$", []string{"user:", "user:1"}, []string{"user", "xuser:1"}},
+		{"h?llo", "h%", "^h.llo$", []string{"hello", "hallo"}, []string{"hllo", "heello"}},
+		{"h*llo", "h%", "^h.*llo$", []string{"hllo", "heeeello"}, []string{"hell"}},
+		{"h**o", "h%", "^h.*o$", []string{"ho", "hello"}, []string{"hell"}},
+		{"h[ae]llo", "h%", "^h[ae]llo$", []string{"hello", "hallo"}, []string{"hillo"}},
+		{"h[^e]llo", "h%", "^h[^e]llo$", []string{"hallo"}, []string{"hello"}},
+		{"h[a-c]llo", "h%", "^h[a-c]llo$", []string{"hbllo"}, []string{"hdllo"}},
+		{"h[c-a]llo", "h%", "^h[a-c]llo$", []string{"hbllo"}, []string{"hdllo"}},
+		{"h[a\\]]llo", "h%", "^h[]a]llo$", []string{"h]llo"}, []string{"h\\llo"}},
+		{"[-^]", "%", "^[-^]$", []string{"-", "^"}, []string{"a"}},
+		{"[]", "%", "^x^$", nil, []string{"", "a"}},
+		{"[ab", "%", "^[ab]$", []string{"a"}, []string{"[ab"}},
+		{"a\\*b", "a*b", "^a\\*b$", []string{"a*b"}, []string{"ab", "axb"}},
+		{"%_\\\\*", "\\%\\_\\\\%", "^%_\\\\.*$", []string{"%_\\", "%_\\x"}, []string{"a_\\"}},
+		{"trailing\\", "trailing\\\\", "^trailing\\\\$", []string{"trailing\\"}, nil},
+	}
+
+	for _, test := range tests {
+		g := ParseGlob([]byte(test.pattern))
+		if g.Prefix != test.prefix {
+			t.Errorf("%q: got prefix %q, expected %q", test.pattern, g.Prefix, test.prefix)
+		}
+		if g.Regexp != test.regexp {
+			t.Errorf("%q: got regexp %q, expected %q", test.pattern, g.Regexp, test.regexp)
+		}
+
+		re := regexp.MustCompilePOSIX(g.Regexp)
+		for _, s := range test.match {
+			if !re.MatchString(s) {
+				t.Errorf("%q should match %q", g.Regexp, s)
+			}
+			if !g.Match([]byte(s)) {
+				t.Errorf("%q should match %q", test.pattern, s)
+			}
+		}
+		for _, s := range test.noMatch {
+			if re.MatchString(s) {
+				t.Errorf("%q should not match %q", g.Regexp, s)
+			}
+			if g.Match([]byte(s)) {
+				t.Errorf("%q should not match %q", test.pattern, s)
+			}
+		}
+	}
+}
+
+func TestGlobMatchBinary(t *testing.T) {
+	g := ParseGlob([]byte("\xff[\x80-\xfe]*\x00"))
+	for _, s := range []string{"\xff\x80\x00", "\xff\xfe\x00\x00"} {
+		if !g.Match([]byte(s)) {
+			t.Errorf("%q should match %q", g.Regexp, s)
+		}
+	}
+	for _, s := range []string{"\xff\x7f\x00", "\xff\xff\x00", "\xff\x80"} {
+		if g.Match([]byte(s)) {
+			t.Errorf("%q should not match %q", g.Regexp, s)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package s2kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// HyperLogLogs are stored the way redis stores them, as strings starting with
+// a 16 byte header ("HYLL", the encoding, three unused bytes and the cached
+// cardinality) followed by the registers, so that GET returns the same bytes
+// as redis and SET of those bytes restores them. The server reads either of
+// the dense and sparse encodings and always writes the dense one.
+const (
+	hllP           = 14
+	hllQ           = 64 - hllP
+	hllRegisters   = 1 << hllP
+	hllBits        = 6
+	hllRegisterMax = 1<<hllBits - 1
+	hllHeaderSize  = 16
+	hllDenseSize   = hllHeaderSize + (hllRegisters*hllBits+7)/8
+
+	hllDense  = 0
+	hllSparse = 1
+
+	// the most significant bit of the cached cardinality marks it as stale
+	hllCacheInvalid = 1 << 63
+)
+
+// hll holds the registers of a HyperLogLog one per byte, as they are easier
+// to work on than the packed six bit registers of the dense encoding.
+type hll [hllRegisters]uint8
+
+// parseHLL decodes the registers of a redis HyperLogLog in either encoding.
+func parseHLL(raw []byte) (*hll, error) {
+	if len(raw) < hllHeaderSize || !bytes.HasPrefix(raw, []byte("HYLL")) {
+		return nil, ErrNotHLL
+	}
+
+	h := new(hll)
+	switch raw[4] {
+	case hllDense:
+		if len(raw) != hllDenseSize {
+			return nil, ErrNotHLL
+		}
+		registers := raw[hllHeaderSize:]
+		for i := range h {
+			h[i] = denseRegister(registers, i)
+		}
+
+	case hllSparse:
+		// runs of registers, either zero (00xxxxxx for up to 64 or
+		// 01xxxxxx yyyyyyyy for up to 16384) or holding a value of up to
+		// 32 (1vvvvvxx for up to 4)
+		i := 0
+		for p := hllHeaderSize; p < len(raw); p++ {
+			op := raw[p]
+			var run int
+			var value uint8
+			switch {
+			case op&0xc0 == 0:
+				run = int(op&0x3f) + 1
+			case op&0xc0 == 0x40:
+				if p++; p == len(raw) {
+					return nil, ErrCorruptHLL
+				}
+				run = int(op&0x3f)<<8 | int(raw[p]) + 1
+			default:
+				run = int(op&0x03) + 1
+				value = (op>>2)&0x1f + 1
+			}
+			if i+run > hllRegisters {
+				return nil, ErrCorruptHLL
+			}
+			for end := i + run; i < end; i++ {
+				h[i] = value
+			}
+		}
+		if i != hllRegisters {
+			return nil, ErrCorruptHLL
+		}
+
+	default:
+		return nil, ErrNotHLL
+	}
+	return h, nil
+}
+
+// hllCachedCount returns the cardinality cached in the header of a valid
+// HyperLogLog, unless it has been invalidated by a change to the registers.
+func hllCachedCount(raw []byte) (uint64, bool) {
+	card := binary.LittleEndian.Uint64(raw[8:hllHeaderSize])
+	return card, card&hllCacheInvalid == 0
+}
+
+func denseRegister(registers []byte, i int) uint8 {
+	bit := i * hllBits
+	b := uint(registers[bit/8]) >> (bit % 8)
+	if bit/8+1 < len(registers) {
+		b |= uint(registers[bit/8+1]) << (8 - bit%8)
+	}
+	return uint8(b & hllRegisterMax)
+}
+
+// dense encodes the registers with a stale cached cardinality, as redis does
+// after modifying a HyperLogLog.
+func (h *hll) dense() []byte {
+	out := make([]byte, hllDenseSize)
+	copy(out, "HYLL")
+	out[4] = hllDense
+	binary.LittleEndian.PutUint64(out[8:hllHeaderSize], hllCacheInvalid)
+
+	registers := out[hllHeaderSize:]
+	for i, value := range h {
+		bit := i * hllBits
+		registers[bit/8] |= value << (bit % 8)
+		if bit/8+1 < len(registers) {
+			registers[bit/8+1] |= value >> (8 - bit%8)
+		}
+	}
+	return out
+}
+
+// add hashes element into its register, returning whether the register grew.
+func (h *hll) add(element []byte) bool {
+	hash := murmurHash64A(element, 0xadc83b19)
+	i := hash & (hllRegisters - 1)
+	// count the trailing zeroes of the remaining bits, plus one, capped so
+	// that the result fits the register
+	hash = hash>>hllP | 1<<hllQ
+	count := uint8(1)
+	for hash&1 == 0 {
+		count++
+		hash >>= 1
+	}
+	if count > h[i] {
+		h[i] = count
+		return true
+	}
+	return false
+}
+
+// merge raises each register to the value it has in other.
+func (h *hll) merge(other *hll) {
+	for i, value := range other {
+		if value > h[i] {
+			h[i] = value
+		}
+	}
+}
+
+// count estimates the cardinality with the improved estimator of Otmar
+// Ertl's "New cardinality estimation algorithms for HyperLogLog sketches",
+// which redis uses and so gives identical results.
+func (h *hll) count() uint64 {
+	// a dense register may hold values past hllQ+1, which redis counts but
+	// leaves out of the estimate
+	var histogram [hllRegisterMax + 1]int
+	for _, value := range h {
+		histogram[value]++
+	}
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(0.5 / math.Ln2 * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if z == prev {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if z == prev {
+			return z / 3
+		}
+	}
+}
+
+// murmurHash64A is Austin Appleby's 64 bit MurmurHash2, reading blocks as
+// little endian like redis does on every platform.
+func murmurHash64A(data []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := seed ^ uint64(len(data))*m
+	for ; len(data) >= 8; data = data[8:] {
+		k := binary.LittleEndian.Uint64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+	if len(data) > 0 {
+		for i := len(data) - 1; i >= 0; i-- {
+			h ^= uint64(data[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
@@ -0,0 +1,113 @@
+package s2kv
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestHLLCount(t *testing.T) {
+	h := new(hll)
+	if n := h.count(); n != 0 {
+		t.Fatalf("empty count = %d", n)
+	}
+
+	added := 0
+	for _, want := range []int{1, 3, 10, 100, 1000, 10000, 100000} {
+		for ; added < want; added++ {
+			h.add([]byte("element:" + strconv.Itoa(added)))
+		}
+		n := h.count()
+		if want <= 10 && int(n) != want {
+			t.Errorf("count of %d elements = %d", want, n)
+		}
+		if diff := math.Abs(float64(n)-float64(want)) / float64(want); diff > 0.02 {
+			t.Errorf("count of %d elements = %d, off by %.1f%%", want, n, diff*100)
+		}
+	}
+
+	if h.add([]byte("element:0")) {
+		t.Error("adding an existing element changed a register")
+	}
+}
+
+func TestHLLDense(t *testing.T) {
+	h := new(hll)
+	for i := 0; i < 5000; i++ {
+		h.add([]byte(strconv.Itoa(i)))
+	}
+	h[0] = hllRegisterMax
+	h[hllRegisters-1] = hllRegisterMax
+
+	raw := h.dense()
+	if len(raw) != hllDenseSize || string(raw[:5]) != "HYLL\x00" {
+		t.Fatalf("bad header %q", raw[:hllHeaderSize])
+	}
+	if _, ok := hllCachedCount(raw); ok {
+		t.Error("cached count of modified registers is valid")
+	}
+
+	parsed, err := parseHLL(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *parsed != *h {
+		t.Error("registers changed by encoding")
+	}
+	if n := parsed.count(); n == 0 {
+		t.Error("count with registers at their maximum = 0")
+	}
+}
+
+func TestHLLSparse(t *testing.T) {
+	header := "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"
+
+	// an empty HyperLogLog, as created by redis
+	h, err := parseHLL([]byte(header + "\x7f\xff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *h != (hll{}) {
+		t.Error("empty sparse HyperLogLog has registers set")
+	}
+
+	// register 0 holding 3, two zeroes, registers 3 and 4 holding 32 and
+	// the rest zero
+	h, err = parseHLL([]byte(header + "\x88\x01\xfd\x7f\xfa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want hll
+	want[0] = 3
+	want[3] = 32
+	want[4] = 32
+	if *h != want {
+		t.Errorf("registers = %v, want %v", h[:8], want[:8])
+	}
+
+	for _, raw := range []string{header + "\x7f", header + "\x7f\xfe", header + "\x7f\xff\x00"} {
+		if _, err := parseHLL([]byte(raw)); err != ErrCorruptHLL {
+			t.Errorf("parseHLL(%q) = %v", raw[hllHeaderSize:], err)
+		}
+	}
+}
+
+func TestHLLInvalid(t *testing.T) {
+	for _, raw := range []string{"", "foo", "HYLL\x00", "HYLL\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff"} {
+		if _, err := parseHLL([]byte(raw)); err != ErrNotHLL {
+			t.Errorf("parseHLL(%q) = %v", raw, err)
+		}
+	}
+}
+
+func TestHLLMerge(t *testing.T) {
+	a, b := new(hll), new(hll)
+	for i := 0; i < 1000; i++ {
+		a.add([]byte(strconv.Itoa(i)))
+		b.add([]byte(strconv.Itoa(i + 500)))
+	}
+	a.merge(b)
+	if n := a.count(); n < 1470 || n > 1530 {
+		t.Errorf("count of merged HyperLogLogs = %d", n)
+	}
+}
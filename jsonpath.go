@@ -0,0 +1,340 @@
+package s2kv
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// JSONPath is a path into a json document as taken by the JSON commands.
+// Paths starting with $ are JSONPath expressions which match any number of
+// values; anything else is a legacy path of RedisJSON 1, which matches at
+// most one and reports an error rather than an empty result.
+//
+// Definite paths, made of object keys and non-negative array indexes only,
+// are followed by SingleStore. Wildcards, recursive descent and negative
+// indexes are resolved against the document here instead.
+type JSONPath struct {
+	Raw    string
+	Legacy bool
+	steps  []jsonPathStep
+}
+
+type jsonPathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+	// descent matches the step against every value below the current ones,
+	// as with ..
+	descent bool
+}
+
+// jsonMatch is a value matched by a path along with the keys (strings) and
+// indexes (ints) leading to it, which is the form SingleStore follows.
+type jsonMatch struct {
+	path  []interface{}
+	value json.RawMessage
+}
+
+// parseJSONPath parses a JSONPath or legacy path. Only filters and slices
+// are unsupported.
+func parseJSONPath(raw string) (JSONPath, error) {
+	p := JSONPath{Raw: raw}
+	rest := raw
+	if strings.HasPrefix(rest, "$") {
+		rest = rest[1:]
+	} else {
+		p.Legacy = true
+		switch {
+		case rest == ".":
+			rest = ""
+		case !strings.HasPrefix(rest, ".") && !strings.HasPrefix(rest, "["):
+			rest = "." + rest
+		}
+	}
+
+	for rest != "" {
+		var step jsonPathStep
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			step.descent = true
+			rest = rest[2:]
+		case rest[0] == '.':
+			rest = rest[1:]
+		case rest[0] != '[':
+			return p, jsonPathError(raw)
+		}
+
+		if strings.HasPrefix(rest, "[") {
+			end, err := parseJSONPathBracket(rest, &step)
+			if err != nil {
+				return p, jsonPathError(raw)
+			}
+			rest = rest[end:]
+		} else {
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			switch name {
+			case "":
+				return p, jsonPathError(raw)
+			case "*":
+				step.wildcard = true
+			default:
+				step.key = name
+			}
+			rest = rest[end:]
+		}
+		p.steps = append(p.steps, step)
+	}
+	return p, nil
+}
+
+// parseJSONPathBracket parses a bracketed step at the start of s, being a
+// quoted key, an index or *, and returns its length.
+func parseJSONPathBracket(s string, step *jsonPathStep) (int, error) {
+	if len(s) > 1 && (s[1] == '\'' || s[1] == '"') {
+		quote := s[1]
+		var key strings.Builder
+		for i := 2; i < len(s); i++ {
+			switch c := s[i]; {
+			case c == '\\' && i+1 < len(s):
+				i++
+				key.WriteByte(s[i])
+			case c == quote:
+				if i+1 >= len(s) || s[i+1] != ']' {
+					return 0, ErrSyntax
+				}
+				step.key = key.String()
+				return i + 2, nil
+			default:
+				key.WriteByte(c)
+			}
+		}
+		return 0, ErrSyntax
+	}
+
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return 0, ErrSyntax
+	}
+	inner := strings.TrimSpace(s[1:end])
+	if inner == "*" {
+		step.wildcard = true
+		return end + 1, nil
+	}
+	index, err := strconv.Atoi(inner)
+	if err != nil {
+		return 0, ErrSyntax
+	}
+	step.index, step.isIndex = index, true
+	return end + 1, nil
+}
+
+func jsonPathError(raw string) error {
+	return ReplyError("ERR JSON Path error: unsupported or invalid path '" + raw + "'")
+}
+
+// definite returns the keys and indexes of p if SingleStore can follow it as
+// is, or false if it has to be resolved against the document.
+func (p JSONPath) definite() ([]interface{}, bool) {
+	out := make([]interface{}, len(p.steps))
+	for i, step := range p.steps {
+		switch {
+		case step.wildcard || step.descent || step.index < 0:
+			return nil, false
+		case step.isIndex:
+			out[i] = step.index
+		default:
+			out[i] = step.key
+		}
+	}
+	return out, true
+}
+
+// resolve returns the values of doc matched by p. Values come after those
+// matched above them and elements of an array in order, so that removing
+// them in reverse never shifts the index of one still to be removed.
+func (p JSONPath) resolve(doc json.RawMessage) []jsonMatch {
+	matches := []jsonMatch{{path: []interface{}{}, value: doc}}
+	for _, step := range p.steps {
+		var next []jsonMatch
+		for _, m := range matches {
+			if step.descent {
+				for _, d := range jsonDescendants(m) {
+					next = append(next, step.match(d)...)
+				}
+			} else {
+				next = append(next, step.match(m)...)
+			}
+		}
+		matches = next
+	}
+	if p.Legacy && len(matches) > 1 {
+		matches = matches[:1]
+	}
+	return matches
+}
+
+// match returns the children of m matched by the step.
+func (step jsonPathStep) match(m jsonMatch) []jsonMatch {
+	var out []jsonMatch
+	child := func(key interface{}, value json.RawMessage) {
+		path := append(append(make([]interface{}, 0, len(m.path)+1), m.path...), key)
+		out = append(out, jsonMatch{path: path, value: value})
+	}
+
+	if keys, values, ok := jsonMembers(m.value); ok {
+		for i, key := range keys {
+			if step.wildcard || (!step.isIndex && key == step.key) {
+				child(key, values[i])
+			}
+		}
+	} else if items, ok := jsonElements(m.value); ok {
+		for i, item := range items {
+			if step.wildcard || (step.isIndex && (i == step.index || i == len(items)+step.index)) {
+				child(i, item)
+			}
+		}
+	}
+	return out
+}
+
+// jsonDescendants returns m followed by every value below it.
+func jsonDescendants(m jsonMatch) []jsonMatch {
+	out := []jsonMatch{m}
+	for _, child := range (jsonPathStep{wildcard: true}).match(m) {
+		out = append(out, jsonDescendants(child)...)
+	}
+	return out
+}
+
+// jsonMembers returns the keys and values of a json object in order, or
+// false if raw isn't an object.
+func jsonMembers(raw json.RawMessage) ([]string, []json.RawMessage, bool) {
+	if jsonType(raw) != "object" {
+		return nil, nil, false
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, false
+	}
+	keys := []string{}
+	var values []json.RawMessage
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, nil, false
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, nil, false
+		}
+		keys = append(keys, key.(string))
+		values = append(values, value)
+	}
+	return keys, values, true
+}
+
+// jsonElements returns the elements of a json array, or false if raw isn't
+// an array.
+func jsonElements(raw json.RawMessage) ([]json.RawMessage, bool) {
+	if jsonType(raw) != "array" {
+		return nil, false
+	}
+	var out []json.RawMessage
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+// jsonType returns the RedisJSON name of the type of a json value.
+func jsonType(raw json.RawMessage) string {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return ""
+	}
+	switch raw[0] {
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case '"':
+		return "string"
+	case 't', 'f':
+		return "boolean"
+	case 'n':
+		return "null"
+	}
+	if bytes.ContainsAny(raw, ".eE") {
+		return "number"
+	}
+	return "integer"
+}
+
+// formatJSON formats a json value the way JSON.GET does with its INDENT,
+// NEWLINE and SPACE options, which are all empty by default.
+func formatJSON(raw json.RawMessage, indent, newline, space string) []byte {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return raw
+	}
+	src := compact.Bytes()
+	if indent == "" && newline == "" && space == "" {
+		return src
+	}
+
+	var out bytes.Buffer
+	depth := 0
+	inString := false
+	lineBreak := func() {
+		out.WriteString(newline)
+		out.WriteString(strings.Repeat(indent, depth))
+	}
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		if inString {
+			out.WriteByte(c)
+			if c == '\\' && i+1 < len(src) {
+				i++
+				out.WriteByte(src[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+			out.WriteByte(c)
+		case '{', '[':
+			out.WriteByte(c)
+			if i+1 < len(src) && (src[i+1] == '}' || src[i+1] == ']') {
+				i++
+				out.WriteByte(src[i])
+				continue
+			}
+			depth++
+			lineBreak()
+		case '}', ']':
+			depth--
+			lineBreak()
+			out.WriteByte(c)
+		case ',':
+			out.WriteByte(c)
+			lineBreak()
+		case ':':
+			out.WriteByte(c)
+			out.WriteString(space)
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,84 @@
+package s2kv
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestJSONPathResolve(t *testing.T) {
+	doc := json.RawMessage(`{"a":[1,{"b":2}],"b":{"b":3},"c d":"x"}`)
+	tests := []struct {
+		path   string
+		values []string
+		paths  []string
+	}{
+		{"$", []string{string(doc)}, []string{`[]`}},
+		{".", []string{string(doc)}, []string{`[]`}},
+		{"$.a[0]", []string{`1`}, []string{`["a",0]`}},
+		{"a[-1].b", []string{`2`}, []string{`["a",1,"b"]`}},
+		{"$['c d']", []string{`"x"`}, []string{`["c d"]`}},
+		{"$.*", []string{`[1,{"b":2}]`, `{"b":3}`, `"x"`}, []string{`["a"]`, `["b"]`, `["c d"]`}},
+		{"$..b", []string{`{"b":3}`, `2`, `3`}, []string{`["b"]`, `["a",1,"b"]`, `["b","b"]`}},
+		{".b..b", []string{`3`}, []string{`["b","b"]`}},
+		{"$.a[5]", nil, nil},
+		{"$.c d.e", nil, nil},
+	}
+
+	for _, test := range tests {
+		p, err := parseJSONPath(test.path)
+		if err != nil {
+			t.Errorf("parseJSONPath(%q): %v", test.path, err)
+			continue
+		}
+		var values, paths []string
+		for _, m := range p.resolve(doc) {
+			raw, _ := json.Marshal(m.path)
+			values = append(values, string(m.value))
+			paths = append(paths, string(raw))
+		}
+		if !reflect.DeepEqual(values, test.values) || !reflect.DeepEqual(paths, test.paths) {
+			t.Errorf("%s matched %v at %v, want %v at %v", test.path, values, paths, test.values, test.paths)
+		}
+	}
+}
+
+func TestJSONPathDefinite(t *testing.T) {
+	tests := map[string]bool{
+		"$":        true,
+		"$.a[0].b": true,
+		`$["a"]`:   true,
+		"$.a[-1]":  false,
+		"$.*":      false,
+		"$..a":     false,
+		"$.a[*]":   false,
+	}
+	for path, want := range tests {
+		p, err := parseJSONPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := p.definite(); ok != want {
+			t.Errorf("%s definite = %v", path, ok)
+		}
+	}
+}
+
+func TestJSONPathInvalid(t *testing.T) {
+	for _, path := range []string{"", "$a", "$.", "$[", "$[x]", "$['a'", "$.a..", "$[?(@.a)]"} {
+		if _, err := parseJSONPath(path); err == nil {
+			t.Errorf("parseJSONPath(%q) succeeded", path)
+		}
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+	raw := json.RawMessage(`{"a": [1, "x,y"], "b": {}}`)
+	if out := string(formatJSON(raw, "", "", "")); out != `{"a":[1,"x,y"],"b":{}}` {
+		t.Errorf("compact = %s", out)
+	}
+	want := "{\n\t\"a\": [\n\t\t1,\n\t\t\"x,y\"\n\t],\n\t\"b\": {}\n}"
+	if out := string(formatJSON(raw, "\t", "\n", " ")); out != want {
+		t.Errorf("formatted = %q, want %q", out, want)
+	}
+}
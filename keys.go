@@ -0,0 +1,196 @@
+package s2kv
+
+import (
+	"strconv"
+	"strings"
+)
+
+// keySpec gives the positions of the keys among the arguments of a command,
+// much like the key specs of redis: first to last, counting back from the
+// end when last is negative, then numkeys keys following the argument at
+// position numkeys if it is set, then the first half of the arguments after
+// STREAMS if streams is set.
+type keySpec struct {
+	first, last int
+	numkeys     int
+	streams     bool
+}
+
+var (
+	firstKey    = keySpec{first: 1, last: 1}
+	allKeys     = keySpec{first: 1, last: -1}
+	twoKeys     = keySpec{first: 1, last: 2}
+	keysTimeout = keySpec{first: 1, last: -2}
+)
+
+// commandKeySpecs holds the key positions of each command taking keys.
+var commandKeySpecs = map[string]keySpec{
+	"SET":              firstKey,
+	"INCR":             firstKey,
+	"INCRBY":           firstKey,
+	"DECR":             firstKey,
+	"DECRBY":           firstKey,
+	"INCRBYFLOAT":      firstKey,
+	"SETBIT":           firstKey,
+	"GETBIT":           firstKey,
+	"BITCOUNT":         firstKey,
+	"BITPOS":           firstKey,
+	"BITOP":            {first: 2, last: -1},
+	"BITFIELD":         firstKey,
+	"GET":              firstKey,
+	"DEL":              allKeys,
+	"EXISTS":           allKeys,
+	"TOUCH":            allKeys,
+	"UNLINK":           allKeys,
+	"TYPE":             firstKey,
+	"RENAME":           twoKeys,
+	"RENAMENX":         twoKeys,
+	"COPY":             twoKeys,
+	"MOVE":             firstKey,
+	"RPUSH":            firstKey,
+	"LREM":             firstKey,
+	"LRANGE":           firstKey,
+	"LPUSH":            firstKey,
+	"LPUSHX":           firstKey,
+	"RPUSHX":           firstKey,
+	"LPOP":             firstKey,
+	"RPOP":             firstKey,
+	"LLEN":             firstKey,
+	"LINDEX":           firstKey,
+	"LSET":             firstKey,
+	"LINSERT":          firstKey,
+	"LPOS":             firstKey,
+	"LTRIM":            firstKey,
+	"LMOVE":            twoKeys,
+	"RPOPLPUSH":        twoKeys,
+	"LMPOP":            {numkeys: 1},
+	"BLPOP":            keysTimeout,
+	"BRPOP":            keysTimeout,
+	"BLMOVE":           twoKeys,
+	"BRPOPLPUSH":       twoKeys,
+	"BLMPOP":           {numkeys: 2},
+	"SADD":             firstKey,
+	"SREM":             firstKey,
+	"SMEMBERS":         firstKey,
+	"SUNION":           allKeys,
+	"SINTER":           allKeys,
+	"SINTERCARD":       {numkeys: 1},
+	"SDIFF":            allKeys,
+	"SUNIONSTORE":      allKeys,
+	"SINTERSTORE":      allKeys,
+	"SDIFFSTORE":       allKeys,
+	"SSCAN":            firstKey,
+	"SISMEMBER":        firstKey,
+	"SMISMEMBER":       firstKey,
+	"SPOP":             firstKey,
+	"SRANDMEMBER":      firstKey,
+	"SMOVE":            twoKeys,
+	"SCARD":            firstKey,
+	"HSET":             firstKey,
+	"HSETNX":           firstKey,
+	"HGET":             firstKey,
+	"HMGET":            firstKey,
+	"HGETALL":          firstKey,
+	"HKEYS":            firstKey,
+	"HVALS":            firstKey,
+	"HDEL":             firstKey,
+	"HEXISTS":          firstKey,
+	"HLEN":             firstKey,
+	"HSTRLEN":          firstKey,
+	"HINCRBY":          firstKey,
+	"HINCRBYFLOAT":     firstKey,
+	"HSCAN":            firstKey,
+	"HRANDFIELD":       firstKey,
+	"ZADD":             firstKey,
+	"ZINCRBY":          firstKey,
+	"ZREM":             firstKey,
+	"ZSCORE":           firstKey,
+	"ZCARD":            firstKey,
+	"ZCOUNT":           firstKey,
+	"ZRANK":            firstKey,
+	"ZREVRANK":         firstKey,
+	"ZRANGE":           firstKey,
+	"ZRANGEBYSCORE":    firstKey,
+	"ZPOPMIN":          firstKey,
+	"ZPOPMAX":          firstKey,
+	"ZSCAN":            firstKey,
+	"ZREMRANGEBYRANK":  firstKey,
+	"ZREMRANGEBYSCORE": firstKey,
+	"ZREMRANGEBYLEX":   firstKey,
+	"ZUNION":           {numkeys: 1},
+	"ZINTER":           {numkeys: 1},
+	"ZDIFF":            {numkeys: 1},
+	"ZUNIONSTORE":      {first: 1, last: 1, numkeys: 2},
+	"ZINTERSTORE":      {first: 1, last: 1, numkeys: 2},
+	"ZDIFFSTORE":       {first: 1, last: 1, numkeys: 2},
+	"XADD":             firstKey,
+	"XRANGE":           firstKey,
+	"XREVRANGE":        firstKey,
+	"XLEN":             firstKey,
+	"XDEL":             firstKey,
+	"XTRIM":            firstKey,
+	"XREAD":            {streams: true},
+	"XGROUP":           {first: 2, last: 2},
+	"XREADGROUP":       {streams: true},
+	"XACK":             firstKey,
+	"XPENDING":         firstKey,
+	"XCLAIM":           firstKey,
+	"XAUTOCLAIM":       firstKey,
+	"XINFO":            {first: 2, last: 2},
+	"PFADD":            firstKey,
+	"PFCOUNT":          allKeys,
+	"PFMERGE":          allKeys,
+	"GEOADD":           firstKey,
+	"GEOPOS":           firstKey,
+	"GEODIST":          firstKey,
+	"GEOHASH":          firstKey,
+	"GEOSEARCH":        firstKey,
+	"GEOSEARCHSTORE":   twoKeys,
+	"JSON.SET":         firstKey,
+	"JSON.GET":         firstKey,
+	"JSON.MGET":        keysTimeout,
+	"JSON.DEL":         firstKey,
+	"JSON.TYPE":        firstKey,
+	"JSON.NUMINCRBY":   firstKey,
+	"JSON.STRAPPEND":   firstKey,
+	"JSON.ARRAPPEND":   firstKey,
+	"JSON.ARRLEN":      firstKey,
+	"JSON.OBJKEYS":     firstKey,
+}
+
+// commandKeys returns the positions of the keys among the arguments of c.
+// Arguments which are out of place are ignored, leaving the command itself to
+// report them.
+func commandKeys(c Command) []int {
+	spec := commandKeySpecs[strings.ToUpper(string(c.Get(0)))]
+	n := c.ArgCount()
+
+	var out []int
+	add := func(first, last int) {
+		if last < 0 {
+			last += n
+		}
+		for i := first; i <= last && i < n; i++ {
+			out = append(out, i)
+		}
+	}
+
+	if spec.first > 0 {
+		add(spec.first, spec.last)
+	}
+	if spec.numkeys > 0 && spec.numkeys < n {
+		count, err := strconv.Atoi(string(c.Get(spec.numkeys)))
+		if err == nil && count > 0 {
+			add(spec.numkeys+1, spec.numkeys+count)
+		}
+	}
+	if spec.streams {
+		for i := 1; i < n; i++ {
+			if strings.EqualFold(string(c.Get(i)), "STREAMS") {
+				add(i+1, i+(n-i-1)/2)
+				break
+			}
+		}
+	}
+	return out
+}
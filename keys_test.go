@@ -0,0 +1,50 @@
+package s2kv
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testCommand []string
+
+func (c testCommand) Get(i int) []byte { return []byte(c[i]) }
+func (c testCommand) ArgCount() int    { return len(c) }
+
+func TestCommandKeys(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		keys []int
+	}{
+		{"PING", nil},
+		{"SCAN 0 MATCH k*", nil},
+		{"GET k", []int{1}},
+		{"HSET k f v", []int{1}},
+		{"DEL a b c", []int{1, 2, 3}},
+		{"rename a b", []int{1, 2}},
+		{"BITOP AND dest a b", []int{2, 3, 4}},
+		{"BLPOP a b 0", []int{1, 2}},
+		{"SINTERCARD 2 a b LIMIT 1", []int{2, 3}},
+		{"BLMPOP 0 2 a b LEFT", []int{3, 4}},
+		{"ZUNIONSTORE dest 2 a b WEIGHTS 1 2", []int{1, 3, 4}},
+		{"ZUNION x a b", nil},
+		{"ZDIFF 5 a", []int{2}},
+		{"XREAD COUNT 1 STREAMS a b 0 0", []int{4, 5}},
+		{"XREADGROUP GROUP g c STREAMS a >", []int{5}},
+		{"XGROUP CREATE k g $", []int{2}},
+		{"JSON.MGET a b $", []int{1, 2}},
+	}
+	for _, test := range tests {
+		if keys := commandKeys(testCommand(strings.Fields(test.cmd))); !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("%s: got keys %v, expected %v", test.cmd, keys, test.keys)
+		}
+	}
+}
+
+func TestCommandKeySpecs(t *testing.T) {
+	for name := range commandKeySpecs {
+		if _, ok := CommandHandlers[name]; !ok {
+			t.Errorf("%s has key positions but no handler", name)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+-- migrates a database created by an earlier schema.sql to support hashes;
+-- load procedures.sql again afterwards
+use kv;
+
+alter table keyspace modify t enum("blob", "set", "list", "hash");
+
+create table hashvalues (
+  k text not null,
+  f blob not null,
+  v blob not null,
+
+  shard (k),
+  sort key (k, f),
+  unique key (k, f) using hash,
+  key (f) using hash
+);
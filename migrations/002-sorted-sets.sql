@@ -0,0 +1,16 @@
+-- migrates a database created by an earlier schema.sql to support sorted
+-- sets; load procedures.sql again afterwards
+use kv;
+
+alter table keyspace modify t enum("blob", "set", "list", "hash", "zset");
+
+create table zsetvalues (
+  k text not null,
+  member blob not null,
+  score double not null,
+
+  shard (k),
+  sort key (k, score, member),
+  unique key (k, member) using hash,
+  key (member) using hash
+);
@@ -0,0 +1,26 @@
+-- migrates a database created by an earlier schema.sql, whose lists were
+-- ordered by insertion time, to the positions of the current schema; load
+-- procedures.sql again afterwards
+-- elements are numbered from 1 in the order they were pushed, which is where
+-- RPUSH starts an empty list, and the table is copied into a new one and
+-- swapped in since sort keys can't be altered in place
+use kv;
+
+create table listvalues_new (
+  k text not null,
+  v blob not null,
+
+  -- position within the list; pushing to the head uses positions below the
+  -- current minimum so elements never need to be renumbered
+  pos bigint not null,
+
+  shard (k),
+  sort key (k, pos),
+  unique key (k, pos) using hash,
+  key (v) using hash
+);
+insert into listvalues_new (k, v, pos)
+  select k, v, row_number() over (partition by k order by ts, seq)
+  from listvalues;
+drop table listvalues;
+alter table listvalues_new rename to listvalues;
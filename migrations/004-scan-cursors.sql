@@ -0,0 +1,10 @@
+-- migrates a database created by an earlier schema.sql to SCAN cursors,
+-- which refer to the position saved in scancursors; load procedures.sql
+-- again afterwards
+use kv;
+
+create rowstore table if not exists scancursors (
+  id bigint not null,
+  pos longblob not null,
+  primary key (id)
+);
@@ -0,0 +1,9 @@
+-- migrates a database created by an earlier schema.sql to support UNLINK;
+-- load procedures.sql again afterwards
+use kv;
+
+create rowstore table unlinkedkeys (
+  k text,
+  t enum("blob", "set", "list", "hash", "zset"),
+  primary key (k)
+);
@@ -0,0 +1,100 @@
+-- migrates a database created by an earlier schema.sql to support logical
+-- databases, putting every existing key in database 0; load procedures.sql
+-- again afterwards
+-- each table is copied into a new one and swapped in, since its primary,
+-- sort and unique keys all change to include the database
+use kv;
+
+create rowstore table keyspace_new (
+  db int not null,
+  k text,
+  t enum("blob", "set", "list", "hash", "zset"),
+  primary key (db, k),
+  shard key (k)
+);
+insert into keyspace_new (db, k, t) select 0, k, t from keyspace;
+drop table keyspace;
+alter table keyspace_new rename to keyspace;
+
+create rowstore table unlinkedkeys_new (
+  db int not null,
+  k text,
+  t enum("blob", "set", "list", "hash", "zset"),
+  primary key (db, k),
+  shard key (k)
+);
+insert into unlinkedkeys_new (db, k, t) select 0, k, t from unlinkedkeys;
+drop table unlinkedkeys;
+alter table unlinkedkeys_new rename to unlinkedkeys;
+
+create table blobvalues_new (
+  db int not null,
+  k text,
+  v blob,
+
+  shard (k),
+  sort key (),
+  unique key (db, k) using hash,
+  key (v) using hash
+);
+insert into blobvalues_new (db, k, v) select 0, k, v from blobvalues;
+drop table blobvalues;
+alter table blobvalues_new rename to blobvalues;
+
+create table setvalues_new (
+  db int not null,
+  k text,
+  v blob,
+
+  shard (k),
+  sort key (v),
+  unique key (db, k, v) using hash,
+  key (v) using hash
+);
+insert into setvalues_new (db, k, v) select 0, k, v from setvalues;
+drop table setvalues;
+alter table setvalues_new rename to setvalues;
+
+create table listvalues_new (
+  db int not null,
+  k text not null,
+  v blob not null,
+  pos bigint not null,
+
+  shard (k),
+  sort key (db, k, pos),
+  key (v) using hash
+);
+insert into listvalues_new (db, k, v, pos) select 0, k, v, pos from listvalues;
+drop table listvalues;
+alter table listvalues_new rename to listvalues;
+
+create table hashvalues_new (
+  db int not null,
+  k text not null,
+  f blob not null,
+  v blob not null,
+
+  shard (k),
+  sort key (db, k, f),
+  unique key (db, k, f) using hash,
+  key (f) using hash
+);
+insert into hashvalues_new (db, k, f, v) select 0, k, f, v from hashvalues;
+drop table hashvalues;
+alter table hashvalues_new rename to hashvalues;
+
+create table zsetvalues_new (
+  db int not null,
+  k text not null,
+  member blob not null,
+  score double not null,
+
+  shard (k),
+  sort key (db, k, score, member),
+  unique key (db, k, member) using hash,
+  key (member) using hash
+);
+insert into zsetvalues_new (db, k, member, score) select 0, k, member, score from zsetvalues;
+drop table zsetvalues;
+alter table zsetvalues_new rename to zsetvalues;
@@ -0,0 +1,108 @@
+-- migrates a database created by an earlier schema.sql, whose keys were
+-- case insensitive text, to the binary safe, case sensitive keys of the
+-- current schema
+-- each table is copied into a new one and swapped in, since the type of a
+-- shard or sort key column can't be altered in place; load procedures.sql
+-- again afterwards, as the procedures now take keys as blobs
+--
+-- keys which only differed in case were already a single key, so the copy
+-- can't run into duplicates
+use kv;
+
+create rowstore table keyspace_new (
+  db int not null,
+  k blob,
+  t enum("blob", "set", "list", "hash", "zset"),
+  primary key (db, k),
+  shard key (k)
+);
+insert into keyspace_new (db, k, t) select db, k, t from keyspace;
+drop table keyspace;
+alter table keyspace_new rename to keyspace;
+
+create rowstore table unlinkedkeys_new (
+  db int not null,
+  k blob,
+  t enum("blob", "set", "list", "hash", "zset"),
+  primary key (db, k),
+  shard key (k)
+);
+insert into unlinkedkeys_new (db, k, t) select db, k, t from unlinkedkeys;
+drop table unlinkedkeys;
+alter table unlinkedkeys_new rename to unlinkedkeys;
+
+create table blobvalues_new (
+  db int not null,
+  k blob,
+  v blob,
+
+  shard (k),
+  sort key (),
+  unique key (db, k) using hash,
+  key (v) using hash
+);
+insert into blobvalues_new (db, k, v) select db, k, v from blobvalues;
+drop table blobvalues;
+alter table blobvalues_new rename to blobvalues;
+
+create table setvalues_new (
+  db int not null,
+  k blob,
+  v blob,
+
+  shard (k),
+  sort key (v),
+  unique key (db, k, v) using hash,
+  key (v) using hash
+);
+insert into setvalues_new (db, k, v) select db, k, v from setvalues;
+drop table setvalues;
+alter table setvalues_new rename to setvalues;
+
+create table listvalues_new (
+  db int not null,
+  k blob not null,
+  v blob not null,
+
+  -- position within the list; pushing to the head uses positions below the
+  -- current minimum so elements never need to be renumbered
+  -- positions are not declared unique since LINSERT shifts them in place
+  pos bigint not null,
+
+  shard (k),
+  sort key (db, k, pos),
+  key (v) using hash
+);
+insert into listvalues_new (db, k, v, pos) select db, k, v, pos from listvalues;
+drop table listvalues;
+alter table listvalues_new rename to listvalues;
+
+create table hashvalues_new (
+  db int not null,
+  k blob not null,
+  f blob not null,
+  v blob not null,
+
+  shard (k),
+  sort key (db, k, f),
+  unique key (db, k, f) using hash,
+  key (f) using hash
+);
+insert into hashvalues_new (db, k, f, v) select db, k, f, v from hashvalues;
+drop table hashvalues;
+alter table hashvalues_new rename to hashvalues;
+
+create table zsetvalues_new (
+  db int not null,
+  k blob not null,
+  member blob not null,
+  score double not null,
+
+  shard (k),
+  sort key (db, k, score, member),
+  unique key (db, k, member) using hash,
+  key (member) using hash
+);
+insert into zsetvalues_new (db, k, member, score) select db, k, member, score from zsetvalues;
+drop table zsetvalues;
+alter table zsetvalues_new rename to zsetvalues;
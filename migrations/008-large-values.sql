@@ -0,0 +1,14 @@
+-- migrates a database created by an earlier schema.sql, whose values were
+-- limited to 64KB blobs, to the longblob values of the current schema; load
+-- procedures.sql again afterwards, as the procedures now take values as
+-- longblobs
+-- keys stay blobs, since the type of a shard key column can't be altered,
+-- so they remain limited to 64KB in a migrated database
+use kv;
+
+alter table blobvalues modify v longblob;
+alter table setvalues modify v longblob;
+alter table listvalues modify v longblob not null;
+alter table hashvalues modify f longblob not null;
+alter table hashvalues modify v longblob not null;
+alter table zsetvalues modify member longblob not null;
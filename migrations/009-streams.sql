@@ -0,0 +1,30 @@
+-- migrates a database created by an earlier schema.sql to support streams;
+-- load procedures.sql again afterwards
+use kv;
+
+alter table keyspace modify t enum("blob", "set", "list", "hash", "zset", "stream");
+alter table unlinkedkeys modify t enum("blob", "set", "list", "hash", "zset", "stream");
+
+-- the last ID generated for each stream, which new entries must exceed even
+-- once the entries up to it have been deleted
+create rowstore table streams (
+  db int not null,
+  k longblob,
+  last_ms bigint unsigned not null,
+  last_seq bigint unsigned not null,
+  primary key (db, k),
+  shard key (k)
+);
+
+create table streamentries (
+  db int not null,
+  k longblob not null,
+  id_ms bigint unsigned not null,
+  id_seq bigint unsigned not null,
+  -- the field value pairs of the entry, encoded by the server
+  fields longblob not null,
+
+  shard (k),
+  sort key (db, k, id_ms, id_seq),
+  unique key (db, k, id_ms, id_seq) using hash
+);
@@ -0,0 +1,41 @@
+-- migrates a database created by an earlier schema.sql to support stream
+-- consumer groups; load procedures.sql again afterwards
+use kv;
+
+-- the consumer groups of each stream, with the last ID delivered to the group
+create rowstore table streamgroups (
+  db int not null,
+  k longblob,
+  grp longblob,
+  last_ms bigint unsigned not null,
+  last_seq bigint unsigned not null,
+  primary key (db, k, grp),
+  shard key (k)
+);
+
+-- the consumers of each group, with when they last read or claimed entries
+-- in milliseconds since the epoch
+create rowstore table streamconsumers (
+  db int not null,
+  k longblob,
+  grp longblob,
+  consumer longblob,
+  seen_ms bigint unsigned not null,
+  primary key (db, k, grp, consumer),
+  shard key (k)
+);
+
+-- the pending entries of each group: those delivered to a consumer but not
+-- yet acknowledged, with when they were last delivered and how often
+create rowstore table streampending (
+  db int not null,
+  k longblob,
+  grp longblob,
+  id_ms bigint unsigned not null,
+  id_seq bigint unsigned not null,
+  consumer longblob not null,
+  delivered_ms bigint unsigned not null,
+  deliveries bigint not null,
+  primary key (db, k, grp, id_ms, id_seq),
+  shard key (k)
+);
@@ -0,0 +1,6 @@
+-- migrates a database created by an earlier schema.sql to support
+-- HyperLogLogs; load procedures.sql again afterwards
+use kv;
+
+alter table keyspace modify t enum("blob", "set", "list", "hash", "zset", "stream", "hll");
+alter table unlinkedkeys modify t enum("blob", "set", "list", "hash", "zset", "stream", "hll");
@@ -0,0 +1,18 @@
+-- migrates a database created by an earlier schema.sql to support geo keys;
+-- load procedures.sql again afterwards
+use kv;
+
+alter table keyspace modify t enum("blob", "set", "list", "hash", "zset", "stream", "hll", "geo");
+alter table unlinkedkeys modify t enum("blob", "set", "list", "hash", "zset", "stream", "hll", "geo");
+
+-- members of geo keys with their locations, kept in a rowstore table for the
+-- spatial index that GEOSEARCH filters with
+create rowstore table geovalues (
+  db int not null,
+  k longblob,
+  member longblob,
+  location geographypoint not null,
+  primary key (db, k, member),
+  shard key (k),
+  index (location)
+);
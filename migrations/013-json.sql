@@ -0,0 +1,16 @@
+-- migrates a database created by an earlier schema.sql to support json
+-- documents; load procedures.sql again afterwards
+use kv;
+
+alter table keyspace modify t enum("blob", "set", "list", "hash", "zset", "stream", "hll", "geo", "json");
+alter table unlinkedkeys modify t enum("blob", "set", "list", "hash", "zset", "stream", "hll", "geo", "json");
+
+create table jsonvalues (
+  db int not null,
+  k longblob,
+  v json not null,
+
+  shard (k),
+  sort key (),
+  unique key (db, k) using hash
+);
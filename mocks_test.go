@@ -187,6 +187,24 @@ func (mr *MockWriterMockRecorder) WriteInt(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteInt", reflect.TypeOf((*MockWriter)(nil).WriteInt), arg0)
 }
 
+// WriteObjects mocks base method.
+func (m *MockWriter) WriteObjects(arg0 ...interface{}) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{}
+	for _, a := range arg0 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "WriteObjects", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WriteObjects indicates an expected call of WriteObjects.
+func (mr *MockWriterMockRecorder) WriteObjects(arg0 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteObjects", reflect.TypeOf((*MockWriter)(nil).WriteObjects), arg0...)
+}
+
 // WriteSimpleString mocks base method.
 func (m *MockWriter) WriteSimpleString(arg0 string) error {
 	m.ctrl.T.Helper()
@@ -2,31 +2,123 @@ use kv;
 
 delimiter //
 
-create or replace function keyExists (_k text)
+-- returns _v as a hex literal, so that keys and other binary strings can be
+-- embedded in dynamic SQL without passing through a character set
+create or replace function quoteBinary (_v longblob) returns longtext as
+begin
+  return concat("x'", hex(_v), "'");
+end //
+
+-- returns the condition that _k is in the keyspace, for dynamic SQL reading
+-- its values; an unlinked key is removed from the keyspace at once but its
+-- values are only deleted in the background, so reads check for the key to
+-- never see them
+create or replace function keyLive (_db int, _k longblob) returns longtext as
+begin
+  return concat("exists(select 1 from keyspace where db = ", _db, " and k = ", quoteBinary(_k), ")");
+end //
+
+-- counts how many of _keys exist, counting repeated keys each time
+create or replace procedure keysExist (_db int, _keys array(longblob))
+returns bigint as
+declare
+  _q query(n bigint) = select count(*) from table(_keys) t join keyspace s on s.db = _db and s.k = t.table_col;
+begin
+  return scalar(_q);
+end //
+
+-- returns the keys matching a glob, given as the LIKE pattern of its literal
+-- prefix (answered by a range scan of the primary key) and a regexp
+create or replace function getKeys (_db int, _prefix longblob, _regexp longblob)
 returns table as return
-  select exists(select 1 from keyspace where k = _k) //
+  select k from keyspace where db = _db and k like _prefix and k rlike _regexp //
+
+-- saves the position reached by a SCAN style iteration, returning the cursor
+-- to resume it from
+-- the cursor is derived from the position, so that iterations reaching the
+-- same position share its row, and never expires, so that an iteration can
+-- be resumed at any time
+create or replace procedure cursorSave (_pos longblob)
+returns bigint as
+declare
+  _id bigint = conv(left(sha1(_pos), 15), 16, 10) + 1;
+begin
+  insert ignore into scancursors (id, pos) values (_id, _pos);
+  return _id;
+end //
 
-create or replace function getKeys (_pattern text)
+create or replace function cursorLoad (_id bigint)
 returns table as return
-  select k from keyspace where k like _pattern //
+  select (select pos from scancursors where id = _id) as pos //
+
+-- returns up to _count keys like _prefix following _after (or from the start
+-- if it is null), flagging those matching _regexp and, unless it is null,
+-- _type
+create or replace procedure keyScan (_db int, _after longblob, _count bigint, _prefix longblob, _regexp longblob, _type text)
+returns query(k longblob, matched bool) as
+begin
+  return to_query(concat(
+    "select k, k rlike ", quoteBinary(_regexp),
+    case
+      when _type is null then ""
+      when _type = "blob" then " and t in ('blob', 'hll')"
+      else concat(" and t = ", quote(_type))
+    end, " as matched",
+    " from keyspace where db = ", _db, " and k like ", quoteBinary(_prefix),
+    if(_after is null, "", concat(" and k > ", quoteBinary(_after))),
+    " order by k limit ", _count));
+end //
 
-create or replace procedure keyDelete (_k text)
+-- keyClear must be used within a transaction
+-- removes _k along with its values, returning true if the key existed
+create or replace procedure keyClear (_db int, _k longblob)
 returns boolean as
 begin
-  start transaction;
+  delete from blobvalues where db = _db and k = _k;
+  delete from listvalues where db = _db and k = _k;
+  delete from setvalues where db = _db and k = _k;
+  delete from hashvalues where db = _db and k = _k;
+  delete from zsetvalues where db = _db and k = _k;
+  delete from geovalues where db = _db and k = _k;
+  delete from jsonvalues where db = _db and k = _k;
+  delete from streamentries where db = _db and k = _k;
+  delete from streams where db = _db and k = _k;
+  delete from streamgroups where db = _db and k = _k;
+  delete from streamconsumers where db = _db and k = _k;
+  delete from streampending where db = _db and k = _k;
+  delete from unlinkedkeys where db = _db and k = _k;
 
-  delete from blobvalues where k = _k;
-  delete from listvalues where k = _k;
-  delete from setvalues where k = _k;
+  delete from keyspace where db = _db and k = _k;
+  return row_count() > 0;
+end //
 
-  delete from keyspace where k = _k;
-  if row_count() > 0 then
-    commit;
-    return true;
-  end if;
+-- removes _keys along with their values, returning how many existed
+create or replace procedure keyDelete (_db int, _keys array(longblob))
+returns bigint as
+declare
+  _deleted bigint;
+begin
+  start transaction;
+  delete from blobvalues where db = _db and k in (select table_col from table(_keys));
+  delete from listvalues where db = _db and k in (select table_col from table(_keys));
+  delete from setvalues where db = _db and k in (select table_col from table(_keys));
+  delete from hashvalues where db = _db and k in (select table_col from table(_keys));
+  delete from zsetvalues where db = _db and k in (select table_col from table(_keys));
+  delete from geovalues where db = _db and k in (select table_col from table(_keys));
+  delete from jsonvalues where db = _db and k in (select table_col from table(_keys));
+  delete from streamentries where db = _db and k in (select table_col from table(_keys));
+  delete from streams where db = _db and k in (select table_col from table(_keys));
+  delete from streamgroups where db = _db and k in (select table_col from table(_keys));
+  delete from streamconsumers where db = _db and k in (select table_col from table(_keys));
+  delete from streampending where db = _db and k in (select table_col from table(_keys));
+  delete from unlinkedkeys where db = _db and k in (select table_col from table(_keys));
+
+  delete from keyspace where db = _db and k in (select table_col from table(_keys));
+  _deleted = row_count();
+  commit;
+  return _deleted;
 
-  rollback;
-  return false;
+exception when others then rollback; raise;
 end //
 
 create or replace procedure flushAll ()
@@ -36,15 +128,263 @@ as begin
   delete from blobvalues;
   delete from listvalues;
   delete from setvalues;
+  delete from hashvalues;
+  delete from zsetvalues;
+  delete from geovalues;
+  delete from jsonvalues;
+  delete from streamentries;
+  delete from streams;
+  delete from streamgroups;
+  delete from streamconsumers;
+  delete from streampending;
+  delete from unlinkedkeys;
+  commit;
+end //
+
+create or replace procedure flushDb (_db int)
+as begin
+  start transaction;
+  delete from keyspace where db = _db;
+  delete from blobvalues where db = _db;
+  delete from listvalues where db = _db;
+  delete from setvalues where db = _db;
+  delete from hashvalues where db = _db;
+  delete from zsetvalues where db = _db;
+  delete from geovalues where db = _db;
+  delete from jsonvalues where db = _db;
+  delete from streamentries where db = _db;
+  delete from streams where db = _db;
+  delete from streamgroups where db = _db;
+  delete from streamconsumers where db = _db;
+  delete from streampending where db = _db;
+  delete from unlinkedkeys where db = _db;
+  commit;
+end //
+
+-- swaps the contents of databases _a and _b
+-- rows of _a are moved aside to database -1 first so that the swap never
+-- collides with the unique keys
+create or replace procedure swapDb (_a int, _b int)
+as
+declare
+  _tables array(text) = [
+    "keyspace", "blobvalues", "listvalues", "setvalues", "hashvalues", "zsetvalues", "geovalues",
+    "jsonvalues", "streams", "streamentries", "streamgroups", "streamconsumers", "streampending",
+    "unlinkedkeys"];
+begin
+  start transaction;
+  for i in 0 .. length(_tables) - 1 loop
+    execute immediate concat("update ", _tables[i], " set db = -1 where db = ", _a);
+    execute immediate concat("update ", _tables[i], " set db = ", _a, " where db = ", _b);
+    execute immediate concat("update ", _tables[i], " set db = ", _b, " where db = -1");
+  end loop;
+  commit;
+
+exception when others then rollback; raise;
+end //
+
+-- removes _keys from the keyspace, leaving their values to be deleted in the
+-- background by keyPurge, and returns how many existed
+-- the last IDs and consumer groups of streams are only a few rows per key so
+-- they go right away
+create or replace procedure keyUnlink (_db int, _keys array(longblob))
+returns bigint as
+declare
+  _unlinked bigint;
+begin
+  start transaction;
+  insert into unlinkedkeys (db, k, t)
+    select _db, k, t from keyspace where db = _db and k in (select table_col from table(_keys))
+    on duplicate key update t = values(t);
+
+  delete from streams where db = _db and k in (select table_col from table(_keys));
+  delete from streamgroups where db = _db and k in (select table_col from table(_keys));
+  delete from streamconsumers where db = _db and k in (select table_col from table(_keys));
+  delete from streampending where db = _db and k in (select table_col from table(_keys));
+  delete from keyspace where db = _db and k in (select table_col from table(_keys));
+  _unlinked = row_count();
+  commit;
+  return _unlinked;
+
+exception when others then rollback; raise;
+end //
+
+-- deletes up to _limit of the values left behind by unlinking _k, forgetting
+-- the key once none are left, and returns how many were deleted
+-- the key is locked so that recreating it waits for the batch to finish
+create or replace procedure keyPurge (_db int, _k longblob, _limit bigint)
+returns bigint as
+declare
+  _q query(t text) = select t from unlinkedkeys where db = _db and k = _k for update;
+  _rows array(record(t text));
+  _deleted bigint;
+begin
+  start transaction;
+  _rows = collect(_q);
+  if length(_rows) = 0 then
+    commit;
+    return 0;
+  end if;
+
+  execute immediate concat(
+    "delete from ", case _rows[0].t
+      when "stream" then "streamentries"
+      when "hll" then "blobvalues"
+      else concat(_rows[0].t, "values")
+    end,
+    " where db = ", _db, " and k = ", quoteBinary(_k), " limit ", _limit);
+  _deleted = row_count();
+  if _deleted < _limit then
+    delete from unlinkedkeys where db = _db and k = _k;
+  end if;
+  commit;
+  return _deleted;
+
+exception when others then rollback; raise;
+end //
+
+create or replace function keyType (_db int, _k longblob)
+returns table as return
+  select (select t from keyspace where db = _db and k = _k) as t //
+
+create or replace function keyCount (_db int)
+returns table as return
+  select count(*) as n from keyspace where db = _db //
+
+create or replace function keyRandom (_db int)
+returns table as return
+  select k from keyspace where db = _db order by rand() limit 1 //
+
+-- keyCopyValues must be used within a transaction
+-- copies _src along with its values to _dest in database _dest_db, which must
+-- not exist
+create or replace procedure keyCopyValues (_db int, _src longblob, _dest_db int, _dest longblob)
+as begin
+  insert into keyspace (db, k, t)
+    select _dest_db, _dest, t from keyspace where db = _db and k = _src;
+  insert into blobvalues (db, k, v)
+    select _dest_db, _dest, v from blobvalues where db = _db and k = _src;
+  insert into listvalues (db, k, v, pos)
+    select _dest_db, _dest, v, pos from listvalues where db = _db and k = _src;
+  insert into setvalues (db, k, v)
+    select _dest_db, _dest, v from setvalues where db = _db and k = _src;
+  insert into hashvalues (db, k, f, v)
+    select _dest_db, _dest, f, v from hashvalues where db = _db and k = _src;
+  insert into zsetvalues (db, k, member, score)
+    select _dest_db, _dest, member, score from zsetvalues where db = _db and k = _src;
+  insert into geovalues (db, k, member, location)
+    select _dest_db, _dest, member, location from geovalues where db = _db and k = _src;
+  insert into jsonvalues (db, k, v)
+    select _dest_db, _dest, v from jsonvalues where db = _db and k = _src;
+  insert into streams (db, k, last_ms, last_seq)
+    select _dest_db, _dest, last_ms, last_seq from streams where db = _db and k = _src;
+  insert into streamentries (db, k, id_ms, id_seq, fields)
+    select _dest_db, _dest, id_ms, id_seq, fields from streamentries where db = _db and k = _src;
+  insert into streamgroups (db, k, grp, last_ms, last_seq)
+    select _dest_db, _dest, grp, last_ms, last_seq from streamgroups where db = _db and k = _src;
+  insert into streamconsumers (db, k, grp, consumer, seen_ms)
+    select _dest_db, _dest, grp, consumer, seen_ms from streamconsumers where db = _db and k = _src;
+  insert into streampending (db, k, grp, id_ms, id_seq, consumer, delivered_ms, deliveries)
+    select _dest_db, _dest, grp, id_ms, id_seq, consumer, delivered_ms, deliveries
+    from streampending where db = _db and k = _src;
+end //
+
+-- renames _src to _dest, replacing _dest unless _nx is set; returns false if
+-- _dest was kept and raises if _src doesn't exist
+create or replace procedure keyRename (_db int, _src longblob, _dest longblob, _nx bool)
+returns bool as
+declare
+  _src_q query(n bigint) = select count(*) from keyspace where db = _db and k = _src;
+  _dest_q query(n bigint) = select count(*) from keyspace where db = _db and k = _dest;
+  _cleared bool;
+begin
+  start transaction;
+  if scalar(_src_q) = 0 then
+    raise user_exception("no such key");
+  end if;
+  if _src = _dest then
+    commit;
+    return not _nx;
+  end if;
+  if _nx and scalar(_dest_q) > 0 then
+    commit;
+    return false;
+  end if;
+
+  _cleared = keyClear(_db, _dest);
+  call keyCopyValues(_db, _src, _db, _dest);
+  _cleared = keyClear(_db, _src);
+  commit;
+  return true;
+
+exception when others then rollback; raise;
+end //
+
+-- copies _src to _dest in database _dest_db, replacing _dest if _replace is
+-- set; returns false if _src doesn't exist or _dest was kept
+create or replace procedure keyCopy (_db int, _src longblob, _dest_db int, _dest longblob, _replace bool)
+returns bool as
+declare
+  _src_q query(n bigint) = select count(*) from keyspace where db = _db and k = _src;
+  _dest_q query(n bigint) = select count(*) from keyspace where db = _dest_db and k = _dest;
+  _cleared bool;
+begin
+  start transaction;
+  if scalar(_src_q) = 0 or (not _replace and scalar(_dest_q) > 0) then
+    commit;
+    return false;
+  end if;
+
+  _cleared = keyClear(_dest_db, _dest);
+  call keyCopyValues(_db, _src, _dest_db, _dest);
   commit;
+  return true;
+
+exception when others then rollback; raise;
+end //
+
+-- moves _k to database _dest_db, returning false if _k doesn't exist or
+-- already exists there
+create or replace procedure keyMove (_db int, _k longblob, _dest_db int)
+returns bool as
+declare
+  _src_q query(n bigint) = select count(*) from keyspace where db = _db and k = _k;
+  _dest_q query(n bigint) = select count(*) from keyspace where db = _dest_db and k = _k;
+  _cleared bool;
+begin
+  start transaction;
+  if scalar(_src_q) = 0 or scalar(_dest_q) > 0 then
+    commit;
+    return false;
+  end if;
+
+  -- the destination may still hold values of an unlinked key
+  _cleared = keyClear(_dest_db, _k);
+  update keyspace set db = _dest_db where db = _db and k = _k;
+  update blobvalues set db = _dest_db where db = _db and k = _k;
+  update listvalues set db = _dest_db where db = _db and k = _k;
+  update setvalues set db = _dest_db where db = _db and k = _k;
+  update hashvalues set db = _dest_db where db = _db and k = _k;
+  update zsetvalues set db = _dest_db where db = _db and k = _k;
+  update geovalues set db = _dest_db where db = _db and k = _k;
+  update jsonvalues set db = _dest_db where db = _db and k = _k;
+  update streams set db = _dest_db where db = _db and k = _k;
+  update streamentries set db = _dest_db where db = _db and k = _k;
+  update streamgroups set db = _dest_db where db = _db and k = _k;
+  update streamconsumers set db = _dest_db where db = _db and k = _k;
+  update streampending set db = _dest_db where db = _db and k = _k;
+  commit;
+  return true;
+
+exception when others then rollback; raise;
 end //
 
 create or replace function assertType (
-  _actual enum("blob", "set", "list"),
-  _expected enum("blob", "set", "list")
+  _actual enum("blob", "set", "list", "hash", "zset", "stream", "hll", "geo", "json"),
+  _expected enum("blob", "set", "list", "hash", "zset", "stream", "hll", "geo", "json")
 ) returns text
 as begin
-  if _actual != _expected then
+  if _actual != _expected and not (_actual = "hll" and _expected = "blob") then
     raise user_exception(concat("type mismatch; got ", _actual, ", expected ", _expected));
   end if;
   return _actual;
@@ -52,59 +392,105 @@ end //
 
 -- assertKey must be used within a transaction
 -- will rollback the parent transaction on failure
-create or replace procedure assertKey (_k text, _type enum("blob", "set", "list"))
+-- HyperLogLogs are strings to redis, so they pass as blobs
+create or replace procedure assertKey (_db int, _k longblob, _type enum("blob", "set", "list", "hash", "zset", "stream", "hll", "geo", "json"))
 as
 declare
-  _q query(t text) = select (select t from keyspace where k = _k);
+  _q query(t text) = select (select t from keyspace where db = _db and k = _k);
+  _unlinked_q query(n bigint) = select count(*) from unlinkedkeys where db = _db and k = _k;
   _actual_type text;
+  _cleared bool;
 begin
   _actual_type = scalar(_q);
 
   if _actual_type is null then
-    -- new key
-    insert into keyspace (k, t) values (_k, _type)
+    -- new key, which may still have values left from being unlinked
+    if scalar(_unlinked_q) > 0 then
+      _cleared = keyClear(_db, _k);
+    end if;
+    insert into keyspace (db, k, t) values (_db, _k, _type)
       on duplicate key update t = assertType(t, _type);
-  elsif _actual_type != _type then
+  elsif _actual_type != _type and not (_actual_type = "hll" and _type = "blob") then
     raise user_exception(concat("type mismatch; got ", _actual_type, ", expected ", _type));
   end if;
 
 exception when others then rollback; raise;
 end //
 
-create or replace procedure blobSet (_k text, _v blob)
+create or replace procedure blobSet (_db int, _k longblob, _v longblob)
 as begin
   start transaction;
-  call assertKey(_k, "blob");
+  call assertKey(_db, _k, "blob");
 
-  insert into blobvalues (k, v) values (_k, _v)
+  insert into blobvalues (db, k, v) values (_db, _k, _v)
     on duplicate key update v = values(v);
 
   commit;
 end //
 
-create or replace function blobGet (_k text)
+create or replace function blobGet (_db int, _k longblob)
 returns table as return
-  select (select v from blobvalues where k = _k) as v //
+  select (select v from blobvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k)) as v //
 
-create or replace function assertNotNull (_v blob) returns blob
+-- parses _v the way redis parses integers, raising if it is not the
+-- canonical decimal form of a signed 64 bit integer
+create or replace function assertInteger (_v longblob) returns bigint
 as begin
-  if _v is null then
-    raise user_exception("invalid operation");
+  if _v is null or length(_v) > 20 or not (_v :> text) rlike "^(0|-?[1-9][0-9]*)$" then
+    raise user_exception("value is not an integer or out of range");
   end if;
-  return _v;
+  if (_v :> decimal(21, 0)) not between -9223372036854775808 and 9223372036854775807 then
+    raise user_exception("value is not an integer or out of range");
+  end if;
+  return _v :> bigint;
+end //
+
+-- parses _v the way redis parses floats, raising on anything else
+create or replace function assertFloat (_v longblob) returns double
+as begin
+  if _v is null or length(_v) > 64
+    or not (_v :> text) rlike "^[-+]?([0-9]+[.]?[0-9]*|[.][0-9]+)([eE][-+]?[0-9]+)?$" then
+    raise user_exception("value is not a valid float");
+  end if;
+  return _v :> double;
+end //
+
+-- formats _v like redis: fixed point, at most 17 decimals, no trailing zeros
+create or replace function formatFloat (_v double) returns text
+as begin
+  if abs(_v) >= 1e47 then
+    raise user_exception("increment would produce NaN or Infinity");
+  end if;
+  return trim(trailing "." from trim(trailing "0" from (_v :> decimal(65, 17)) :> text));
+end //
+
+create or replace function integerAdd (_v longblob, _delta bigint) returns text
+as
+declare
+  _sum decimal(21, 0) = (assertInteger(_v) :> decimal(21, 0)) + _delta;
+begin
+  if _sum not between -9223372036854775808 and 9223372036854775807 then
+    raise user_exception("increment or decrement would overflow");
+  end if;
+  return (_sum :> bigint) :> text;
+end //
+
+create or replace function floatAdd (_v longblob, _delta double) returns text
+as begin
+  return formatFloat(assertFloat(_v) + _delta);
 end //
 
-create or replace procedure incrBy (_k text, _v bigint) returns bigint
+create or replace procedure incrBy (_db int, _k longblob, _v bigint) returns bigint
 as
 declare
-  _ret_q query(v bigint) = select v :> bigint from blobvalues where k = _k;
+  _ret_q query(v bigint) = select v :> bigint from blobvalues where db = _db and k = _k;
   _ret bigint;
 begin
   start transaction;
-  call assertKey(_k, "blob");
+  call assertKey(_db, _k, "blob");
 
-  insert into blobvalues (k, v) values (_k, _v)
-    on duplicate key update v = assertNotNull(v + _v);
+  insert into blobvalues (db, k, v) values (_db, _k, _v)
+    on duplicate key update v = integerAdd(v, _v);
 
   _ret = scalar(_ret_q);
 
@@ -113,141 +499,3114 @@ begin
   return _ret;
 end //
 
-create or replace procedure listAppend(_k text, _v blob)
+create or replace procedure decrBy (_db int, _k longblob, _v bigint) returns bigint
 as begin
+  return incrBy(_db, _k, -1 * _v);
+end //
+
+create or replace procedure incrByFloat (_db int, _k longblob, _v double) returns text
+as
+declare
+  _ret_q query(v text) = select v :> text from blobvalues where db = _db and k = _k;
+  _ret text;
+begin
   start transaction;
-  call assertKey(_k, "list");
+  call assertKey(_db, _k, "blob");
+
+  insert into blobvalues (db, k, v) values (_db, _k, formatFloat(_v))
+    on duplicate key update v = floatAdd(v, _v);
+
+  _ret = scalar(_ret_q);
 
-  insert into listvalues (k, v) values (_k, _v);
   commit;
+
+  return _ret;
 end //
 
-create or replace procedure listRemove(_k text, _v blob)
-returns int as
+-- pads _v with zero bytes up to _n bytes
+create or replace function zeroPad (_v longblob, _n bigint) returns longblob
+as begin
+  if length(ifnull(_v, "")) >= _n then
+    return ifnull(_v, "");
+  end if;
+  return concat(ifnull(_v, ""), repeat(unhex("00"), _n - length(ifnull(_v, ""))));
+end //
+
+-- reads _bits bits (at most 64) starting at bit _offset as an unsigned
+-- number; bits past the end of _v read as zero
+create or replace function bitsGet (_v longblob, _offset bigint, _bits int) returns decimal(30, 0)
+as
 declare
-  _rowcount int;
+  _first bigint = floor(_offset / 8);
+  _n int = floor((_offset + _bits - 1) / 8) - _first + 1;
+  _seg longblob = zeroPad(substr(ifnull(_v, ""), _first + 1, _n), _n);
+  _acc decimal(30, 0) = 0;
+  _shift int = (_first + _n) * 8 - (_offset + _bits);
 begin
-  start transaction;
-  call assertKey(_k, "list");
+  for i in 1 .. _n loop
+    _acc = _acc * 256 + ascii(substr(_seg, i, 1));
+  end loop;
+  return floor(_acc / (pow(2, _shift) :> decimal(30, 0))) mod (pow(2, _bits) :> decimal(30, 0));
+end //
 
-  delete from listvalues where k = _k and v = _v;
-  _rowcount = row_count();
-  commit;
+-- overwrites _bits bits (at most 64) starting at bit _offset with the
+-- unsigned number _u, growing _v with zero bytes as needed
+create or replace function bitsSet (_v longblob, _offset bigint, _bits int, _u decimal(30, 0)) returns longblob
+as
+declare
+  _first bigint = floor(_offset / 8);
+  _n int = floor((_offset + _bits - 1) / 8) - _first + 1;
+  _padded longblob = zeroPad(_v, _first + _n);
+  _shift int = (_first + _n) * 8 - (_offset + _bits);
+  _acc decimal(30, 0) = 0;
+  _seg longblob = "";
+begin
+  for i in 1 .. _n loop
+    _acc = _acc * 256 + ascii(substr(_padded, _first + i, 1));
+  end loop;
 
-  return _rowcount;
+  _acc = _acc + (_u - bitsGet(_v, _offset, _bits)) * (pow(2, _shift) :> decimal(30, 0));
+
+  for i in 1 .. _n loop
+    _seg = concat(unhex(lpad(conv(_acc mod 256, 10, 16), 2, "0")), _seg);
+    _acc = floor(_acc / 256);
+  end loop;
+
+  return concat(substr(_padded, 1, _first), _seg, substr(_padded, _first + _n + 1));
 end //
 
-create or replace function listGet(_k text)
-returns table as return
-  select v from listvalues
-    where k = _k
-    order by ts, seq
-  //
+-- counts the set bits of _v between bit offsets _start and _end (inclusive)
+create or replace function bitsCount (_v longblob, _start bigint, _end bigint) returns bigint
+as
+declare
+  _count bigint = 0;
+  _pos bigint = _start;
+  _nbytes bigint;
+  _seg longblob;
+begin
+  while _pos <= _end and _pos mod 8 != 0 loop
+    _count = _count + bitsGet(_v, _pos, 1);
+    _pos = _pos + 1;
+  end loop;
 
--- retrieves elements of list between offset _start and _end (inclusive)
--- _start is 0 based
-create or replace function listRange(_k text, _start int, _end int)
-returns table as return
-  select v
-  from (
-    select v, (row_number() over (order by ts, seq)) - 1 as _rownum
-    from listvalues
-    where k = _k
-  )
-  where _rownum >= _start and _rownum <= _end
-  order by _rownum asc //
+  _nbytes = floor((_end + 1 - _pos) / 8);
+  if _nbytes > 0 then
+    _seg = substr(_v, floor(_pos / 8) + 1, _nbytes);
+    for i in 0 .. ceil(length(_seg) / 8) - 1 loop
+      _count = _count + bit_count(conv(hex(substr(_seg, i * 8 + 1, 8)), 16, 10) :> bigint unsigned);
+    end loop;
+    _pos = _pos + _nbytes * 8;
+  end if;
 
-create or replace procedure setAdd(_k text, _v blob)
-as begin
-  start transaction;
-  call assertKey(_k, "set");
-  insert ignore into setvalues (k, v) values (_k, _v);
-  commit;
+  while _pos <= _end loop
+    _count = _count + bitsGet(_v, _pos, 1);
+    _pos = _pos + 1;
+  end loop;
+
+  return _count;
 end //
 
-create or replace procedure setRemove(_k text, _v blob)
-returns int as
+-- returns the first bit offset between _start and _end (inclusive) which is
+-- equal to _bit, or _end + 1 if there is none
+create or replace function bitsPos (_v longblob, _bit int, _start bigint, _end bigint) returns bigint
+as
 declare
-  _rowcount int;
+  _pos bigint = _start;
+  _skip longblob = if(_bit = 1, unhex("00"), unhex("ff"));
+  _seg longblob;
+begin
+  while _pos <= _end loop
+    if _pos mod 8 = 0 and _pos + 7 <= _end then
+      -- skip whole bytes which can't contain a match
+      _seg = substr(_v, floor(_pos / 8) + 1, floor((_end + 1 - _pos) / 8));
+      _pos = _pos + (length(_seg) - length(trim(leading _skip from _seg))) * 8;
+    end if;
+    if _pos > _end or bitsGet(_v, _pos, 1) = _bit then
+      return least(_pos, _end + 1);
+    end if;
+    _pos = _pos + 1;
+  end loop;
+  return _end + 1;
+end //
+
+create or replace procedure setBit (_db int, _k longblob, _offset bigint, _bit int) returns int
+as
+declare
+  _cur_q query(v longblob) = select (select v from blobvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k));
+  _cur longblob;
 begin
   start transaction;
-  call assertKey(_k, "set");
-  delete from setvalues where k = _k and v = _v;
-  _rowcount = row_count();
+  call assertKey(_db, _k, "blob");
+
+  _cur = scalar(_cur_q);
+  insert into blobvalues (db, k, v) values (_db, _k, bitsSet(_cur, _offset, 1, _bit))
+    on duplicate key update v = values(v);
+
   commit;
-  return _rowcount;
+
+  return bitsGet(_cur, _offset, 1);
 end //
 
-create or replace function setGet(_k text)
+create or replace function getBit (_db int, _k longblob, _offset bigint)
 returns table as return
-  select v from setvalues where k = _k //
+  select bitsGet((select v from blobvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k)), _offset, 1) :> int as v //
 
-create or replace procedure setUnion(_keys array(text))
-returns query(v blob) as
+-- _start and _end follow redis semantics: negative values count back from
+-- the end of the value, and they are byte offsets unless _bitmode is set
+create or replace procedure bitCount (_db int, _k longblob, _start bigint, _end bigint, _bitmode bool)
+returns bigint as
 declare
-  _q text = "select distinct(v) from setvalues where k in (";
+  _v_q query(v longblob) = select (select v from blobvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k));
+  _v longblob;
+  _len bigint;
 begin
-  if length(_keys) < 2 then
-    raise user_exception("setUnion requires at least 2 keys");
+  _v = scalar(_v_q);
+  _len = if(_bitmode, length(ifnull(_v, "")) * 8, length(ifnull(_v, "")));
+  if _start < 0 then _start = greatest(_len + _start, 0); end if;
+  if _end < 0 then _end = greatest(_len + _end, 0); end if;
+  _end = least(_end, _len - 1);
+  if _len = 0 or _start > _end then
+    return 0;
   end if;
 
-  for i in 0 .. length(_keys) - 1 loop
-    if i > 0 then
-      _q = concat(_q, ",");
-    end if;
+  if not _bitmode then
+    _start = _start * 8;
+    _end = _end * 8 + 7;
+  end if;
+  return bitsCount(_v, _start, _end);
+end //
 
-    _q = concat(_q, quote(_keys[i]));
-  end loop;
+-- like bitCount, _start and _end follow redis semantics; _has_end must be
+-- false if the client didn't provide an end offset
+create or replace procedure bitPos (_db int, _k longblob, _bit int, _start bigint, _end bigint, _has_end bool, _bitmode bool)
+returns bigint as
+declare
+  _v_q query(v longblob) = select (select v from blobvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k));
+  _v longblob;
+  _len bigint;
+  _pos bigint;
+begin
+  _v = scalar(_v_q);
+  _len = if(_bitmode, length(ifnull(_v, "")) * 8, length(ifnull(_v, "")));
+  if _len = 0 then
+    return if(_bit = 1, -1, 0);
+  end if;
 
-  _q = concat(_q, ")");
+  if _start < 0 then _start = greatest(_len + _start, 0); end if;
+  if _end < 0 then _end = greatest(_len + _end, 0); end if;
+  _end = least(_end, _len - 1);
+  if _start > _end then
+    return -1;
+  end if;
 
-  return to_query(_q);
+  if not _bitmode then
+    _start = _start * 8;
+    _end = _end * 8 + 7;
+  end if;
+
+  _pos = bitsPos(_v, _bit, _start, _end);
+  if _pos <= _end then
+    return _pos;
+  elsif _bit = 0 and not _has_end then
+    -- looking for a clear bit past the end of the value always succeeds
+    return _pos;
+  end if;
+  return -1;
+end //
+
+-- applies the bitwise _op ("and", "or", "xor" or "not") to _a and _b,
+-- treating the shorter value as if it were padded with zero bytes
+create or replace function bitwise (_op text, _a longblob, _b longblob) returns longblob
+as
+declare
+  _n bigint = greatest(length(ifnull(_a, "")), length(ifnull(_b, "")));
+  _pa longblob = zeroPad(_a, _n);
+  _pb longblob = zeroPad(_b, _n);
+  _x bigint unsigned;
+  _y bigint unsigned;
+  _out longblob = "";
+begin
+  for i in 0 .. ceil(_n / 8) - 1 loop
+    _x = conv(hex(zeroPad(substr(_pa, i * 8 + 1, 8), 8)), 16, 10) :> bigint unsigned;
+    _y = conv(hex(zeroPad(substr(_pb, i * 8 + 1, 8), 8)), 16, 10) :> bigint unsigned;
+    if _op = "and" then
+      _x = _x & _y;
+    elsif _op = "or" then
+      _x = _x | _y;
+    elsif _op = "xor" then
+      _x = _x ^ _y;
+    else
+      _x = ~_x;
+    end if;
+    _out = concat(_out, unhex(lpad(conv(_x, 10, 16), 16, "0")));
+  end loop;
+  return substr(_out, 1, _n);
 end //
 
-create or replace procedure setIntersect(_keys array(text))
-returns query(v blob) as
+-- stores the result of _op applied across the values of _keys in _dest,
+-- replacing whatever _dest held, and returns the length of the result
+create or replace procedure bitOp (_db int, _op text, _dest longblob, _keys array(longblob))
+returns bigint as
 declare
-  _prefix text = "select distinct(s0.v) from ";
-  _tables text = " ";
-  _joins text = " ";
+  _key longblob;
+  _t text;
+  _v longblob;
+  _result longblob;
+  _existed boolean;
 begin
-  if length(_keys) < 2 then
-    raise user_exception("setIntersect requires at least 2 keys");
+  if _op = "not" and length(_keys) != 1 then
+    raise user_exception("BITOP NOT must be called with a single source key.");
   end if;
 
+  start transaction;
+
   for i in 0 .. length(_keys) - 1 loop
+    _key = _keys[i];
+    select (select t from keyspace where db = _db and k = _key) into _t;
+    if _t is not null and _t != "blob" and _t != "hll" then
+      raise user_exception(concat("type mismatch; got ", _t, ", expected blob"));
+    end if;
+    select (select v from blobvalues where db = _db and k = _key) into _v;
+
     if i = 0 then
-      _tables = concat(_tables, "setvalues s0");
-      _joins = concat(_joins, "s0.k = ", quote(_keys[0]));
+      _result = if(_op = "not", bitwise(_op, _v, null), ifnull(_v, ""));
     else
-      _tables = concat(_tables, ", setvalues s", i);
-      _joins = concat(
-        _joins,
-        -- and s1.k = _keys[1]
-        " and s", i, ".k = ", quote(_keys[i]),
-        -- and s0.v = s1.v
-        " and s0.v = s", i, ".v"
-      );
+      _result = bitwise(_op, _result, _v);
     end if;
   end loop;
 
-  return to_query(concat(_prefix, _tables, " where ", _joins));
+  _existed = keyClear(_db, _dest);
+  if length(_result) > 0 then
+    insert into keyspace (db, k, t) values (_db, _dest, "blob");
+    insert into blobvalues (db, k, v) values (_db, _dest, _result);
+  end if;
+
+  commit;
+
+  return length(_result);
+
+exception when others then rollback; raise;
 end //
 
-create or replace function setsWithMember(_v blob)
-returns table as return
-    select k from setvalues where v = _v //
+-- wraps, saturates or fails (returning null) if _value doesn't fit in a
+-- field of _bits bits, depending on _overflow ("wrap", "sat" or "fail")
+create or replace function bitfieldOverflow (_value decimal(30, 0), _signed bool, _bits int, _overflow text)
+returns decimal(30, 0) as
+declare
+  _size decimal(30, 0) = pow(2, _bits) :> decimal(30, 0);
+  _min decimal(30, 0) = if(_signed, -_size / 2, 0);
+  _max decimal(30, 0) = if(_signed, _size / 2, _size) - 1;
+  _wrapped decimal(30, 0);
+begin
+  if _value between _min and _max then
+    return _value;
+  elsif _overflow = "sat" then
+    return if(_value < _min, _min, _max);
+  elsif _overflow = "fail" then
+    return null;
+  end if;
 
-create or replace function setCardinality(_k text)
-returns table as return
-    select count(*) from setvalues where k = _k //
+  _wrapped = (_value - _min) mod _size;
+  if _wrapped < 0 then
+    _wrapped = _wrapped + _size;
+  end if;
+  return _wrapped + _min;
+end //
 
-create or replace procedure setIntersectCardinality(_keys array(text))
-returns query(c bigint) as
+-- runs the BITFIELD subcommands described by the parallel arrays against _k,
+-- returning their results as a json array
+create or replace procedure bitField (
+  _db int,
+  _k longblob,
+  _ops array(text),
+  _signed array(bool),
+  _bits array(int),
+  _offsets array(bigint),
+  _values array(bigint),
+  _overflows array(text)
+) returns text as
 declare
-  _base query(v blob) = setIntersect(_keys);
-  _q query(c bigint) = select count(*) from _base;
-begin return _q;
+  _v_q query(v longblob) = select (select v from blobvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k));
+  _v longblob;
+  _size decimal(30, 0);
+  _cur decimal(30, 0);
+  _new decimal(30, 0);
+  _writes bool = false;
+  _out longtext = "";
+begin
+  start transaction;
+
+  for i in 0 .. length(_ops) - 1 loop
+    if _ops[i] != "get" then
+      _writes = true;
+    end if;
+  end loop;
+  if _writes then
+    call assertKey(_db, _k, "blob");
+  end if;
+
+  _v = scalar(_v_q);
+
+  for i in 0 .. length(_ops) - 1 loop
+    _size = pow(2, _bits[i]) :> decimal(30, 0);
+    _cur = bitsGet(_v, _offsets[i], _bits[i]);
+    if _signed[i] and _cur >= _size / 2 then
+      _cur = _cur - _size;
+    end if;
+
+    if _ops[i] = "get" then
+      _new = _cur;
+    else
+      _new = bitfieldOverflow(
+        if(_ops[i] = "set", 0, _cur) + _values[i], _signed[i], _bits[i], _overflows[i]);
+      if _new is not null then
+        _v = bitsSet(_v, _offsets[i], _bits[i], if(_new < 0, _new + _size, _new));
+      end if;
+    end if;
+
+    _out = concat(_out, if(i > 0, ",", ""),
+      ifnull(if(_ops[i] = "set" and _new is not null, _cur, _new) :> text, "null"));
+  end loop;
+
+  if _writes then
+    insert into blobvalues (db, k, v) values (_db, _k, _v)
+      on duplicate key update v = values(v);
+  end if;
+
+  commit;
+
+  return concat("[", _out, "]");
+
+exception when others then rollback; raise;
+end //
+
+-- listLock must be used within a transaction
+-- locks the keyspace row of _k so that concurrent pushes and pops on the same
+-- list are serialized, returning false if the key does not exist
+create or replace procedure listLock(_db int, _k longblob)
+returns boolean as
+declare
+  _q query(t text) = select t from keyspace where db = _db and k = _k for update;
+  _rows array(record(t text));
+begin
+  _rows = collect(_q);
+  if length(_rows) = 0 then
+    return false;
+  end if;
+  if _rows[0].t != "list" then
+    raise user_exception(concat("type mismatch; got ", _rows[0].t, ", expected list"));
+  end if;
+  return true;
+end //
+
+-- pushes each of _values in turn onto the head (_left) or tail of _k,
+-- returning the new length of the list
+-- the elements are inserted by a single insert ... select
+-- if _existing is set nothing is pushed unless _k already exists, in which
+-- case 0 is returned
+create or replace procedure listPush(_db int, _k longblob, _values array(longblob), _left bool, _existing bool)
+returns bigint as
+declare
+  _q query(lo bigint, hi bigint) = select ifnull(min(pos), 1), ifnull(max(pos), 0) from listvalues where db = _db and k = _k;
+  _bounds array(record(lo bigint, hi bigint));
+  _lo bigint;
+  _hi bigint;
+  _locked boolean;
+  _indexed array(longblob) = create_array(length(_values));
+  _len bigint;
+begin
+  start transaction;
+  if not _existing then
+    call assertKey(_db, _k, "list");
+  end if;
+  _locked = listLock(_db, _k);
+  if not _locked then
+    commit;
+    return 0;
+  end if;
+
+  -- table() doesn't say where in the array each row came from, so each value
+  -- is prefixed with its index as 20 digits
+  for i in 0 .. length(_values) - 1 loop
+    _indexed[i] = concat(lpad(i, 20, "0"), _values[i]);
+  end loop;
+  _bounds = collect(_q);
+  _lo = _bounds[0].lo;
+  _hi = _bounds[0].hi;
+  insert into listvalues (db, k, v, pos)
+    select _db, _k, substr(table_col, 21),
+      if(_left, _lo - 1 - (left(table_col, 20) :> bigint), _hi + 1 + (left(table_col, 20) :> bigint))
+    from table(_indexed);
+
+  select (select count(*) from listvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k)) into _len;
+  commit;
+  return _len;
+
+exception when others then rollback; raise;
+end //
+
+-- listTake must be used within a transaction holding listLock(_db, _k)
+-- removes up to _count elements from the head (_left) or tail of _k, deleting
+-- the key once the list is empty
+-- returns the removed elements each prefixed with its index as 20 digits, so
+-- that ordering by them selects the elements in order from table()
+create or replace procedure listTake(_db int, _k longblob, _count bigint, _left bool)
+returns array(longblob) as
+declare
+  _q query(v longblob, pos bigint) =
+    select v, pos
+    from (
+      select v, pos, row_number() over (order by if(_left, pos, -pos)) as _rownum
+      from listvalues
+      where db = _db and k = _k
+    )
+    where _rownum <= _count
+    order by _rownum;
+  _rows array(record(v longblob, pos bigint));
+  _last bigint;
+  _out array(longblob);
+begin
+  _rows = collect(_q);
+  _out = create_array(length(_rows));
+  if length(_rows) = 0 then
+    return _out;
+  end if;
+
+  _last = _rows[length(_rows) - 1].pos;
+  delete from listvalues where db = _db and k = _k and if(_left, pos <= _last, pos >= _last);
+
+  for i in 0 .. length(_rows) - 1 loop
+    _out[i] = concat(lpad(i, 20, "0"), _rows[i].v);
+  end loop;
+
+  delete from keyspace where db = _db and k = _k and not exists(select 1 from listvalues where db = _db and k = _k);
+
+  return _out;
+end //
+
+-- removes and returns up to _count elements from the head (_left) or tail of
+-- _k, deleting the key once the list is empty
+create or replace procedure listPop(_db int, _k longblob, _count bigint, _left bool)
+returns query(v longblob) as
+declare
+  _locked boolean;
+  _taken array(longblob) = create_array(0);
+  _q query(v longblob) = select substr(table_col, 21) as v from table(_taken) order by table_col;
+begin
+  start transaction;
+  _locked = listLock(_db, _k);
+  if _locked then
+    _taken = listTake(_db, _k, _count, _left);
+  end if;
+  commit;
+  return _q;
+
+exception when others then rollback; raise;
+end //
+
+-- pops up to _count elements from the first non-empty list in _keys, returning
+-- them along with the key they were popped from
+create or replace procedure listMultiPop(_db int, _keys array(longblob), _count bigint, _left bool)
+returns query(k longblob, v longblob) as
+declare
+  _locked boolean;
+  _key longblob;
+  _taken array(longblob) = create_array(0);
+  _q query(k longblob, v longblob) =
+    select _key as k, substr(table_col, 21) as v from table(_taken) order by table_col;
+  _i bigint = 0;
+begin
+  start transaction;
+  while length(_taken) = 0 and _i < length(_keys) loop
+    _key = _keys[_i];
+    _locked = listLock(_db, _key);
+    if _locked then
+      _taken = listTake(_db, _key, _count, _left);
+    end if;
+    _i = _i + 1;
+  end loop;
+  commit;
+  return _q;
+
+exception when others then rollback; raise;
+end //
+
+-- pops an element from the head (_from_left) or tail of _src and pushes it
+-- onto the head (_to_left) or tail of _dest, returning the element or null if
+-- _src does not exist
+-- _src and _dest may be the same key, rotating the list
+create or replace procedure listMove(_db int, _src longblob, _dest longblob, _from_left bool, _to_left bool)
+returns longblob as
+declare
+  _q query(v longblob, pos bigint) =
+    select v, pos from listvalues where db = _db and k = _src order by if(_from_left, pos, -pos) limit 1;
+  _rows array(record(v longblob, pos bigint));
+  _locked boolean;
+  _v longblob;
+begin
+  start transaction;
+  _locked = listLock(_db, _src);
+  if not _locked then
+    commit;
+    return null;
+  end if;
+
+  _rows = collect(_q);
+  if length(_rows) = 0 then
+    commit;
+    return null;
+  end if;
+  _v = _rows[0].v;
+
+  call assertKey(_db, _dest, "list");
+  _locked = listLock(_db, _dest);
+
+  delete from listvalues where db = _db and k = _src and pos = _rows[0].pos;
+  insert into listvalues (db, k, v, pos)
+    select _db, _dest, _v, if(_to_left, ifnull(min(pos), 1) - 1, ifnull(max(pos), 0) + 1)
+    from listvalues where db = _db and k = _dest;
+
+  delete from keyspace where db = _db and k = _src and not exists(select 1 from listvalues where db = _db and k = _src);
+
+  commit;
+  return _v;
+
+exception when others then rollback; raise;
+end //
+
+create or replace procedure listRemove(_db int, _k longblob, _v longblob)
+returns int as
+declare
+  _rowcount int;
+begin
+  start transaction;
+  call assertKey(_db, _k, "list");
+
+  delete from listvalues where db = _db and k = _k and v = _v;
+  _rowcount = row_count();
+  commit;
+
+  return _rowcount;
+end //
+
+create or replace function listGet(_db int, _k longblob)
+returns table as return
+  select v from listvalues
+    where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k)
+    order by pos
+  //
+
+-- retrieves elements of list between _start and _stop (inclusive), where
+-- negative indices count back from the tail
+-- the range is read from whichever end of the list is closer so only the
+-- elements up to it are scanned
+create or replace procedure listRange(_db int, _k longblob, _start bigint, _stop bigint)
+returns query(v longblob) as
+declare
+  _len bigint;
+begin
+  select (select count(*) from listvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k)) into _len;
+  if _start < 0 then
+    _start = _start + _len;
+  end if;
+  if _stop < 0 then
+    _stop = _stop + _len;
+  end if;
+  if _start < 0 then
+    _start = 0;
+  end if;
+  if _stop >= _len then
+    _stop = _len - 1;
+  end if;
+
+  if _start > _stop then
+    return to_query("select v from listvalues limit 0");
+  end if;
+
+  if _start <= _len - 1 - _stop then
+    return to_query(concat(
+      "select v from listvalues where db = ", _db, " and k = ", quoteBinary(_k), " and ", keyLive(_db, _k),
+      " order by pos limit ", _start, ", ", _stop - _start + 1));
+  end if;
+
+  return to_query(concat(
+    "select v from (",
+    "select v, pos from listvalues where db = ", _db, " and k = ", quoteBinary(_k), " and ", keyLive(_db, _k),
+    " order by pos desc limit ", _len - 1 - _stop, ", ", _stop - _start + 1,
+    ") order by pos"));
+end //
+
+create or replace function listLength(_db int, _k longblob)
+returns table as return
+  select count(*) as n from listvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k) //
+
+-- finds the position of the element at _index, where negative indices count
+-- back from the tail
+create or replace function listSeek(_db int, _k longblob, _index bigint)
+returns table as return
+  select pos
+  from (
+    select pos, (row_number() over (order by if(_index < 0, -pos, pos))) - 1 as _rownum
+    from listvalues
+    where db = _db and k = _k
+  )
+  where _rownum = if(_index < 0, -_index - 1, _index) //
+
+create or replace function listIndex(_db int, _k longblob, _index bigint)
+returns table as return
+  select (
+    select l.v
+    from listvalues l join listSeek(_db, _k, _index) s on l.pos = s.pos
+    where l.db = _db and l.k = _k and exists(select 1 from keyspace where db = _db and k = _k)
+  ) as v //
+
+create or replace procedure listSet(_db int, _k longblob, _index bigint, _v longblob)
+as
+declare
+  _locked boolean;
+  _q query(pos bigint) = select pos from listSeek(_db, _k, _index);
+  _rows array(record(pos bigint));
+begin
+  start transaction;
+  _locked = listLock(_db, _k);
+  if not _locked then
+    raise user_exception("no such key");
+  end if;
+
+  _rows = collect(_q);
+  if length(_rows) = 0 then
+    raise user_exception("index out of range");
+  end if;
+
+  update listvalues set v = _v where db = _db and k = _k and pos = _rows[0].pos;
+  commit;
+
+exception when others then rollback; raise;
+end //
+
+-- inserts _v before or after the first occurrence of _pivot, returning the new
+-- length of the list, 0 if _k does not exist or -1 if _pivot was not found
+-- only the elements on the shorter side of the pivot are shifted
+create or replace procedure listInsert(_db int, _k longblob, _before bool, _pivot longblob, _v longblob)
+returns bigint as
+declare
+  _locked boolean;
+  _q query(pos bigint) = select min(pos) from listvalues where db = _db and k = _k and v = _pivot;
+  _pivot_pos bigint;
+  _split bigint;
+  _head bigint;
+  _tail bigint;
+begin
+  start transaction;
+  _locked = listLock(_db, _k);
+  if not _locked then
+    commit;
+    return 0;
+  end if;
+
+  _pivot_pos = scalar(_q);
+  if _pivot_pos is null then
+    commit;
+    return -1;
+  end if;
+
+  -- elements before _split end up before _v
+  _split = if(_before, _pivot_pos, _pivot_pos + 1);
+  select (select count(*) from listvalues where db = _db and k = _k and pos < _split) into _head;
+  select (select count(*) from listvalues where db = _db and k = _k and pos >= _split) into _tail;
+
+  if _head < _tail then
+    update listvalues set pos = pos - 1 where db = _db and k = _k and pos < _split;
+    insert into listvalues (db, k, v, pos) values (_db, _k, _v, _split - 1);
+  else
+    update listvalues set pos = pos + 1 where db = _db and k = _k and pos >= _split;
+    insert into listvalues (db, k, v, pos) values (_db, _k, _v, _split);
+  end if;
+
+  commit;
+  return _head + _tail + 1;
+
+exception when others then rollback; raise;
+end //
+
+-- returns the indices of elements equal to _v, skipping to the abs(_rank)th
+-- match and scanning from the tail if _rank is negative
+-- _count and _maxlen limit the matches returned and elements scanned, 0
+-- meaning unlimited
+create or replace function listPositions(_db int, _k longblob, _v longblob, _rank bigint, _count bigint, _maxlen bigint)
+returns table as return
+  select idx
+  from (
+    select idx, row_number() over (order by _scan) as _match
+    from (
+      select v,
+        (row_number() over (order by pos)) - 1 as idx,
+        row_number() over (order by if(_rank < 0, -pos, pos)) as _scan
+      from listvalues
+      where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k)
+    )
+    where v = _v and (_maxlen = 0 or _scan <= _maxlen)
+  )
+  where _match >= abs(_rank) and (_count = 0 or _match < abs(_rank) + _count)
+  order by _match //
+
+-- keeps only the elements between _start and _stop (inclusive), where
+-- negative indices count back from the tail
+create or replace procedure listTrim(_db int, _k longblob, _start bigint, _stop bigint)
+as
+declare
+  _locked boolean;
+  _len bigint;
+  _lo bigint;
+  _hi bigint;
+begin
+  start transaction;
+  _locked = listLock(_db, _k);
+  if _locked then
+    select (select count(*) from listvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k)) into _len;
+    if _start < 0 then
+      _start = _start + _len;
+    end if;
+    if _stop < 0 then
+      _stop = _stop + _len;
+    end if;
+    if _start < 0 then
+      _start = 0;
+    end if;
+    if _stop >= _len then
+      _stop = _len - 1;
+    end if;
+
+    if _start > _stop then
+      delete from listvalues where db = _db and k = _k;
+    else
+      select (select pos from listSeek(_db, _k, _start)) into _lo;
+      select (select pos from listSeek(_db, _k, _stop)) into _hi;
+      delete from listvalues where db = _db and k = _k and (pos < _lo or pos > _hi);
+    end if;
+
+    delete from keyspace where db = _db and k = _k and not exists(select 1 from listvalues where db = _db and k = _k);
+  end if;
+  commit;
+
+exception when others then rollback; raise;
+end //
+
+-- adds _values to _k, returning the number of members which were not already
+-- in the set
+create or replace procedure setAdd(_db int, _k longblob, _values array(longblob))
+returns bigint as
+declare
+  _rowcount bigint;
+begin
+  start transaction;
+  call assertKey(_db, _k, "set");
+  insert ignore into setvalues (db, k, v) select _db, _k, table_col from table(_values);
+  _rowcount = row_count();
+  commit;
+  return _rowcount;
+end //
+
+-- removes _values from _k, returning the number of members removed
+create or replace procedure setRemove(_db int, _k longblob, _values array(longblob))
+returns bigint as
+declare
+  _rowcount bigint;
+begin
+  start transaction;
+  call assertKey(_db, _k, "set");
+  delete from setvalues where db = _db and k = _k and v in (select table_col from table(_values));
+  _rowcount = row_count();
+
+  delete from keyspace where db = _db and k = _k and not exists(select 1 from setvalues where db = _db and k = _k);
+
+  commit;
+  return _rowcount;
+end //
+
+create or replace function setGet(_db int, _k longblob)
+returns table as return
+  select v from setvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k) //
+
+-- builds a query combining the sets at _keys with _op: "union", "inter" or
+-- "diff", where diff returns the members of _keys[0] which are not in any of
+-- the other keys
+create or replace function setCombineQuery(_db int, _op text, _keys array(longblob))
+returns longtext as
+declare
+  _list longtext = "";
+  _tables longtext = "setvalues s0";
+  _joins longtext = concat("s0.db = ", _db, " and s0.k = ", quoteBinary(_keys[0]), " and ", keyLive(_db, _keys[0]));
+begin
+  if _op = "union" then
+    for i in 0 .. length(_keys) - 1 loop
+      if i > 0 then
+        _list = concat(_list, ",");
+      end if;
+
+      _list = concat(_list, quoteBinary(_keys[i]));
+    end loop;
+
+    return concat("select distinct(v) as v from setvalues where db = ", _db, " and k in (", _list, ") and k in (select k from keyspace where db = ", _db, ")");
+  end if;
+
+  if _op = "diff" then
+    for i in 1 .. length(_keys) - 1 loop
+      if i > 1 then
+        _list = concat(_list, ",");
+      end if;
+
+      _list = concat(_list, quoteBinary(_keys[i]));
+    end loop;
+
+    if _list = "" then
+      return concat("select v from setvalues where db = ", _db, " and k = ", quoteBinary(_keys[0]), " and ", keyLive(_db, _keys[0]));
+    end if;
+    return concat(
+      "select v from setvalues where db = ", _db, " and k = ", quoteBinary(_keys[0]), " and ", keyLive(_db, _keys[0]),
+      " and v not in (select v from setvalues where db = ", _db, " and k in (", _list, ") and k in (select k from keyspace where db = ", _db, "))");
+  end if;
+
+  for i in 1 .. length(_keys) - 1 loop
+    _tables = concat(_tables, ", setvalues s", i);
+    _joins = concat(
+      _joins,
+      -- and s1.db = _db and s1.k = _keys[1]
+      " and s", i, ".db = ", _db, " and s", i, ".k = ", quoteBinary(_keys[i]), " and ", keyLive(_db, _keys[i]),
+      -- and s0.v = s1.v
+      " and s0.v = s", i, ".v"
+    );
+  end loop;
+
+  return concat("select distinct(s0.v) as v from ", _tables, " where ", _joins);
+end //
+
+create or replace procedure setUnion(_db int, _keys array(longblob))
+returns query(v longblob) as
+begin
+  return to_query(setCombineQuery(_db, "union", _keys));
+end //
+
+create or replace procedure setIntersect(_db int, _keys array(longblob))
+returns query(v longblob) as
+begin
+  return to_query(setCombineQuery(_db, "inter", _keys));
+end //
+
+create or replace procedure setDiff(_db int, _keys array(longblob))
+returns query(v longblob) as
+begin
+  return to_query(setCombineQuery(_db, "diff", _keys));
+end //
+
+-- replaces _dest with the combination of the sets at _keys (see
+-- setCombineQuery), returning its cardinality
+create or replace procedure setCombineStore(_db int, _dest longblob, _op text, _keys array(longblob))
+returns bigint as
+declare
+  _q longtext = setCombineQuery(_db, _op, _keys);
+  _staging longblob = concat(_dest, unhex("00"), "staging:", connection_id());
+  _dest_is_source bool = false;
+  _existed bool;
+  _count bigint;
+begin
+  start transaction;
+
+  for i in 0 .. length(_keys) - 1 loop
+    if _keys[i] = _dest then
+      _dest_is_source = true;
+    end if;
+  end loop;
+
+  if _dest_is_source then
+    -- the result depends on _dest, so it is staged under a private key
+    -- before _dest is cleared
+    execute immediate concat(
+      "insert into setvalues (db, k, v) select ", _db, ", ", quoteBinary(_staging), ", v from (", _q, ")");
+    _existed = keyClear(_db, _dest);
+    insert into setvalues (db, k, v) select _db, _dest, v from setvalues where db = _db and k = _staging;
+    _count = row_count();
+    delete from setvalues where db = _db and k = _staging;
+  else
+    _existed = keyClear(_db, _dest);
+    execute immediate concat(
+      "insert into setvalues (db, k, v) select ", _db, ", ", quoteBinary(_dest), ", v from (", _q, ")");
+    _count = row_count();
+  end if;
+
+  if _count > 0 then
+    insert into keyspace (db, k, t) values (_db, _dest, "set");
+  end if;
+
+  commit;
+  return _count;
+
+exception when others then rollback; raise;
+end //
+
+create or replace function setsWithMember(_db int, _v longblob)
+returns table as return
+    select s.k from setvalues s join keyspace ks on ks.db = s.db and ks.k = s.k
+    where s.db = _db and s.v = _v //
+
+create or replace function setCardinality(_db int, _k longblob)
+returns table as return
+    select count(*) from setvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k) //
+
+-- counts the members of the intersection of _keys, stopping once _limit
+-- members have been found unless _limit is 0
+create or replace procedure setIntersectCardinality(_db int, _keys array(longblob), _limit bigint)
+returns query(c bigint) as
+declare
+  _q longtext = setCombineQuery(_db, "inter", _keys);
+begin
+  if _limit > 0 then
+    _q = concat(_q, " limit ", _limit);
+  end if;
+  return to_query(concat("select count(*) as c from (", _q, ")"));
+end //
+
+-- returns up to _count members of _k like _prefix following _after (or from
+-- the start if it is null), flagging those matching _regexp
+create or replace procedure setScan(_db int, _k longblob, _after longblob, _count bigint, _prefix longblob, _regexp longblob)
+returns query(v longblob, matched bool) as
+begin
+  return to_query(concat(
+    "select v, v rlike ", quoteBinary(_regexp), " as matched",
+    " from setvalues where db = ", _db, " and k = ", quoteBinary(_k), " and ", keyLive(_db, _k), " and v like ", quoteBinary(_prefix),
+    if(_after is null, "", concat(" and v > ", quoteBinary(_after))),
+    " order by v limit ", _count));
+end //
+
+create or replace function setIsMember(_db int, _k longblob, _v longblob)
+returns table as return
+  select exists(select 1 from setvalues where db = _db and k = _k and v = _v and exists(select 1 from keyspace where db = _db and k = _k)) as found //
+
+create or replace procedure setMultiIsMember(_db int, _k longblob, _members array(longblob))
+returns query(v longblob) as
+declare
+  _q longtext = concat("select v from setvalues where db = ", _db, " and k = ", quoteBinary(_k), " and ", keyLive(_db, _k), " and v in (");
+begin
+  for i in 0 .. length(_members) - 1 loop
+    if i > 0 then
+      _q = concat(_q, ",");
+    end if;
+
+    _q = concat(_q, quoteBinary(_members[i]));
+  end loop;
+
+  _q = concat(_q, ")");
+
+  return to_query(_q);
+end //
+
+-- removes and returns up to _count random members of _k, deleting the key
+-- once the set is empty
+create or replace procedure setPop(_db int, _k longblob, _count bigint)
+returns query(v longblob) as
+declare
+  _q query(v longblob) =
+    select v
+    from (
+      select v, row_number() over (order by rand()) as _rownum
+      from setvalues
+      where db = _db and k = _k
+    )
+    where _rownum <= _count;
+  _rows array(record(v longblob));
+  _v longblob;
+  _out longtext = "";
+begin
+  start transaction;
+  call assertKey(_db, _k, "set");
+
+  _rows = collect(_q);
+  for i in 0 .. length(_rows) - 1 loop
+    _v = _rows[i].v;
+    delete from setvalues where db = _db and k = _k and v = _v;
+    _out = concat(_out, if(i > 0, " union all ", ""), "select ", quoteBinary(_v), " as v");
+  end loop;
+
+  delete from keyspace where db = _db and k = _k and not exists(select 1 from setvalues where db = _db and k = _k);
+
+  commit;
+
+  if length(_rows) = 0 then
+    return to_query("select v from setvalues limit 0");
+  end if;
+  return to_query(_out);
+
+exception when others then rollback; raise;
+end //
+
+-- returns _count random members of _k, distinct unless _repeat is set
+create or replace procedure setRandomMembers(_db int, _k longblob, _count bigint, _repeat bool)
+returns query(v longblob) as
+declare
+  _len_q query(c bigint) = select count(*) from setvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k);
+  _len bigint;
+  _picks array(bigint);
+  _q query(v longblob) =
+    select s.v
+    from (
+      select v, row_number() over (order by v) - 1 as n
+      from setvalues
+      where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k)
+    ) s
+    join table(_picks) p on s.n = p.table_col;
+begin
+  if not _repeat then
+    return to_query(concat(
+      "select v from setvalues where db = ", _db, " and k = ", quoteBinary(_k), " and ", keyLive(_db, _k),
+      " order by rand() limit ", _count));
+  end if;
+
+  _len = scalar(_len_q);
+  if _len = 0 or _count = 0 then
+    return to_query("select v from setvalues limit 0");
+  end if;
+
+  _picks = create_array(_count);
+  for i in 0 .. _count - 1 loop
+    _picks[i] = floor(rand() * _len);
+  end loop;
+  return _q;
+end //
+
+-- moves _v from _src to _dest, returning whether it was a member of _src
+create or replace procedure setMove(_db int, _src longblob, _dest longblob, _v longblob)
+returns bool as
+declare
+  _t text;
+  _moved bool;
+begin
+  start transaction;
+  select (select t from keyspace where db = _db and k = _src) into _t;
+  if _t is not null and _t != "set" then
+    raise user_exception(concat("type mismatch; got ", _t, ", expected set"));
+  end if;
+  select (select t from keyspace where db = _db and k = _dest) into _t;
+  if _t is not null and _t != "set" then
+    raise user_exception(concat("type mismatch; got ", _t, ", expected set"));
+  end if;
+
+  if _src = _dest then
+    select exists(select 1 from setvalues where db = _db and k = _src and v = _v) into _moved;
+    commit;
+    return _moved;
+  end if;
+
+  delete from setvalues where db = _db and k = _src and v = _v;
+  _moved = row_count() > 0;
+  if _moved then
+    call assertKey(_db, _dest, "set");
+    insert ignore into setvalues (db, k, v) values (_db, _dest, _v);
+    delete from keyspace where db = _db and k = _src and not exists(select 1 from setvalues where db = _db and k = _src);
+  end if;
+
+  commit;
+  return _moved;
+
+exception when others then rollback; raise;
+end //
+
+create or replace procedure hashSet(_db int, _k longblob, _fields array(longblob), _values array(longblob))
+returns bigint as
+declare
+  _f longblob;
+  _existed bool;
+  _added bigint = 0;
+begin
+  start transaction;
+  call assertKey(_db, _k, "hash");
+
+  for i in 0 .. length(_fields) - 1 loop
+    _f = _fields[i];
+    select exists(select 1 from hashvalues where db = _db and k = _k and f = _f) into _existed;
+    insert into hashvalues (db, k, f, v) values (_db, _k, _f, _values[i])
+      on duplicate key update v = values(v);
+    if not _existed then
+      _added = _added + 1;
+    end if;
+  end loop;
+
+  commit;
+  return _added;
+end //
+
+create or replace procedure hashSetNX(_db int, _k longblob, _f longblob, _v longblob)
+returns int as
+declare
+  _rowcount int;
+begin
+  start transaction;
+  call assertKey(_db, _k, "hash");
+  insert ignore into hashvalues (db, k, f, v) values (_db, _k, _f, _v);
+  _rowcount = row_count();
+  commit;
+  return _rowcount;
+end //
+
+create or replace function hashGet(_db int, _k longblob, _f longblob)
+returns table as return
+  select (select v from hashvalues where db = _db and k = _k and f = _f and exists(select 1 from keyspace where db = _db and k = _k)) as v //
+
+create or replace procedure hashMultiGet(_db int, _k longblob, _fields array(longblob))
+returns query(f longblob, v longblob) as
+declare
+  _q longtext = concat("select f, v from hashvalues where db = ", _db, " and k = ", quoteBinary(_k), " and ", keyLive(_db, _k), " and f in (");
+begin
+  for i in 0 .. length(_fields) - 1 loop
+    if i > 0 then
+      _q = concat(_q, ",");
+    end if;
+
+    _q = concat(_q, quoteBinary(_fields[i]));
+  end loop;
+
+  _q = concat(_q, ")");
+
+  return to_query(_q);
+end //
+
+create or replace function hashGetAll(_db int, _k longblob)
+returns table as return
+  select f, v from hashvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k) //
+
+create or replace procedure hashDelete(_db int, _k longblob, _fields array(longblob))
+returns bigint as
+declare
+  _f longblob;
+  _removed bigint = 0;
+begin
+  start transaction;
+  call assertKey(_db, _k, "hash");
+
+  for i in 0 .. length(_fields) - 1 loop
+    _f = _fields[i];
+    delete from hashvalues where db = _db and k = _k and f = _f;
+    _removed = _removed + row_count();
+  end loop;
+
+  -- like redis, a hash without fields doesn't exist
+  delete from keyspace where db = _db and k = _k and not exists(select 1 from hashvalues where db = _db and k = _k);
+
+  commit;
+  return _removed;
+end //
+
+create or replace function hashExists(_db int, _k longblob, _f longblob)
+returns table as return
+  select exists(select 1 from hashvalues where db = _db and k = _k and f = _f and exists(select 1 from keyspace where db = _db and k = _k)) //
+
+create or replace function hashLength(_db int, _k longblob)
+returns table as return
+  select count(*) from hashvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k) //
+
+create or replace function hashStrLength(_db int, _k longblob, _f longblob)
+returns table as return
+  select ifnull((select length(v) from hashvalues where db = _db and k = _k and f = _f and exists(select 1 from keyspace where db = _db and k = _k)), 0) //
+
+create or replace procedure hashIncrBy(_db int, _k longblob, _f longblob, _v bigint)
+returns bigint as
+declare
+  _ret_q query(v bigint) = select v :> bigint from hashvalues where db = _db and k = _k and f = _f;
+  _ret bigint;
+begin
+  start transaction;
+  call assertKey(_db, _k, "hash");
+
+  insert into hashvalues (db, k, f, v) values (_db, _k, _f, _v)
+    on duplicate key update v = integerAdd(v, _v);
+
+  _ret = scalar(_ret_q);
+
+  commit;
+
+  return _ret;
+end //
+
+create or replace procedure hashIncrByFloat(_db int, _k longblob, _f longblob, _v double)
+returns text as
+declare
+  _ret_q query(v text) = select v :> text from hashvalues where db = _db and k = _k and f = _f;
+  _ret text;
+begin
+  start transaction;
+  call assertKey(_db, _k, "hash");
+
+  insert into hashvalues (db, k, f, v) values (_db, _k, _f, formatFloat(_v))
+    on duplicate key update v = floatAdd(v, _v);
+
+  _ret = scalar(_ret_q);
+
+  commit;
+
+  return _ret;
+end //
+
+-- returns up to _count fields of _k like _prefix following _after (or from
+-- the start if it is null), flagging those matching _regexp
+create or replace procedure hashScan(_db int, _k longblob, _after longblob, _count bigint, _prefix longblob, _regexp longblob)
+returns query(f longblob, v longblob, matched bool) as
+begin
+  return to_query(concat(
+    "select f, v, f rlike ", quoteBinary(_regexp), " as matched",
+    " from hashvalues where db = ", _db, " and k = ", quoteBinary(_k), " and ", keyLive(_db, _k), " and f like ", quoteBinary(_prefix),
+    if(_after is null, "", concat(" and f > ", quoteBinary(_after))),
+    " order by f limit ", _count));
+end //
+
+-- returns _count random fields of _k; when _repeat is set fields are picked
+-- independently, so the same field may be returned more than once
+create or replace procedure hashRandomFields(_db int, _k longblob, _count bigint, _repeat bool)
+returns query(f longblob, v longblob) as
+declare
+  _len_q query(c bigint) = select count(*) from hashvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k);
+  _len bigint;
+  _picks array(bigint);
+  _q query(f longblob, v longblob) =
+    select h.f, h.v
+    from (
+      select f, v, row_number() over (order by f) - 1 as n
+      from hashvalues
+      where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k)
+    ) h
+    join table(_picks) p on h.n = p.table_col;
+begin
+  if not _repeat then
+    return to_query(concat(
+      "select f, v from hashvalues where db = ", _db, " and k = ", quoteBinary(_k), " and ", keyLive(_db, _k),
+      " order by rand() limit ", _count));
+  end if;
+
+  _len = scalar(_len_q);
+  if _len = 0 or _count = 0 then
+    return to_query("select f, v from hashvalues limit 0");
+  end if;
+
+  _picks = create_array(_count);
+  for i in 0 .. _count - 1 loop
+    _picks[i] = floor(rand() * _len);
+  end loop;
+  return _q;
+end //
+
+-- returns up to _count members of _k like _prefix following _after (or from
+-- the start if it is null), flagging those matching _regexp
+create or replace procedure zsetScan(_db int, _k longblob, _after longblob, _count bigint, _prefix longblob, _regexp longblob)
+returns query(member longblob, score double, matched bool) as
+begin
+  return to_query(concat(
+    "select member, score, member rlike ", quoteBinary(_regexp), " as matched",
+    " from zsetvalues where db = ", _db, " and k = ", quoteBinary(_k), " and ", keyLive(_db, _k), " and member like ", quoteBinary(_prefix),
+    if(_after is null, "", concat(" and member > ", quoteBinary(_after))),
+    " order by member limit ", _count));
+end //
+
+-- adds or updates members of _k following the ZADD flags, returning the
+-- number of members added (plus the number updated if _ch is set)
+create or replace procedure zsetAdd(
+  _db int,
+  _k longblob,
+  _members array(longblob),
+  _scores array(double),
+  _nx bool, _xx bool, _gt bool, _lt bool, _ch bool
+) returns bigint as
+declare
+  _m longblob;
+  _s double;
+  _cur double;
+  _added bigint = 0;
+  _changed bigint = 0;
+begin
+  start transaction;
+  call assertKey(_db, _k, "zset");
+
+  for i in 0 .. length(_members) - 1 loop
+    _m = _members[i];
+    _s = _scores[i];
+    select (select score from zsetvalues where db = _db and k = _k and member = _m) into _cur;
+
+    if _cur is null then
+      if not _xx then
+        insert into zsetvalues (db, k, member, score) values (_db, _k, _m, _s);
+        _added = _added + 1;
+      end if;
+    elsif not _nx and _s != _cur and (not _gt or _s > _cur) and (not _lt or _s < _cur) then
+      update zsetvalues set score = _s where db = _db and k = _k and member = _m;
+      _changed = _changed + 1;
+    end if;
+  end loop;
+
+  -- XX against a missing key must not create it
+  delete from keyspace where db = _db and k = _k and not exists(select 1 from zsetvalues where db = _db and k = _k);
+
+  commit;
+  return if(_ch, _added + _changed, _added);
+end //
+
+-- increments the score of _m by _delta following the ZADD flags, returning
+-- the new score or null if the flags prevented the update
+create or replace procedure zsetIncrBy(
+  _db int,
+  _k longblob,
+  _m longblob,
+  _delta double,
+  _nx bool, _xx bool, _gt bool, _lt bool
+) returns double as
+declare
+  _cur_q query(s double) = select (select score from zsetvalues where db = _db and k = _k and member = _m);
+  _cur double;
+  _new double;
+begin
+  start transaction;
+  call assertKey(_db, _k, "zset");
+
+  _cur = scalar(_cur_q);
+  if _cur is null then
+    if _xx then
+      delete from keyspace where db = _db and k = _k and not exists(select 1 from zsetvalues where db = _db and k = _k);
+      commit;
+      return null;
+    end if;
+    _new = _delta;
+    insert into zsetvalues (db, k, member, score) values (_db, _k, _m, _new);
+  else
+    _new = _cur + _delta;
+    if _nx or (_gt and _new <= _cur) or (_lt and _new >= _cur) then
+      commit;
+      return null;
+    end if;
+    update zsetvalues set score = _new where db = _db and k = _k and member = _m;
+  end if;
+
+  commit;
+  return _new;
+end //
+
+create or replace procedure zsetRemove(_db int, _k longblob, _members array(longblob))
+returns bigint as
+declare
+  _m longblob;
+  _removed bigint = 0;
+begin
+  start transaction;
+  call assertKey(_db, _k, "zset");
+
+  for i in 0 .. length(_members) - 1 loop
+    _m = _members[i];
+    delete from zsetvalues where db = _db and k = _k and member = _m;
+    _removed = _removed + row_count();
+  end loop;
+
+  delete from keyspace where db = _db and k = _k and not exists(select 1 from zsetvalues where db = _db and k = _k);
+
+  commit;
+  return _removed;
+end //
+
+create or replace function zsetScore(_db int, _k longblob, _m longblob)
+returns table as return
+  select (select score from zsetvalues where db = _db and k = _k and member = _m and exists(select 1 from keyspace where db = _db and k = _k)) as score //
+
+create or replace function zsetCardinality(_db int, _k longblob)
+returns table as return
+  select count(*) from zsetvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k) //
+
+create or replace function zsetCount(_db int, _k longblob, _min double, _minex bool, _max double, _maxex bool)
+returns table as return
+  select count(*) from zsetvalues
+    where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k)
+      and (score > _min or (not _minex and score = _min))
+      and (score < _max or (not _maxex and score = _max)) //
+
+-- returns the 0 based rank of _m, counting from the highest score if _rev
+-- is set, or null if _m is not a member
+create or replace function zsetRank(_db int, _k longblob, _m longblob, _rev bool)
+returns table as return
+  select (
+    select _rank from (
+      select member, row_number() over (
+        order by
+          case when _rev then score end desc, case when _rev then member end desc,
+          score, member
+      ) - 1 as _rank
+      from zsetvalues
+      where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k)
+    )
+    where member = _m
+  ) as _rank //
+
+-- retrieves members between ranks _start and _stop (inclusive), which like
+-- redis may be negative to count back from the end
+create or replace function zsetRangeByRank(_db int, _k longblob, _start bigint, _stop bigint, _rev bool)
+returns table as return
+  select member, score
+  from (
+    select member, score, row_number() over (
+      order by
+        case when _rev then score end desc, case when _rev then member end desc,
+        score, member
+    ) - 1 as _rownum, count(*) over () as _len
+    from zsetvalues
+    where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k)
+  )
+  where _rownum >= if(_start < 0, _len + _start, _start)
+    and _rownum <= if(_stop < 0, _len + _stop, _stop)
+  order by _rownum asc //
+
+-- retrieves members with scores between _min and _max, skipping _offset of
+-- them and returning at most _count (or all of them if _count is negative)
+create or replace function zsetRangeByScore(
+  _db int,
+  _k longblob,
+  _min double, _minex bool,
+  _max double, _maxex bool,
+  _rev bool, _offset bigint, _count bigint
+) returns table as return
+  select member, score
+  from (
+    select member, score, row_number() over (
+      order by
+        case when _rev then score end desc, case when _rev then member end desc,
+        score, member
+    ) - 1 as _rownum
+    from zsetvalues
+    where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k)
+      and (score > _min or (not _minex and score = _min))
+      and (score < _max or (not _maxex and score = _max))
+  )
+  where _rownum >= _offset and (_count < 0 or _rownum < _offset + _count)
+  order by _rownum asc //
+
+-- like zsetRangeByScore but compares members, a null bound is unbounded
+create or replace function zsetRangeByLex(
+  _db int,
+  _k longblob,
+  _min longblob, _minex bool,
+  _max longblob, _maxex bool,
+  _rev bool, _offset bigint, _count bigint
+) returns table as return
+  select member, score
+  from (
+    select member, score, row_number() over (
+      order by
+        case when _rev then member end desc,
+        member
+    ) - 1 as _rownum
+    from zsetvalues
+    where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k)
+      and (_min is null or member > _min or (not _minex and member = _min))
+      and (_max is null or member < _max or (not _maxex and member = _max))
+  )
+  where _rownum >= _offset and (_count < 0 or _rownum < _offset + _count)
+  order by _rownum asc //
+
+-- removes and returns up to _count members with the lowest scores, or the
+-- highest if _max is set
+create or replace procedure zsetPop(_db int, _k longblob, _count bigint, _max bool)
+returns query(member longblob, score double) as
+declare
+  _q query(member longblob, score double) = select member, score from zsetRangeByRank(_db, _k, 0, _count - 1, _max);
+  _rows array(record(member longblob, score double));
+  _m longblob;
+  _out longtext = "";
+begin
+  start transaction;
+  call assertKey(_db, _k, "zset");
+
+  _rows = collect(_q);
+  for i in 0 .. length(_rows) - 1 loop
+    _m = _rows[i].member;
+    delete from zsetvalues where db = _db and k = _k and member = _m;
+    _out = concat(_out, if(i > 0, " union all ", ""),
+      "select ", quoteBinary(_m), " as member, ", _rows[i].score, " as score, ", i, " as i");
+  end loop;
+
+  delete from keyspace where db = _db and k = _k and not exists(select 1 from zsetvalues where db = _db and k = _k);
+
+  commit;
+
+  if length(_rows) = 0 then
+    return to_query("select member, score from zsetvalues limit 0");
+  end if;
+  return to_query(concat("select member, score from (", _out, ") order by i"));
+
+exception when others then rollback; raise;
+end //
+
+create or replace procedure zsetRemoveRangeByRank(_db int, _k longblob, _start bigint, _stop bigint)
+returns bigint as
+declare
+  _rowcount bigint;
+begin
+  start transaction;
+  call assertKey(_db, _k, "zset");
+
+  delete from zsetvalues where db = _db and k = _k and member in (
+    select member from zsetRangeByRank(_db, _k, _start, _stop, false));
+  _rowcount = row_count();
+
+  delete from keyspace where db = _db and k = _k and not exists(select 1 from zsetvalues where db = _db and k = _k);
+
+  commit;
+  return _rowcount;
+end //
+
+create or replace procedure zsetRemoveRangeByScore(_db int, _k longblob, _min double, _minex bool, _max double, _maxex bool)
+returns bigint as
+declare
+  _rowcount bigint;
+begin
+  start transaction;
+  call assertKey(_db, _k, "zset");
+
+  delete from zsetvalues
+    where db = _db and k = _k
+      and (score > _min or (not _minex and score = _min))
+      and (score < _max or (not _maxex and score = _max));
+  _rowcount = row_count();
+
+  delete from keyspace where db = _db and k = _k and not exists(select 1 from zsetvalues where db = _db and k = _k);
+
+  commit;
+  return _rowcount;
+end //
+
+create or replace procedure zsetRemoveRangeByLex(_db int, _k longblob, _min longblob, _minex bool, _max longblob, _maxex bool)
+returns bigint as
+declare
+  _rowcount bigint;
+begin
+  start transaction;
+  call assertKey(_db, _k, "zset");
+
+  delete from zsetvalues
+    where db = _db and k = _k
+      and (_min is null or member > _min or (not _minex and member = _min))
+      and (_max is null or member < _max or (not _maxex and member = _max));
+  _rowcount = row_count();
+
+  delete from keyspace where db = _db and k = _k and not exists(select 1 from zsetvalues where db = _db and k = _k);
+
+  commit;
+  return _rowcount;
+end //
+
+-- raises unless every key in _keys is missing, a sorted set or a set
+create or replace procedure zsetAssertSources(_db int, _keys array(longblob))
+as
+declare
+  _key longblob;
+  _t text;
+begin
+  for i in 0 .. length(_keys) - 1 loop
+    _key = _keys[i];
+    select (select t from keyspace where db = _db and k = _key) into _t;
+    if _t is not null and _t != "zset" and _t != "set" then
+      raise user_exception(concat("type mismatch; got ", _t, ", expected zset"));
+    end if;
+  end loop;
+end //
+
+-- builds a query combining the sorted sets at _keys with _op ("union",
+-- "inter" or "diff"); plain sets are treated as sorted sets where every
+-- member has a score of 1
+-- scores are multiplied by _weights and then merged with _aggregate ("sum",
+-- "min" or "max"); diff always keeps the scores of the first key
+create or replace function zsetCombineQuery(_db int, _op text, _keys array(longblob), _weights array(double), _aggregate text)
+returns longtext as
+declare
+  _sources longtext = "";
+begin
+  for i in 0 .. length(_keys) - 1 loop
+    if i > 0 then
+      _sources = concat(_sources, " union all ");
+    end if;
+
+    _sources = concat(_sources,
+      -- select 0 as src, member, score * 2 as score from zsetvalues where db = 0 and k = 'a'
+      "select ", i, " as src, member, score * ", _weights[i], " as score",
+      " from zsetvalues where db = ", _db, " and k = ", quoteBinary(_keys[i]), " and ", keyLive(_db, _keys[i]),
+      -- union all select 0, v, 2 from setvalues where db = 0 and k = 'a'
+      " union all select ", i, ", v, ", _weights[i],
+      " from setvalues where db = ", _db, " and k = ", quoteBinary(_keys[i]), " and ", keyLive(_db, _keys[i]));
+  end loop;
+
+  if _op = "union" then
+    return concat(
+      "select member, ", _aggregate, "(score) as score from (", _sources, ") group by member");
+  elsif _op = "inter" then
+    return concat(
+      "select member, ", _aggregate, "(score) as score from (", _sources, ") group by member",
+      " having count(distinct src) = ", length(_keys));
+  end if;
+
+  return concat(
+    "select member, max(score) as score from (", _sources, ") group by member",
+    " having max(src) = 0");
+end //
+
+create or replace procedure zsetCombine(_db int, _op text, _keys array(longblob), _weights array(double), _aggregate text)
+returns query(member longblob, score double) as
+begin
+  call zsetAssertSources(_db, _keys);
+
+  return to_query(concat(
+    "select member, score from (", zsetCombineQuery(_db, _op, _keys, _weights, _aggregate), ")",
+    " order by score, member"));
+end //
+
+-- like zsetCombine but replaces _dest with the result, returning its
+-- cardinality
+create or replace procedure zsetCombineStore(_db int, _dest longblob, _op text, _keys array(longblob), _weights array(double), _aggregate text)
+returns bigint as
+declare
+  _q longtext = zsetCombineQuery(_db, _op, _keys, _weights, _aggregate);
+  _staging longblob = concat(_dest, unhex("00"), "staging:", connection_id());
+  _dest_is_source bool = false;
+  _existed bool;
+  _count bigint;
+begin
+  start transaction;
+  call zsetAssertSources(_db, _keys);
+
+  for i in 0 .. length(_keys) - 1 loop
+    if _keys[i] = _dest then
+      _dest_is_source = true;
+    end if;
+  end loop;
+
+  if _dest_is_source then
+    -- the result depends on _dest, so it is staged under a private key
+    -- before _dest is cleared
+    execute immediate concat(
+      "insert into zsetvalues (db, k, member, score) select ", _db, ", ", quoteBinary(_staging), ", member, score from (", _q, ")");
+    _existed = keyClear(_db, _dest);
+    insert into zsetvalues (db, k, member, score)
+      select _db, _dest, member, score from zsetvalues where db = _db and k = _staging;
+    _count = row_count();
+    delete from zsetvalues where db = _db and k = _staging;
+  else
+    _existed = keyClear(_db, _dest);
+    execute immediate concat(
+      "insert into zsetvalues (db, k, member, score) select ", _db, ", ", quoteBinary(_dest), ", member, score from (", _q, ")");
+    _count = row_count();
+  end if;
+
+  if _count > 0 then
+    insert into keyspace (db, k, t) values (_db, _dest, "zset");
+  end if;
+
+  commit;
+  return _count;
+
+exception when others then rollback; raise;
+end //
+
+-- the current time in milliseconds since the epoch, as used by stream IDs
+create or replace function nowMs() returns bigint unsigned as
+begin
+  return floor(unix_timestamp(now(6)) * 1000);
+end //
+
+-- streamLock must be used within a transaction
+-- locks _k, returning false if it doesn't exist and raising if it isn't a
+-- stream
+create or replace procedure streamLock(_db int, _k longblob)
+returns boolean as
+declare
+  _q query(t text) = select t from keyspace where db = _db and k = _k for update;
+  _rows array(record(t text));
+begin
+  _rows = collect(_q);
+  if length(_rows) = 0 then
+    return false;
+  end if;
+  if _rows[0].t != "stream" then
+    raise user_exception(concat("type mismatch; got ", _rows[0].t, ", expected stream"));
+  end if;
+  return true;
+end //
+
+-- streamTrimEntries must be used within a transaction holding
+-- streamLock(_db, _k)
+-- deletes the oldest entries of _k beyond the newest _maxlen, or if _maxlen
+-- is null those before _min_ms-_min_seq, deleting at most _limit entries
+-- unless it is 0; returns how many were deleted
+create or replace procedure streamTrimEntries(
+  _db int, _k longblob,
+  _maxlen bigint, _min_ms bigint unsigned, _min_seq bigint unsigned, _limit bigint
+) returns bigint as
+declare
+  _len_q query(n bigint) = select count(*) from streamentries where db = _db and k = _k;
+  _older_q query(n bigint) =
+    select count(*) from streamentries
+    where db = _db and k = _k and (id_ms < _min_ms or (id_ms = _min_ms and id_seq < _min_seq));
+  _n bigint;
+  _cut_q query(id_ms bigint unsigned, id_seq bigint unsigned) =
+    select id_ms, id_seq
+    from (
+      select id_ms, id_seq, row_number() over (order by id_ms, id_seq) as _rownum
+      from streamentries
+      where db = _db and k = _k
+    )
+    where _rownum = _n;
+  _cut array(record(id_ms bigint unsigned, id_seq bigint unsigned));
+begin
+  if _maxlen is not null then
+    _n = scalar(_len_q) - _maxlen;
+  elsif _min_ms is not null then
+    _n = scalar(_older_q);
+  else
+    return 0;
+  end if;
+  if _limit > 0 and _n > _limit then
+    _n = _limit;
+  end if;
+  if _n <= 0 then
+    return 0;
+  end if;
+
+  -- the newest entry to delete
+  _cut = collect(_cut_q);
+  delete from streamentries
+    where db = _db and k = _k
+      and (id_ms < _cut[0].id_ms or (id_ms = _cut[0].id_ms and id_seq <= _cut[0].id_seq));
+  return row_count();
+end //
+
+-- appends an entry holding _fields to _k, returning its ID as "ms-seq", or
+-- null if _nomkstream is set and _k doesn't exist
+-- a null _ms takes the current time and a null _seq the next sequence number
+-- for _ms; the ID must be greater than the last one added to _k
+-- the stream is then trimmed as by streamTrimEntries
+create or replace procedure streamAdd(
+  _db int, _k longblob,
+  _ms bigint unsigned, _seq bigint unsigned, _fields longblob, _nomkstream bool,
+  _maxlen bigint, _min_ms bigint unsigned, _min_seq bigint unsigned, _limit bigint
+) returns text as
+declare
+  _last_q query(last_ms bigint unsigned, last_seq bigint unsigned) =
+    select last_ms, last_seq from streams where db = _db and k = _k;
+  _last array(record(last_ms bigint unsigned, last_seq bigint unsigned));
+  _max bigint unsigned = 18446744073709551615;
+  _locked boolean;
+  _trimmed bigint;
+begin
+  start transaction;
+  if not _nomkstream then
+    call assertKey(_db, _k, "stream");
+    insert ignore into streams (db, k, last_ms, last_seq) values (_db, _k, 0, 0);
+  end if;
+  _locked = streamLock(_db, _k);
+  if not _locked then
+    commit;
+    return null;
+  end if;
+
+  _last = collect(_last_q);
+  if _ms is null then
+    _ms = greatest(nowMs(), _last[0].last_ms);
+    if _ms = _last[0].last_ms and _last[0].last_seq = _max then
+      if _ms = _max then
+        raise user_exception("The stream has exhausted the last possible ID, unable to add more items");
+      end if;
+      _ms = _ms + 1;
+    end if;
+  end if;
+  if _seq is null then
+    if _ms != _last[0].last_ms then
+      _seq = 0;
+    elsif _last[0].last_seq < _max then
+      _seq = _last[0].last_seq + 1;
+    end if;
+  end if;
+  if _seq is null or _ms < _last[0].last_ms or (_ms = _last[0].last_ms and _seq <= _last[0].last_seq) then
+    raise user_exception("The ID specified in XADD is equal or smaller than the target stream top item");
+  end if;
+
+  insert into streamentries (db, k, id_ms, id_seq, fields) values (_db, _k, _ms, _seq, _fields);
+  update streams set last_ms = _ms, last_seq = _seq where db = _db and k = _k;
+  _trimmed = streamTrimEntries(_db, _k, _maxlen, _min_ms, _min_seq, _limit);
+  commit;
+  return concat(_ms, "-", _seq);
+
+exception when others then rollback; raise;
+end //
+
+-- trims _k as by streamTrimEntries, returning how many entries were deleted
+create or replace procedure streamTrim(
+  _db int, _k longblob,
+  _maxlen bigint, _min_ms bigint unsigned, _min_seq bigint unsigned, _limit bigint
+) returns bigint as
+declare
+  _locked boolean;
+  _trimmed bigint = 0;
+begin
+  start transaction;
+  _locked = streamLock(_db, _k);
+  if _locked then
+    _trimmed = streamTrimEntries(_db, _k, _maxlen, _min_ms, _min_seq, _limit);
+  end if;
+  commit;
+  return _trimmed;
+
+exception when others then rollback; raise;
+end //
+
+-- deletes the entries of _k with the IDs _ms[i]-_seq[i], returning how many
+-- existed
+-- the stream itself remains even once it is empty
+create or replace procedure streamDelete(_db int, _k longblob, _ms array(bigint unsigned), _seq array(bigint unsigned))
+returns bigint as
+declare
+  _locked boolean;
+  _deleted bigint = 0;
+begin
+  start transaction;
+  _locked = streamLock(_db, _k);
+  if _locked then
+    for i in 0 .. length(_ms) - 1 loop
+      delete from streamentries where db = _db and k = _k and id_ms = _ms[i] and id_seq = _seq[i];
+      _deleted = _deleted + row_count();
+    end loop;
+  end if;
+  commit;
+  return _deleted;
+
+exception when others then rollback; raise;
+end //
+
+-- returns up to _count entries of _k, or all of them if _count is negative,
+-- from _start_ms-_start_seq to _end_ms-_end_seq inclusive, in reverse if
+-- _rev is set
+create or replace procedure streamRange(
+  _db int, _k longblob,
+  _start_ms bigint unsigned, _start_seq bigint unsigned,
+  _end_ms bigint unsigned, _end_seq bigint unsigned,
+  _rev bool, _count bigint
+) returns query(id_ms bigint unsigned, id_seq bigint unsigned, fields longblob) as
+begin
+  return to_query(concat(
+    "select id_ms, id_seq, fields from streamentries where db = ", _db, " and k = ", quoteBinary(_k), " and ", keyLive(_db, _k),
+    " and (id_ms > ", _start_ms, " or (id_ms = ", _start_ms, " and id_seq >= ", _start_seq, "))",
+    " and (id_ms < ", _end_ms, " or (id_ms = ", _end_ms, " and id_seq <= ", _end_seq, "))",
+    " order by id_ms", if(_rev, " desc", ""), ", id_seq", if(_rev, " desc", ""),
+    if(_count < 0, "", concat(" limit ", _count))));
+end //
+
+create or replace function streamLength(_db int, _k longblob)
+returns table as return
+  select count(*) as n from streamentries where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k) //
+
+-- returns the last ID added to _k, or no rows if it doesn't exist
+create or replace function streamLastID(_db int, _k longblob)
+returns table as return
+  select last_ms, last_seq from streams where db = _db and k = _k //
+
+-- returns literal stream ID columns, for building queries that select them
+create or replace function streamIDColumns(_ms bigint unsigned, _seq bigint unsigned)
+returns text as
+begin
+  return concat(_ms, " :> bigint unsigned as id_ms, ", _seq, " :> bigint unsigned as id_seq");
+end //
+
+-- streamGroupKeyLock must be used within a transaction
+-- locks _k for an XGROUP subcommand, raising if it doesn't exist
+create or replace procedure streamGroupKeyLock(_db int, _k longblob)
+as
+declare
+  _locked boolean;
+begin
+  _locked = streamLock(_db, _k);
+  if not _locked then
+    raise user_exception("The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.");
+  end if;
+end //
+
+-- streamGroupLock must be used within a transaction holding
+-- streamLock(_db, _k)
+-- locks group _grp of _k, returning false if it doesn't exist
+create or replace procedure streamGroupLock(_db int, _k longblob, _grp longblob)
+returns boolean as
+declare
+  _q query(grp longblob) = select grp from streamgroups where db = _db and k = _k and grp = _grp for update;
+  _rows array(record(grp longblob));
+begin
+  _rows = collect(_q);
+  return length(_rows) > 0;
+end //
+
+-- streamConsumerSeen must be used within a transaction holding
+-- streamGroupLock(_db, _k, _grp)
+-- records that _consumer is active now, creating it if needed
+create or replace procedure streamConsumerSeen(_db int, _k longblob, _grp longblob, _consumer longblob)
+as begin
+  insert into streamconsumers (db, k, grp, consumer, seen_ms) values (_db, _k, _grp, _consumer, nowMs())
+    on duplicate key update seen_ms = values(seen_ms);
+end //
+
+-- creates group _grp of _k, which delivers the entries after _ms-_seq or
+-- after the last entry added if _ms is null
+-- _k is created if it doesn't exist and _mkstream is set
+create or replace procedure streamGroupCreate(
+  _db int, _k longblob, _grp longblob, _ms bigint unsigned, _seq bigint unsigned, _mkstream bool
+) as
+begin
+  start transaction;
+  if _mkstream then
+    call assertKey(_db, _k, "stream");
+    insert ignore into streams (db, k, last_ms, last_seq) values (_db, _k, 0, 0);
+  end if;
+  call streamGroupKeyLock(_db, _k);
+
+  insert ignore into streamgroups (db, k, grp, last_ms, last_seq)
+    select _db, _k, _grp, coalesce(_ms, last_ms), if(_ms is null, last_seq, _seq)
+    from streams where db = _db and k = _k;
+  if row_count() = 0 then
+    raise user_exception("BUSYGROUP Consumer Group name already exists");
+  end if;
+  commit;
+
+exception when others then rollback; raise;
+end //
+
+-- destroys group _grp of _k along with its consumers and pending entries,
+-- returning whether it existed
+create or replace procedure streamGroupDestroy(_db int, _k longblob, _grp longblob)
+returns bigint as
+declare
+  _locked boolean;
+  _destroyed bigint = 0;
+begin
+  start transaction;
+  call streamGroupKeyLock(_db, _k);
+  _locked = streamGroupLock(_db, _k, _grp);
+  if _locked then
+    delete from streampending where db = _db and k = _k and grp = _grp;
+    delete from streamconsumers where db = _db and k = _k and grp = _grp;
+    delete from streamgroups where db = _db and k = _k and grp = _grp;
+    _destroyed = 1;
+  end if;
+  commit;
+  return _destroyed;
+
+exception when others then rollback; raise;
+end //
+
+-- sets the last ID delivered to group _grp of _k to _ms-_seq, or to the last
+-- entry added if _ms is null
+create or replace procedure streamGroupSetID(_db int, _k longblob, _grp longblob, _ms bigint unsigned, _seq bigint unsigned)
+as
+declare
+  _last_q query(last_ms bigint unsigned, last_seq bigint unsigned) =
+    select last_ms, last_seq from streams where db = _db and k = _k;
+  _last array(record(last_ms bigint unsigned, last_seq bigint unsigned));
+  _locked boolean;
+begin
+  start transaction;
+  call streamGroupKeyLock(_db, _k);
+  _locked = streamGroupLock(_db, _k, _grp);
+  if not _locked then
+    raise user_exception(concat("NOGROUP No such consumer group '", _grp, "' for key name '", _k, "'"));
+  end if;
+
+  if _ms is null then
+    _last = collect(_last_q);
+    _ms = _last[0].last_ms;
+    _seq = _last[0].last_seq;
+  end if;
+  update streamgroups set last_ms = _ms, last_seq = _seq where db = _db and k = _k and grp = _grp;
+  commit;
+
+exception when others then rollback; raise;
+end //
+
+-- creates _consumer in group _grp of _k, returning whether it was new
+create or replace procedure streamConsumerCreate(_db int, _k longblob, _grp longblob, _consumer longblob)
+returns bigint as
+declare
+  _locked boolean;
+  _created bigint;
+begin
+  start transaction;
+  call streamGroupKeyLock(_db, _k);
+  _locked = streamGroupLock(_db, _k, _grp);
+  if not _locked then
+    raise user_exception(concat("NOGROUP No such consumer group '", _grp, "' for key name '", _k, "'"));
+  end if;
+
+  insert ignore into streamconsumers (db, k, grp, consumer, seen_ms) values (_db, _k, _grp, _consumer, nowMs());
+  _created = row_count();
+  commit;
+  return _created;
+
+exception when others then rollback; raise;
+end //
+
+-- deletes _consumer from group _grp of _k along with its pending entries,
+-- returning how many it had
+create or replace procedure streamConsumerDelete(_db int, _k longblob, _grp longblob, _consumer longblob)
+returns bigint as
+declare
+  _locked boolean;
+  _deleted bigint;
+begin
+  start transaction;
+  call streamGroupKeyLock(_db, _k);
+  _locked = streamGroupLock(_db, _k, _grp);
+  if not _locked then
+    raise user_exception(concat("NOGROUP No such consumer group '", _grp, "' for key name '", _k, "'"));
+  end if;
+
+  delete from streampending where db = _db and k = _k and grp = _grp and consumer = _consumer;
+  _deleted = row_count();
+  delete from streamconsumers where db = _db and k = _k and grp = _grp and consumer = _consumer;
+  commit;
+  return _deleted;
+
+exception when others then rollback; raise;
+end //
+
+-- delivers up to _count entries of _k after the last one delivered to group
+-- _grp, or all of them if _count is negative, to _consumer, adding them to
+-- the pending entries of the group unless _noack is set
+create or replace procedure streamReadGroup(
+  _db int, _k longblob, _grp longblob, _consumer longblob, _count bigint, _noack bool
+) returns query(id_ms bigint unsigned, id_seq bigint unsigned, fields longblob) as
+declare
+  _last_q query(last_ms bigint unsigned, last_seq bigint unsigned) =
+    select last_ms, last_seq from streamgroups where db = _db and k = _k and grp = _grp;
+  _last array(record(last_ms bigint unsigned, last_seq bigint unsigned));
+  _last_ms bigint unsigned;
+  _last_seq bigint unsigned;
+  _end_q query(id_ms bigint unsigned, id_seq bigint unsigned) =
+    select id_ms, id_seq
+    from (
+      select id_ms, id_seq, row_number() over (order by id_ms, id_seq) as _rownum
+      from streamentries
+      where db = _db and k = _k and (id_ms > _last_ms or (id_ms = _last_ms and id_seq > _last_seq))
+    )
+    where _count < 0 or _rownum <= _count
+    order by _rownum desc
+    limit 1;
+  _end array(record(id_ms bigint unsigned, id_seq bigint unsigned));
+  _locked boolean;
+begin
+  start transaction;
+  _locked = streamLock(_db, _k);
+  _locked = streamGroupLock(_db, _k, _grp);
+  if not _locked then
+    raise user_exception(concat(
+      "NOGROUP No such key '", _k, "' or consumer group '", _grp, "' in XREADGROUP with GROUP option"));
+  end if;
+  call streamConsumerSeen(_db, _k, _grp, _consumer);
+
+  _last = collect(_last_q);
+  _last_ms = _last[0].last_ms;
+  _last_seq = _last[0].last_seq;
+  _end = collect(_end_q);
+  if length(_end) = 0 then
+    commit;
+    return to_query("select id_ms, id_seq, fields from streamentries limit 0");
+  end if;
+
+  update streamgroups set last_ms = _end[0].id_ms, last_seq = _end[0].id_seq
+    where db = _db and k = _k and grp = _grp;
+  if not _noack then
+    -- entries delivered again after XGROUP SETID move to the new consumer
+    insert into streampending (db, k, grp, id_ms, id_seq, consumer, delivered_ms, deliveries)
+      select _db, _k, _grp, id_ms, id_seq, _consumer, nowMs(), 1
+      from streamentries
+      where db = _db and k = _k
+        and (id_ms > _last_ms or (id_ms = _last_ms and id_seq > _last_seq))
+        and (id_ms < _end[0].id_ms or (id_ms = _end[0].id_ms and id_seq <= _end[0].id_seq))
+      on duplicate key update
+        consumer = values(consumer), delivered_ms = values(delivered_ms), deliveries = 1;
+  end if;
+  commit;
+
+  return to_query(concat(
+    "select id_ms, id_seq, fields from streamentries where db = ", _db, " and k = ", quoteBinary(_k),
+    " and (id_ms > ", _last_ms, " or (id_ms = ", _last_ms, " and id_seq > ", _last_seq, "))",
+    " and (id_ms < ", _end[0].id_ms, " or (id_ms = ", _end[0].id_ms, " and id_seq <= ", _end[0].id_seq, "))",
+    " order by id_ms, id_seq"));
+
+exception when others then rollback; raise;
+end //
+
+-- returns up to _count of the entries pending for _consumer in group _grp of
+-- _k from _start_ms-_start_seq, or all of them if _count is negative,
+-- counting them as delivered again
+-- the fields of entries since deleted from _k are null
+create or replace procedure streamReadPending(
+  _db int, _k longblob, _grp longblob, _consumer longblob,
+  _start_ms bigint unsigned, _start_seq bigint unsigned, _count bigint
+) returns query(id_ms bigint unsigned, id_seq bigint unsigned, fields longblob) as
+declare
+  _end_q query(id_ms bigint unsigned, id_seq bigint unsigned) =
+    select id_ms, id_seq
+    from (
+      select id_ms, id_seq, row_number() over (order by id_ms, id_seq) as _rownum
+      from streampending
+      where db = _db and k = _k and grp = _grp and consumer = _consumer
+        and (id_ms > _start_ms or (id_ms = _start_ms and id_seq >= _start_seq))
+    )
+    where _count < 0 or _rownum <= _count
+    order by _rownum desc
+    limit 1;
+  _end array(record(id_ms bigint unsigned, id_seq bigint unsigned));
+  _locked boolean;
+begin
+  start transaction;
+  _locked = streamLock(_db, _k);
+  _locked = streamGroupLock(_db, _k, _grp);
+  if not _locked then
+    raise user_exception(concat(
+      "NOGROUP No such key '", _k, "' or consumer group '", _grp, "' in XREADGROUP with GROUP option"));
+  end if;
+  call streamConsumerSeen(_db, _k, _grp, _consumer);
+
+  _end = collect(_end_q);
+  if length(_end) = 0 then
+    commit;
+    return to_query("select id_ms, id_seq, fields from streamentries limit 0");
+  end if;
+
+  update streampending p
+    join streamentries e on e.db = p.db and e.k = p.k and e.id_ms = p.id_ms and e.id_seq = p.id_seq
+    set p.delivered_ms = nowMs(), p.deliveries = p.deliveries + 1
+    where p.db = _db and p.k = _k and p.grp = _grp and p.consumer = _consumer
+      and (p.id_ms > _start_ms or (p.id_ms = _start_ms and p.id_seq >= _start_seq))
+      and (p.id_ms < _end[0].id_ms or (p.id_ms = _end[0].id_ms and p.id_seq <= _end[0].id_seq));
+  commit;
+
+  return to_query(concat(
+    "select p.id_ms, p.id_seq, e.fields from streampending p left join streamentries e",
+    " on e.db = p.db and e.k = p.k and e.id_ms = p.id_ms and e.id_seq = p.id_seq",
+    " where p.db = ", _db, " and p.k = ", quoteBinary(_k), " and p.grp = ", quoteBinary(_grp),
+    " and p.consumer = ", quoteBinary(_consumer),
+    " and (p.id_ms > ", _start_ms, " or (p.id_ms = ", _start_ms, " and p.id_seq >= ", _start_seq, "))",
+    " and (p.id_ms < ", _end[0].id_ms, " or (p.id_ms = ", _end[0].id_ms, " and p.id_seq <= ", _end[0].id_seq, "))",
+    " order by p.id_ms, p.id_seq"));
+
+exception when others then rollback; raise;
+end //
+
+-- removes the IDs _ms[i]-_seq[i] from the pending entries of group _grp of
+-- _k, returning how many were pending
+create or replace procedure streamAck(_db int, _k longblob, _grp longblob, _ms array(bigint unsigned), _seq array(bigint unsigned))
+returns bigint as
+declare
+  _locked boolean;
+  _acked bigint = 0;
+begin
+  start transaction;
+  _locked = streamLock(_db, _k);
+  for i in 0 .. length(_ms) - 1 loop
+    delete from streampending where db = _db and k = _k and grp = _grp and id_ms = _ms[i] and id_seq = _seq[i];
+    _acked = _acked + row_count();
+  end loop;
+  commit;
+  return _acked;
+
+exception when others then rollback; raise;
+end //
+
+-- counts the pending entries of group _grp of _k by consumer, with the first
+-- and last pending IDs of the whole group repeated on every row
+create or replace procedure streamPendingSummary(_db int, _k longblob, _grp longblob)
+returns query(
+  consumer longblob, n bigint,
+  first_ms bigint unsigned, first_seq bigint unsigned, last_ms bigint unsigned, last_seq bigint unsigned
+) as
+declare
+  _group_q query(grp longblob) = select grp from streamgroups where db = _db and k = _k and grp = _grp;
+  _group array(record(grp longblob));
+  _first_q query(id_ms bigint unsigned, id_seq bigint unsigned) =
+    select id_ms, id_seq from streampending where db = _db and k = _k and grp = _grp
+    order by id_ms, id_seq limit 1;
+  _last_q query(id_ms bigint unsigned, id_seq bigint unsigned) =
+    select id_ms, id_seq from streampending where db = _db and k = _k and grp = _grp
+    order by id_ms desc, id_seq desc limit 1;
+  _first array(record(id_ms bigint unsigned, id_seq bigint unsigned));
+  _last array(record(id_ms bigint unsigned, id_seq bigint unsigned));
+begin
+  _group = collect(_group_q);
+  if length(_group) = 0 then
+    raise user_exception(concat("NOGROUP No such key '", _k, "' or consumer group '", _grp, "'"));
+  end if;
+  _first = collect(_first_q);
+  if length(_first) = 0 then
+    return to_query(
+      "select consumer, 0 as n, id_ms as first_ms, id_seq as first_seq, id_ms as last_ms, id_seq as last_seq from streampending limit 0");
+  end if;
+  _last = collect(_last_q);
+
+  return to_query(concat(
+    "select consumer, count(*) as n, ",
+    _first[0].id_ms, " :> bigint unsigned as first_ms, ", _first[0].id_seq, " :> bigint unsigned as first_seq, ",
+    _last[0].id_ms, " :> bigint unsigned as last_ms, ", _last[0].id_seq, " :> bigint unsigned as last_seq",
+    " from streampending where db = ", _db, " and k = ", quoteBinary(_k), " and grp = ", quoteBinary(_grp),
+    " group by consumer order by consumer"));
+end //
+
+-- returns up to _count of the pending entries of group _grp of _k from
+-- _start_ms-_start_seq to _end_ms-_end_seq inclusive that have been idle for
+-- at least _min_idle milliseconds, only those of _consumer unless it is null
+create or replace procedure streamPending(
+  _db int, _k longblob, _grp longblob, _min_idle bigint,
+  _start_ms bigint unsigned, _start_seq bigint unsigned,
+  _end_ms bigint unsigned, _end_seq bigint unsigned,
+  _count bigint, _consumer longblob
+) returns query(id_ms bigint unsigned, id_seq bigint unsigned, consumer longblob, idle bigint, deliveries bigint) as
+declare
+  _group_q query(grp longblob) = select grp from streamgroups where db = _db and k = _k and grp = _grp;
+  _group array(record(grp longblob));
+  _idle longtext;
+begin
+  _group = collect(_group_q);
+  if length(_group) = 0 then
+    raise user_exception(concat("NOGROUP No such key '", _k, "' or consumer group '", _grp, "'"));
+  end if;
+
+  _idle = concat("(greatest(", nowMs(), ", delivered_ms) - delivered_ms) :> bigint");
+  return to_query(concat(
+    "select id_ms, id_seq, consumer, ", _idle, " as idle, deliveries from streampending",
+    " where db = ", _db, " and k = ", quoteBinary(_k), " and grp = ", quoteBinary(_grp),
+    " and (id_ms > ", _start_ms, " or (id_ms = ", _start_ms, " and id_seq >= ", _start_seq, "))",
+    " and (id_ms < ", _end_ms, " or (id_ms = ", _end_ms, " and id_seq <= ", _end_seq, "))",
+    " and ", _idle, " >= ", _min_idle,
+    if(_consumer is null, "", concat(" and consumer = ", quoteBinary(_consumer))),
+    " order by id_ms, id_seq limit ", _count));
+end //
+
+-- claims the pending entries _ms[i]-_seq[i] of group _grp of _k for
+-- _consumer if they have been idle for at least _min_idle milliseconds,
+-- returning the claimed entries in order
+-- they are marked as delivered at _time, or _idle milliseconds ago if _time
+-- is null, with _deliveries deliveries if it isn't null or else one more
+-- unless _justid is set
+-- _force adds entries of _k that aren't pending, pending entries since
+-- deleted from _k are dropped, and the ID last delivered to the group is
+-- raised to _last_ms-_last_seq unless it is null
+create or replace procedure streamClaim(
+  _db int, _k longblob, _grp longblob, _consumer longblob, _min_idle bigint,
+  _ms array(bigint unsigned), _seq array(bigint unsigned),
+  _idle bigint, _time bigint unsigned, _deliveries bigint, _force bool, _justid bool,
+  _last_ms bigint unsigned, _last_seq bigint unsigned
+) returns query(id_ms bigint unsigned, id_seq bigint unsigned, fields longblob) as
+declare
+  _id_ms bigint unsigned;
+  _id_seq bigint unsigned;
+  _entry_q query(n bigint) =
+    select count(*) from streamentries where db = _db and k = _k and id_ms = _id_ms and id_seq = _id_seq;
+  _pending_q query(delivered_ms bigint unsigned) =
+    select delivered_ms from streampending
+    where db = _db and k = _k and grp = _grp and id_ms = _id_ms and id_seq = _id_seq;
+  _pending array(record(delivered_ms bigint unsigned));
+  _now bigint unsigned = nowMs();
+  _delivered bigint unsigned;
+  _exists boolean;
+  _forced boolean;
+  _locked boolean;
+  _claimed bigint = 0;
+  _out longtext = "";
+begin
+  start transaction;
+  _locked = streamLock(_db, _k);
+  _locked = streamGroupLock(_db, _k, _grp);
+  if not _locked then
+    raise user_exception(concat("NOGROUP No such key '", _k, "' or consumer group '", _grp, "'"));
+  end if;
+  if _last_ms is not null then
+    update streamgroups set last_ms = _last_ms, last_seq = _last_seq
+      where db = _db and k = _k and grp = _grp
+        and (last_ms < _last_ms or (last_ms = _last_ms and last_seq < _last_seq));
+  end if;
+  call streamConsumerSeen(_db, _k, _grp, _consumer);
+  _delivered = coalesce(_time, _now - least(coalesce(_idle, 0), _now));
+
+  for i in 0 .. length(_ms) - 1 loop
+    _id_ms = _ms[i];
+    _id_seq = _seq[i];
+    _exists = scalar(_entry_q) > 0;
+    _pending = collect(_pending_q);
+    _forced = false;
+    if length(_pending) = 0 and _force and _exists then
+      insert into streampending (db, k, grp, id_ms, id_seq, consumer, delivered_ms, deliveries)
+        values (_db, _k, _grp, _id_ms, _id_seq, _consumer, _now, 0);
+      _pending = collect(_pending_q);
+      _forced = true;
+    end if;
+
+    if length(_pending) > 0 then
+      if not _exists then
+        delete from streampending where db = _db and k = _k and grp = _grp and id_ms = _id_ms and id_seq = _id_seq;
+      elsif _forced or greatest(_now, _pending[0].delivered_ms) - _pending[0].delivered_ms >= _min_idle then
+        update streampending
+          set consumer = _consumer, delivered_ms = _delivered,
+            deliveries = coalesce(_deliveries, deliveries + if(_justid, 0, 1))
+          where db = _db and k = _k and grp = _grp and id_ms = _id_ms and id_seq = _id_seq;
+        _out = concat(_out, if(_claimed > 0, " union all ", ""),
+          "select ", streamIDColumns(_id_ms, _id_seq), ", ", _claimed, " as i");
+        _claimed = _claimed + 1;
+      end if;
+    end if;
+  end loop;
+  commit;
+
+  if _claimed = 0 then
+    return to_query("select id_ms, id_seq, fields from streamentries limit 0");
+  end if;
+  return to_query(concat(
+    "select e.id_ms, e.id_seq, e.fields from (", _out, ") as claimed",
+    " join streamentries e on e.id_ms = claimed.id_ms and e.id_seq = claimed.id_seq",
+    " where e.db = ", _db, " and e.k = ", quoteBinary(_k),
+    " order by claimed.i"));
+
+exception when others then rollback; raise;
+end //
+
+-- claims up to _count of the pending entries of group _grp of _k from
+-- _start_ms-_start_seq for _consumer as streamClaim does, if they have been
+-- idle for at least _min_idle milliseconds, looking at no more than ten times
+-- as many
+-- returns the claimed entries as kind "claimed", the IDs of pending entries
+-- dropped as they were deleted from _k as kind "deleted" and the ID to
+-- continue from as kind "next", which is 0-0 once every entry has been seen
+create or replace procedure streamAutoClaim(
+  _db int, _k longblob, _grp longblob, _consumer longblob, _min_idle bigint,
+  _start_ms bigint unsigned, _start_seq bigint unsigned, _count bigint, _justid bool
+) returns query(kind text, id_ms bigint unsigned, id_seq bigint unsigned, fields longblob) as
+declare
+  _scan_q query(id_ms bigint unsigned, id_seq bigint unsigned, delivered_ms bigint unsigned, found bool) =
+    select id_ms, id_seq, delivered_ms, found
+    from (
+      select p.id_ms, p.id_seq, p.delivered_ms, e.id_ms is not null as found,
+        row_number() over (order by p.id_ms, p.id_seq) as _rownum
+      from streampending p
+      left join streamentries e on e.db = p.db and e.k = p.k and e.id_ms = p.id_ms and e.id_seq = p.id_seq
+      where p.db = _db and p.k = _k and p.grp = _grp
+        and (p.id_ms > _start_ms or (p.id_ms = _start_ms and p.id_seq >= _start_seq))
+    )
+    where _rownum <= _count * 10 + 1
+    order by _rownum;
+  _rows array(record(id_ms bigint unsigned, id_seq bigint unsigned, delivered_ms bigint unsigned, found bool));
+  _now bigint unsigned = nowMs();
+  _locked boolean;
+  _claimed bigint = 0;
+  _i bigint = 0;
+  _out longtext = "";
+begin
+  start transaction;
+  _locked = streamLock(_db, _k);
+  _locked = streamGroupLock(_db, _k, _grp);
+  if not _locked then
+    raise user_exception(concat("NOGROUP No such key '", _k, "' or consumer group '", _grp, "'"));
+  end if;
+  call streamConsumerSeen(_db, _k, _grp, _consumer);
+
+  _rows = collect(_scan_q);
+  while _i < length(_rows) and _i < _count * 10 and _claimed < _count loop
+    if not _rows[_i].found then
+      delete from streampending
+        where db = _db and k = _k and grp = _grp and id_ms = _rows[_i].id_ms and id_seq = _rows[_i].id_seq;
+      _out = concat(_out, "select 'deleted' as kind, ", streamIDColumns(_rows[_i].id_ms, _rows[_i].id_seq),
+        ", ", _i, " as i union all ");
+    elsif greatest(_now, _rows[_i].delivered_ms) - _rows[_i].delivered_ms >= _min_idle then
+      update streampending
+        set consumer = _consumer, delivered_ms = _now, deliveries = deliveries + if(_justid, 0, 1)
+        where db = _db and k = _k and grp = _grp and id_ms = _rows[_i].id_ms and id_seq = _rows[_i].id_seq;
+      _out = concat(_out, "select 'claimed' as kind, ", streamIDColumns(_rows[_i].id_ms, _rows[_i].id_seq),
+        ", ", _i, " as i union all ");
+      _claimed = _claimed + 1;
+    end if;
+    _i = _i + 1;
+  end loop;
+
+  if _i < length(_rows) then
+    _out = concat(_out, "select 'next' as kind, ", streamIDColumns(_rows[_i].id_ms, _rows[_i].id_seq), ", ", _i, " as i");
+  else
+    _out = concat(_out, "select 'next' as kind, ", streamIDColumns(0, 0), ", ", _i, " as i");
+  end if;
+  commit;
+
+  return to_query(concat(
+    "select o.kind, o.id_ms, o.id_seq, e.fields from (", _out, ") as o",
+    " left join streamentries e on o.kind = 'claimed' and e.db = ", _db, " and e.k = ", quoteBinary(_k),
+    " and e.id_ms = o.id_ms and e.id_seq = o.id_seq",
+    " order by o.i"));
+
+exception when others then rollback; raise;
+end //
+
+-- returns the consumer groups of _k with how many consumers and pending
+-- entries each has
+create or replace function streamGroupInfo(_db int, _k longblob)
+returns table as return
+  select g.grp, coalesce(c.n, 0) as consumers, coalesce(p.n, 0) as pending, g.last_ms, g.last_seq
+  from streamgroups g
+  left join (
+    select grp, count(*) as n from streamconsumers where db = _db and k = _k group by grp
+  ) c on c.grp = g.grp
+  left join (
+    select grp, count(*) as n from streampending where db = _db and k = _k group by grp
+  ) p on p.grp = g.grp
+  where g.db = _db and g.k = _k
+  order by g.grp //
+
+-- returns the consumers of group _grp of _k with how many pending entries
+-- each has and for how many milliseconds it has been idle
+create or replace function streamConsumerInfo(_db int, _k longblob, _grp longblob)
+returns table as return
+  select c.consumer, coalesce(p.n, 0) as pending, greatest(nowMs(), c.seen_ms) - c.seen_ms as idle
+  from streamconsumers c
+  left join (
+    select consumer, count(*) as n from streampending
+    where db = _db and k = _k and grp = _grp
+    group by consumer
+  ) p on p.consumer = c.consumer
+  where c.db = _db and c.k = _k and c.grp = _grp
+  order by c.consumer //
+
+-- returns the type and value of _k, which the server decodes as a
+-- HyperLogLog when it is one or a string holding its registers
+create or replace function hllGet (_db int, _k longblob)
+returns table as return
+  select ks.t, b.v from keyspace ks
+  left join blobvalues b on b.db = ks.db and b.k = ks.k
+  where ks.db = _db and ks.k = _k //
+
+-- replaces the registers of the HyperLogLog _k with _v provided that it still
+-- holds _old, or doesn't exist if _old is null, and returns whether it did
+-- the server computes the registers, so on false it reads _k again and retries
+create or replace procedure hllSet (_db int, _k longblob, _old longblob, _v longblob)
+returns bool as
+declare
+  _q query(t text) = select t from keyspace where db = _db and k = _k for update;
+  _v_q query(v longblob) = select (select v from blobvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k));
+  _rows array(record(t text));
+begin
+  start transaction;
+  _rows = collect(_q);
+  if length(_rows) = 0 then
+    if _old is not null then
+      rollback;
+      return false;
+    end if;
+    call assertKey(_db, _k, "hll");
+    insert into blobvalues (db, k, v) values (_db, _k, _v);
+  else
+    if _old is null or not (scalar(_v_q) <=> _old) then
+      rollback;
+      return false;
+    end if;
+    update keyspace set t = "hll" where db = _db and k = _k;
+    update blobvalues set v = _v where db = _db and k = _k;
+  end if;
+  commit;
+  return true;
+
+exception when others then rollback; raise;
+end //
+
+-- sets the locations of _members of _k following the NX, XX and CH flags of
+-- GEOADD, returning how many were added (and changed, with CH)
+create or replace procedure geoAdd(
+  _db int,
+  _k longblob,
+  _members array(longblob),
+  _lons array(double),
+  _lats array(double),
+  _nx bool, _xx bool, _ch bool
+) returns bigint as
+declare
+  _m longblob;
+  _n bigint;
+  _added bigint = 0;
+  _changed bigint = 0;
+begin
+  start transaction;
+  call assertKey(_db, _k, "geo");
+
+  for i in 0 .. length(_members) - 1 loop
+    _m = _members[i];
+    select count(*) into _n from geovalues where db = _db and k = _k and member = _m;
+
+    if _n = 0 then
+      if not _xx then
+        insert into geovalues (db, k, member, location)
+          values (_db, _k, _m, geography_point(_lons[i], _lats[i]));
+        _added = _added + 1;
+      end if;
+    elsif not _nx then
+      update geovalues set location = geography_point(_lons[i], _lats[i])
+        where db = _db and k = _k and member = _m
+          and geography_distance(location, geography_point(_lons[i], _lats[i])) > 0;
+      _changed = _changed + row_count();
+    end if;
+  end loop;
+
+  -- XX against a missing key must not create it
+  delete from keyspace where db = _db and k = _k and not exists(select 1 from geovalues where db = _db and k = _k);
+
+  commit;
+  return if(_ch, _added + _changed, _added);
+
+exception when others then rollback; raise;
+end //
+
+-- returns the locations of those of _members which are in _k
+create or replace procedure geoPos(_db int, _k longblob, _members array(longblob))
+returns query(member longblob, lon double, lat double) as
+declare
+  _q longtext = concat(
+    "select member, geography_longitude(location) as lon, geography_latitude(location) as lat",
+    " from geovalues where db = ", _db, " and k = ", quoteBinary(_k), " and ", keyLive(_db, _k), " and member in (");
+begin
+  for i in 0 .. length(_members) - 1 loop
+    if i > 0 then
+      _q = concat(_q, ",");
+    end if;
+
+    _q = concat(_q, quoteBinary(_members[i]));
+  end loop;
+
+  _q = concat(_q, ")");
+
+  return to_query(_q);
+end //
+
+-- returns the distance in meters between members _a and _b of _k, or no rows
+-- if either is missing
+create or replace function geoDist(_db int, _k longblob, _a longblob, _b longblob)
+returns table as return
+  select geography_distance(a.location, b.location) as dist
+  from geovalues a
+  join geovalues b on b.db = a.db and b.k = a.k
+  where a.db = _db and a.k = _k and a.member = _a and b.member = _b and exists(select 1 from keyspace where db = _db and k = _k) //
+
+-- builds a query for the members of _k, with their distance in meters from
+-- the point _lon, _lat, that are within _radius meters of it or, when _radius
+-- is null, within a box _width by _height meters centred on it
+-- the spatial index narrows a box search down to the circle around it before
+-- the box is checked the way redis checks it: the latitude of each member
+-- first, then its longitude along its own parallel
+-- they are ordered by distance if _order is "asc" or "desc" and limited to
+-- _count unless it is null
+create or replace function geoSearchQuery(
+  _db int,
+  _k longblob,
+  _lon double, _lat double,
+  _radius double, _width double, _height double,
+  _order text, _count bigint
+) returns longtext as
+declare
+  _center longtext = concat("geography_point(", _lon, ", ", _lat, ")");
+  _parallel longtext = concat("geography_point(", _lon, ", geography_latitude(location))");
+  _q longtext;
+begin
+  _q = concat(
+    "select member, location, geography_distance(location, ", _center, ") as dist",
+    " from geovalues where db = ", _db, " and k = ", quoteBinary(_k), " and ", keyLive(_db, _k),
+    " and geography_within_distance(location, ", _center, ", ",
+    ifnull(_radius, sqrt(_width * _width + _height * _height) / 2), ")");
+
+  if _radius is null then
+    _q = concat(
+      "select member, location, dist from (", _q, ")",
+      " where geography_distance(", _parallel, ", ", _center, ") <= ", _height / 2,
+      " and geography_distance(location, ", _parallel, ") <= ", _width / 2);
+  end if;
+
+  return concat(
+    "select member, location, dist from (", _q, ")",
+    if(_order is null, "", concat(" order by dist ", _order)),
+    if(_count is null, "", concat(" limit ", _count)));
+end //
+
+-- returns the members of _k found by geoSearchQuery with their locations
+create or replace procedure geoSearch(
+  _db int,
+  _k longblob,
+  _lon double, _lat double,
+  _radius double, _width double, _height double,
+  _order text, _count bigint
+) returns query(member longblob, lon double, lat double, dist double) as
+begin
+  return to_query(concat(
+    "select member, geography_longitude(location) as lon, geography_latitude(location) as lat, dist from (",
+    geoSearchQuery(_db, _k, _lon, _lat, _radius, _width, _height, _order, _count), ")"));
+end //
+
+-- like geoSearch but replaces _dest with the members found, returning how
+-- many there were
+-- unless _unit is null _dest becomes a sorted set scoring each member by its
+-- distance in units of _unit meters, as with STOREDIST
+create or replace procedure geoSearchStore(
+  _db int,
+  _dest longblob,
+  _k longblob,
+  _lon double, _lat double,
+  _radius double, _width double, _height double,
+  _order text, _count bigint,
+  _unit double
+) returns bigint as
+declare
+  _q longtext = geoSearchQuery(_db, _k, _lon, _lat, _radius, _width, _height, _order, _count);
+  _staging longblob = concat(_dest, unhex("00"), "staging:", connection_id());
+  _table text = if(_unit is null, "geovalues", "zsetvalues");
+  _insert longtext = if(_unit is null,
+    "insert into geovalues (db, k, member, location) select ",
+    "insert into zsetvalues (db, k, member, score) select ");
+  _values longtext = if(_unit is null, "member, location", concat("member, dist / ", _unit));
+  _t text;
+  _existed bool;
+  _n bigint;
+begin
+  start transaction;
+  select (select t from keyspace where db = _db and k = _k) into _t;
+  if _t is not null and _t != "geo" then
+    raise user_exception(concat("type mismatch; got ", _t, ", expected geo"));
+  end if;
+
+  if _dest = _k then
+    -- the result depends on _dest, so it is staged under a private key
+    -- before _dest is cleared
+    execute immediate concat(_insert, _db, ", ", quoteBinary(_staging), ", ", _values, " from (", _q, ")");
+    _existed = keyClear(_db, _dest);
+    execute immediate concat(
+      _insert, _db, ", ", quoteBinary(_dest), ", ", if(_unit is null, "member, location", "member, score"),
+      " from ", _table, " where db = ", _db, " and k = ", quoteBinary(_staging));
+    _n = row_count();
+    execute immediate concat("delete from ", _table, " where db = ", _db, " and k = ", quoteBinary(_staging));
+  else
+    _existed = keyClear(_db, _dest);
+    execute immediate concat(_insert, _db, ", ", quoteBinary(_dest), ", ", _values, " from (", _q, ")");
+    _n = row_count();
+  end if;
+
+  if _n > 0 then
+    insert into keyspace (db, k, t) values (_db, _dest, if(_unit is null, "geo", "zset"));
+  end if;
+
+  commit;
+  return _n;
+
+exception when others then rollback; raise;
+end //
+
+-- the json functions of SingleStore take key paths as a fixed number of
+-- arguments, so paths are passed around as json arrays of object keys
+-- (strings) and array indexes (numbers) and followed one step at a time
+
+-- returns the member of object _v named by step _i of _path, or the element
+-- of array _v it indexes, or null if there is none
+create or replace function jsonChild(_v json, _path json, _i bigint) returns json as
+begin
+  if _v is null then
+    return null;
+  end if;
+  if json_get_type(json_extract_json(_path, _i)) = "string" then
+    if json_get_type(_v) != "object" then
+      return null;
+    end if;
+    return json_extract_json(_v, json_extract_string(_path, _i));
+  end if;
+  if json_get_type(_v) != "array" then
+    return null;
+  end if;
+  return json_extract_json(_v, json_extract_bigint(_path, _i));
+end //
+
+-- returns the value at _path in _v, or null if there is none
+create or replace function jsonExtract(_v json, _path json) returns json as
+declare
+  _i bigint = 0;
+begin
+  while _v is not null and _i < json_length(_path) loop
+    _v = jsonChild(_v, _path, _i);
+    _i = _i + 1;
+  end loop;
+  return _v;
+end //
+
+-- returns _path without its last step
+create or replace function jsonParentPath(_path json) returns json as
+declare
+  _out longtext = "";
+  _i bigint = 0;
+begin
+  while _i < json_length(_path) - 1 loop
+    _out = concat(_out, if(_i > 0, ",", ""), json_extract_json(_path, _i));
+    _i = _i + 1;
+  end loop;
+  return concat("[", _out, "]");
+end //
+
+-- returns _v with the value at _path set to _new, or removed if _new is null,
+-- by rebuilding each of its ancestors from the bottom up
+create or replace function jsonUpdate(_v json, _path json, _new json) returns json as
+declare
+  _n bigint = json_length(_path);
+  _parents array(json) = create_array(greatest(_n, 1));
+  _remove bool = _new is null;
+  _i bigint = 1;
+begin
+  if _n = 0 then
+    return _new;
+  end if;
+
+  _parents[0] = _v;
+  while _i < _n loop
+    _parents[_i] = jsonChild(_parents[_i - 1], _path, _i - 1);
+    _i = _i + 1;
+  end loop;
+
+  _i = _n - 1;
+  while _i >= 0 loop
+    if json_get_type(json_extract_json(_path, _i)) = "string" then
+      if _remove and _i = _n - 1 then
+        _new = json_delete_key(_parents[_i], json_extract_string(_path, _i));
+      else
+        _new = json_set_json(_parents[_i], json_extract_string(_path, _i), _new);
+      end if;
+    else
+      if _remove and _i = _n - 1 then
+        _new = json_delete_key(_parents[_i], json_extract_bigint(_path, _i));
+      else
+        _new = json_set_json(_parents[_i], json_extract_bigint(_path, _i), _new);
+      end if;
+    end if;
+    _i = _i - 1;
+  end loop;
+  return _new;
+end //
+
+-- returns _s as a json string
+create or replace function jsonString(_s longtext) returns json as
+begin
+  return json_extract_json(json_set_string("{}", "s", _s), "s");
+end //
+
+-- jsonLock must be used within a transaction
+-- locks _k, returning false if it doesn't exist and raising if it isn't a
+-- json document
+create or replace procedure jsonLock(_db int, _k longblob)
+returns boolean as
+declare
+  _q query(t text) = select t from keyspace where db = _db and k = _k for update;
+  _rows array(record(t text));
+begin
+  _rows = collect(_q);
+  if length(_rows) = 0 then
+    return false;
+  end if;
+  if _rows[0].t != "json" then
+    raise user_exception(concat("type mismatch; got ", _rows[0].t, ", expected json"));
+  end if;
+  return true;
+end //
+
+-- jsonUnchanged must be used within a transaction holding jsonLock(_db, _k)
+-- returns false if _old is set and the document at _k no longer is _old,
+-- being the document the server resolved the paths of a command against
+create or replace procedure jsonUnchanged(_db int, _k longblob, _old longtext)
+returns boolean as
+declare
+  _q query(v json) = select (select v from jsonvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k));
+begin
+  return _old is null or (scalar(_q) :> longtext) <=> _old;
+end //
+
+-- returns the values at _paths of the document at _k as a json array holding
+-- [value] for each path that exists and null for each that doesn't, or null
+-- if _k doesn't exist
+create or replace procedure jsonGet(_db int, _k longblob, _paths array(json))
+returns longtext as
+declare
+  _q query(v json) = select (select v from jsonvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k));
+  _doc json;
+  _v json;
+  _out longtext = "";
+begin
+  _doc = scalar(_q);
+  if _doc is null then
+    return null;
+  end if;
+
+  for i in 0 .. length(_paths) - 1 loop
+    _v = jsonExtract(_doc, _paths[i]);
+    _out = concat(_out, if(i > 0, ",", ""), if(_v is null, "null", concat("[", _v, "]")));
+  end loop;
+  return concat("[", _out, "]");
+end //
+
+-- sets the values at _paths of the document at _k to _v, or the whole
+-- document if the only path is the root, returning false if nothing was set
+-- and null if the document is no longer _old, see jsonUnchanged
+-- NX only creates values and XX only replaces them; besides the root only
+-- members of existing objects are created
+create or replace procedure jsonSet(_db int, _k longblob, _paths array(json), _old longtext, _v json, _nx bool, _xx bool)
+returns boolean as
+declare
+  _q query(v json) = select (select v from jsonvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k));
+  _doc json;
+  _path json;
+  _exists bool;
+  _unchanged bool;
+  _set bool = false;
+begin
+  start transaction;
+  _exists = jsonLock(_db, _k);
+  _unchanged = jsonUnchanged(_db, _k, _old);
+  if not _unchanged then
+    rollback;
+    return null;
+  end if;
+
+  if length(_paths) = 1 and json_length(_paths[0]) = 0 then
+    if (_nx and _exists) or (_xx and not _exists) then
+      commit;
+      return false;
+    end if;
+    call assertKey(_db, _k, "json");
+    insert into jsonvalues (db, k, v) values (_db, _k, _v)
+      on duplicate key update v = values(v);
+    commit;
+    return true;
+  end if;
+
+  if not _exists then
+    raise user_exception("new objects must be created at the root");
+  end if;
+
+  _doc = scalar(_q);
+  for i in 0 .. length(_paths) - 1 loop
+    _path = _paths[i];
+    if jsonExtract(_doc, _path) is not null then
+      if not _nx then
+        _doc = jsonUpdate(_doc, _path, _v);
+        _set = true;
+      end if;
+    elsif not _xx
+      and json_get_type(json_extract_json(_path, json_length(_path) - 1)) = "string"
+      and json_get_type(jsonExtract(_doc, jsonParentPath(_path))) = "object" then
+      _doc = jsonUpdate(_doc, _path, _v);
+      _set = true;
+    end if;
+  end loop;
+
+  if _set then
+    update jsonvalues set v = _doc where db = _db and k = _k;
+  end if;
+  commit;
+  return _set;
+
+exception when others then rollback; raise;
+end //
+
+-- removes the values at _paths of the document at _k, deleting _k if one of
+-- them is the root, and returns how many existed, or null if the document is
+-- no longer _old
+-- the server orders _paths so that removing an array element doesn't shift
+-- the indexes of those still to be removed
+create or replace procedure jsonDelete(_db int, _k longblob, _paths array(json), _old longtext)
+returns bigint as
+declare
+  _q query(v json) = select (select v from jsonvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k));
+  _doc json;
+  _exists bool;
+  _unchanged bool;
+  _cleared bool;
+  _deleted bigint = 0;
+begin
+  start transaction;
+  _exists = jsonLock(_db, _k);
+  _unchanged = jsonUnchanged(_db, _k, _old);
+  if not _unchanged then
+    rollback;
+    return null;
+  end if;
+  if not _exists then
+    commit;
+    return 0;
+  end if;
+
+  _doc = scalar(_q);
+  for i in 0 .. length(_paths) - 1 loop
+    if json_length(_paths[i]) = 0 then
+      _cleared = keyClear(_db, _k);
+      commit;
+      return 1;
+    end if;
+    if jsonExtract(_doc, _paths[i]) is not null then
+      _doc = jsonUpdate(_doc, _paths[i], null);
+      _deleted = _deleted + 1;
+    end if;
+  end loop;
+
+  if _deleted > 0 then
+    update jsonvalues set v = _doc where db = _db and k = _k;
+  end if;
+  commit;
+  return _deleted;
+
+exception when others then rollback; raise;
+end //
+
+-- adds _by to the numbers at _paths of the document at _k, returning a json
+-- array of their new values with null for those which aren't numbers, or
+-- null if the document is no longer _old
+-- integers stay integers unless _by is fractional
+create or replace procedure jsonNumIncrBy(_db int, _k longblob, _paths array(json), _old longtext, _by longtext)
+returns longtext as
+declare
+  _q query(v json) = select (select v from jsonvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k));
+  _doc json;
+  _cur longtext;
+  _new longtext;
+  _exists bool;
+  _unchanged bool;
+  _changed bool = false;
+  _out longtext = "";
+begin
+  start transaction;
+  _exists = jsonLock(_db, _k);
+  _unchanged = jsonUnchanged(_db, _k, _old);
+  if not _unchanged then
+    rollback;
+    return null;
+  end if;
+  if not _exists then
+    raise user_exception("could not perform this operation on a key that doesn't exist");
+  end if;
+
+  _doc = scalar(_q);
+  for i in 0 .. length(_paths) - 1 loop
+    _cur = jsonExtract(_doc, _paths[i]);
+    if _cur is null or not _cur rlike "^-?[0-9]" then
+      _new = null;
+    elsif _cur rlike "^-?[0-9]+$" and _by rlike "^-?[0-9]+$" then
+      _new = ((_cur :> bigint) + (_by :> bigint)) :> text;
+    else
+      _new = ((_cur :> double) + (_by :> double)) :> text;
+    end if;
+
+    if _new is not null then
+      _doc = jsonUpdate(_doc, _paths[i], _new);
+      _changed = true;
+    end if;
+    _out = concat(_out, if(i > 0, ",", ""), ifnull(_new, "null"));
+  end loop;
+
+  if _changed then
+    update jsonvalues set v = _doc where db = _db and k = _k;
+  end if;
+  commit;
+  return concat("[", _out, "]");
+
+exception when others then rollback; raise;
+end //
+
+-- appends _s to the strings at _paths of the document at _k, returning a json
+-- array of their new lengths with null for those which aren't strings, or
+-- null if the document is no longer _old
+create or replace procedure jsonStrAppend(_db int, _k longblob, _paths array(json), _old longtext, _s longtext)
+returns longtext as
+declare
+  _q query(v json) = select (select v from jsonvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k));
+  _doc json;
+  _cur json;
+  _str longtext;
+  _exists bool;
+  _unchanged bool;
+  _changed bool = false;
+  _out longtext = "";
+begin
+  start transaction;
+  _exists = jsonLock(_db, _k);
+  _unchanged = jsonUnchanged(_db, _k, _old);
+  if not _unchanged then
+    rollback;
+    return null;
+  end if;
+  if not _exists then
+    raise user_exception("could not perform this operation on a key that doesn't exist");
+  end if;
+
+  _doc = scalar(_q);
+  for i in 0 .. length(_paths) - 1 loop
+    _cur = jsonExtract(_doc, _paths[i]);
+    if _cur is null or json_get_type(_cur) != "string" then
+      _out = concat(_out, if(i > 0, ",", ""), "null");
+    else
+      _str = concat(json_extract_string(concat("[", _cur, "]"), 0), _s);
+      _doc = jsonUpdate(_doc, _paths[i], jsonString(_str));
+      _changed = true;
+      _out = concat(_out, if(i > 0, ",", ""), char_length(_str));
+    end if;
+  end loop;
+
+  if _changed then
+    update jsonvalues set v = _doc where db = _db and k = _k;
+  end if;
+  commit;
+  return concat("[", _out, "]");
+
+exception when others then rollback; raise;
+end //
+
+-- appends _values to the arrays at _paths of the document at _k, returning a
+-- json array of their new lengths with null for those which aren't arrays,
+-- or null if the document is no longer _old
+create or replace procedure jsonArrAppend(_db int, _k longblob, _paths array(json), _old longtext, _values array(json))
+returns longtext as
+declare
+  _q query(v json) = select (select v from jsonvalues where db = _db and k = _k and exists(select 1 from keyspace where db = _db and k = _k));
+  _doc json;
+  _cur json;
+  _exists bool;
+  _unchanged bool;
+  _changed bool = false;
+  _out longtext = "";
+begin
+  start transaction;
+  _exists = jsonLock(_db, _k);
+  _unchanged = jsonUnchanged(_db, _k, _old);
+  if not _unchanged then
+    rollback;
+    return null;
+  end if;
+  if not _exists then
+    raise user_exception("could not perform this operation on a key that doesn't exist");
+  end if;
+
+  _doc = scalar(_q);
+  for i in 0 .. length(_paths) - 1 loop
+    _cur = jsonExtract(_doc, _paths[i]);
+    if _cur is null or json_get_type(_cur) != "array" then
+      _out = concat(_out, if(i > 0, ",", ""), "null");
+    else
+      for j in 0 .. length(_values) - 1 loop
+        _cur = json_array_push_json(_cur, _values[j]);
+      end loop;
+      _doc = jsonUpdate(_doc, _paths[i], _cur);
+      _changed = true;
+      _out = concat(_out, if(i > 0, ",", ""), json_length(_cur));
+    end if;
+  end loop;
+
+  if _changed then
+    update jsonvalues set v = _doc where db = _db and k = _k;
+  end if;
+  commit;
+  return concat("[", _out, "]");
+
+exception when others then rollback; raise;
 end //
 
 delimiter ;
\ No newline at end of file
@@ -2,42 +2,176 @@ drop database if exists kv;
 create database kv;
 use kv;
 
+-- every key belongs to one of the logical databases chosen with SELECT; the
+-- tables are still sharded by key alone so that SWAPDB and MOVE update rows
+-- in place
 create rowstore table keyspace (
-  k text,
-  t enum("blob", "set", "list"),
-  primary key (k)
+  db int not null,
+  k longblob,
+  t enum("blob", "set", "list", "hash", "zset", "stream", "hll", "geo", "json"),
+  primary key (db, k),
+  shard key (k)
+);
+
+-- positions of SCAN style iterations, so that clients get a numeric cursor
+-- while iteration follows the primary key of the scanned table
+create rowstore table scancursors (
+  id bigint not null,
+  pos longblob not null,
+  primary key (id)
+);
+
+-- keys removed by UNLINK whose values are still being deleted in the
+-- background
+create rowstore table unlinkedkeys (
+  db int not null,
+  k longblob,
+  t enum("blob", "set", "list", "hash", "zset", "stream", "hll", "geo", "json"),
+  primary key (db, k),
+  shard key (k)
 );
 
 create table blobvalues (
-  k text,
-  v blob,
+  db int not null,
+  k longblob,
+  v longblob,
 
   shard (k),
   sort key (),
-  unique key (k) using hash,
+  unique key (db, k) using hash,
   key (v) using hash
 );
 
 create table setvalues (
-  k text,
-  v blob,
+  db int not null,
+  k longblob,
+  v longblob,
 
   shard (k),
   sort key (v),
-  unique key (k, v) using hash,
+  unique key (db, k, v) using hash,
   key (v) using hash
 );
 
 create table listvalues (
-  k text not null,
-  v blob not null,
+  db int not null,
+  k longblob not null,
+  v longblob not null,
 
-  -- ordering columns
-  ts datetime(6) not null default now(),
-  seq bigint not null auto_increment,
+  -- position within the list; pushing to the head uses positions below the
+  -- current minimum so elements never need to be renumbered
+  -- positions are not declared unique since LINSERT shifts them in place
+  pos bigint not null,
 
   shard (k),
-  sort key (k, ts, seq),
-  unique key (k, ts, seq) using hash,
+  sort key (db, k, pos),
   key (v) using hash
-);
\ No newline at end of file
+);
+
+create table hashvalues (
+  db int not null,
+  k longblob not null,
+  f longblob not null,
+  v longblob not null,
+
+  shard (k),
+  sort key (db, k, f),
+  unique key (db, k, f) using hash,
+  key (f) using hash
+);
+
+create table zsetvalues (
+  db int not null,
+  k longblob not null,
+  member longblob not null,
+  score double not null,
+
+  shard (k),
+  sort key (db, k, score, member),
+  unique key (db, k, member) using hash,
+  key (member) using hash
+);
+
+-- members of geo keys with their locations, kept in a rowstore table for the
+-- spatial index that GEOSEARCH filters with
+create rowstore table geovalues (
+  db int not null,
+  k longblob,
+  member longblob,
+  location geographypoint not null,
+  primary key (db, k, member),
+  shard key (k),
+  index (location)
+);
+
+create table jsonvalues (
+  db int not null,
+  k longblob,
+  v json not null,
+
+  shard (k),
+  sort key (),
+  unique key (db, k) using hash
+);
+
+-- the last ID generated for each stream, which new entries must exceed even
+-- once the entries up to it have been deleted
+create rowstore table streams (
+  db int not null,
+  k longblob,
+  last_ms bigint unsigned not null,
+  last_seq bigint unsigned not null,
+  primary key (db, k),
+  shard key (k)
+);
+
+create table streamentries (
+  db int not null,
+  k longblob not null,
+  id_ms bigint unsigned not null,
+  id_seq bigint unsigned not null,
+  -- the field value pairs of the entry, encoded by the server
+  fields longblob not null,
+
+  shard (k),
+  sort key (db, k, id_ms, id_seq),
+  unique key (db, k, id_ms, id_seq) using hash
+);
+
+-- the consumer groups of each stream, with the last ID delivered to the group
+create rowstore table streamgroups (
+  db int not null,
+  k longblob,
+  grp longblob,
+  last_ms bigint unsigned not null,
+  last_seq bigint unsigned not null,
+  primary key (db, k, grp),
+  shard key (k)
+);
+
+-- the consumers of each group, with when they last read or claimed entries
+-- in milliseconds since the epoch
+create rowstore table streamconsumers (
+  db int not null,
+  k longblob,
+  grp longblob,
+  consumer longblob,
+  seen_ms bigint unsigned not null,
+  primary key (db, k, grp, consumer),
+  shard key (k)
+);
+
+-- the pending entries of each group: those delivered to a consumer but not
+-- yet acknowledged, with when they were last delivered and how often
+create rowstore table streampending (
+  db int not null,
+  k longblob,
+  grp longblob,
+  id_ms bigint unsigned not null,
+  id_seq bigint unsigned not null,
+  consumer longblob not null,
+  delivered_ms bigint unsigned not null,
+  deliveries bigint not null,
+  primary key (db, k, grp, id_ms, id_seq),
+  shard key (k)
+);
@@ -2,8 +2,11 @@ package s2kv
 
 import (
 	"bufio"
+	"context"
+	"io"
 	"log"
 	"net"
+	"runtime/debug"
 	"strings"
 
 	"github.com/secmask/go-redisproto"
@@ -13,7 +16,22 @@ type Server struct {
 	db *SingleStore
 }
 
-func NewServer(db *SingleStore) *Server {
+// ErrBulkLength is sent before closing the connection of a client which sent
+// a bulk string longer than proto-max-bulk-len.
+const ErrBulkLength = "ERR Protocol error: invalid bulk length"
+
+func init() {
+	// variadic commands such as SADD and DEL may be sent with many arguments
+	redisproto.MaxNumArg = 1024 * 1024
+}
+
+func NewServer(db *SingleStore, config ServerConfig) *Server {
+	// the parser rejects longer bulk strings before they are read, so they
+	// never reach the database
+	redisproto.MaxBulkSize = config.ProtoMaxBulkLen
+	if redisproto.MaxBulkSize <= 0 {
+		redisproto.MaxBulkSize = DefaultProtoMaxBulkLen
+	}
 	return &Server{db: db}
 }
 
@@ -34,7 +52,26 @@ func (s *Server) ListenAndServe(port string) error {
 
 func (s *Server) handleConnection(conn net.Conn) {
 	defer conn.Close()
-	parser := redisproto.NewParser(conn)
+
+	// read from the connection in the background so that a client
+	// disconnecting while blocked on a list is noticed
+	// the end of the input only ends the command loop once it is reached,
+	// since a client which shuts down its write side still expects replies
+	// to the commands it sent; only a connection error cancels them
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		_, err := io.Copy(pw, conn)
+		if err != nil {
+			cancel()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	db := s.db.WithContext(ctx)
+	parser := redisproto.NewParser(pr)
 	writer := redisproto.NewWriter(bufio.NewWriter(conn))
 
 	var ew error
@@ -46,6 +83,13 @@ func (s *Server) handleConnection(conn net.Conn) {
 			_, ok := err.(*redisproto.ProtocolError)
 			if ok {
 				ew = writer.WriteError(err.Error())
+			} else if err == redisproto.InvalidBulkSize {
+				// the rest of the bulk string is still unread, so the
+				// connection can't be used for further commands
+				_ = writer.WriteError(ErrBulkLength)
+				writer.Flush()
+				log.Println(err, " closed connection to ", conn.RemoteAddr())
+				break
 			} else {
 				log.Println(err, " closed connection to ", conn.RemoteAddr())
 				break
@@ -56,7 +100,13 @@ func (s *Server) handleConnection(conn net.Conn) {
 			if !ok {
 				ew = writer.WriteError("command not supported")
 			} else {
-				ew = handler(s.db, writer, command)
+				ew = db.WaitUnlinked(command)
+				if ew == nil {
+					ew = runHandler(handler, db, writer, command)
+				}
+				if msg, ok := ErrorReply(ew); ok {
+					ew = writer.WriteError(msg)
+				}
 			}
 		}
 
@@ -71,3 +121,15 @@ func (s *Server) handleConnection(conn net.Conn) {
 		}
 	}
 }
+
+// runHandler calls handler, turning a panic into an error reply so that a
+// bug in one command can't take down the connections of every client.
+func runHandler(handler CommandHandler, db *SingleStore, w Writer, c Command) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic on `%s`: %v\n%s", CommandString(c), r, debug.Stack())
+			err = ReplyError("ERR internal error")
+		}
+	}()
+	return handler(db, w, c)
+}
@@ -0,0 +1,177 @@
+package s2kv
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// unlinkBatchSize is how many values of an unlinked key are deleted at a time,
+// so that purging a huge collection never holds up the database for long.
+const unlinkBatchSize = 10000
+
+// dbKey is a key within one of the logical databases.
+type dbKey struct {
+	db int
+	k  string
+}
+
+// unlinker tracks keys removed by UNLINK whose values are still being deleted
+// in the background. Reads never see those values, as the procedures only
+// read the values of keys in the keyspace, but commands naming one of these
+// keys still wait for it to be purged so that recreating it doesn't have to
+// delete all of its old values at once.
+type unlinker struct {
+	mu      sync.Mutex
+	pending map[dbKey]*unlinkedKey
+	queue   chan *unlinkedKey
+
+	// swapping is held by SWAPDB and by each purge batch, so that a batch
+	// never runs against the database its key has just been swapped out of
+	swapping sync.Mutex
+}
+
+type unlinkedKey struct {
+	dbKey
+	purged chan struct{}
+}
+
+func newUnlinker() *unlinker {
+	return &unlinker{
+		pending: make(map[dbKey]*unlinkedKey),
+		queue:   make(chan *unlinkedKey, 1024),
+	}
+}
+
+// add marks keys of db as pending, returning those which weren't already.
+func (u *unlinker) add(db int, keys [][]byte) []*unlinkedKey {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var added []*unlinkedKey
+	for _, k := range keys {
+		key := dbKey{db, string(k)}
+		if _, ok := u.pending[key]; !ok {
+			uk := &unlinkedKey{dbKey: key, purged: make(chan struct{})}
+			u.pending[key] = uk
+			added = append(added, uk)
+		}
+	}
+	return added
+}
+
+func (u *unlinker) done(uk *unlinkedKey) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	close(uk.purged)
+	delete(u.pending, uk.dbKey)
+}
+
+// key returns the key uk currently refers to, which SWAPDB may change.
+func (u *unlinker) key(uk *unlinkedKey) dbKey {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return uk.dbKey
+}
+
+// wait blocks until none of the keys of c name a pending key of db.
+func (u *unlinker) wait(ctx context.Context, db int, c Command) error {
+	u.mu.Lock()
+	idle := len(u.pending) == 0
+	u.mu.Unlock()
+	if idle {
+		return nil
+	}
+
+	for _, i := range commandKeys(c) {
+		u.mu.Lock()
+		uk, ok := u.pending[dbKey{db, string(c.Get(i))}]
+		u.mu.Unlock()
+
+		if ok {
+			select {
+			case <-uk.purged:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// swap runs swapDB, which swaps databases a and b, and moves the pending keys
+// of each into the other if it succeeds.
+func (u *unlinker) swap(a, b int, swapDB func() error) error {
+	u.swapping.Lock()
+	defer u.swapping.Unlock()
+	if err := swapDB(); err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var moved []*unlinkedKey
+	for key, uk := range u.pending {
+		if key.db == a || key.db == b {
+			delete(u.pending, key)
+			moved = append(moved, uk)
+		}
+	}
+	for _, uk := range moved {
+		if uk.db == a {
+			uk.db = b
+		} else {
+			uk.db = a
+		}
+		u.pending[uk.dbKey] = uk
+	}
+	return nil
+}
+
+// purgeUnlinked deletes the values of unlinked keys as they are queued,
+// starting with any left behind by a previous run.
+func (s *SingleStore) purgeUnlinked() {
+	var leftover []struct {
+		DB int    `db:"db"`
+		K  []byte `db:"k"`
+	}
+	if err := s.db.Select(&leftover, "select db, k from unlinkedkeys"); err != nil {
+		log.Println("Error loading unlinked keys: ", err)
+	}
+	for _, l := range leftover {
+		for _, uk := range s.unlinked.add(l.DB, [][]byte{l.K}) {
+			s.purge(uk)
+			s.unlinked.done(uk)
+		}
+	}
+
+	for uk := range s.unlinked.queue {
+		s.purge(uk)
+		s.unlinked.done(uk)
+	}
+}
+
+// purge deletes the values of the unlinked key uk. If this fails they are
+// left for keyClear to delete whenever the key is next written.
+func (s *SingleStore) purge(uk *unlinkedKey) {
+	for {
+		n, err := s.purgeBatch(uk)
+		if err != nil {
+			log.Printf("Error purging unlinked key `%s`: %s", uk.k, err)
+			return
+		}
+		if n < unlinkBatchSize {
+			return
+		}
+	}
+}
+
+func (s *SingleStore) purgeBatch(uk *unlinkedKey) (int64, error) {
+	s.unlinked.swapping.Lock()
+	defer s.unlinked.swapping.Unlock()
+
+	key := s.unlinked.key(uk)
+	var n int64
+	err := s.db.Get(&n, "echo keyPurge(?, ?, ?)", key.db, []byte(key.k), unlinkBatchSize)
+	return n, err
+}